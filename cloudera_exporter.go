@@ -25,6 +25,9 @@ import (
   "runtime"
   "fmt"
   "strings"
+  "sync"
+  "syscall"
+  "os/signal"
 
 
   // Own libraries
@@ -49,8 +52,45 @@ import (
 /* ======================================================================
  * Global variables
  * ====================================================================== */
- // Exporter Configuration Struct
+ // Exporter Configuration Struct and enabled scrapers by module name. Both are
+ // replaced on each config reload, so they must be accessed with the config
+ // mutex. The reloads are serialized by the reload mutex, so they are applied
+ // in order
 var config *cp.CE_config
+var scrapers map[string]cl.Scraper
+var config_mutex sync.RWMutex
+var reload_mutex sync.Mutex
+
+// Counters of the scrapes of each target by name. They are kept between the
+// config reloads, so they only grow
+var target_metrics = make(map[string]cl.Metrics)
+var target_metrics_mutex sync.Mutex
+
+// Config file and execution flags, kept to reload the config file
+var config_file string
+var arg_host string
+var arg_num_procs int
+var arg_log_level int
+
+// Commands of the exporter
+var run_command = kingpin.Command("run", "Run the exporter. Default command").Default()
+var check_config_command = kingpin.Command("check-config", "Check the config file, report all its problems and exit")
+var check_connection = check_config_command.Flag("check-connection", "Test the connection and the user permissions against each target").Bool()
+var print_effective_config = false
+
+// Config reload metrics
+var config_last_reload_successful = prometheus.NewGauge(prometheus.GaugeOpts{
+  Namespace: "kbdi",
+  Subsystem: "exporter",
+  Name: "config_last_reload_successful",
+  Help: "Whether the last configuration reload attempt was successful",
+})
+var config_last_reload_success_timestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+  Namespace: "kbdi",
+  Subsystem: "exporter",
+  Name: "config_last_reload_success_timestamp_seconds",
+  Help: "Timestamp of the last successful configuration reload",
+})
 
 // Timeout Offset for Prometheus TimeStamping
 var timeoutOffset = 0.0
@@ -75,11 +115,26 @@ var metrics_path="/metrics"
 func init() {
   set_version_properties()
 	prometheus.MustRegister(version.NewCollector("kbdi"))
+  prometheus.MustRegister(config_last_reload_successful, config_last_reload_success_timestamp)
+}
+
+
+// Returns the counters of the scrapes of a target, created on its first
+// scrape
+func get_target_metrics(target_name string) cl.Metrics {
+  target_metrics_mutex.Lock()
+  defer target_metrics_mutex.Unlock()
+  metrics, ok := target_metrics[target_name]
+  if !ok {
+    metrics = cl.NewMetrics()
+    target_metrics[target_name] = metrics
+  }
+  return metrics
 }
 
 
 // Create and returns a Handler for the Collector
-func newHandler(metrics cl.Metrics, scrapers []cl.Scraper) http.HandlerFunc {
+func newHandler() http.HandlerFunc {
   return func(w http.ResponseWriter, r *http.Request) {
 
     // Use request context for cancellation when connection gets closed.
@@ -109,11 +164,30 @@ func newHandler(metrics cl.Metrics, scrapers []cl.Scraper) http.HandlerFunc {
       }
     }
 
+    // Config in use for this scrape, even if it is reloaded in the meantime
+    current_config, current_scrapers := get_config()
+
+    // Cloudera Manager to scrape. The first one of the config file by default
+    target, ok := current_config.Get_target(r.URL.Query().Get("target"))
+    if !ok {
+      http.Error(w, fmt.Sprintf("Unknown target %q", r.URL.Query().Get("target")), http.StatusBadRequest)
+      return
+    }
+
+    // Modules to scrape. All the enabled ones, or the ones of the collect[]
+    // parameters
+    selected_scrapers, err := filter_scrapers(current_scrapers, r.URL.Query()["collect[]"])
+    if err != nil {
+      http.Error(w, err.Error(), http.StatusBadRequest)
+      return
+    }
+
     // Create Prometheus registry with filtererd scrapers
     registry := prometheus.NewRegistry()
 
-    // Register the collector with the data connection struct in the registry
-    registry.MustRegister(cl.New(ctx, config.Connection, metrics, scrapers))
+    // Register the collector with the data connection struct in the registry,
+    // adding the constant labels of the target to all its metrics
+    prometheus.WrapRegistererWith(target.Get_labels(), registry).MustRegister(cl.New(ctx, target.Connection, get_target_metrics(target.Name), selected_scrapers))
 
     gatherers := prometheus.Gatherers { prometheus.DefaultGatherer, registry }
 
@@ -135,68 +209,212 @@ func set_version_properties() {
 }
 
 
-// Prepare and parse the execution flags
-func parse_exec_flags () {
+// Prepare and parse the execution flags. Returns the command to execute
+func parse_exec_flags () string {
+  kingpin.Flag("config-file", "Path to ini or yaml (.yaml, .yml) file.", ).Default(path.Join(os.Getenv("HOME"), "config.ini")).StringVar(&config_file)
+  kingpin.Flag("web.listen-address", "Listent Address.",).Default("").StringVar(&arg_host)
+  kingpin.Flag("num-procs", "Number Processes for parallel execution",).Default("0").IntVar(&arg_num_procs)
+  kingpin.Flag("log-level", "Debug Log Mode",).Default("0").IntVar(&arg_log_level)
+  kingpin.Flag("timeout-offset", "Time to subtract from timeout in seconds.", ).Default("0.25").Float64Var(&timeoutOffset)
+  kingpin.Flag("print-effective-config", "Print the config merged from the flags, the environment, the config file and the defaults, and exit.").BoolVar(&print_effective_config)
   kingpin.Version(version.Print("cloudera_exporter"))
   kingpin.HelpFlag.Short('h')
-  kingpin.Parse()
+  return kingpin.Parse()
 }
 
 
-// Register scrapers enabled.
-func register_scrapers (config *cp.CE_config) []cl.Scraper{
-  enabledScrapers := []cl.Scraper{}
+// Register scrapers enabled, by module name.
+func register_scrapers (config *cp.CE_config) map[string]cl.Scraper {
+  enabledScrapers := make(map[string]cl.Scraper)
   log.Info_msg("Enabled scrapers:")
-  for scraper, enabled := range config.Scrapers.Scrapers {
-    if enabled {
+  for module_name, scraper := range config.Scrapers.Modules {
+    if config.Scrapers.Scrapers[scraper] {
       log.Info_msg(" -> %s", strings.Title(strings.Replace(scraper.Name(), "_", " ", -1)))
-      enabledScrapers = append(enabledScrapers, scraper)
+      enabledScrapers[module_name] = scraper
     }
   }
   return enabledScrapers
 }
 
 
-// Read the flags and the config file and set all the values of the
-// Configuration Structure
-func parse_flags_and_config_file() error {
-  var err error
+// Returns the scrapers of the modules requested, or all the enabled scrapers
+// if no module is requested. The modules must be enabled
+func filter_scrapers (enabled_scrapers map[string]cl.Scraper, modules []string) ([]cl.Scraper, error) {
+  selected := []cl.Scraper{}
+  if len(modules) == 0 {
+    for _, scraper := range enabled_scrapers {
+      selected = append(selected, scraper)
+    }
+    return selected, nil
+  }
+  requested := make(map[string]bool)
+  for _, module_name := range modules {
+    if requested[module_name] {
+      continue
+    }
+    requested[module_name] = true
+    scraper, ok := enabled_scrapers[module_name]
+    if !ok {
+      return nil, fmt.Errorf("Unknown or disabled module %q", module_name)
+    }
+    selected = append(selected, scraper)
+  }
+  return selected, nil
+}
 
-  // Parse flags and config file
-  configFile := kingpin.Flag("config-file", "Path to ini file.", ).Default(path.Join(os.Getenv("HOME"), "config.ini")).String()
-  arg_host := *(kingpin.Flag("web.listen-address", "Listent Address.",).Default("").String())
-  arg_num_procs := *(kingpin.Flag("num-procs", "Number Processes for parallel execution",).Default("0").Int())
-  arg_log_level := *(kingpin.Flag("log-level", "Debug Log Mode",).Default("0").Int())
-  timeoutOffset = *(kingpin.Flag("timeout-offset", "Time to subtract from timeout in seconds.", ).Default("0.25").Float64())
-  parse_exec_flags()
 
-  if config, err = cp.Parse_config(*configFile); err != nil {
-    return err
+// Read the config file and apply the execution flags, without querying the
+// Cloudera Managers. The config is checked but not set
+func parse_config_file() (*cp.CE_config, error) {
+  new_config, err := cp.Parse_config(config_file)
+  if err != nil {
+    return nil, err
   }
 
   // If host, num_procs or log_level are defined in the execution flags, they
-  // have priority over the configuration file
+  // have priority over the configuration file. The host flag only overrides
+  // the first target
   if arg_host != "" {
-    config.Connection.Host = arg_host
+    new_config.Targets[0].Connection.Host = arg_host
+    new_config.Set_source(cp.Target_field(new_config.Targets[0].Name, "host"), cp.SOURCE_FLAG)
   }
   if arg_num_procs != 0 {
-    config.Num_procs = arg_num_procs
+    new_config.Num_procs = arg_num_procs
+    new_config.Set_source("system.num_procs", cp.SOURCE_FLAG)
   }
   if arg_log_level != 0 {
-    config.Log_level = arg_log_level
+    new_config.Log_level = arg_log_level
+    new_config.Set_source("system.log_level", cp.SOURCE_FLAG)
+  }
+
+  return new_config, nil
+}
+
+
+// Returns the config in use and its scrapers
+func get_config() (*cp.CE_config, map[string]cl.Scraper) {
+  config_mutex.RLock()
+  defer config_mutex.RUnlock()
+  return config, scrapers
+}
+
+
+// Replace the config in use with a new config, already checked, and its
+// scrapers. The custom metrics of the new config replace the previous ones
+// and the log level is changed, without creating the loggers again
+func set_config(new_config *cp.CE_config) {
+  config_mutex.Lock()
+  defer config_mutex.Unlock()
+  if config != nil && (new_config.Deploy_ip != config.Deploy_ip || new_config.Deploy_port != config.Deploy_port) {
+    log.Warn_msg("The deploy IP and port can't be changed without restarting the exporter")
   }
+  log.Set_level(new_config.Log_level)
+  runtime.GOMAXPROCS(new_config.Num_procs)
+  cl.Set_custom_metrics(new_config.Get_custom_metrics())
+  config, scrapers = new_config, register_scrapers(new_config)
+}
+
 
+// Check the config file and report all its problems. Returns the exit code of
+// the check-config command
+func check_config_file() int {
+  log.Info_msg("Checking config file: %s", config_file)
+  problems := cp.Check_config(config_file)
+
+  // Parse the config file as the exporter does, to catch any other problem,
+  // and test the connection against each target
+  if len(problems) == 0 {
+    new_config, err := cp.Parse_config(config_file)
+    if err != nil {
+      problems = append(problems, err)
+    } else if *check_connection {
+      for _, target := range new_config.Targets {
+        log.Info_msg("Testing connection to target %s (%s:%s)", target.Name, target.Connection.Host, target.Connection.Port)
+        if err := cl.Check_connection(nil, target.Connection); err != nil {
+          problems = append(problems, fmt.Errorf("Target %s: %s", target.Name, err))
+        }
+      }
+    }
+  }
 
-  // Check if Api_version is defined on the config file, else, the version is
-  // obtained by Cloudera Manager API
-  if config.Connection.Api_version == "" {
-    if config.Connection.Api_version, err = cl.Get_api_cloudera_version(nil, config.Connection); err != nil {
-      return err
+  if len(problems) > 0 {
+    for _, problem := range problems {
+      log.Err_msg("%s", problem)
     }
+    log.Err_msg("The config file %s has %d problems", config_file, len(problems))
+    return 1
+  }
+  log.Ok_msg("The config file %s is valid", config_file)
+  return 0
+}
+
+
+// Read again the config file and replace the config in use. If the new config
+// is not valid, the config in use is kept. The Cloudera Managers are not
+// queried, so a config can be reloaded while they are unreachable
+func reload_config() error {
+  reload_mutex.Lock()
+  defer reload_mutex.Unlock()
+  log.Info_msg("Reloading config file: %s", config_file)
+  new_config, err := parse_config_file()
+  if err != nil {
+    log.Err_msg("Config reload failed, keeping the previous config: %s", err)
+    config_last_reload_successful.Set(0)
+    return err
   }
+
+  set_config(new_config)
+  // The targets of the new config may be different
+  cl.Invalidate_metadata_cache()
+
+  config_last_reload_successful.Set(1)
+  config_last_reload_success_timestamp.SetToCurrentTime()
+  log.Ok_msg("Config file reloaded")
   return nil
 }
 
+
+// Reload the config file on each SIGHUP signal
+func watch_reload_signal() {
+  hup := make(chan os.Signal, 1)
+  signal.Notify(hup, syscall.SIGHUP)
+  go func() {
+    for range hup {
+      reload_config()
+    }
+  }()
+}
+
+
+// Handler to reload the config file with a POST request
+func reload_handler(w http.ResponseWriter, r *http.Request) {
+  if r.Method != http.MethodPost {
+    w.Header().Set("Allow", http.MethodPost)
+    http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+    return
+  }
+  if err := reload_config(); err != nil {
+    http.Error(w, fmt.Sprintf("Failed to reload config: %s", err), http.StatusInternalServerError)
+    return
+  }
+  fmt.Fprintln(w, "Config reloaded")
+}
+
+
+// Handler to invalidate the cached metadata of the Cloudera Managers with a
+// POST request
+func invalidate_cache_handler(w http.ResponseWriter, r *http.Request) {
+  if r.Method != http.MethodPost {
+    w.Header().Set("Allow", http.MethodPost)
+    http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+    return
+  }
+  cl.Invalidate_metadata_cache()
+  log.Info_msg("Metadata cache invalidated")
+  fmt.Fprintln(w, "Metadata cache invalidated")
+}
+
+
 // Main function
 func main(){
   // Starting Logging
@@ -208,32 +426,51 @@ func main(){
   log.Info_msg("Exporter Version: %s", version.Version)
 
   // Parse Flags and config file
-  if err := parse_flags_and_config_file(); err != nil {
+  command := parse_exec_flags()
+  if command == check_config_command.FullCommand() {
+    os.Exit(check_config_file())
+  }
+  // The config is read without querying the Cloudera Managers. The API
+  // version of the targets that don't set it is queried on their first scrape
+  new_config, err := parse_config_file()
+  if err != nil {
     log.Err_msg(err.Error())
     return
   }
-  log.Init(os.Stdout, os.Stdout, os.Stdout, os.Stderr, os.Stdout, config.Log_level)
+  if print_effective_config {
+    fmt.Print(new_config.Format_effective_config())
+    return
+  }
+  set_config(new_config)
 
   //Parallel Execution
-  runtime.GOMAXPROCS(config.Num_procs)
-  log.Info_msg("Cores allocated: %s", strconv.Itoa(config.Num_procs))
+  log.Info_msg("Cores allocated: %s", strconv.Itoa(runtime.GOMAXPROCS(0)))
 
   // Run info
   log.Info_msg("Build context %s", version.BuildContext())
 
   // Exporter creation
   log.Info_msg("Registering Handlers")
-  handlerFunc := newHandler(cl.NewMetrics(), register_scrapers(config))
+  config_last_reload_successful.Set(1)
+  config_last_reload_success_timestamp.SetToCurrentTime()
+  handlerFunc := newHandler()
   http.Handle(metrics_path, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, handlerFunc))
+  http.HandleFunc("/-/reload", reload_handler)
+  http.HandleFunc("/-/invalidate-cache", invalidate_cache_handler)
+  watch_reload_signal()
   http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) { w.Write(landingPage) })
   log.Ok_msg("Landing Page and Handlers are running")
 
 
-  // Exporter HTTP connection
-  log.Info_msg("Target to scraping metrics from: %s:%s", config.Connection.Host, config.Connection.Port)
-  ip := func () string {if config.Deploy_ip == "" { return "0.0.0.0" } else { return config.Deploy_ip }}
-  log.Info_msg("Metrics published on: %s:%d", ip(), config.Deploy_port)
+  // Exporter HTTP connection. The config may be reloaded from now on
+  current_config, _ := get_config()
+  for _, target := range current_config.Targets {
+    log.Info_msg("Target to scraping metrics from: %s (%s:%s)", target.Name, target.Connection.Host, target.Connection.Port)
+  }
+  deploy_ip, deploy_port := current_config.Deploy_ip, current_config.Deploy_port
+  ip := func () string {if deploy_ip == "" { return "0.0.0.0" } else { return deploy_ip }}
+  log.Info_msg("Metrics published on: %s:%d", ip(), deploy_port)
   log.Ok_msg("Keedio's Cloudera Exporter running")
-  log.Err_msg(http.ListenAndServe(fmt.Sprintf("%s:%d", config.Deploy_ip, config.Deploy_port), nil).Error())
+  log.Err_msg(http.ListenAndServe(fmt.Sprintf("%s:%d", deploy_ip, deploy_port), nil).Error())
   return
 }
@@ -0,0 +1,306 @@
+/*
+ *
+ * title           :cloudera_exporter_test.go
+ * description     :Tests of the config reload and the module selection of the Cloudera Exporter
+ * author		       :Alejandro Villegas
+ * date            :2019/07/12
+ * version         :1.0
+ *
+ */
+package main
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "os"
+  "path"
+  "sort"
+  "strings"
+  "sync"
+  "sync/atomic"
+  "testing"
+
+  // Own libraries
+  cl "keedio/cloudera_exporter/collector"
+  cp "keedio/cloudera_exporter/config_parser"
+  log "keedio/cloudera_exporter/logger"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+  dto "github.com/prometheus/client_model/go"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// The loggers are discarded, so the output of the tests is only theirs
+func TestMain(m *testing.M) {
+  log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, 0)
+  os.Exit(m.Run())
+}
+
+
+// Write the config file used by the reloads
+func write_config_file(t *testing.T, content string) {
+  if err := ioutil.WriteFile(config_file, []byte(content), 0600); err != nil {
+    t.Fatalf("Writing config file: %s", err)
+  }
+}
+
+
+func Test_reload_config(t *testing.T) {
+  directory, err := ioutil.TempDir("", "cloudera_exporter")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(directory)
+  config_file = path.Join(directory, "config.yaml")
+  config = &cp.CE_config{}
+
+  // A valid config replaces the config in use
+  write_config_file(t, `
+targets:
+  - name: first
+    host: localhost
+    port: 7180
+    version: v19
+    username: user
+    password: passwd
+modules:
+  status:
+    enabled: true
+`)
+  if err := reload_config(); err != nil {
+    t.Fatalf("reload_config() = %s, expected no error", err)
+  }
+  if config.Targets[0].Name != "first" || len(scrapers) != 1 || scrapers["status"] == nil {
+    t.Fatalf("reload_config() loaded target %s and scrapers %v", config.Targets[0].Name, scrapers)
+  }
+
+  // The Cloudera Managers are not queried, so a target without version is
+  // loaded even if its Cloudera Manager is unreachable
+  write_config_file(t, `
+targets:
+  - name: unreachable
+    host: 127.0.0.1
+    port: 1
+    username: user
+    password: passwd
+modules:
+  status:
+    enabled: true
+`)
+  if err := reload_config(); err != nil {
+    t.Fatalf("reload_config() with an unreachable target = %s, expected no error", err)
+  }
+  if current_config, _ := get_config(); current_config.Targets[0].Name != "unreachable" || current_config.Targets[0].Connection.Api_version != "" {
+    t.Fatalf("reload_config() loaded target %s with version %q", current_config.Targets[0].Name, current_config.Targets[0].Connection.Api_version)
+  }
+
+  // An invalid config keeps the config in use
+  write_config_file(t, "targets: []\n")
+  if err := reload_config(); err == nil {
+    t.Errorf("reload_config() with an invalid config = nil, expected an error")
+  }
+  if config.Targets[0].Name != "unreachable" || len(scrapers) != 1 {
+    t.Errorf("reload_config() with an invalid config replaced the config in use")
+  }
+}
+
+
+// The reloads run while the scrapes are logging and reading the config. Run
+// with -race to check them
+func Test_reload_config_concurrent(t *testing.T) {
+  directory, err := ioutil.TempDir("", "cloudera_exporter")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(directory)
+  config_file = path.Join(directory, "config.yaml")
+  write_config_file(t, `
+targets:
+  - name: first
+    host: localhost
+    port: 7180
+    version: v19
+    username: user
+    password: passwd
+system:
+  log_level: 1
+`)
+  if err := reload_config(); err != nil {
+    t.Fatalf("reload_config() = %s, expected no error", err)
+  }
+
+  var wg sync.WaitGroup
+  for index := 0; index < 4; index++ {
+    wg.Add(2)
+    go func() {
+      defer wg.Done()
+      reload_config()
+    }()
+    go func() {
+      defer wg.Done()
+      current_config, _ := get_config()
+      log.Debug_msg("Scraping target %s", current_config.Targets[0].Name)
+      log.Err_msg("Scrape failed")
+    }()
+  }
+  wg.Wait()
+}
+
+
+func Test_reload_handler(t *testing.T) {
+  directory, err := ioutil.TempDir("", "cloudera_exporter")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(directory)
+  config_file = path.Join(directory, "config.yaml")
+  write_config_file(t, "targets: []\n")
+
+  tests := []struct {
+    method string
+    expected int
+  }{
+    {http.MethodGet, http.StatusMethodNotAllowed},
+    {http.MethodPost, http.StatusInternalServerError},
+  }
+  for _, test := range tests {
+    recorder := httptest.NewRecorder()
+    reload_handler(recorder, httptest.NewRequest(test.method, "/-/reload", nil))
+    if recorder.Code != test.expected {
+      t.Errorf("%s /-/reload = %d, expected %d", test.method, recorder.Code, test.expected)
+    }
+  }
+}
+
+
+func Test_parse_config_file(t *testing.T) {
+  directory, err := ioutil.TempDir("", "cloudera_exporter")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(directory)
+  config_file = path.Join(directory, "config.yaml")
+
+  // Fake Cloudera Manager, counting the queries of the API version
+  var queries int32
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    atomic.AddInt32(&queries, 1)
+    w.Write([]byte("v19"))
+  }))
+  defer server.Close()
+  server_url, err := url.Parse(server.URL)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  write_config_file(t, `
+targets:
+  - name: without_version
+    host: `+ server_url.Hostname() +`
+    port: `+ server_url.Port() +`
+    username: user
+    password: passwd
+  - name: with_version
+    host: `+ server_url.Hostname() +`
+    port: `+ server_url.Port() +`
+    version: v33
+    username: user
+    password: passwd
+`)
+
+  // The effective config is read without querying the Cloudera Managers
+  new_config, err := parse_config_file()
+  if err != nil {
+    t.Fatalf("parse_config_file() = %s, expected no error", err)
+  }
+  if count := atomic.LoadInt32(&queries); new_config.Targets[0].Connection.Api_version != "" || count != 0 {
+    t.Errorf("parse_config_file() queried the API version: %q, %d queries", new_config.Targets[0].Connection.Api_version, count)
+  }
+}
+
+
+func Test_filter_scrapers(t *testing.T) {
+  enabled_scrapers := map[string]cl.Scraper{
+    "host": cl.ScrapeHost{},
+    "hdfs": cl.ScrapeHDFS{},
+    "impala": cl.ScrapeImpalaMetrics{},
+  }
+  all := []string{cl.ScrapeHDFS{}.Name(), cl.ScrapeHost{}.Name(), cl.ScrapeImpalaMetrics{}.Name()}
+  sort.Strings(all)
+
+  tests := []struct {
+    name string
+    modules []string
+    expected []string
+    valid bool
+  }{
+    {"no modules", nil, all, true},
+    {"one module", []string{"host"}, []string{cl.ScrapeHost{}.Name()}, true},
+    {"several modules", []string{"impala", "hdfs"}, []string{cl.ScrapeHDFS{}.Name(), cl.ScrapeImpalaMetrics{}.Name()}, true},
+    {"duplicated modules", []string{"host", "host"}, []string{cl.ScrapeHost{}.Name()}, true},
+    {"unknown module", []string{"host", "solr"}, nil, false},
+    {"disabled module", []string{"yarn"}, nil, false},
+  }
+  for _, test := range tests {
+    selected, err := filter_scrapers(enabled_scrapers, test.modules)
+    if (err == nil) != test.valid {
+      t.Errorf("%s: filter_scrapers() error = %v, expected valid %v", test.name, err, test.valid)
+      continue
+    }
+    names := []string{}
+    for _, scraper := range selected {
+      names = append(names, scraper.Name())
+    }
+    sort.Strings(names)
+    if test.valid && strings.Join(names, ",") != strings.Join(test.expected, ",") {
+      t.Errorf("%s: filter_scrapers() = %v, expected %v", test.name, names, test.expected)
+    }
+  }
+}
+
+
+func Test_get_target_metrics(t *testing.T) {
+  first := get_target_metrics("first")
+  second := get_target_metrics("second")
+  first.TotalScrapes.Inc()
+  first.ScrapeErrors.WithLabelValues("status_collector").Inc()
+
+  // Each target has its own counters, kept between the scrapes
+  counter_value := func(counter prometheus.Counter) float64 {
+    metric := dto.Metric{}
+    if err := counter.Write(&metric); err != nil {
+      t.Fatal(err)
+    }
+    return metric.GetCounter().GetValue()
+  }
+  tests := []struct {
+    name string
+    counter prometheus.Counter
+    expected float64
+  }{
+    {"first scrapes", get_target_metrics("first").TotalScrapes, 1},
+    {"first errors", get_target_metrics("first").ScrapeErrors.WithLabelValues("status_collector"), 1},
+    {"second scrapes", second.TotalScrapes, 0},
+    {"second errors", second.ScrapeErrors.WithLabelValues("status_collector"), 0},
+  }
+  for _, test := range tests {
+    if value := counter_value(test.counter); value != test.expected {
+      t.Errorf("%s = %v, expected %v", test.name, value, test.expected)
+    }
+  }
+}
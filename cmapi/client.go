@@ -0,0 +1,650 @@
+/*
+ *
+ * title           :cmapi/client.go
+ * description     :Typed client of the Cloudera Manager API
+ * author		       :Alejandro Villegas
+ * date            :2019/08/07
+ * version         :1.0
+ *
+ */
+package cmapi
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "encoding/json"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "net/url"
+  "fmt"
+  "strconv"
+  "strings"
+  "time"
+
+  // Own libraries
+  jp "keedio/cloudera_exporter/json_parser"
+  log "keedio/cloudera_exporter/logger"
+)
+
+
+
+
+/* ======================================================================
+ * Constants
+ * ====================================================================== */
+// Items requested in each page of the paginated endpoints, like the events
+const DEFAULT_PAGE_SIZE = 100
+
+// Content types of the timeseries responses
+const (
+  CONTENT_TYPE_JSON = "application/json"
+  CONTENT_TYPE_CSV =  "text/csv"
+)
+
+
+
+
+/* ======================================================================
+ * Data Structs
+ * ====================================================================== */
+// Function that decodes the body of a response, read from the reader while
+// it's received
+type Decode_function func(reader io.Reader) error
+
+// Optional parameters of the timeseries endpoint. The zero value of each field
+// leaves the parameter out, so the API default is used: the last 5 minutes,
+// with the rollup chosen by the Cloudera Manager, in JSON
+type Time_series_options struct {
+  // Start and end of the time window
+  From time.Time
+  To time.Time
+  // Aggregation of the points: RAW, TEN_MINUTELY, HOURLY, SIX_HOURLY, DAILY
+  // or WEEKLY
+  Desired_rollup string
+  // Whether the desired rollup is used even if the Cloudera Manager would
+  // choose another one for the time window
+  Must_use_desired_rollup bool
+  // Content type of the response, application/json or text/csv
+  Content_type string
+}
+
+// Client of the Cloudera Manager API. It builds the paths of the endpoints,
+// escaping the names of the clusters, services and hosts, requests all the
+// pages of the paginated endpoints and decodes the responses into typed
+// objects while they are received
+type Client struct {
+  // URL of the API, like http://cloudera-manager:7180/api
+  Base_url string
+  // Version of the API of the endpoints, like v19
+  Api_version string
+  // HTTP client of the queries. Its transport decides how the queries are
+  // made: retries, rate limit...
+  Http_client *http.Client
+  // Credentials of the queries. The password is read on each query, so it
+  // can be rotated
+  User string
+  Passwd func() string
+  // Maximum size of a response in bytes. The larger ones are discarded,
+  // unless it's 0
+  Max_response_size int
+  // Items requested in each page of the paginated endpoints
+  Page_size int
+}
+
+// Error of a response of the API with a status code other than 2xx or 3xx
+type Status_error struct {
+  Status_code int
+  Status string
+}
+
+// Names of the offset and limit parameters of a paginated endpoint
+type paging struct {
+  offset_parameter string
+  limit_parameter string
+}
+
+// Reader of a response body that counts the bytes read
+type counting_reader struct {
+  reader io.Reader
+  count int
+}
+
+
+
+
+/* ======================================================================
+ * Global variables
+ * ====================================================================== */
+// Rollups of the timeseries points accepted by the API
+var time_series_rollups = map[string]bool {
+  "RAW": true, "TEN_MINUTELY": true, "HOURLY": true, "SIX_HOURLY": true, "DAILY": true, "WEEKLY": true,
+}
+
+// Paging of the events endpoint
+var event_paging = paging{offset_parameter: "resultOffset", limit_parameter: "maxResults"}
+
+// Paging of the command history endpoints, like the snapshot history
+var command_paging = paging{offset_parameter: "offset", limit_parameter: "limit"}
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Returns a client of the API of the base URL that makes its queries with
+// the HTTP client, or the default one if it's nil
+func New_client(base_url string, api_version string, http_client *http.Client) *Client {
+  if http_client == nil {
+    http_client = http.DefaultClient
+  }
+  return &Client {
+    Base_url: strings.TrimSuffix(base_url, "/"),
+    Api_version: api_version,
+    Http_client: http_client,
+    Page_size: DEFAULT_PAGE_SIZE,
+  }
+}
+
+
+func (err *Status_error) Error() string {
+  return fmt.Sprintf("Invalid HTTP response code: %s", err.Status)
+}
+
+
+// Read from the body and count the bytes read
+func (reader *counting_reader) Read(buffer []byte) (int, error) {
+  read, err := reader.reader.Read(buffer)
+  reader.count += read
+  return read, err
+}
+
+
+// Build the path of an endpoint from its segments. Each segment is escaped,
+// so the names with spaces, slashes or question marks reach the API as a
+// single segment
+func Build_path(segments ...string) string {
+  escaped := make([]string, len(segments))
+  for index, segment := range segments {
+    escaped[index] = url.PathEscape(segment)
+  }
+  return strings.Join(escaped, "/")
+}
+
+
+// Add the query parameters to the path of an endpoint
+func With_parameters(path string, parameters url.Values) string {
+  if len(parameters) == 0 {
+    return path
+  }
+  return path + "?" + parameters.Encode()
+}
+
+
+// Path of the clusters
+func Clusters_path() string {
+  return "clusters"
+}
+
+// Path of a cluster
+func Cluster_path(cluster_name string) string {
+  return Build_path("clusters", cluster_name)
+}
+
+// Path of the host templates of a cluster
+func Host_templates_path(cluster_name string) string {
+  return Build_path("clusters", cluster_name, "hostTemplates")
+}
+
+// Path of the services of a cluster
+func Services_path(cluster_name string) string {
+  return Build_path("clusters", cluster_name, "services")
+}
+
+// Path of the roles of a service
+func Roles_path(cluster_name string, service_name string) string {
+  return Build_path("clusters", cluster_name, "services", service_name, "roles")
+}
+
+// Path of the nameservices of an HDFS service
+func Nameservices_path(cluster_name string, service_name string) string {
+  return Build_path("clusters", cluster_name, "services", service_name, "nameservices")
+}
+
+// Path of the snapshot policies of a service
+func Snapshot_policies_path(cluster_name string, service_name string) string {
+  return Build_path("clusters", cluster_name, "services", service_name, "snapshots", "policies")
+}
+
+// Path of the history of a snapshot policy
+func Snapshot_history_path(cluster_name string, service_name string, policy_name string) string {
+  return Build_path("clusters", cluster_name, "services", service_name, "snapshots", "policies", policy_name, "history")
+}
+
+// Path of the replication schedules of a service
+func Replications_path(cluster_name string, service_name string) string {
+  return Build_path("clusters", cluster_name, "services", service_name, "replications")
+}
+
+// Path of the run history of a replication schedule
+func Replication_history_path(cluster_name string, service_name string, schedule_id string) string {
+  return Build_path("clusters", cluster_name, "services", service_name, "replications", schedule_id, "history")
+}
+
+// Path of the usage report of an HDFS service
+func Hdfs_usage_report_path(cluster_name string, service_name string) string {
+  return Build_path("clusters", cluster_name, "services", service_name, "reports", "hdfsUsageReport")
+}
+
+// Check the options of a TSquery. The rollup and the content type must be
+// ones of the API, and the time window must not be empty
+func (options Time_series_options) Check() error {
+  if options.Desired_rollup != "" && !time_series_rollups[options.Desired_rollup] {
+    return fmt.Errorf("Invalid rollup %s. Valid rollups are RAW, TEN_MINUTELY, HOURLY, SIX_HOURLY, DAILY and WEEKLY", options.Desired_rollup)
+  }
+  if options.Must_use_desired_rollup && options.Desired_rollup == "" {
+    return fmt.Errorf("The desired rollup must be set to force its use")
+  }
+  if options.Content_type != "" && options.Content_type != CONTENT_TYPE_JSON && options.Content_type != CONTENT_TYPE_CSV {
+    return fmt.Errorf("Invalid content type %s. Valid content types are %s and %s", options.Content_type, CONTENT_TYPE_JSON, CONTENT_TYPE_CSV)
+  }
+  if !options.From.IsZero() && !options.To.IsZero() && !options.From.Before(options.To) {
+    return fmt.Errorf("The start of the time window must be before its end")
+  }
+  return nil
+}
+
+
+// Path of a TSquery with its options. The query and the options are encoded
+// as query parameters, so the quotes, equal signs, ampersands, plus signs,
+// slashes and non ASCII characters of the statements reach the API as they
+// are
+func Time_series_path(query string, options Time_series_options) string {
+  parameters := url.Values{"query": {query}}
+  if !options.From.IsZero() {
+    parameters.Set("from", options.From.UTC().Format(time.RFC3339))
+  }
+  if !options.To.IsZero() {
+    parameters.Set("to", options.To.UTC().Format(time.RFC3339))
+  }
+  if options.Desired_rollup != "" {
+    parameters.Set("desiredRollup", options.Desired_rollup)
+  }
+  if options.Must_use_desired_rollup {
+    parameters.Set("mustUseDesiredRollup", "true")
+  }
+  if options.Content_type != "" {
+    parameters.Set("contentType", options.Content_type)
+  }
+  return With_parameters("timeseries", parameters)
+}
+
+// Path of the hosts
+func Hosts_path() string {
+  return "hosts"
+}
+
+// Path of a host
+func Host_path(host_id string) string {
+  return Build_path("hosts", host_id)
+}
+
+// Path of the events
+func Events_path() string {
+  return "events"
+}
+
+// Path of the Cloudera Management Service
+func Management_service_path() string {
+  return "cm/service"
+}
+
+// Path of the roles of the Cloudera Management Service
+func Management_roles_path() string {
+  return "cm/service/roles"
+}
+
+
+// Make a GET query of the URL and decode the response with the function,
+// while it's received. The responses with a status code other than 2xx or
+// 3xx are returned as a Status_error. The body is read up to one byte over
+// the maximum size, to detect the larger responses
+func (client *Client) get_url(ctx context.Context, uri string, decode Decode_function) error {
+  log.Debug_msg("Making API Query: %s ", uri)
+
+  // Build the request Object
+  req, err := http.NewRequest(http.MethodGet, uri, nil)
+  if err != nil {
+    log.Err_msg("Building Request for URL:%s, Failed. Error: %s", uri, err)
+    return err
+  }
+  if ctx != nil {
+    req = req.WithContext(ctx)
+  }
+  req.Header.Add("Content-Type", "application/json")
+  if client.User != "" && client.Passwd != nil {
+    req.SetBasicAuth(client.User, client.Passwd())
+  }
+
+  // Make the API request
+  res, err := client.Http_client.Do(req)
+  if err != nil {
+    log.Err_msg("%s", err)
+    return err
+  }
+  defer res.Body.Close()
+  if res.StatusCode < 200 || res.StatusCode >= 400 {
+    log.Err_msg("Invalid HTTP response code: %s for the request: %s", res.Status, uri)
+    return &Status_error{Status_code: res.StatusCode, Status: res.Status}
+  }
+
+  // Decode the body while it's read
+  max_size := client.Max_response_size
+  reader := &counting_reader{reader: res.Body}
+  if max_size > 0 {
+    if res.ContentLength > int64(max_size) {
+      err = fmt.Errorf("Response of %d bytes larger than the maximum size of %d bytes", res.ContentLength, max_size)
+      log.Err_msg("%s for the request: %s", err, uri)
+      return err
+    }
+    reader.reader = io.LimitReader(res.Body, int64(max_size) + 1)
+  }
+  err = decode(reader)
+  if max_size > 0 && reader.count > max_size {
+    err = fmt.Errorf("Response larger than the maximum size of %d bytes", max_size)
+    log.Err_msg("%s for the request: %s", err, uri)
+    return err
+  }
+  if err != nil {
+    log.Err_msg("Failed to parse response with error: %s", err)
+    return err
+  }
+
+  // The rest of the body, like the trailing spaces after the JSON, is read so
+  // the connection can be reused
+  io.Copy(ioutil.Discard, reader)
+  return nil
+}
+
+
+// Make a GET query of the path, relative to the API version, like clusters
+// or timeseries?query=..., and decode the response with the function
+func (client *Client) Get(ctx context.Context, path string, decode Decode_function) error {
+  return client.get_url(ctx, fmt.Sprintf("%s/%s/%s", client.Base_url, client.Api_version, path), decode)
+}
+
+
+// Returns a decode function that keeps the body of the response as it is,
+// for the plain text responses or the ones parsed with gjson
+func Read_body(body *string) Decode_function {
+  return func(reader io.Reader) error {
+    var builder strings.Builder
+    _, err := io.Copy(&builder, reader)
+    *body = builder.String()
+    return err
+  }
+}
+
+
+// Returns a decode function that decodes the JSON body into the value
+func decode_json(value interface{}) Decode_function {
+  return func(reader io.Reader) error {
+    return json.NewDecoder(reader).Decode(value)
+  }
+}
+
+
+// Request all the pages of a paginated endpoint, with its offset and limit
+// parameters. Each page is decoded by the first function, which returns the
+// ids of its items, and the items not seen in the previous pages are added
+// by their index with the second one. The requests end with a page shorter
+// than the page size or without new items, so an endpoint that ignores the
+// offset is requested twice at most
+func (client *Client) get_pages(ctx context.Context, path string, parameters url.Values, paging paging, decode_page func(reader io.Reader) ([]string, error), add_item func(index int)) error {
+  page_size := client.Page_size
+  if page_size < 1 {
+    page_size = DEFAULT_PAGE_SIZE
+  }
+  seen := map[string]bool{}
+  for offset := 0; ; offset += page_size {
+    page_parameters := url.Values{}
+    for key, values := range parameters {
+      page_parameters[key] = values
+    }
+    page_parameters.Set(paging.offset_parameter, strconv.Itoa(offset))
+    page_parameters.Set(paging.limit_parameter, strconv.Itoa(page_size))
+
+    var ids []string
+    err := client.Get(ctx, With_parameters(path, page_parameters), func(reader io.Reader) (err error) {
+      ids, err = decode_page(reader)
+      return err
+    })
+    if err != nil {
+      return err
+    }
+    new_items := 0
+    for index, id := range ids {
+      if seen[id] {
+        continue
+      }
+      seen[id] = true
+      new_items++
+      add_item(index)
+    }
+    if new_items < len(ids) {
+      log.Warn_msg("The page of %s at offset %d repeats %d items. The endpoint may ignore the %s parameter", path, offset, len(ids) - new_items, paging.offset_parameter)
+    }
+    if len(ids) < page_size || new_items == 0 {
+      return nil
+    }
+  }
+}
+
+
+// Returns the highest version of the API supported by the Cloudera Manager,
+// like v19. It's the only endpoint out of the API versions, and answers in
+// plain text
+func (client *Client) Highest_api_version(ctx context.Context) (string, error) {
+  var version string
+  err := client.get_url(ctx, client.Base_url + "/version", Read_body(&version))
+  return strings.TrimSpace(version), err
+}
+
+
+// Returns the version of the Cloudera Manager
+func (client *Client) Version(ctx context.Context) (version jp.Api_version_info, err error) {
+  err = client.Get(ctx, "cm/version", decode_json(&version))
+  return version, err
+}
+
+
+// Returns the responses of the statements of a TSquery, in the order of the
+// statements. The responses are always requested in JSON
+func (client *Client) Time_series(ctx context.Context, query string, options Time_series_options) (responses []jp.Api_time_series_response, err error) {
+  options.Content_type = CONTENT_TYPE_JSON
+  if err := options.Check(); err != nil {
+    return nil, err
+  }
+  err = client.Get(ctx, Time_series_path(query, options), func(reader io.Reader) (err error) {
+    responses, err = jp.Decode_timeseries_responses(reader)
+    return err
+  })
+  return responses, err
+}
+
+
+// Returns the events matching the query, like category==HEALTH_EVENT, or all
+// of them if it's empty. The events are requested page by page
+func (client *Client) Events(ctx context.Context, query string) ([]jp.Api_event, error) {
+  events := []jp.Api_event{}
+  parameters := url.Values{}
+  if query != "" {
+    parameters.Set("query", query)
+  }
+  var page []jp.Api_event
+  err := client.get_pages(ctx, Events_path(), parameters, event_paging, func(reader io.Reader) (ids []string, err error) {
+    page, err = jp.Decode_api_events(reader)
+    ids = make([]string, len(page))
+    for index := range page {
+      ids[index] = page[index].Id
+    }
+    return ids, err
+  }, func(index int) {
+    events = append(events, page[index])
+  })
+  return events, err
+}
+
+
+// Returns the clusters of the Cloudera Manager
+func (client *Client) List_clusters(ctx context.Context) (clusters []jp.Api_cluster, err error) {
+  err = client.Get(ctx, Clusters_path(), func(reader io.Reader) (err error) {
+    clusters, err = jp.Decode_api_clusters(reader)
+    return err
+  })
+  return clusters, err
+}
+
+
+// Returns the host templates of a cluster
+func (client *Client) List_host_templates(ctx context.Context, cluster_name string) (templates []jp.Api_host_template, err error) {
+  err = client.Get(ctx, Host_templates_path(cluster_name), func(reader io.Reader) (err error) {
+    templates, err = jp.Decode_api_host_templates(reader)
+    return err
+  })
+  return templates, err
+}
+
+
+// Returns the hosts of the Cloudera Manager. The endpoint is not paginated,
+// so all the hosts come in a single response
+func (client *Client) List_hosts(ctx context.Context) (hosts []jp.Api_host, err error) {
+  err = client.Get(ctx, Hosts_path(), func(reader io.Reader) (err error) {
+    hosts, err = jp.Decode_api_hosts(reader)
+    return err
+  })
+  return hosts, err
+}
+
+
+// Returns a host of the Cloudera Manager, with its health summary
+func (client *Client) Get_host(ctx context.Context, host_id string) (host jp.Api_host, err error) {
+  err = client.Get(ctx, Host_path(host_id), decode_json(&host))
+  return host, err
+}
+
+
+// Returns the services of a cluster
+func (client *Client) List_services(ctx context.Context, cluster_name string) (services []jp.Api_service, err error) {
+  err = client.Get(ctx, Services_path(cluster_name), func(reader io.Reader) (err error) {
+    services, err = jp.Decode_api_services(reader)
+    return err
+  })
+  return services, err
+}
+
+
+// Returns the roles of a service of a cluster. The endpoint is not
+// paginated, so all the roles come in a single response
+func (client *Client) List_roles(ctx context.Context, cluster_name string, service_name string) (roles []jp.Api_role, err error) {
+  err = client.Get(ctx, Roles_path(cluster_name, service_name), func(reader io.Reader) (err error) {
+    roles, err = jp.Decode_api_roles(reader)
+    return err
+  })
+  return roles, err
+}
+
+
+// Returns the roles of the Cloudera Management Service
+func (client *Client) List_management_roles(ctx context.Context) (roles []jp.Api_role, err error) {
+  err = client.Get(ctx, Management_roles_path(), func(reader io.Reader) (err error) {
+    roles, err = jp.Decode_api_roles(reader)
+    return err
+  })
+  return roles, err
+}
+
+
+// Returns the nameservices of an HDFS service
+func (client *Client) List_nameservices(ctx context.Context, cluster_name string, service_name string) (nameservices []jp.Api_nameservice, err error) {
+  err = client.Get(ctx, Nameservices_path(cluster_name, service_name), func(reader io.Reader) (err error) {
+    nameservices, err = jp.Decode_api_nameservices(reader)
+    return err
+  })
+  return nameservices, err
+}
+
+
+// Returns the snapshot policies of a service
+func (client *Client) List_snapshot_policies(ctx context.Context, cluster_name string, service_name string) (policies []jp.Api_snapshot_policy, err error) {
+  err = client.Get(ctx, Snapshot_policies_path(cluster_name, service_name), func(reader io.Reader) (err error) {
+    policies, err = jp.Decode_api_snapshot_policies(reader)
+    return err
+  })
+  return policies, err
+}
+
+
+// Returns all the commands of the history of a snapshot policy. The commands
+// are requested page by page, so the snapshots retained are counted from the
+// whole history
+func (client *Client) List_snapshot_history(ctx context.Context, cluster_name string, service_name string, policy_name string) ([]jp.Api_snapshot_command, error) {
+  commands := []jp.Api_snapshot_command{}
+  var page []jp.Api_snapshot_command
+  err := client.get_pages(ctx, Snapshot_history_path(cluster_name, service_name, policy_name), nil, command_paging, func(reader io.Reader) (ids []string, err error) {
+    page, err = jp.Decode_api_snapshot_commands(reader)
+    ids = make([]string, len(page))
+    for index := range page {
+      ids[index] = page[index].Id.String()
+    }
+    return ids, err
+  }, func(index int) {
+    commands = append(commands, page[index])
+  })
+  return commands, err
+}
+
+
+// Returns the replication schedules of a service
+func (client *Client) List_replications(ctx context.Context, cluster_name string, service_name string) (schedules []jp.Api_replication_schedule, err error) {
+  err = client.Get(ctx, Replications_path(cluster_name, service_name), func(reader io.Reader) (err error) {
+    schedules, err = jp.Decode_api_replication_schedules(reader)
+    return err
+  })
+  return schedules, err
+}
+
+
+// Returns the newest runs of a replication schedule, up to the limit
+func (client *Client) List_replication_history(ctx context.Context, cluster_name string, service_name string, schedule_id string, limit int) (commands []jp.Api_replication_command, err error) {
+  path := With_parameters(Replication_history_path(cluster_name, service_name, schedule_id), url.Values{"limit": {strconv.Itoa(limit)}})
+  err = client.Get(ctx, path, func(reader io.Reader) (err error) {
+    commands, err = jp.Decode_api_replication_commands(reader)
+    return err
+  })
+  return commands, err
+}
+
+
+// Returns the daily usage of an HDFS service by user since the time. The HA
+// and federated services require the nameservice of the report, and the
+// others take an empty one
+func (client *Client) Hdfs_usage_report(ctx context.Context, cluster_name string, service_name string, nameservice string, from time.Time) (rows []jp.Api_hdfs_usage_report_row, err error) {
+  parameters := url.Values{"aggregation": {"daily"}, "from": {from.UTC().Format(time.RFC3339)}}
+  if nameservice != "" {
+    parameters.Set("nameservice", nameservice)
+  }
+  path := With_parameters(Hdfs_usage_report_path(cluster_name, service_name), parameters)
+  err = client.Get(ctx, path, func(reader io.Reader) (err error) {
+    rows, err = jp.Decode_api_hdfs_usage_report(reader)
+    return err
+  })
+  return rows, err
+}
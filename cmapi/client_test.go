@@ -0,0 +1,514 @@
+/*
+ *
+ * title           :cmapi/client_test.go
+ * description     :Tests of the typed client of the Cloudera Manager API
+ * author		       :Alejandro Villegas
+ * date            :2019/08/07
+ * version         :1.0
+ *
+ */
+package cmapi
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "os"
+  "reflect"
+  "strconv"
+  "strings"
+  "sync"
+  "testing"
+  "time"
+
+  // Own libraries
+  jp "keedio/cloudera_exporter/json_parser"
+  log "keedio/cloudera_exporter/logger"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// The logs of the queries are discarded
+func TestMain(m *testing.M) {
+  log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, 0)
+  os.Exit(m.Run())
+}
+
+
+// Fake Cloudera Manager that answers the escaped paths of the responses,
+// without the /api/v19/ prefix, and fails the rest with a 404 response. The
+// requested URLs are kept
+type test_server struct {
+  *httptest.Server
+  lock sync.Mutex
+  requests []string
+}
+
+func new_test_server(responses map[string]string) *test_server {
+  server := &test_server{}
+  server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    server.lock.Lock()
+    server.requests = append(server.requests, r.URL.RequestURI())
+    server.lock.Unlock()
+    if response, ok := responses[strings.TrimPrefix(r.URL.EscapedPath(), "/api/v19/")]; ok {
+      w.Write([]byte(response))
+      return
+    }
+    w.WriteHeader(http.StatusNotFound)
+  }))
+  return server
+}
+
+
+// Returns an events response with the number of events, from the first one
+func test_events_response(first int, num_events int) string {
+  events := make([]string, num_events)
+  for index := range events {
+    events[index] = fmt.Sprintf(`{"id": "e%d", "content": "Event %d", "category": "HEALTH_EVENT", "severity": "CRITICAL", "alert": true, "attributes": [{"name": "CLUSTER", "values": ["cluster1"]}]}`, first + index, first + index)
+  }
+  return `{"items": [` + strings.Join(events, ",") + `], "totalResults": ` + strconv.Itoa(num_events) + `}`
+}
+
+
+// Returns a hosts response with the number of hosts, from the first one
+func test_hosts_response(first int, num_hosts int) string {
+  hosts := make([]string, num_hosts)
+  for index := range hosts {
+    id := first + index
+    hosts[index] = fmt.Sprintf(`{"hostId": "h%d", "hostname": "host%d", "ipAddress": "10.0.0.%d", "rackId": "/default", "commissionState": "COMMISSIONED", "maintenanceMode": false, "healthSummary": "GOOD", "clusterRef": {"clusterName": "cluster1"}}`, id, id, id % 256)
+  }
+  return `{"items": [` + strings.Join(hosts, ",") + `]}`
+}
+
+
+func Test_Client_path_escaping(t *testing.T) {
+  server := new_test_server(map[string]string{
+    "clusters/Cluster%201/services": `{"items": [{"name": "hdfs"}]}`,
+    "clusters/a%2Fb%3Fc/services": `{"items": [{"name": "yarn"}]}`,
+    "clusters/Cluster%201/services/hdfs%201/nameservices": `{"items": [{"name": "ns1", "active": {"roleName": "nn1"}, "standBy": {"roleName": "nn2"}}]}`,
+  })
+  defer server.Close()
+  client := New_client(server.URL + "/api/", "v19", nil)
+
+  tests := []struct {
+    cluster_name string
+    service string
+  }{
+    {"Cluster 1", "hdfs"},
+    {"a/b?c", "yarn"},
+  }
+  for _, test := range tests {
+    services, err := client.List_services(context.Background(), test.cluster_name)
+    if err != nil || len(services) != 1 || services[0].Name != test.service {
+      t.Errorf("List_services(%q) = %v, %v, expected the service %s", test.cluster_name, services, err, test.service)
+    }
+  }
+
+  nameservices, err := client.List_nameservices(context.Background(), "Cluster 1", "hdfs 1")
+  if err != nil || len(nameservices) != 1 || nameservices[0].Active.Role_name != "nn1" || nameservices[0].Stand_by.Role_name != "nn2" {
+    t.Errorf("List_nameservices() = %v, %v, expected ns1 with nn1 and nn2", nameservices, err)
+  }
+}
+
+
+func Test_Client_pagination(t *testing.T) {
+  tests := []struct {
+    name string
+    num_events int
+    page_size int
+    requests []string
+  }{
+    {"single page", 3, 5, []string{"maxResults=5&resultOffset=0"}},
+    {"last page shorter", 5, 2, []string{"maxResults=2&resultOffset=0", "maxResults=2&resultOffset=2", "maxResults=2&resultOffset=4"}},
+    {"last page empty", 4, 2, []string{"maxResults=2&resultOffset=0", "maxResults=2&resultOffset=2", "maxResults=2&resultOffset=4"}},
+    {"no events", 0, 2, []string{"maxResults=2&resultOffset=0"}},
+  }
+  for _, test := range tests {
+    num_events := test.num_events
+    var requests []string
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+      requests = append(requests, r.URL.Query().Encode())
+      offset, _ := strconv.Atoi(r.URL.Query().Get("resultOffset"))
+      limit, _ := strconv.Atoi(r.URL.Query().Get("maxResults"))
+      if offset + limit > num_events {
+        limit = num_events - offset
+      }
+      if limit < 0 {
+        limit = 0
+      }
+      w.Write([]byte(test_events_response(offset, limit)))
+    }))
+    client := New_client(server.URL + "/api", "v19", nil)
+    client.Page_size = test.page_size
+    events, err := client.Events(context.Background(), "")
+    server.Close()
+
+    if err != nil || len(events) != test.num_events {
+      t.Errorf("%s: Events() = %d events, %v, expected %d", test.name, len(events), err, test.num_events)
+    }
+    for index, event := range events {
+      if event.Id != fmt.Sprintf("e%d", index) {
+        t.Errorf("%s: Events() event %d = %s, expected e%d", test.name, index, event.Id, index)
+      }
+    }
+    if strings.Join(requests, " ") != strings.Join(test.requests, " ") {
+      t.Errorf("%s: Events() requests %v, expected %v", test.name, requests, test.requests)
+    }
+  }
+}
+
+
+// The endpoints that ignore the paging parameters return the same items on
+// every page. The requests end on the first page without new items
+func Test_Client_pagination_ignored(t *testing.T) {
+  tests := []struct {
+    name string
+    num_events int
+    honor_limit bool
+    expected int
+  }{
+    {"offset and limit ignored", 150, false, 150},
+    {"offset ignored", 150, true, 100},
+    {"full page", 100, false, 100},
+  }
+  for _, test := range tests {
+    test := test
+    requests := 0
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+      requests++
+      num_events := test.num_events
+      if limit, _ := strconv.Atoi(r.URL.Query().Get("maxResults")); test.honor_limit && limit < num_events {
+        num_events = limit
+      }
+      w.Write([]byte(test_events_response(0, num_events)))
+    }))
+    events, err := New_client(server.URL + "/api", "v19", nil).Events(context.Background(), "category==HEALTH_EVENT")
+    server.Close()
+
+    if err != nil || len(events) != test.expected {
+      t.Errorf("%s: Events() = %d events, %v, expected %d", test.name, len(events), err, test.expected)
+    }
+    if requests != 2 {
+      t.Errorf("%s: Events() made %d requests, expected 2", test.name, requests)
+    }
+  }
+}
+
+
+// The hosts and the roles are not paginated, so they are requested once
+// without the paging parameters
+func Test_Client_unpaged_endpoints(t *testing.T) {
+  server := new_test_server(map[string]string{
+    "hosts": test_hosts_response(0, 150),
+    "clusters/cluster1/services/hdfs/roles": `{"items": [{"name": "hdfs-NAMENODE-1", "type": "NAMENODE", "hostRef": {"hostId": "h1"}}]}`,
+  })
+  defer server.Close()
+  client := New_client(server.URL + "/api", "v19", nil)
+
+  hosts, err := client.List_hosts(context.Background())
+  if err != nil || len(hosts) != 150 {
+    t.Errorf("List_hosts() = %d hosts, %v, expected 150", len(hosts), err)
+  }
+  roles, err := client.List_roles(context.Background(), "cluster1", "hdfs")
+  if err != nil || len(roles) != 1 || roles[0].Host_ref.Host_id != "h1" {
+    t.Errorf("List_roles() = %v, %v, expected hdfs-NAMENODE-1 in h1", roles, err)
+  }
+  expected := []string{"/api/v19/hosts", "/api/v19/clusters/cluster1/services/hdfs/roles"}
+  if strings.Join(server.requests, " ") != strings.Join(expected, " ") {
+    t.Errorf("Requests %v, expected %v", server.requests, expected)
+  }
+}
+
+
+func Test_Client_Time_series(t *testing.T) {
+  server := new_test_server(map[string]string{
+    "timeseries": `{"items": [{"timeSeries": [{"metadata": {"entityName": "host1", "attributes": {"hostname": "host1"}}, "data": [{"timestamp": "2019-08-07T08:00:00.000Z", "value": 42}]}]}, {"timeSeries": []}]}`,
+  })
+  defer server.Close()
+  client := New_client(server.URL + "/api", "v19", nil)
+
+  responses, err := client.Time_series(context.Background(), "SELECT cpu_percent;SELECT load_1", Time_series_options{Desired_rollup: "HOURLY"})
+  if err != nil || len(responses) != 2 || len(responses[0].Time_series) != 1 || len(responses[1].Time_series) != 0 {
+    t.Fatalf("Time_series() = %v, %v, expected two responses", responses, err)
+  }
+  if value, err := responses[0].Time_series[0].Get_value(); err != nil || value != 42 {
+    t.Errorf("Time_series() value = %v, %v, expected 42", value, err)
+  }
+  expected := "/api/v19/timeseries?contentType=application%2Fjson&desiredRollup=HOURLY&query=SELECT+cpu_percent%3BSELECT+load_1"
+  if len(server.requests) != 1 || server.requests[0] != expected {
+    t.Errorf("Time_series() requests %v, expected %s", server.requests, expected)
+  }
+
+  // The invalid options are not requested
+  if _, err := client.Time_series(context.Background(), "SELECT cpu_percent", Time_series_options{Desired_rollup: "MINUTELY"}); err == nil || len(server.requests) != 1 {
+    t.Errorf("Time_series() with an invalid rollup = %v, %d requests, expected an error without request", err, len(server.requests))
+  }
+}
+
+
+func Test_Client_error_statuses(t *testing.T) {
+  tests := []struct {
+    name string
+    status_code int
+    body string
+    error_status int
+  }{
+    {"success", http.StatusOK, `{"items": []}`, 0},
+    {"not modified", http.StatusNotModified, ``, 0},
+    {"unauthorized", http.StatusUnauthorized, `{"message": "Bad credentials"}`, http.StatusUnauthorized},
+    {"not found", http.StatusNotFound, ``, http.StatusNotFound},
+    {"server error", http.StatusInternalServerError, ``, http.StatusInternalServerError},
+    {"unavailable", http.StatusServiceUnavailable, ``, http.StatusServiceUnavailable},
+  }
+  for _, test := range tests {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+      w.WriteHeader(test.status_code)
+      w.Write([]byte(test.body))
+    }))
+    _, err := New_client(server.URL + "/api", "v19", nil).List_clusters(context.Background())
+    server.Close()
+
+    status_err, ok := err.(*Status_error)
+    if test.error_status == 0 {
+      if ok {
+        t.Errorf("%s: List_clusters() = %v, expected no status error", test.name, err)
+      }
+      continue
+    }
+    if !ok || status_err.Status_code != test.error_status {
+      t.Errorf("%s: List_clusters() = %v, expected a status error %d", test.name, err, test.error_status)
+    }
+  }
+}
+
+
+func Test_Client_credentials(t *testing.T) {
+  var user, passwd string
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    user, passwd, _ = r.BasicAuth()
+    w.Write([]byte("v19\n"))
+  }))
+  defer server.Close()
+
+  client := New_client(server.URL + "/api", "", nil)
+  passwords := []string{"first", "second"}
+  for _, password := range passwords {
+    password := password
+    client.User = "admin"
+    client.Passwd = func() string { return password }
+    version, err := client.Highest_api_version(context.Background())
+    if err != nil || version != "v19" {
+      t.Errorf("Highest_api_version() = %q, %v, expected v19", version, err)
+    }
+    if user != "admin" || passwd != password {
+      t.Errorf("Highest_api_version() credentials %s:%s, expected admin:%s", user, passwd, password)
+    }
+  }
+}
+
+
+func Test_Client_max_response_size(t *testing.T) {
+  body := `{"items": [{"hostId": "h1"}]}`
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    response := body
+    if r.URL.Query().Get("trailing") != "" {
+      response += strings.Repeat(" ", 10)
+    }
+    if r.URL.Query().Get("chunked") != "" {
+      // Without Content-Length, the size is only known while it's read
+      w.Write([]byte(response[:5]))
+      w.(http.Flusher).Flush()
+      response = response[5:]
+    }
+    w.Write([]byte(response))
+  }))
+  defer server.Close()
+
+  tests := []struct {
+    name string
+    parameters string
+    max_size int
+    valid bool
+  }{
+    {"without maximum size", "", 0, true},
+    {"maximum size", "", len(body), true},
+    {"larger than the Content-Length", "", len(body) - 1, false},
+    {"chunked maximum size", "?chunked=1", len(body), true},
+    {"chunked larger", "?chunked=1", len(body) - 1, false},
+    {"trailing spaces", "?chunked=1&trailing=1", 0, true},
+  }
+  for _, test := range tests {
+    client := New_client(server.URL, "", nil)
+    client.Max_response_size = test.max_size
+    var hosts []jp.Api_host
+    err := client.get_url(context.Background(), server.URL + test.parameters, func(reader io.Reader) (err error) {
+      hosts, err = jp.Decode_api_hosts(reader)
+      return err
+    })
+    if (err == nil) != test.valid {
+      t.Errorf("%s: get_url() = %v, expected valid %v", test.name, err, test.valid)
+    }
+    if test.valid && (len(hosts) != 1 || hosts[0].Host_id != "h1") {
+      t.Errorf("%s: get_url() decoded %v", test.name, hosts)
+    }
+    if !test.valid && (err == nil || !strings.Contains(err.Error(), "larger than the maximum size")) {
+      t.Errorf("%s: get_url() error %v, expected larger than the maximum size", test.name, err)
+    }
+  }
+}
+
+
+func Test_Build_path(t *testing.T) {
+  tests := []struct {
+    segments []string
+    expected string
+  }{
+    {[]string{"clusters"}, "clusters"},
+    {[]string{"clusters", "cluster1", "services"}, "clusters/cluster1/services"},
+    {[]string{"clusters", "Cluster 1"}, "clusters/Cluster%201"},
+    {[]string{"clusters", "a/b"}, "clusters/a%2Fb"},
+    {[]string{"clusters", "a?b#c"}, "clusters/a%3Fb%23c"},
+    {[]string{"clusters", "50%"}, "clusters/50%25"},
+    {[]string{"hosts", "höst"}, "hosts/h%C3%B6st"},
+  }
+  for _, test := range tests {
+    if path := Build_path(test.segments...); path != test.expected {
+      t.Errorf("Build_path(%q) = %s, expected %s", test.segments, path, test.expected)
+    }
+  }
+}
+
+
+func Test_With_parameters(t *testing.T) {
+  tests := []struct {
+    path string
+    parameters url.Values
+    expected string
+  }{
+    {"hosts", nil, "hosts"},
+    {"hosts", url.Values{}, "hosts"},
+    {"hosts", url.Values{"offset": {"0"}, "limit": {"100"}}, "hosts?limit=100&offset=0"},
+    {"hosts", url.Values{"view": {"full summary"}}, "hosts?view=full+summary"},
+  }
+  for _, test := range tests {
+    if path := With_parameters(test.path, test.parameters); path != test.expected {
+      t.Errorf("With_parameters(%s, %v) = %s, expected %s", test.path, test.parameters, path, test.expected)
+    }
+  }
+}
+
+
+func Test_paths(t *testing.T) {
+  tests := []struct {
+    path string
+    expected string
+  }{
+    {Clusters_path(), "clusters"},
+    {Cluster_path("Cluster 1"), "clusters/Cluster%201"},
+    {Host_templates_path("Cluster 1"), "clusters/Cluster%201/hostTemplates"},
+    {Services_path("a/b"), "clusters/a%2Fb/services"},
+    {Roles_path("Cluster 1", "hdfs 1"), "clusters/Cluster%201/services/hdfs%201/roles"},
+    {Nameservices_path("cluster1", "hdfs"), "clusters/cluster1/services/hdfs/nameservices"},
+    {Snapshot_policies_path("cluster1", "hdfs"), "clusters/cluster1/services/hdfs/snapshots/policies"},
+    {Snapshot_history_path("cluster1", "hdfs", "daily/tmp"), "clusters/cluster1/services/hdfs/snapshots/policies/daily%2Ftmp/history"},
+    {Replications_path("cluster1", "hive"), "clusters/cluster1/services/hive/replications"},
+    {Replication_history_path("cluster1", "hive", "12"), "clusters/cluster1/services/hive/replications/12/history"},
+    {Hdfs_usage_report_path("cluster1", "hdfs"), "clusters/cluster1/services/hdfs/reports/hdfsUsageReport"},
+    {Hosts_path(), "hosts"},
+    {Host_path("a?b"), "hosts/a%3Fb"},
+    {Management_service_path(), "cm/service"},
+    {Management_roles_path(), "cm/service/roles"},
+    {Events_path(), "events"},
+  }
+  for _, test := range tests {
+    if test.path != test.expected {
+      t.Errorf("Path %s, expected %s", test.path, test.expected)
+    }
+  }
+}
+
+
+func Test_Time_series_path(t *testing.T) {
+  from := time.Date(2019, 8, 7, 10, 0, 0, 0, time.FixedZone("CEST", 2 * 3600))
+  to := time.Date(2019, 8, 7, 9, 0, 0, 0, time.UTC)
+  tests := []struct {
+    name string
+    query string
+    options Time_series_options
+    expected url.Values
+  }{
+    {"plain query", "SELECT cpu_percent", Time_series_options{}, url.Values{"query": {"SELECT cpu_percent"}}},
+    {"quotes and equal signs", `SELECT x WHERE hostname="a=b"`, Time_series_options{}, url.Values{"query": {`SELECT x WHERE hostname="a=b"`}}},
+    {"ampersands and plus signs", "SELECT a + b WHERE name = \"x&y\"", Time_series_options{}, url.Values{"query": {"SELECT a + b WHERE name = \"x&y\""}}},
+    {"slashes and non ASCII", "SELECT x WHERE path = \"/tmp/ñ\"", Time_series_options{}, url.Values{"query": {"SELECT x WHERE path = \"/tmp/ñ\""}}},
+    {"time window in UTC", "SELECT x", Time_series_options{From: from, To: to},
+      url.Values{"query": {"SELECT x"}, "from": {"2019-08-07T08:00:00Z"}, "to": {"2019-08-07T09:00:00Z"}}},
+    {"rollup", "SELECT x", Time_series_options{Desired_rollup: "HOURLY", Must_use_desired_rollup: true},
+      url.Values{"query": {"SELECT x"}, "desiredRollup": {"HOURLY"}, "mustUseDesiredRollup": {"true"}}},
+    {"rollup not forced", "SELECT x", Time_series_options{Desired_rollup: "DAILY"},
+      url.Values{"query": {"SELECT x"}, "desiredRollup": {"DAILY"}}},
+    {"content type", "SELECT x", Time_series_options{Content_type: CONTENT_TYPE_CSV},
+      url.Values{"query": {"SELECT x"}, "contentType": {"text/csv"}}},
+  }
+  for _, test := range tests {
+    path := Time_series_path(test.query, test.options)
+    if !strings.HasPrefix(path, "timeseries?") {
+      t.Errorf("%s: Time_series_path() = %s, expected the timeseries endpoint", test.name, path)
+      continue
+    }
+    raw_query := strings.TrimPrefix(path, "timeseries?")
+    // The ampersands and plus signs are checked decoding the parameters
+    if strings.ContainsAny(raw_query, " \"/ñ") {
+      t.Errorf("%s: Time_series_path() = %s, expected the query encoded", test.name, path)
+    }
+    parameters, err := url.ParseQuery(raw_query)
+    if err != nil {
+      t.Errorf("%s: Time_series_path() = %s, invalid query: %v", test.name, path, err)
+      continue
+    }
+    if !reflect.DeepEqual(parameters, test.expected) {
+      t.Errorf("%s: Time_series_path() parameters = %v, expected %v", test.name, parameters, test.expected)
+    }
+  }
+}
+
+
+func Test_Time_series_options_Check(t *testing.T) {
+  from := time.Date(2019, 8, 7, 8, 0, 0, 0, time.UTC)
+  tests := []struct {
+    name string
+    options Time_series_options
+    valid bool
+  }{
+    {"default options", Time_series_options{}, true},
+    {"all the options", Time_series_options{From: from, To: from.Add(time.Hour), Desired_rollup: "TEN_MINUTELY", Must_use_desired_rollup: true, Content_type: CONTENT_TYPE_JSON}, true},
+    {"only the start", Time_series_options{From: from}, true},
+    {"only the end", Time_series_options{To: from}, true},
+    {"invalid rollup", Time_series_options{Desired_rollup: "MINUTELY"}, false},
+    {"lowercase rollup", Time_series_options{Desired_rollup: "hourly"}, false},
+    {"forced rollup without rollup", Time_series_options{Must_use_desired_rollup: true}, false},
+    {"invalid content type", Time_series_options{Content_type: "text/plain"}, false},
+    {"empty time window", Time_series_options{From: from, To: from}, false},
+    {"reversed time window", Time_series_options{From: from.Add(time.Hour), To: from}, false},
+  }
+  for _, test := range tests {
+    if err := test.options.Check(); (err == nil) != test.valid {
+      t.Errorf("%s: Check() = %v, expected valid %v", test.name, err, test.valid)
+    }
+  }
+}
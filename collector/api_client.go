@@ -0,0 +1,445 @@
+/*
+ *
+ * title           :collector/api_client.go
+ * description     :Retries and circuit breaker of the Cloudera Manager API queries
+ * author		       :Alejandro Villegas
+ * date            :2019/07/30
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "errors"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "math/rand"
+  "net/http"
+  "strconv"
+  "sync"
+  "time"
+
+  // Own libraries
+  log "keedio/cloudera_exporter/logger"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+
+
+
+/* ======================================================================
+ * Constants
+ * ====================================================================== */
+// Default options of the API client
+const (
+  // Retries of a failed query
+  API_DEFAULT_MAX_RETRIES = 2
+  // Wait before the first retry. It's doubled on each retry
+  API_DEFAULT_INITIAL_BACKOFF = time.Second
+  // Maximum wait between two retries
+  API_DEFAULT_MAX_BACKOFF = 10 * time.Second
+  // Consecutive failed queries that open the circuit
+  API_DEFAULT_CIRCUIT_FAILURES = 5
+  // Time the circuit stays open before trying a new query
+  API_DEFAULT_CIRCUIT_TIMEOUT = time.Minute
+  // TSquery statements sent in each request
+  API_DEFAULT_TSQUERY_BATCH_SIZE = 10
+  // Maximum size of a response, in bytes
+  API_DEFAULT_MAX_RESPONSE_SIZE = 64 * 1024 * 1024
+)
+
+// States of the circuit breaker of a Cloudera Manager
+const (
+  // The queries are made
+  CIRCUIT_CLOSED = iota
+  // The queries are skipped until the timeout expires
+  CIRCUIT_OPEN
+  // The timeout expired and a single query is made to probe the API
+  CIRCUIT_HALF_OPEN
+)
+
+
+
+
+/* ======================================================================
+ * Data Structs
+ * ====================================================================== */
+// Options of the queries to the Cloudera Manager API. The zero value makes a
+// single attempt of each query and never opens the circuit
+type Api_client_options struct {
+  Max_retries int
+  Initial_backoff time.Duration
+  Max_backoff time.Duration
+  // Consecutive failed queries that open the circuit. 0 to disable it
+  Circuit_failures int
+  Circuit_timeout time.Duration
+  // TSquery statements sent in each request. 0 or 1 to send one per request
+  Tsquery_batch_size int
+  // Concurrent queries of all the scrapers. 0 or 1 to make them one by one
+  Max_concurrent_queries int
+  // Rate limit of the queries. 0 requests per second to not limit them
+  Requests_per_second float64
+  Burst int
+  // Time the metadata queries are cached. 0 to not cache them
+  Metadata_cache_ttl time.Duration
+  // Whether the metrics of the queries have the TSquery text as label
+  Debug_query_label bool
+  // Maximum size of a response in bytes. 0 to not limit it
+  Max_response_size int
+}
+
+// Circuit breaker of a Cloudera Manager. While it's open the queries fail
+// without reaching the API. When the timeout expires it's half open: a single
+// query probes the API, while the rest are still skipped, and the circuit is
+// closed if it succeeds or opened again if it fails
+type circuit_breaker struct {
+  failures int
+  open_until time.Time
+  probing bool
+}
+
+// Transport of the HTTP client of the queries to a Cloudera Manager. Each
+// attempt of a query waits for the rate limit and a slot of the pool of the
+// Cloudera Manager, and is skipped if its circuit is open. Only the GET and
+// HEAD queries are retried. Unless direct is
+// set, like in the probe, which only records the instrumentation metrics
+type api_transport struct {
+  config Collector_connection_data
+  direct bool
+}
+
+// Body of a response that counts the bytes read and calls the function once,
+// when it's closed
+type instrumented_body struct {
+  io.ReadCloser
+  size int
+  on_close func(size int)
+}
+
+
+
+
+/* ======================================================================
+ * Global variables
+ * ====================================================================== */
+// Circuit breakers by Cloudera Manager address. They are shared by all the
+// scrapes of the same Cloudera Manager
+var circuit_breakers = make(map[string]*circuit_breaker)
+var circuit_breakers_mutex sync.Mutex
+
+// Error of the queries rejected by an open circuit
+var error_circuit_open = errors.New("Circuit open: the Cloudera Manager API failed too many times, query skipped")
+
+var circuitOpenDesc = new_metric_desc(
+  prometheus.BuildFQName(namespace, subsystem, "cm_circuit_open"),
+  "Whether the circuit breaker of the Cloudera Manager is open (1) and the queries are skipped, or closed (0).",
+  nil,
+  nil,
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Returns true if a response with this status code is worth retrying
+func is_retryable_status(status_code int) bool {
+  switch status_code {
+  case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+    return true
+  }
+  return false
+}
+
+
+// Returns true if a response with this status code shows the API is failing,
+// so it counts as a failure in the circuit breaker, even if it is not retried
+func is_failure_status(status_code int) bool {
+  return status_code >= http.StatusInternalServerError || is_retryable_status(status_code)
+}
+
+
+// Returns true if the request can be sent again after a failure: the GET and
+// HEAD requests, which don't change the Cloudera Manager
+func is_retryable_request(req *http.Request) bool {
+  return req.Method == "" || req.Method == http.MethodGet || req.Method == http.MethodHead
+}
+
+
+// Parse the Retry-After header of a response, in seconds or as an HTTP date
+// after now. Returns 0 if it's not set or not valid
+func parse_retry_after(header string, now time.Time) time.Duration {
+  if header == "" {
+    return 0
+  }
+  if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+    return time.Duration(seconds) * time.Second
+  }
+  if date, err := http.ParseTime(header); err == nil {
+    if wait := date.Sub(now); wait > 0 {
+      return wait
+    }
+  }
+  return 0
+}
+
+
+// Wait before a retry: the exponential backoff of the attempt with jitter, or
+// the Retry-After of the response if it's longer. The Retry-After is capped
+// at the maximum backoff, so a wrong header can't stall the scrape
+func retry_wait(options Api_client_options, attempt int, retry_after time.Duration) time.Duration {
+  backoff := options.Initial_backoff
+  for i := 0; i < attempt && backoff < options.Max_backoff; i++ {
+    backoff *= 2
+  }
+  if options.Max_backoff > 0 && backoff > options.Max_backoff {
+    backoff = options.Max_backoff
+  }
+  if backoff > 0 {
+    // Between the half and the whole backoff, so the retries of the
+    // concurrent queries are spread
+    backoff = backoff / 2 + time.Duration(rand.Int63n(int64(backoff / 2) + 1))
+  }
+  if options.Max_backoff > 0 && retry_after > options.Max_backoff {
+    retry_after = options.Max_backoff
+  }
+  if retry_after > backoff {
+    return retry_after
+  }
+  return backoff
+}
+
+
+// State of the circuit breaker at the given time
+func (breaker *circuit_breaker) state(options Api_client_options, now time.Time) int {
+  if options.Circuit_failures <= 0 || breaker.failures < options.Circuit_failures {
+    return CIRCUIT_CLOSED
+  }
+  if now.Before(breaker.open_until) {
+    return CIRCUIT_OPEN
+  }
+  return CIRCUIT_HALF_OPEN
+}
+
+
+// Returns whether a query can be made at the given time, and whether it's the
+// probe of the API. While the circuit is half open only the first query is
+// allowed, as the probe
+func (breaker *circuit_breaker) allow(options Api_client_options, now time.Time) (bool, bool) {
+  switch breaker.state(options, now) {
+  case CIRCUIT_CLOSED:
+    return true, false
+  case CIRCUIT_HALF_OPEN:
+    if !breaker.probing {
+      breaker.probing = true
+      return true, true
+    }
+  }
+  return false, false
+}
+
+
+// Update the circuit breaker with the result of a query made at the given
+// time. Returns the state of the circuit before and after the update
+func (breaker *circuit_breaker) record(options Api_client_options, failed bool, now time.Time) (int, int) {
+  previous := breaker.state(options, now)
+  breaker.probing = false
+  if !failed {
+    breaker.failures = 0
+    breaker.open_until = time.Time{}
+    return previous, CIRCUIT_CLOSED
+  }
+  breaker.failures++
+  if breaker.failures >= options.Circuit_failures {
+    breaker.open_until = now.Add(options.Circuit_timeout)
+  }
+  return previous, breaker.state(options, now)
+}
+
+
+// Returns the circuit breaker of a Cloudera Manager
+func get_circuit_breaker(config Collector_connection_data) *circuit_breaker {
+  address := fmt.Sprintf("%s:%s", config.Host, config.Port)
+  breaker, ok := circuit_breakers[address]
+  if !ok {
+    breaker = &circuit_breaker{}
+    circuit_breakers[address] = breaker
+  }
+  return breaker
+}
+
+
+// Returns the current state of the circuit of the Cloudera Manager
+func get_circuit_state(config Collector_connection_data) int {
+  if config.Client.Circuit_failures <= 0 {
+    return CIRCUIT_CLOSED
+  }
+  circuit_breakers_mutex.Lock()
+  defer circuit_breakers_mutex.Unlock()
+  return get_circuit_breaker(config).state(config.Client, time.Now())
+}
+
+
+// Returns true if the circuit of the Cloudera Manager is open or half open,
+// so the queries are skipped
+func Is_circuit_open(config Collector_connection_data) bool {
+  return get_circuit_state(config) != CIRCUIT_CLOSED
+}
+
+
+// Returns whether a query to the Cloudera Manager can be made now, and
+// whether it's the probe of a half open circuit. The result of an allowed
+// query must be recorded
+func allow_query(config Collector_connection_data) (bool, bool) {
+  if config.Client.Circuit_failures <= 0 {
+    return true, false
+  }
+  circuit_breakers_mutex.Lock()
+  defer circuit_breakers_mutex.Unlock()
+  return get_circuit_breaker(config).allow(config.Client, time.Now())
+}
+
+
+// Update the circuit breaker of the Cloudera Manager with the result of a
+// query. Only the failures of the API open the circuit, not the rejected
+// queries
+func record_query_result(config Collector_connection_data, failed bool) {
+  if config.Client.Circuit_failures <= 0 {
+    return
+  }
+  circuit_breakers_mutex.Lock()
+  defer circuit_breakers_mutex.Unlock()
+  breaker := get_circuit_breaker(config)
+  previous, current := breaker.record(config.Client, failed, time.Now())
+  if previous != CIRCUIT_CLOSED && current == CIRCUIT_CLOSED {
+    log.Info_msg("Circuit of the Cloudera Manager %s:%s closed", config.Host, config.Port)
+  } else if previous != CIRCUIT_OPEN && current == CIRCUIT_OPEN {
+    log.Warn_msg("Circuit of the Cloudera Manager %s:%s opened after %d failed queries. Queries skipped for %s", config.Host, config.Port, breaker.failures, config.Client.Circuit_timeout)
+  }
+}
+
+
+// Read from the body and count the bytes read
+func (body *instrumented_body) Read(buffer []byte) (int, error) {
+  read, err := body.ReadCloser.Read(buffer)
+  body.size += read
+  return read, err
+}
+
+
+// Close the body and call the function, the first time
+func (body *instrumented_body) Close() error {
+  err := body.ReadCloser.Close()
+  if body.on_close != nil {
+    body.on_close(body.size)
+    body.on_close = nil
+  }
+  return err
+}
+
+
+// Make an attempt of a query. The instrumentation metrics of the query are
+// recorded and the done function is called when the body of the response is
+// closed, or right away if there is no response
+func (transport *api_transport) attempt(req *http.Request, done func()) (*http.Response, error) {
+  uri := req.URL.String()
+  start := time.Now()
+  res, err := http.DefaultTransport.RoundTrip(req)
+  if err != nil {
+    record_api_request(transport.config, uri, 0, 0, err, time.Since(start))
+    done()
+    return nil, err
+  }
+  var status_err error
+  if res.StatusCode < 200 || res.StatusCode >= 400 {
+    status_err = fmt.Errorf("Invalid HTTP response code: %s", res.Status)
+  }
+  status_code := res.StatusCode
+  res.Body = &instrumented_body{ReadCloser: res.Body, on_close: func(size int) {
+    record_api_request(transport.config, uri, status_code, size, status_err, time.Since(start))
+    done()
+  }}
+  return res, nil
+}
+
+
+// Make a query to the Cloudera Manager API, retrying the connection errors
+// and the responses of an unavailable API with exponential backoff. The slot
+// of the pool is held until the body of the response is closed. The probe of
+// a half open circuit is not retried, and the response of the last attempt
+// is returned
+func (transport *api_transport) RoundTrip(req *http.Request) (*http.Response, error) {
+  if transport.direct {
+    return transport.attempt(req, func() {})
+  }
+  config := transport.config
+  ctx := req.Context()
+  uri := req.URL.String()
+
+  for attempt := 0; ; attempt++ {
+    // Don't wait for the rate limit and the pool if the circuit is open
+    if get_circuit_state(config) == CIRCUIT_OPEN {
+      log.Debug_msg("Skipping API Query: %s. %s", uri, error_circuit_open)
+      return nil, error_circuit_open
+    }
+    if err := wait_rate_limit(ctx, config); err != nil {
+      return nil, err
+    }
+    release, err := acquire_query_slot(ctx, config)
+    if err != nil {
+      return nil, err
+    }
+    allowed, probe := allow_query(config)
+    if !allowed {
+      release()
+      log.Debug_msg("Skipping API Query: %s. %s", uri, error_circuit_open)
+      return nil, error_circuit_open
+    }
+    res, err := transport.attempt(req, release)
+    retry_after := time.Duration(0)
+    if err == nil {
+      if !is_retryable_status(res.StatusCode) {
+        // Success, a failure of the API that is not worth retrying, like a
+        // 500 response, or a failure of the query, like a 404 response
+        record_query_result(config, is_failure_status(res.StatusCode))
+        return res, nil
+      }
+      retry_after = parse_retry_after(res.Header.Get("Retry-After"), time.Now())
+      err = fmt.Errorf("Invalid HTTP response code: %s", res.Status)
+    }
+    if probe || !is_retryable_request(req) || attempt >= config.Client.Max_retries || ctx.Err() != nil {
+      record_query_result(config, true)
+      if res != nil {
+        return res, nil
+      }
+      return nil, err
+    }
+    if res != nil {
+      // The body is read so the connection can be reused
+      io.Copy(ioutil.Discard, res.Body)
+      res.Body.Close()
+    }
+
+    wait := retry_wait(config.Client, attempt, retry_after)
+    log.Debug_msg("Retrying API Query: %s in %s. Error: %s", uri, wait, err)
+    timer := time.NewTimer(wait)
+    select {
+    case <-ctx.Done():
+      timer.Stop()
+      record_query_result(config, true)
+      return nil, err
+    case <-timer.C:
+    }
+  }
+}
@@ -0,0 +1,272 @@
+/*
+ *
+ * title           :collector/api_client_test.go
+ * description     :Tests of the retries and the circuit breaker of the Cloudera Manager API queries
+ * author		       :Alejandro Villegas
+ * date            :2019/07/30
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "strconv"
+  "strings"
+  "sync/atomic"
+  "testing"
+  "time"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+func Test_parse_retry_after(t *testing.T) {
+  now := time.Date(2019, 7, 30, 12, 0, 0, 0, time.UTC)
+  tests := []struct {
+    header string
+    expected time.Duration
+  }{
+    {"", 0},
+    {"5", 5 * time.Second},
+    {"0", 0},
+    {"-5", 0},
+    {"soon", 0},
+    {"Tue, 30 Jul 2019 12:01:00 GMT", time.Minute},
+    {"Tue, 30 Jul 2019 11:59:00 GMT", 0},
+  }
+  for _, test := range tests {
+    if wait := parse_retry_after(test.header, now); wait != test.expected {
+      t.Errorf("parse_retry_after(%q) = %s, expected %s", test.header, wait, test.expected)
+    }
+  }
+}
+
+
+func Test_retry_wait(t *testing.T) {
+  options := Api_client_options{Initial_backoff: time.Second, Max_backoff: 10 * time.Second}
+  tests := []struct {
+    name string
+    options Api_client_options
+    attempt int
+    retry_after time.Duration
+    min time.Duration
+    max time.Duration
+  }{
+    {"first attempt", options, 0, 0, 500 * time.Millisecond, time.Second},
+    {"third attempt", options, 2, 0, 2 * time.Second, 4 * time.Second},
+    {"capped backoff", options, 10, 0, 5 * time.Second, 10 * time.Second},
+    {"longer retry after", options, 0, 3 * time.Second, 3 * time.Second, 3 * time.Second},
+    {"shorter retry after", options, 2, time.Millisecond, 2 * time.Second, 4 * time.Second},
+    {"capped retry after", options, 0, time.Hour, 10 * time.Second, 10 * time.Second},
+    {"no backoff", Api_client_options{}, 3, 0, 0, 0},
+    {"retry after without maximum", Api_client_options{}, 0, time.Hour, time.Hour, time.Hour},
+  }
+  for _, test := range tests {
+    // The jitter is random, so each case is checked several times
+    for i := 0; i < 20; i++ {
+      if wait := retry_wait(test.options, test.attempt, test.retry_after); wait < test.min || wait > test.max {
+        t.Errorf("%s: retry_wait() = %s, expected between %s and %s", test.name, wait, test.min, test.max)
+        break
+      }
+    }
+  }
+}
+
+
+func Test_circuit_breaker(t *testing.T) {
+  options := Api_client_options{Circuit_failures: 2, Circuit_timeout: time.Minute}
+  start := time.Date(2019, 7, 30, 12, 0, 0, 0, time.UTC)
+
+  // Each step allows a query or records its result, and checks the state of
+  // the circuit afterwards
+  const (
+    ALLOW = iota
+    SUCCEED
+    FAIL
+  )
+  steps := []struct {
+    name string
+    action int
+    elapsed time.Duration
+    allowed bool
+    probe bool
+    state int
+  }{
+    {"closed allows", ALLOW, 0, true, false, CIRCUIT_CLOSED},
+    {"first failure", FAIL, 0, false, false, CIRCUIT_CLOSED},
+    {"closed after one failure", ALLOW, 0, true, false, CIRCUIT_CLOSED},
+    {"second failure opens", FAIL, 0, false, false, CIRCUIT_OPEN},
+    {"open rejects", ALLOW, 30 * time.Second, false, false, CIRCUIT_OPEN},
+    {"half open after the timeout", ALLOW, time.Minute, true, true, CIRCUIT_HALF_OPEN},
+    {"single probe", ALLOW, time.Minute, false, false, CIRCUIT_HALF_OPEN},
+    {"failed probe opens again", FAIL, time.Minute, false, false, CIRCUIT_OPEN},
+    {"open again rejects", ALLOW, 90 * time.Second, false, false, CIRCUIT_OPEN},
+    {"new probe", ALLOW, 2 * time.Minute, true, true, CIRCUIT_HALF_OPEN},
+    {"successful probe closes", SUCCEED, 2 * time.Minute, false, false, CIRCUIT_CLOSED},
+    {"closed again allows", ALLOW, 2 * time.Minute, true, false, CIRCUIT_CLOSED},
+  }
+  breaker := circuit_breaker{}
+  for _, step := range steps {
+    now := start.Add(step.elapsed)
+    allowed, probe := false, false
+    switch step.action {
+    case ALLOW:
+      allowed, probe = breaker.allow(options, now)
+    case SUCCEED:
+      breaker.record(options, false, now)
+    case FAIL:
+      breaker.record(options, true, now)
+    }
+    if allowed != step.allowed || probe != step.probe {
+      t.Errorf("%s: allow() = %v, %v, expected %v, %v", step.name, allowed, probe, step.allowed, step.probe)
+    }
+    if state := breaker.state(options, now); state != step.state {
+      t.Errorf("%s: state() = %d, expected %d", step.name, state, step.state)
+    }
+  }
+
+  // Disabled circuit breaker
+  disabled := circuit_breaker{failures: 10, open_until: start.Add(time.Hour)}
+  if allowed, _ := disabled.allow(Api_client_options{}, start); !allowed {
+    t.Errorf("allow() of a disabled circuit breaker = false, expected true")
+  }
+}
+
+
+func Test_api_transport(t *testing.T) {
+  var requests, failing int32 = 0, 1
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    atomic.AddInt32(&requests, 1)
+    if atomic.LoadInt32(&failing) == 1 {
+      w.WriteHeader(http.StatusServiceUnavailable)
+      return
+    }
+    w.Write([]byte("v19"))
+  }))
+  defer server.Close()
+  config := new_test_connection(t, server, Api_client_options{Max_retries: 2, Initial_backoff: time.Millisecond, Max_backoff: time.Millisecond, Circuit_failures: 1, Circuit_timeout: 50 * time.Millisecond})
+
+  steps := []struct {
+    name string
+    wait time.Duration
+    fix bool
+    requests int32
+    valid bool
+    circuit_open bool
+  }{
+    {"retried and opens the circuit", 0, false, 3, false, true},
+    {"skipped while open", 0, false, 0, false, true},
+    {"single failed probe", 60 * time.Millisecond, false, 1, false, true},
+    {"successful probe", 60 * time.Millisecond, true, 1, true, false},
+    {"closed", 0, false, 1, true, false},
+  }
+  for _, step := range steps {
+    time.Sleep(step.wait)
+    if step.fix {
+      atomic.StoreInt32(&failing, 0)
+    }
+    atomic.StoreInt32(&requests, 0)
+    body, err := new_cmapi_client(config).Highest_api_version(context.Background())
+    if count := atomic.LoadInt32(&requests); count != step.requests {
+      t.Errorf("%s: %d requests, expected %d", step.name, count, step.requests)
+    }
+    if (err == nil) != step.valid || (err == nil && body != "v19") {
+      t.Errorf("%s: Highest_api_version() = %q, %v, expected valid %v", step.name, body, err, step.valid)
+    }
+    if Is_circuit_open(config) != step.circuit_open {
+      t.Errorf("%s: Is_circuit_open() = %v, expected %v", step.name, Is_circuit_open(config), step.circuit_open)
+    }
+  }
+}
+
+
+
+// The queries that change the Cloudera Manager are not retried, and the
+// server errors that are not retried still open the circuit
+func Test_api_transport_failures(t *testing.T) {
+  var requests int32
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    atomic.AddInt32(&requests, 1)
+    status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+    w.WriteHeader(status)
+  }))
+  defer server.Close()
+
+  tests := []struct {
+    name string
+    method string
+    status int
+    requests int32
+    circuit_open bool
+  }{
+    {"retried GET", http.MethodGet, http.StatusServiceUnavailable, 3, true},
+    {"retried HEAD", http.MethodHead, http.StatusBadGateway, 3, true},
+    {"POST not retried", http.MethodPost, http.StatusServiceUnavailable, 1, true},
+    {"PUT not retried", http.MethodPut, http.StatusTooManyRequests, 1, true},
+    {"internal server error", http.MethodGet, http.StatusInternalServerError, 1, true},
+    {"not implemented", http.MethodGet, http.StatusNotImplemented, 1, true},
+    {"not found", http.MethodGet, http.StatusNotFound, 1, false},
+    {"success", http.MethodGet, http.StatusOK, 1, false},
+  }
+  for index, test := range tests {
+    // Each case has its own circuit breaker, by the address of the config.
+    // The requests go to the URL of the server anyway
+    server_url, _ := url.Parse(server.URL)
+    config := Collector_connection_data{
+      Host: fmt.Sprintf("case%d.%s", index, server_url.Hostname()),
+      Port: server_url.Port(),
+      Client: Api_client_options{Max_retries: 2, Initial_backoff: time.Millisecond, Max_backoff: time.Millisecond, Circuit_failures: 1, Circuit_timeout: time.Minute, Max_concurrent_queries: 1},
+    }
+    transport := &api_transport{config: config}
+    req, err := http.NewRequest(test.method, fmt.Sprintf("%s/api/v19/cm/version?status=%d", server.URL, test.status), nil)
+    if err != nil {
+      t.Fatal(err)
+    }
+    atomic.StoreInt32(&requests, 0)
+    res, err := transport.RoundTrip(req)
+    if err != nil || res.StatusCode != test.status {
+      t.Errorf("%s: RoundTrip() = %v, %v, expected status %d", test.name, res, err, test.status)
+    }
+    if res != nil {
+      res.Body.Close()
+    }
+    if count := atomic.LoadInt32(&requests); count != test.requests {
+      t.Errorf("%s: %d requests, expected %d", test.name, count, test.requests)
+    }
+    if Is_circuit_open(config) != test.circuit_open {
+      t.Errorf("%s: Is_circuit_open() = %v, expected %v", test.name, Is_circuit_open(config), test.circuit_open)
+    }
+  }
+}
+
+func Test_instrumented_body(t *testing.T) {
+  closed := []int{}
+  body := &instrumented_body{
+    ReadCloser: ioutil.NopCloser(strings.NewReader("0123456789")),
+    on_close: func(size int) { closed = append(closed, size) },
+  }
+  buffer := make([]byte, 4)
+  body.Read(buffer)
+  body.Read(buffer)
+  body.Close()
+  body.Close()
+  if len(closed) != 1 || closed[0] != 8 {
+    t.Errorf("instrumented_body closed with the sizes %v, expected a single close with 8", closed)
+  }
+}
@@ -0,0 +1,238 @@
+/*
+ *
+ * title           :collector/api_instrumentation.go
+ * description     :Latency, status codes and response sizes of the Cloudera Manager API queries
+ * author		       :Alejandro Villegas
+ * date            :2019/08/05
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "fmt"
+  "net/url"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+
+
+
+/* ======================================================================
+ * Constants
+ * ====================================================================== */
+// Endpoint class of the queries that don't match any known endpoint
+const API_ENDPOINT_OTHER = "other"
+
+// Status code label of the queries without response, like the connection
+// errors
+const API_STATUS_CODE_ERROR = "error"
+
+
+
+
+/* ======================================================================
+ * Data Structs
+ * ====================================================================== */
+// Instrumentation of the queries to a Cloudera Manager
+type api_request_stats struct {
+  // Whether the metrics have the query label with the TSquery text
+  query_label bool
+  duration *prometheus.HistogramVec
+  responses *prometheus.CounterVec
+  size *prometheus.HistogramVec
+}
+
+
+
+
+/* ======================================================================
+ * Global variables
+ * ====================================================================== */
+// Instrumentation by Cloudera Manager address, shared by all the scrapes of
+// the same Cloudera Manager
+var api_request_stats_by_address = make(map[string]*api_request_stats)
+var api_request_stats_mutex sync.Mutex
+
+// Collections of the API whose next path segment is the name or the ID of an
+// item, not part of the endpoint
+var api_item_collections = map[string]bool {
+  "clusters": true, "hostTemplates": true, "hosts": true, "policies": true,
+  "replications": true, "roles": true, "services": true,
+}
+
+// Endpoint classes by the path of the query without the items names and IDs
+var api_endpoint_classes = map[string]string {
+  "version": "api_version",
+  "timeseries": "timeseries",
+  "events": "events",
+  "hosts": "hosts",
+  "clusters": "clusters",
+  "clusters/hostTemplates": "host_templates",
+  "clusters/services": "services",
+  "clusters/services/roles": "roles",
+  "clusters/services/nameservices": "nameservices",
+  "clusters/services/snapshots/policies": "snapshot_policies",
+  "clusters/services/snapshots/policies/history": "snapshot_history",
+  "clusters/services/replications": "replications",
+  "clusters/services/replications/history": "replication_history",
+  "clusters/services/reports/hdfsUsageReport": "hdfs_usage_report",
+  "cm/service": "cm_service",
+  "cm/service/roles": "cm_service_roles",
+  "cm/version": "cm_version",
+}
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Returns the instrumentation of a Cloudera Manager. It's created again if
+// the query label is enabled or disabled when the config is reloaded
+func get_api_request_stats(config Collector_connection_data) *api_request_stats {
+  address := fmt.Sprintf("%s:%s", config.Host, config.Port)
+
+  api_request_stats_mutex.Lock()
+  defer api_request_stats_mutex.Unlock()
+  stats, ok := api_request_stats_by_address[address]
+  if ok && stats.query_label == config.Client.Debug_query_label {
+    return stats
+  }
+  labels := []string{"endpoint"}
+  if config.Client.Debug_query_label {
+    labels = append(labels, "query")
+  }
+  stats = &api_request_stats {
+    query_label: config.Client.Debug_query_label,
+    duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+      Namespace: namespace,
+      Subsystem: subsystem,
+      Name: "cm_request_duration_seconds",
+      Help: "Duration of the queries to the Cloudera Manager API by endpoint class.",
+      Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+    }, labels),
+    responses: prometheus.NewCounterVec(prometheus.CounterOpts{
+      Namespace: namespace,
+      Subsystem: subsystem,
+      Name: "cm_requests_total",
+      Help: "Total number of queries to the Cloudera Manager API by endpoint class and status code.",
+    }, append([]string{"code"}, labels...)),
+    size: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+      Namespace: namespace,
+      Subsystem: subsystem,
+      Name: "cm_response_size_bytes",
+      Help: "Size of the responses of the Cloudera Manager API by endpoint class.",
+      Buckets: prometheus.ExponentialBuckets(1024, 4, 8),
+    }, labels),
+  }
+  api_request_stats_by_address[address] = stats
+  return stats
+}
+
+
+// Returns the endpoint class of the URL of a query: the path of the endpoint
+// without the API version and the names and IDs of the items, like roles for
+// clusters/<cluster>/services/<service>/roles. The escaped path is split, so
+// the names with slashes are still a single segment
+func get_api_endpoint_class(uri string) string {
+  parsed, err := url.Parse(uri)
+  if err != nil {
+    return API_ENDPOINT_OTHER
+  }
+  segments := strings.Split(strings.Trim(parsed.EscapedPath(), "/"), "/")
+  if len(segments) > 0 && segments[0] == "api" {
+    segments = segments[1:]
+  }
+  // The query of the API version is the only one without version
+  if len(segments) > 1 {
+    segments = segments[1:]
+  }
+
+  endpoint := []string{}
+  for index := 0; index < len(segments); index++ {
+    endpoint = append(endpoint, segments[index])
+    if api_item_collections[segments[index]] {
+      index++
+    }
+  }
+  if class, ok := api_endpoint_classes[strings.Join(endpoint, "/")]; ok {
+    return class
+  }
+  return API_ENDPOINT_OTHER
+}
+
+
+// Returns the TSquery text of the URL of a query, or an empty string if it's
+// not a TSquery. The client encodes the semicolons between the statements of a
+// batch as %3B, but the parameters are split by hand so a raw semicolon of a
+// URL built elsewhere doesn't lose the query, as url.ParseQuery rejects it
+func get_api_tsquery(uri string) string {
+  parsed, err := url.Parse(uri)
+  if err != nil {
+    return ""
+  }
+  for _, parameter := range strings.Split(parsed.RawQuery, "&") {
+    if !strings.HasPrefix(parameter, "query=") {
+      continue
+    }
+    if query, err := url.QueryUnescape(strings.TrimPrefix(parameter, "query=")); err == nil {
+      return query
+    }
+  }
+  return ""
+}
+
+
+// Record a query to the Cloudera Manager API. The status code is 0 if the
+// query got no response, and the size is only recorded for the success ones.
+// The TSquery text is only recorded if the query label is enabled
+func record_api_request(config Collector_connection_data, uri string, status_code int, size int, err error, duration time.Duration) {
+  stats := get_api_request_stats(config)
+  labels := []string{get_api_endpoint_class(uri)}
+  if stats.query_label {
+    labels = append(labels, get_api_tsquery(uri))
+  }
+  code := API_STATUS_CODE_ERROR
+  if status_code > 0 {
+    code = strconv.Itoa(status_code)
+  }
+
+  stats.duration.WithLabelValues(labels...).Observe(duration.Seconds())
+  stats.responses.WithLabelValues(append([]string{code}, labels...)...).Inc()
+  if err == nil {
+    stats.size.WithLabelValues(labels...).Observe(float64(size))
+  }
+}
+
+
+// Send the descriptions of the instrumentation metrics of the Cloudera
+// Manager
+func describe_api_request_stats(config Collector_connection_data, ch chan<- *prometheus.Desc) {
+  stats := get_api_request_stats(config)
+  stats.duration.Describe(ch)
+  stats.responses.Describe(ch)
+  stats.size.Describe(ch)
+}
+
+
+// Send the instrumentation metrics of the Cloudera Manager
+func collect_api_request_stats(config Collector_connection_data, ch chan<- prometheus.Metric) {
+  stats := get_api_request_stats(config)
+  stats.duration.Collect(ch)
+  stats.responses.Collect(ch)
+  stats.size.Collect(ch)
+}
@@ -0,0 +1,92 @@
+/*
+ *
+ * title           :collector/api_instrumentation_test.go
+ * description     :Tests of the metrics of the queries to the Cloudera Manager API
+ * author		       :Alejandro Villegas
+ * date            :2019/08/01
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "testing"
+
+  // Own libraries
+  "keedio/cloudera_exporter/cmapi"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+func Test_get_api_endpoint_class(t *testing.T) {
+  base := "http://cm:7180/api/v19/"
+  tests := []struct {
+    uri string
+    expected string
+  }{
+    {"http://cm:7180/api/version", "api_version"},
+    {base + "timeseries?query=SELECT%20cpu_percent", "timeseries"},
+    {base + "hosts", "hosts"},
+    {base + "hosts/5d1f-host-id", "hosts"},
+    {base + "clusters", "clusters"},
+    {base + "clusters/cluster1", "clusters"},
+    {base + "clusters/cluster1/hostTemplates", "host_templates"},
+    {base + "clusters/cluster1/services", "services"},
+    {base + "clusters/cluster1/services/hdfs/roles", "roles"},
+    {base + "clusters/cluster1/services/hdfs/nameservices", "nameservices"},
+    {base + "clusters/cluster1/services/hdfs/snapshots/policies", "snapshot_policies"},
+    {base + "clusters/cluster1/services/hdfs/snapshots/policies/daily/history", "snapshot_history"},
+    {base + "clusters/cluster1/services/hdfs/replications", "replications"},
+    {base + "clusters/cluster1/services/hdfs/replications/12/history", "replication_history"},
+    {base + "clusters/cluster1/services/hdfs/reports/hdfsUsageReport?aggregation=daily", "hdfs_usage_report"},
+    {base + "cm/service", "cm_service"},
+    {base + "cm/service/roles", "cm_service_roles"},
+    {base + "cm/version", "cm_version"},
+    {base + "clusters/Cluster%201/services/hdfs%2Fnn/roles", "roles"},
+    {base + "clusters/cluster1/services/hdfs/roles/role1/process", API_ENDPOINT_OTHER},
+    {base + "events?resultOffset=0&maxResults=100", "events"},
+    {base + "tools/echo", API_ENDPOINT_OTHER},
+    {"http://cm:7180/", API_ENDPOINT_OTHER},
+    {"http://cm:7180/api/v19/%zz", API_ENDPOINT_OTHER},
+  }
+  for _, test := range tests {
+    if class := get_api_endpoint_class(test.uri); class != test.expected {
+      t.Errorf("get_api_endpoint_class(%q) = %q, expected %q", test.uri, class, test.expected)
+    }
+  }
+}
+
+
+func Test_get_api_tsquery(t *testing.T) {
+  base := "http://cm:7180/api/v19/timeseries?"
+  tests := []struct {
+    uri string
+    expected string
+  }{
+    {base + "query=SELECT%20cpu_percent", "SELECT cpu_percent"},
+    {base + "query=SELECT+cpu_percent+WHERE+hostname%3D%22h1%22", `SELECT cpu_percent WHERE hostname="h1"`},
+    {base + "from=2019-08-01T00%3A00%3A00Z&query=SELECT%20a;SELECT%20b&contentType=application%2Fjson", "SELECT a;SELECT b"},
+    {base + "query=SELECT+a%3BSELECT+b&contentType=application%2Fjson", "SELECT a;SELECT b"},
+    {"http://cm:7180/api/v19/" + cmapi.Time_series_path(`SELECT a WHERE x="1;2";SELECT b`, cmapi.Time_series_options{}), `SELECT a WHERE x="1;2";SELECT b`},
+    {base + "desiredRollup=HOURLY", ""},
+    {base + "query=%zz", ""},
+    {"http://cm:7180/api/v19/hosts", ""},
+    {"http://cm:7180/api/v19/hosts?view=full", ""},
+  }
+  for _, test := range tests {
+    if query := get_api_tsquery(test.uri); query != test.expected {
+      t.Errorf("get_api_tsquery(%q) = %q, expected %q", test.uri, query, test.expected)
+    }
+  }
+}
@@ -34,6 +34,13 @@ const subsystem = "exporter"
 
 
 
+/* ======================================================================
+ * Global variables
+ * ====================================================================== */
+
+
+
+
 /* ======================================================================
  * Exporter collects Cloudera Manager metrics. It implements prometheus.Collector.
  * ====================================================================== */
@@ -43,6 +50,10 @@ type Collector_connection_data struct {
   Api_version string
   User string
   Passwd string
+  // File with the password. If it is set, Passwd is not used
+  Passwd_file string
+  // Retries and circuit breaker of the queries
+  Client Api_client_options
 }
 
 type Collector struct {
@@ -72,9 +83,16 @@ func New(ctx context.Context, config Collector_connection_data, metrics Metrics,
 // Describe implements prometheus.Collector.
 func (c *Collector) Describe (ch chan<- *prometheus.Desc) {
 	ch <- c.metrics.TotalScrapes.Desc()
-	ch <- c.metrics.Error.Desc()
 	c.metrics.ScrapeErrors.Describe(ch)
-	ch <- c.metrics.CMUp.Desc()
+	ch <- upDesc
+	ch <- lastScrapeErrorDesc
+	ch <- collectorSuccessDesc
+	ch <- queriesFailedDesc
+	ch <- circuitOpenDesc
+	ch <- requestsThrottledDesc
+	ch <- metadataCacheHitsDesc
+	ch <- metadataCacheMissesDesc
+	describe_api_request_stats(c.config, ch)
 }
 
 
@@ -82,7 +100,15 @@ func (c *Collector) Describe (ch chan<- *prometheus.Desc) {
 func (c *Collector) Collect (ch chan<- prometheus.Metric) {
 	c.scrape(c.ctx, ch)
 	ch <- c.metrics.TotalScrapes
-	ch <- c.metrics.Error
 	c.metrics.ScrapeErrors.Collect(ch)
-	ch <- c.metrics.CMUp
+	circuit_open := 0.0
+	if Is_circuit_open(c.config) {
+		circuit_open = 1
+	}
+	ch <- prometheus.MustNewConstMetric(circuitOpenDesc, prometheus.GaugeValue, circuit_open)
+	ch <- prometheus.MustNewConstMetric(requestsThrottledDesc, prometheus.CounterValue, float64(Get_throttled_requests(c.config)))
+	cache_hits, cache_misses := Get_metadata_cache_stats(c.config)
+	ch <- prometheus.MustNewConstMetric(metadataCacheHitsDesc, prometheus.CounterValue, float64(cache_hits))
+	ch <- prometheus.MustNewConstMetric(metadataCacheMissesDesc, prometheus.CounterValue, float64(cache_misses))
+	collect_api_request_stats(c.config, ch)
 }
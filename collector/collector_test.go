@@ -0,0 +1,38 @@
+/*
+ *
+ * title           :collector/collector_test.go
+ * description     :Common setup of the tests of the collector package
+ * author		       :Alejandro Villegas
+ * date            :2019/07/02
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "io/ioutil"
+  "os"
+  "testing"
+
+  // Own libraries
+  log "keedio/cloudera_exporter/logger"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// The loggers are discarded, so the output of the tests is only theirs
+func TestMain(m *testing.M) {
+  log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, 0)
+  os.Exit(m.Run())
+}
@@ -20,12 +20,13 @@ import (
 	"context"
 	"net/http"
   "errors"
-	"io/ioutil"
   "fmt"
-  "strconv"
   "strings"
+  "sync"
+  "time"
 
   // Own libraries
+  "keedio/cloudera_exporter/cmapi"
   jp "keedio/cloudera_exporter/json_parser"
   log "keedio/cloudera_exporter/logger"
 
@@ -40,9 +41,11 @@ import (
 /* ======================================================================
  * Constants
  * ====================================================================== */
-const MASTER_POS = 0
-const BORDER_POS = 1
-const WORKER_POS = 2
+// Node class of the hosts that do not match any classification rule
+const NODE_CLASS_UNCLASSIFIED = "unclassified"
+
+// Maximum duration of the probe of the Cloudera Manager API
+const PROBE_TIMEOUT = 10 * time.Second
 
 
 
@@ -53,294 +56,319 @@ const WORKER_POS = 2
 // Structure to relate the sentence of TSquery with its metric of Prometheus
 type relation struct {
   Query string
-  Metric_struct prometheus.Desc
+  Metric_struct *prometheus.Desc
+}
+
+// Structure to classify the hosts by the types of the roles they run. A host
+// belongs to the first class with any of its role types
+type Node_class_rule struct {
+  Class string
+  Role_types []string
 }
 
 
 /* ======================================================================
- * Functions
+ * Global variables
  * ====================================================================== */
- // Make the query specified to the Cloudera Manager API and returns the JSON response
-func make_query(ctx context.Context, uri string, user string, passwd string) (body string, err error) {
-  log.Debug_msg("Making API Query: %s ", uri)
+// Classification rules used when the config file does not define any
+var Default_node_class_rules = []Node_class_rule {
+  {"master", []string{"NAMENODE", "SECONDARYNAMENODE", "JOURNALNODE", "FAILOVERCONTROLLER", "RESOURCEMANAGER", "JOBHISTORY", "MASTER", "HIVEMETASTORE", "HIVESERVER2", "CATALOGSERVER", "STATESTORE", "SERVER", "OOZIE_SERVER", "SERVICEMONITOR", "HOSTMONITOR", "ACTIVITYMONITOR", "EVENTSERVER", "ALERTPUBLISHER", "REPORTSMANAGER", "NAVIGATOR", "NAVIGATORMETASERVER"}},
+  {"worker", []string{"DATANODE", "NODEMANAGER", "REGIONSERVER", "IMPALAD", "KUDU_TSERVER"}},
+  {"border", []string{"GATEWAY", "HUE_SERVER", "HTTPFS", "HBASETHRIFTSERVER", "HBASERESTSERVER"}},
+}
 
-  // Get HTTP Protocol Client
-  httpClient := http.DefaultClient
+// Highest API version of the Cloudera Managers whose version is not set in
+// the config file, by address. It's queried on their first scrape
+var api_versions = make(map[string]string)
+var api_versions_mutex sync.Mutex
 
-  // Build the request Object
-  req, err := http.NewRequest(http.MethodGet, uri, nil)
 
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Add the role types of the roles listed by the function to the set of role
+// types of each host. The lock protects the sets, as the queries run in
+// parallel
+func add_hosts_role_types(ctx context.Context, list_roles func(ctx context.Context) ([]jp.Api_role, error), host_role_types map[string] map[string]bool, lock *sync.Mutex) {
+  roles, err := list_roles(ctx)
   if err != nil {
-    log.Err_msg("Building Request for URL:%s, Failed. Error: %s", uri, err)
-    return "", err
+    log.Err_msg("Error listing the roles: %s", err)
+    return
   }
+  lock.Lock()
+  defer lock.Unlock()
+  for _, role := range roles {
+    if _, ok := host_role_types[role.Host_ref.Host_id]; ok {
+      host_role_types[role.Host_ref.Host_id][role.Type] = true
+    }
+  }
+}
 
-  // Overwrite request with timeout context.
-  if ctx != nil {
-    req = req.WithContext(ctx)
+
+// Returns the class of a host given the role types it runs and the
+// classification rules. Rules are evaluated in order
+func classify_node(role_types map[string]bool, rules []Node_class_rule) string {
+  for _, rule := range rules {
+    for _, role_type := range rule.Role_types {
+      if role_types[role_type] {
+        return rule.Class
+      }
+    }
   }
+  return NODE_CLASS_UNCLASSIFIED
+}
 
-  // Request response header
-  req.Header.Add("Content-Type", "application/json")
 
-  // Set Authentication credentials
-  req.SetBasicAuth(user, passwd)
+// Fill and return a map with the host_id as key and its node class as value.
+// The roles of every service of every cluster, and the Cloudera Management
+// Service roles, are applied to the classification rules
+func get_node_class_list (ctx context.Context, config Collector_connection_data, rules []Node_class_rule) map[string] string {
+  node_map := make(map[string] string)
+  host_role_types := make(map[string] map[string]bool)
 
-  // Make the API request
-  res, err := httpClient.Do(req)
+  client := new_metadata_client(config)
+
+  // Get Hosts list
+  hosts, err := client.List_hosts(ctx)
   if err != nil {
-    log.Err_msg("%s", err)
-    return "" ,err
+    log.Err_msg("Error listing the hosts: %s", err)
+    return node_map
   }
-  if res == nil {
-    log.Err_msg("HTTP response is NULL")
-    return "", errors.New("HTTP response is NULL")
+  for _, host := range hosts {
+    host_role_types[host.Host_id] = make(map[string]bool)
   }
-  if res.StatusCode < 200 || res.StatusCode >= 400 {
-    log.Err_msg("Invalid HTTP response code: %s for the request: %s", res.Status, uri)
-    res.Body.Close()
-    return "" , errors.New("Invalid HTTP response code")
-  }
-
-  // Get Body Response
-  content, err := ioutil.ReadAll(res.Body)
 
-  if err != nil {
-    log.Err_msg("Failed to parse response with error: %s", err)
-    res.Body.Close()
-    return "" ,err
+  // Cloudera Management Service roles, and roles of each service of each
+  // Cluster, queried in parallel
+  var lock sync.Mutex
+  tasks := []func(){
+    func() { add_hosts_role_types(ctx, client.List_management_roles, host_role_types, &lock) },
   }
+  clusters, err := client.List_clusters(ctx)
+  if err == nil {
+    for _, cluster := range clusters {
+      services, err := client.List_services(ctx, cluster.Name)
+      if err != nil {
+        continue
+      }
+      for _, service := range services {
+        cluster_name, service_name := cluster.Name, service.Name
+        list_roles := func(ctx context.Context) ([]jp.Api_role, error) {
+          return client.List_roles(ctx, cluster_name, service_name)
+        }
+        tasks = append(tasks, func() { add_hosts_role_types(ctx, list_roles, host_role_types, &lock) })
+      }
+    }
+  }
+  run_parallel(tasks)
 
-  res.Body.Close()
-
-  return string(content), err
-}
-
-
-// Create a empty map to storage the host_id as Key and a list of flags for Border, Worker or Master Host Role
-func init_host_types_map(ctx context.Context, config Collector_connection_data) map[string] []string {
-  node_map := make(map[string] []string)
-
-  // Get Hosts list
-  json_hosts_data, _ := make_query(
-    ctx,
-    jp.Build_api_query_url(
-      config.Host,
-      config.Port,
-      config.Api_version,
-      fmt.Sprintf("hosts")),
-    config.User,
-    config.Passwd,
-  )
-  json_hosts_results := jp.Parse_json_response(json_hosts_data)
-  num_hosts, _ := strconv.Atoi(jp.Get_json_field(json_hosts_results, "items.#"))
-  for host_index := 0; host_index < num_hosts; host_index ++ {
-    host_id := jp.Get_json_field(json_hosts_results, fmt.Sprintf("items.%d.hostId", host_index))
-    node_map[host_id] = []string {"0", "0", "0"}
+  for host_id, role_types := range host_role_types {
+    node_map[host_id] = classify_node(role_types, rules)
   }
   return node_map
 }
 
 
-// Activate the flags for the border nodes
-func look_for_border_nodes(ctx context.Context, config Collector_connection_data, cluster_name string, node_map map[string] []string) map[string] []string {
-  json_type_data, _ := make_query(
-    ctx,
-    jp.Build_api_query_url(
-      config.Host,
-      config.Port,
-      config.Api_version,
-      fmt.Sprintf("clusters/%s/services/hdfs/roles", cluster_name)),
-    config.User,
-    config.Passwd,
-  )
-
-  // Parse JSON Response
-  json_type_results := jp.Parse_json_response(json_type_data)
-
-  // For each Host
-  num_hosts, _ := strconv.Atoi(jp.Get_json_field(json_type_results, "items.#"))
-  for host_index := 0; host_index < num_hosts; host_index ++ {
-    host_id := jp.Get_json_field(json_type_results, fmt.Sprintf("items.%d.hostRef.hostId", host_index))
-    host_type := jp.Get_json_field(json_type_results, fmt.Sprintf("items.%d.type", host_index))
-    _, ok := node_map[host_id];
-    if strings.Contains(host_type, "GATEWAY") && ok {
-      node_map[host_id][BORDER_POS] = "1"
-    }
+// Return the node class of a host
+func get_node_class (node_class_list map[string] string, host_id string) string {
+  if node_class, ok := node_class_list[host_id]; ok {
+    return node_class
   }
-  return node_map
+  return NODE_CLASS_UNCLASSIFIED
 }
 
 
-// Activate the flags for the worker nodes
-func look_for_worker_nodes(ctx context.Context, config Collector_connection_data, cluster_name string, node_map map[string] []string) map[string] []string {
-  json_type_data, _ := make_query(
-    ctx,
-    jp.Build_api_query_url(
-      config.Host,
-      config.Port,
-      config.Api_version,
-      fmt.Sprintf("clusters/%s/services/hdfs/roles", cluster_name)),
-    config.User,
-    config.Passwd,
-  )
-
-  // Parse JSON Response
-  json_type_results := jp.Parse_json_response(json_type_data)
-
-  // For each Host
-  num_hosts, _ := strconv.Atoi(jp.Get_json_field(json_type_results, "items.#"))
-  for host_index := 0; host_index < num_hosts; host_index ++ {
-    host_id := jp.Get_json_field(json_type_results, fmt.Sprintf("items.%d.hostRef.hostId", host_index))
-    host_type := jp.Get_json_field(json_type_results, fmt.Sprintf("items.%d.type", host_index))
-    if strings.Contains(host_type, "DATANODE") {
-      node_map[host_id][WORKER_POS] = "1"
-    }
+// Make the TSquery and decode the responses of its statements, in the order
+// of the statements, while the response is received
+func make_timeseries_query(ctx context.Context, config Collector_connection_data, query string, options cmapi.Time_series_options) (responses []jp.Api_time_series_response, err error) {
+  responses, err = new_cmapi_client(config).Time_series(ctx, query, options)
+  if err != nil {
+    log.Err_msg("Error making query: %s", err)
   }
-  return node_map
+  return responses, err
 }
 
 
-// Activate the flags for the master nodes
-func look_for_master_nodes(ctx context.Context, config Collector_connection_data, cluster_name string, node_map map[string] []string) map[string] []string {
-  json_master_data, _ := make_query(
-    ctx,
-    jp.Build_api_query_url(
-      config.Host,
-      config.Port,
-      config.Api_version,
-      fmt.Sprintf("cm/service/roles")),
-    config.User,
-      config.Passwd,
-  )
-
-  // Parse JSON Response
-  json_master_results := jp.Parse_json_response(json_master_data)
+// Make the TSqueries in batches of several statements, separated by
+// semicolons, and returns the decoded response and the error of each query,
+// in the order of the queries. The statements of a failed batch return its
+// error
+func make_and_parse_timeseries_queries(ctx context.Context, config Collector_connection_data, queries []string) ([]jp.Api_time_series_response, []error) {
+  results := make([]jp.Api_time_series_response, len(queries))
+  errs := make([]error, len(queries))
+  batch_size := config.Client.Tsquery_batch_size
+  if batch_size < 1 {
+    batch_size = 1
+  }
 
-  // For each Host
-  num_hosts, _ := strconv.Atoi(jp.Get_json_field(json_master_results, "items.#"))
-  for host_index := 0; host_index < num_hosts; host_index ++ {
-    host_id := jp.Get_json_field(json_master_results, fmt.Sprintf("items.%d.hostRef.hostId", host_index))
-    host_type := jp.Get_json_field(json_master_results, fmt.Sprintf("items.%d.serviceRef.serviceName", host_index))
-    if strings.Contains(host_type, "mgmt") {
-      node_map[host_id][MASTER_POS] = "1"
+  // The batches are made in parallel. Each one fills its own range of the
+  // results
+  tasks := []func(){}
+  for start := 0; start < len(queries); start += batch_size {
+    end := start + batch_size
+    if end > len(queries) {
+      end = len(queries)
     }
+    start := start
+    tasks = append(tasks, func() {
+      responses, err := make_timeseries_query(ctx, config, strings.Join(queries[start:end], ";"), cmapi.Time_series_options{})
+      if err == nil {
+        if len(responses) == end - start {
+          copy(results[start:end], responses)
+          return
+        }
+        err = fmt.Errorf("Expected %d TSquery results and got %d", end - start, len(responses))
+        log.Err_msg("Error making query: %s", err)
+      }
+      for index := start; index < end; index++ {
+        errs[index] = err
+      }
+    })
   }
-  return node_map
+  run_parallel(tasks)
+  return results, errs
 }
 
 
-// fill and return the role map of hosts
-func get_type_node_list (ctx context.Context, config Collector_connection_data) map[string] []string {
-  node_map := init_host_types_map(ctx, config)
-
-  // Get Cluster list
-  json_clusters_data, _ := make_query(
-    ctx,
-    jp.Build_api_query_url(
-      config.Host,
-      config.Port,
-      config.Api_version,
-      fmt.Sprintf("clusters")),
-    config.User,
-    config.Passwd,
-  )
+// Make the TSqueries of the relations of a module in batches and create the
+// metrics of each response with the function of the module. The relations
+// without query or whose metrics are not published are skipped. Returns the
+// number of success and failed queries
+func scrape_timeseries_relations(ctx context.Context, config Collector_connection_data, relations []relation, create_metric func(response jp.Api_time_series_response, query string, metric_struct *prometheus.Desc) bool) (int, int) {
+  success_queries := 0
+  error_queries := 0
+  selected := []relation{}
+  queries := []string{}
+  for index := range relations {
+    if relations[index].Query == "" || !is_metric_scraped(ctx, relations[index].Metric_struct) {
+      continue
+    }
+    selected = append(selected, relations[index])
+    queries = append(queries, relations[index].Query)
+  }
 
-  // Parse JSON Response
-  json_clusters_results := jp.Parse_json_response(json_clusters_data)
-  // For each Cluster
-  num_clusters, _ := strconv.Atoi(jp.Get_json_field(json_clusters_results, "items.#"))
-  for cluster_index := 0; cluster_index < num_clusters; cluster_index ++ {
-    cluster_name := jp.Get_json_field(json_clusters_results, fmt.Sprintf("items.%d.name", cluster_index))
-    node_map = look_for_border_nodes(ctx, config, cluster_name, node_map)
-    node_map = look_for_master_nodes(ctx, config, cluster_name, node_map)
-    node_map = look_for_worker_nodes(ctx, config, cluster_name, node_map)
+  results, errs := make_and_parse_timeseries_queries(ctx, config, queries)
+  for index, selected_relation := range selected {
+    if errs[index] == nil && create_metric(results[index], selected_relation.Query, selected_relation.Metric_struct) {
+      success_queries += 1
+    } else {
+      error_queries += 1
+    }
   }
-  return node_map
+  return success_queries, error_queries
 }
 
 
-// Return the is_master flag
-func get_if_is_master (host_id string) string {
-  return string(type_node_list[host_id][MASTER_POS])
+// Make and parse a Cloudera API Query
+func make_and_parse_api_query(ctx context.Context, config Collector_connection_data, query string) (result gjson.Result, err error) {
+  // Make query
+  var json_response string
+  err = new_cmapi_client(config).Get(ctx, query, cmapi.Read_body(&json_response))
+
+  // parse and return the result
+  return jp.Parse_json_response(json_response), err
 }
 
 
-// Return the is_border flag
-func get_if_is_border (host_id string) string {
-  return type_node_list[host_id][BORDER_POS]
+// Returns a client of the Cloudera Manager API whose queries are made with
+// retries, rate limit and the circuit breaker of the Cloudera Manager. The
+// responses are decoded while they are received
+func new_cmapi_client(config Collector_connection_data) *cmapi.Client {
+  client := cmapi.New_client(
+    fmt.Sprintf("http://%s:%s/api", config.Host, config.Port),
+    config.Api_version,
+    &http.Client{Transport: &api_transport{config: config}},
+  )
+  client.User = config.User
+  client.Passwd = config.Get_passwd
+  client.Max_response_size = config.Client.Max_response_size
+  return client
 }
 
 
-// Return the is_worker flag
-func get_if_is_worker (host_id string) string {
-  return type_node_list[host_id][WORKER_POS]
+// Returns a string with the Cloudera Manager version
+func get_cloudera_manager_version(ctx context.Context, config Collector_connection_data) string {
+  version, err := new_metadata_client(config).Version(ctx)
+  if err != nil {
+    return ""
+  }
+  return version.Version
 }
 
 
-// Make the query and parse the json response.
-func make_and_parse_timeseries_query(ctx context.Context, config Collector_connection_data, query string) (result gjson.Result, err error) {
-  // Make query
-  json_timeseries, err := make_query(
-    ctx,
-    jp.Build_timeseries_api_query_url(
-      config.Host,
-      config.Port,
-      config.Api_version,
-      jp.Encode_tsquery_to_http(query)),
-    config.User,
-    config.Passwd,
-  )
-
-  // parse and return the result
+// Check the connection to the Cloudera Manager API and the permissions of the
+// user, making a query of the API
+func Check_connection(ctx context.Context, config Collector_connection_data) error {
+  config, err := resolve_api_version(ctx, config)
   if err != nil {
-    log.Err_msg("Error making query: %s", err)
+    return err
+  }
+  if _, err = new_api_client(config).List_clusters(ctx); err != nil {
+    return fmt.Errorf("Can't query the clusters of the Cloudera Manager API. Check the user permissions: %s", err)
   }
-  return jp.Parse_json_response(json_timeseries), err
+  return nil
 }
 
 
-// Make and parse a Cloudera API Query
-func make_and_parse_api_query(ctx context.Context, config Collector_connection_data, query string) (result gjson.Result, err error) {
-  // Make query
-  json_timeseries, err := make_query(
-    ctx,
-    jp.Build_api_query_url(
-      config.Host,
-      config.Port,
-      config.Api_version,
-      query),
-    config.User,
-    config.Passwd,
-  )
+// Check if the Cloudera Manager API is reachable with a single query of the
+// API version, the lightest one. The probe skips the retries, the circuit
+// breaker, the rate limit and the pool, so it reflects the state of the API
+// right now
+func Probe_cloudera_manager(ctx context.Context, config Collector_connection_data) bool {
+  if ctx == nil {
+    ctx = context.Background()
+  }
+  ctx, cancel := context.WithTimeout(ctx, PROBE_TIMEOUT)
+  defer cancel()
 
-  // parse and return the result
-  return jp.Parse_json_response(json_timeseries), err
+  client := new_cmapi_client(config)
+  client.Http_client = &http.Client{Transport: &api_transport{config: config, direct: true}}
+  _, err := client.Highest_api_version(ctx)
+  return err == nil
 }
 
 
-// Returns a string with the Cloudera Manager version
-func get_cloudera_manager_version(ctx context.Context, config Collector_connection_data) string {
-  // Make query
-  json_parsed, err := make_and_parse_api_query(ctx, config, "cm/version")
-  if err != nil {
-    return ""
+// Returns the connection with its API version. If the config file does not
+// set it, the highest version of the Cloudera Manager is queried on its first
+// scrape and kept until the metadata cache is invalidated, so the config can
+// be loaded while the Cloudera Manager is unreachable
+func resolve_api_version(ctx context.Context, config Collector_connection_data) (Collector_connection_data, error) {
+  if config.Api_version != "" {
+    return config, nil
+  }
+  address := fmt.Sprintf("%s:%s", config.Host, config.Port)
+  api_versions_mutex.Lock()
+  version, ok := api_versions[address]
+  api_versions_mutex.Unlock()
+  if !ok {
+    var err error
+    if version, err = Get_api_cloudera_version(ctx, config); err != nil {
+      return config, err
+    }
+    api_versions_mutex.Lock()
+    api_versions[address] = version
+    api_versions_mutex.Unlock()
   }
-  return jp.Get_api_query_cm_version(json_parsed)
+  config.Api_version = version
+  return config, nil
+}
+
+
+// Forget the API versions queried to the Cloudera Managers, so they are
+// queried again on their next scrape
+func invalidate_api_versions() {
+  api_versions_mutex.Lock()
+  defer api_versions_mutex.Unlock()
+  api_versions = make(map[string]string)
 }
 
 
 // Returns a string with the highest version of the Cloudera API
 func Get_api_cloudera_version(ctx context.Context, config Collector_connection_data) (string, error) {
   // Make query
-  json_parsed, err := make_query(
-    ctx,
-    fmt.Sprintf("http://%s:%s/api/version", config.Host, config.Port),
-    config.User,
-    config.Passwd,
-  )
+  version, err := new_cmapi_client(config).Highest_api_version(ctx)
   if err != nil {
     return "", errors.New("The exporter can not determine the API version by consulting the cloudera Manager API")
   }
-  return json_parsed, nil
+  return version, nil
 }
@@ -0,0 +1,286 @@
+/*
+ *
+ * title           :collector/common_module_test.go
+ * description     :Tests of the common code to all the Scrapers
+ * author		       :Alejandro Villegas
+ * date            :2019/07/03
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "encoding/json"
+  "errors"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "reflect"
+  "strings"
+  "sync"
+  "sync/atomic"
+  "testing"
+
+  // Own libraries
+  jp "keedio/cloudera_exporter/json_parser"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Returns the connection data to the fake Cloudera Manager of the test server
+func new_test_connection(t *testing.T, server *httptest.Server, options Api_client_options) Collector_connection_data {
+  server_url, err := url.Parse(server.URL)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return Collector_connection_data{
+    Host: server_url.Hostname(),
+    Port: server_url.Port(),
+    Api_version: "v19",
+    User: "user",
+    Passwd: "passwd",
+    Client: options,
+  }
+}
+
+
+// Returns a set with the role types
+func role_type_set(role_types ...string) map[string]bool {
+  set := make(map[string]bool)
+  for _, role_type := range role_types {
+    set[role_type] = true
+  }
+  return set
+}
+
+
+func Test_classify_node(t *testing.T) {
+  rules := []Node_class_rule{
+    {"master", []string{"NAMENODE", "RESOURCEMANAGER"}},
+    {"worker", []string{"DATANODE"}},
+    {"border", []string{"GATEWAY"}},
+  }
+  tests := []struct {
+    name string
+    role_types map[string]bool
+    rules []Node_class_rule
+    expected string
+  }{
+    {"single rule", role_type_set("DATANODE"), rules, "worker"},
+    {"first rule wins", role_type_set("GATEWAY", "DATANODE", "RESOURCEMANAGER"), rules, "master"},
+    {"unknown role types", role_type_set("KAFKA_BROKER"), rules, NODE_CLASS_UNCLASSIFIED},
+    {"no roles", role_type_set(), rules, NODE_CLASS_UNCLASSIFIED},
+    {"no rules", role_type_set("DATANODE"), nil, NODE_CLASS_UNCLASSIFIED},
+    {"default rules", role_type_set("IMPALAD"), Default_node_class_rules, "worker"},
+  }
+  for _, test := range tests {
+    if node_class := classify_node(test.role_types, test.rules); node_class != test.expected {
+      t.Errorf("%s: classify_node() = %q, expected %q", test.name, node_class, test.expected)
+    }
+  }
+}
+
+
+func Test_get_node_class(t *testing.T) {
+  node_class_list := map[string]string{"host1": "worker"}
+  tests := []struct {
+    host_id string
+    expected string
+  }{
+    {"host1", "worker"},
+    {"host2", NODE_CLASS_UNCLASSIFIED},
+  }
+  for _, test := range tests {
+    if node_class := get_node_class(node_class_list, test.host_id); node_class != test.expected {
+      t.Errorf("get_node_class(%q) = %q, expected %q", test.host_id, node_class, test.expected)
+    }
+  }
+}
+
+
+func Test_add_hosts_role_types(t *testing.T) {
+  list_roles := func(ctx context.Context) ([]jp.Api_role, error) {
+    role := func(role_type string, host_id string) jp.Api_role {
+      return jp.Api_role{Type: role_type, Host_ref: jp.Api_host_ref{Host_id: host_id}}
+    }
+    return []jp.Api_role{role("DATANODE", "host1"), role("GATEWAY", "host1"), role("NAMENODE", "unknown")}, nil
+  }
+  failing_list_roles := func(ctx context.Context) ([]jp.Api_role, error) {
+    return nil, errors.New("query failed")
+  }
+
+  var lock sync.Mutex
+  host_role_types := map[string]map[string]bool{"host1": role_type_set(), "host2": role_type_set()}
+  add_hosts_role_types(context.Background(), list_roles, host_role_types, &lock)
+  add_hosts_role_types(context.Background(), failing_list_roles, host_role_types, &lock)
+
+  expected := map[string]map[string]bool{"host1": role_type_set("DATANODE", "GATEWAY"), "host2": role_type_set()}
+  if !reflect.DeepEqual(host_role_types, expected) {
+    t.Errorf("add_hosts_role_types() = %v, expected %v", host_role_types, expected)
+  }
+}
+
+
+// Fake timeseries endpoint, that answers each statement with a response of
+// the statement, counting the requests. The statements "fail" fail their
+// whole request and the statements "lost" get no response
+func new_test_timeseries_server(requests *int32) *httptest.Server {
+  return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    atomic.AddInt32(requests, 1)
+    items := []jp.Api_time_series_response{}
+    for _, statement := range strings.Split(r.URL.Query().Get("query"), ";") {
+      switch statement {
+      case "fail":
+        w.WriteHeader(http.StatusBadRequest)
+        return
+      case "lost":
+        continue
+      }
+      items = append(items, jp.Api_time_series_response{Time_series_query: statement})
+    }
+    json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+  }))
+}
+
+
+func Test_make_and_parse_timeseries_queries(t *testing.T) {
+  var requests int32
+  server := new_test_timeseries_server(&requests)
+  defer server.Close()
+
+  queries := []string{"q1", "q2", "q3", "q4", "q5"}
+  tests := []struct {
+    name string
+    batch_size int
+    queries []string
+    requests int32
+    failed []bool
+  }{
+    {"no batches", 0, queries, 5, []bool{false, false, false, false, false}},
+    {"one statement per batch", 1, queries, 5, []bool{false, false, false, false, false}},
+    {"batches of two", 2, queries, 3, []bool{false, false, false, false, false}},
+    {"single batch", 10, queries, 1, []bool{false, false, false, false, false}},
+    {"no queries", 2, []string{}, 0, []bool{}},
+    {"failed batch", 2, []string{"q1", "q2", "fail", "q4", "q5"}, 3, []bool{false, false, true, true, false}},
+    {"lost response", 2, []string{"q1", "lost", "q3"}, 2, []bool{true, true, false}},
+    {"failed statement alone", 1, []string{"q1", "fail", "q3"}, 3, []bool{false, true, false}},
+  }
+  for _, test := range tests {
+    config := new_test_connection(t, server, Api_client_options{Tsquery_batch_size: test.batch_size, Max_concurrent_queries: 2})
+    atomic.StoreInt32(&requests, 0)
+    results, errs := make_and_parse_timeseries_queries(context.Background(), config, test.queries)
+    if count := atomic.LoadInt32(&requests); count != test.requests {
+      t.Errorf("%s: %d requests, expected %d", test.name, count, test.requests)
+    }
+    if len(results) != len(test.queries) || len(errs) != len(test.queries) {
+      t.Errorf("%s: %d results and %d errors, expected %d", test.name, len(results), len(errs), len(test.queries))
+      continue
+    }
+    // Each query gets its own response, in the order of the queries
+    for index, query := range test.queries {
+      if failed := errs[index] != nil; failed != test.failed[index] {
+        t.Errorf("%s: query %s error = %v, expected failed %v", test.name, query, errs[index], test.failed[index])
+      } else if !failed && results[index].Time_series_query != query {
+        t.Errorf("%s: query %s got the response of %q", test.name, query, results[index].Time_series_query)
+      }
+    }
+  }
+}
+
+
+func Test_scrape_timeseries_relations(t *testing.T) {
+  var requests int32
+  server := new_test_timeseries_server(&requests)
+  defer server.Close()
+  config := new_test_connection(t, server, Api_client_options{Tsquery_batch_size: 2})
+
+  published := new_metric_desc("kbdi_test_relations_published", "Test metric", nil, nil)
+  dropped := new_metric_desc("kbdi_test_relations_dropped", "Test metric", nil, nil)
+  relations := []relation{
+    {"q1", published},
+    {"", published},
+    {"q2", dropped},
+    {"rejected", published},
+    {"fail", published},
+  }
+  ctx := with_module_options(context.Background(), &Module_options{Metric_denylist: []string{"kbdi_test_relations_dropped"}})
+
+  // The relations without query or not published are not queried, and the
+  // failed batches and the metrics not created count as failed queries
+  created := []string{}
+  create_metric := func(response jp.Api_time_series_response, query string, metric_struct *prometheus.Desc) bool {
+    created = append(created, query)
+    return query != "rejected"
+  }
+  success_queries, error_queries := scrape_timeseries_relations(ctx, config, relations, create_metric)
+  if success_queries != 1 || error_queries != 2 {
+    t.Errorf("scrape_timeseries_relations() = %d, %d, expected 1, 2", success_queries, error_queries)
+  }
+  if strings.Join(created, ",") != "q1,rejected" {
+    t.Errorf("scrape_timeseries_relations() created the metrics of %v, expected [q1 rejected]", created)
+  }
+  if count := atomic.LoadInt32(&requests); count != 2 {
+    t.Errorf("scrape_timeseries_relations() made %d requests, expected 2", count)
+  }
+}
+
+
+func Test_resolve_api_version(t *testing.T) {
+  var requests int32
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    atomic.AddInt32(&requests, 1)
+    w.Write([]byte("v19\n"))
+  }))
+  defer server.Close()
+  defer invalidate_api_versions()
+  pinned := new_test_connection(t, server, Api_client_options{})
+  pinned.Api_version = "v33"
+  unpinned := new_test_connection(t, server, Api_client_options{})
+  unpinned.Api_version = ""
+  unreachable := Collector_connection_data{Host: "127.0.0.1", Port: "1"}
+
+  // The version is queried on the first scrape of the target and kept until
+  // the metadata cache is invalidated. A failed query is not kept
+  tests := []struct {
+    name string
+    config Collector_connection_data
+    invalidate bool
+    expected string
+    requests int32
+    valid bool
+  }{
+    {"version in the config", pinned, false, "v33", 0, true},
+    {"first scrape", unpinned, false, "v19", 1, true},
+    {"next scrape", unpinned, false, "v19", 0, true},
+    {"after the invalidation", unpinned, true, "v19", 1, true},
+    {"unreachable", unreachable, false, "", 0, false},
+  }
+  for _, test := range tests {
+    if test.invalidate {
+      Invalidate_metadata_cache()
+    }
+    atomic.StoreInt32(&requests, 0)
+    config, err := resolve_api_version(context.Background(), test.config)
+    if (err == nil) != test.valid || config.Api_version != test.expected {
+      t.Errorf("%s: resolve_api_version() = %q, %v, expected %q", test.name, config.Api_version, err, test.expected)
+    }
+    if count := atomic.LoadInt32(&requests); count != test.requests {
+      t.Errorf("%s: %d requests, expected %d", test.name, count, test.requests)
+    }
+  }
+}
@@ -0,0 +1,120 @@
+/*
+ *
+ * title           :collector/credentials.go
+ * description     :Credentials of the Cloudera Manager API connection
+ * author		       :Alejandro Villegas
+ * date            :2019/07/24
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "fmt"
+  "io/ioutil"
+  "os"
+  "strings"
+  "sync"
+  "time"
+
+  // Own libraries
+  log "keedio/cloudera_exporter/logger"
+)
+
+
+
+
+/* ======================================================================
+ * Data Structs
+ * ====================================================================== */
+// Content of a password file and the state of the file when it was read
+type password_file_cache struct {
+  mod_time time.Time
+  size int64
+  password string
+  // Last error reading the file, to log it only once
+  last_error string
+}
+
+
+
+
+/* ======================================================================
+ * Global variables
+ * ====================================================================== */
+// Password files read, by path. The connection data is copied by value, so
+// the cache is shared by all the copies
+var password_files = make(map[string]*password_file_cache)
+var password_files_mutex sync.Mutex
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Read a password file. The trailing line breaks are removed
+func Read_password_file(file_name string) (string, error) {
+  content, err := ioutil.ReadFile(file_name)
+  if err != nil {
+    return "", err
+  }
+  password := strings.TrimRight(string(content), "\r\n")
+  if password == "" {
+    return "", fmt.Errorf("Empty password file: %s", file_name)
+  }
+  return password, nil
+}
+
+
+// Returns the password of the connection. If a password file is configured,
+// it is read again when it changes, so rotated passwords are used without a
+// restart. If the file can't be read, the last password read is used
+func (config Collector_connection_data) Get_passwd() string {
+  if config.Passwd_file == "" {
+    return config.Passwd
+  }
+
+  password_files_mutex.Lock()
+  defer password_files_mutex.Unlock()
+  cached, ok := password_files[config.Passwd_file]
+  if !ok {
+    cached = &password_file_cache{}
+    password_files[config.Passwd_file] = cached
+  }
+
+  info, err := os.Stat(config.Passwd_file)
+  if err == nil && info.ModTime().Equal(cached.mod_time) && info.Size() == cached.size {
+    return cached.password
+  }
+  password := ""
+  if err == nil {
+    password, err = Read_password_file(config.Passwd_file)
+  }
+  if err != nil {
+    if err.Error() != cached.last_error {
+      log.Err_msg("Can't read the password file %s: %s", config.Passwd_file, err)
+      cached.last_error = err.Error()
+    }
+    return cached.password
+  }
+  if cached.password != "" {
+    log.Info_msg("Password file %s changed, using the new password", config.Passwd_file)
+  }
+  *cached = password_file_cache{info.ModTime(), info.Size(), password, ""}
+  return password
+}
+
+
+// Text representation of the connection data without the password, so it is
+// never written to the logs
+func (config Collector_connection_data) String() string {
+  return fmt.Sprintf("{Host:%s Port:%s Api_version:%s User:%s Passwd:******}", config.Host, config.Port, config.Api_version, config.User)
+}
@@ -0,0 +1,110 @@
+/*
+ *
+ * title           :collector/credentials_test.go
+ * description     :Tests of the password files of the Cloudera Manager connections
+ * author		       :Alejandro Villegas
+ * date            :2019/07/15
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "io/ioutil"
+  "os"
+  "path"
+  "strconv"
+  "strings"
+  "testing"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+func Test_Read_password_file(t *testing.T) {
+  directory, err := ioutil.TempDir("", "credentials")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(directory)
+
+  tests := []struct {
+    name string
+    content string
+    expected string
+    valid bool
+  }{
+    {"plain", "secret", "secret", true},
+    {"line break", "secret\n", "secret", true},
+    {"windows line break", "secret\r\n", "secret", true},
+    {"trailing spaces are kept", "secret \n", "secret ", true},
+    {"empty", "\n", "", false},
+  }
+  for index, test := range tests {
+    file_name := path.Join(directory, "password_" + strconv.Itoa(index))
+    if err := ioutil.WriteFile(file_name, []byte(test.content), 0600); err != nil {
+      t.Fatal(err)
+    }
+    password, err := Read_password_file(file_name)
+    if password != test.expected || (err == nil) != test.valid {
+      t.Errorf("%s: Read_password_file() = %q, %v, expected %q, valid %v", test.name, password, err, test.expected, test.valid)
+    }
+  }
+  if _, err := Read_password_file(path.Join(directory, "missing")); err == nil {
+    t.Errorf("Read_password_file() of a missing file = nil, expected an error")
+  }
+}
+
+
+func Test_Get_passwd(t *testing.T) {
+  directory, err := ioutil.TempDir("", "credentials")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(directory)
+  file_name := path.Join(directory, "password")
+
+  if passwd := (Collector_connection_data{Passwd: "inline"}).Get_passwd(); passwd != "inline" {
+    t.Errorf("Get_passwd() without password file = %q, expected %q", passwd, "inline")
+  }
+
+  // The password file is read again when it changes, and the last password
+  // read is kept if it can't be read
+  config := Collector_connection_data{Passwd_file: file_name}
+  steps := []struct {
+    content string
+    expected string
+  }{
+    {"first\n", "first"},
+    {"", "first"},
+    {"rotated password\n", "rotated password"},
+  }
+  for index, step := range steps {
+    if step.content == "" {
+      os.Remove(file_name)
+    } else if err := ioutil.WriteFile(file_name, []byte(step.content), 0600); err != nil {
+      t.Fatal(err)
+    }
+    if passwd := config.Get_passwd(); passwd != step.expected {
+      t.Errorf("Step %d: Get_passwd() = %q, expected %q", index, passwd, step.expected)
+    }
+  }
+}
+
+
+func Test_Collector_connection_data_String(t *testing.T) {
+  config := Collector_connection_data{Host: "cm", Port: "7180", User: "admin", Passwd: "secret"}
+  if text := config.String(); strings.Contains(text, "secret") || !strings.Contains(text, "admin") {
+    t.Errorf("String() = %q, expected the user without the password", text)
+  }
+}
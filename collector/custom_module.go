@@ -0,0 +1,253 @@
+/*
+ *
+ * title           :collector/custom_module.go
+ * description     :Submodule Collector for the TSquery metrics defined in the config file
+ * author		       :Alejandro Villegas
+ * date            :2019/07/18
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "fmt"
+  "regexp"
+  "strings"
+  "time"
+
+  // Own libraries
+  "keedio/cloudera_exporter/cmapi"
+  jp "keedio/cloudera_exporter/json_parser"
+  log "keedio/cloudera_exporter/logger"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+
+
+
+/* ======================================================================
+ * Constants
+ * ====================================================================== */
+const CUSTOM_SCRAPER_NAME = "custom"
+
+
+
+
+/* ======================================================================
+ * Data Structs
+ * ====================================================================== */
+// Metric defined in the config file. Each label of the metric takes the value
+// of a "metadata.attributes" field of the timeseries
+type Custom_metric struct {
+  Name string
+  Query string
+  Value_type prometheus.ValueType
+  Label_names []string
+  Label_attributes []string
+  Time_series Custom_metric_time_series
+  Metric_struct *prometheus.Desc
+  // Full name of the metric, known by its descriptor once the config of
+  // the metric is set
+  fq_name string
+}
+
+// Time window and rollup of the query of a custom metric. The zero value
+// queries the API default window, the last 5 minutes
+type Custom_metric_time_series struct {
+  // Time window of the query, ending at the scrape time. 0 for the default
+  Window time.Duration
+  // Aggregation of the points, like HOURLY. "" to let the Cloudera Manager
+  // choose it
+  Desired_rollup string
+  // Whether the desired rollup is used for any window
+  Must_use_desired_rollup bool
+}
+
+
+
+
+/* ======================================================================
+ * Global variables
+ * ====================================================================== */
+var custom_metric_name_regexp = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Create and returns a custom metric with its prometheus descriptor. The
+// "labels" parameter is a list of pairs (label name, timeseries attribute)
+func New_custom_metric(name string, help string, query string, value_type string, labels [][2]string, time_series Custom_metric_time_series) (*Custom_metric, error) {
+  if !custom_metric_name_regexp.MatchString(name) {
+    return nil, fmt.Errorf("Invalid custom metric name: %s", name)
+  }
+  if query == "" {
+    return nil, fmt.Errorf("No query specified for custom metric: %s", name)
+  }
+  if help == "" {
+    help = fmt.Sprintf("Custom metric %s", name)
+  }
+
+  if time_series.Window < 0 {
+    return nil, fmt.Errorf("Invalid window %s for custom metric %s", time_series.Window, name)
+  }
+  if err := time_series.options(time.Now()).Check(); err != nil {
+    return nil, fmt.Errorf("%s for custom metric %s", err, name)
+  }
+
+  metric := Custom_metric {
+    Name: name,
+    Query: query,
+    Time_series: time_series,
+  }
+  switch value_type {
+  case "", "gauge":
+    metric.Value_type = prometheus.GaugeValue
+  case "counter":
+    metric.Value_type = prometheus.CounterValue
+  default:
+    return nil, fmt.Errorf("Invalid type %s for custom metric %s. Valid types are gauge and counter", value_type, name)
+  }
+  label_names := make(map[string]bool)
+  for _, label := range labels {
+    // Names starting with "__" are reserved by Prometheus
+    if !custom_metric_name_regexp.MatchString(label[0]) || strings.HasPrefix(label[0], "__") || label[1] == "" {
+      return nil, fmt.Errorf("Invalid label %s:%s for custom metric %s", label[0], label[1], name)
+    }
+    if label_names[label[0]] {
+      return nil, fmt.Errorf("Duplicated label %s for custom metric %s", label[0], name)
+    }
+    label_names[label[0]] = true
+    metric.Label_names = append(metric.Label_names, label[0])
+    metric.Label_attributes = append(metric.Label_attributes, label[1])
+  }
+
+  // Its label names are not reserved, as they depend on the config file
+  metric.fq_name = prometheus.BuildFQName(namespace, CUSTOM_SCRAPER_NAME, name)
+  metric.Metric_struct = prometheus.NewDesc(metric.fq_name, help, metric.Label_names, nil)
+  return &metric, nil
+}
+
+
+// Returns the options of the timeseries query of a custom metric made at the
+// given time
+func (time_series Custom_metric_time_series) options(now time.Time) cmapi.Time_series_options {
+  options := cmapi.Time_series_options {
+    Desired_rollup: time_series.Desired_rollup,
+    Must_use_desired_rollup: time_series.Must_use_desired_rollup,
+  }
+  if time_series.Window > 0 {
+    options.From = now.Add(-time_series.Window)
+  }
+  return options
+}
+
+
+// Generic function to make the query of a custom metric and publish a metric
+// for each timeseries of the response, with the newest value of the window
+func create_custom_metric (ctx context.Context, config Collector_connection_data, metric *Custom_metric, ch chan<- prometheus.Metric) bool {
+  // Make the query
+  responses, err := make_timeseries_query(ctx, config, metric.Query, metric.Time_series.options(time.Now()))
+  if err != nil {
+    return false
+  }
+  if len(responses) == 0 {
+    log.Err_msg("No response for the query %s", metric.Query)
+    return false
+  }
+
+  for _, custom_metric := range build_custom_metrics(metric, responses[0]) {
+    ch <- custom_metric
+  }
+  return true
+}
+
+
+// Build the metrics of a custom metric from the response of its query, one
+// for each timeseries with data. The label mappings come from the config
+// file, so several timeseries can map to the same label values. Only the
+// first one is published, as a duplicated metric would fail the whole scrape
+func build_custom_metrics(metric *Custom_metric, response jp.Api_time_series_response) []prometheus.Metric {
+  metrics := []prometheus.Metric{}
+  label_sets := make(map[string]bool)
+  for _, serie := range response.Time_series {
+    // Extract Metadata for each TimeSerie
+    label_values := make([]string, len(metric.Label_attributes))
+    for label_index, attribute := range metric.Label_attributes {
+      label_values[label_index] = serie.Get_attribute(attribute)
+    }
+    // Get Query LAST value
+    value, err := serie.Get_last_value()
+    if err != nil {
+      log.Debug_msg("No data for query: %s", metric.Query)
+      continue
+    }
+    label_set := strings.Join(label_values, "\xff")
+    if label_sets[label_set] {
+      log.Warn_msg("The timeseries of the entity %s of the custom metric %s has the same labels %v as a previous one. It is skipped", serie.Metadata.Entity_name, metric.Name, label_values)
+      continue
+    }
+    label_sets[label_set] = true
+    // Assing the data to the Prometheus descriptor
+    metrics = append(metrics, prometheus.MustNewConstMetric(metric.Metric_struct, metric.Value_type, value, label_values...))
+  }
+  return metrics
+}
+
+
+
+
+/* ======================================================================
+ * Scrape "Class"
+ * ====================================================================== */
+// ScrapeCustom struct
+type ScrapeCustom struct {
+  Metrics []*Custom_metric
+}
+
+// Name of the Scraper. Should be unique.
+func (ScrapeCustom) Name() string {
+  return CUSTOM_SCRAPER_NAME
+}
+
+// Help describes the role of the Scraper.
+func (ScrapeCustom) Help() string {
+  return "Custom TSquery Metrics defined in the config file"
+}
+
+// Version.
+func (ScrapeCustom) Version() float64 {
+  return 1.0
+}
+
+// Scrape generic function. Override for custom module.
+func (s ScrapeCustom) Scrape (ctx context.Context, config *Collector_connection_data, ch chan<- prometheus.Metric) error {
+  log.Debug_msg("Ejecutando Custom Metrics Scraper")
+
+  // Queries counters
+  success_queries := 0
+  error_queries := 0
+
+  for _, metric := range s.Metrics {
+    if !is_metric_scraped(ctx, metric.Metric_struct) {
+      continue
+    }
+    eval_scrape(create_custom_metric(ctx, *config, metric, ch), &success_queries, &error_queries)
+  }
+  log.Debug_msg("In the Custom Module has been executed %d queries. %d success and %d with errors", success_queries + error_queries, success_queries, error_queries)
+  return scrape_result(success_queries, error_queries)
+}
+
+var _ Scraper = &ScrapeCustom{}
@@ -0,0 +1,167 @@
+/*
+ *
+ * title           :collector/custom_module_test.go
+ * description     :Tests of the TSquery metrics defined in the config file
+ * author		       :Alejandro Villegas
+ * date            :2019/07/18
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "encoding/json"
+  "fmt"
+  "strings"
+  "testing"
+  "time"
+
+  // Own libraries
+  jp "keedio/cloudera_exporter/json_parser"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+  dto "github.com/prometheus/client_model/go"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+func Test_New_custom_metric(t *testing.T) {
+  query := "SELECT cpu_percent WHERE category=HOST"
+  host_label := [][2]string{{"host", "hostname"}}
+  tests := []struct {
+    name string
+    metric_name string
+    query string
+    value_type string
+    labels [][2]string
+    time_series Custom_metric_time_series
+    valid bool
+  }{
+    {"gauge", "cpu", query, "", host_label, Custom_metric_time_series{}, true},
+    {"counter", "cpu_total", query, "counter", nil, Custom_metric_time_series{}, true},
+    {"with rollup", "cpu", query, "gauge", host_label, Custom_metric_time_series{Window: time.Hour, Desired_rollup: "HOURLY", Must_use_desired_rollup: true}, true},
+    {"invalid name", "cpu-percent", query, "", nil, Custom_metric_time_series{}, false},
+    {"no query", "cpu", "", "", nil, Custom_metric_time_series{}, false},
+    {"invalid type", "cpu", query, "histogram", nil, Custom_metric_time_series{}, false},
+    {"invalid label name", "cpu", query, "", [][2]string{{"host-name", "hostname"}}, Custom_metric_time_series{}, false},
+    {"reserved label name", "cpu", query, "", [][2]string{{"__host", "hostname"}}, Custom_metric_time_series{}, false},
+    {"no label attribute", "cpu", query, "", [][2]string{{"host", ""}}, Custom_metric_time_series{}, false},
+    {"duplicated label", "cpu", query, "", [][2]string{{"host", "hostname"}, {"host", "hostId"}}, Custom_metric_time_series{}, false},
+    {"negative window", "cpu", query, "", nil, Custom_metric_time_series{Window: -time.Hour}, false},
+    {"invalid rollup", "cpu", query, "", nil, Custom_metric_time_series{Desired_rollup: "MONTHLY"}, false},
+    {"forced rollup without rollup", "cpu", query, "", nil, Custom_metric_time_series{Must_use_desired_rollup: true}, false},
+  }
+  for _, test := range tests {
+    metric, err := New_custom_metric(test.metric_name, "", test.query, test.value_type, test.labels, test.time_series)
+    if (err == nil) != test.valid {
+      t.Errorf("%s: New_custom_metric() error = %v, expected valid %v", test.name, err, test.valid)
+      continue
+    }
+    if err != nil {
+      continue
+    }
+    if len(metric.Label_names) != len(test.labels) || len(metric.Label_attributes) != len(test.labels) {
+      t.Errorf("%s: New_custom_metric() labels = %v %v, expected %v", test.name, metric.Label_names, metric.Label_attributes, test.labels)
+    }
+    // The descriptor must be valid to publish the metric
+    label_values := make([]string, len(metric.Label_names))
+    if _, err := prometheus.NewConstMetric(metric.Metric_struct, metric.Value_type, 1, label_values...); err != nil {
+      t.Errorf("%s: invalid descriptor: %s", test.name, err)
+    }
+  }
+}
+
+
+func Test_custom_metric_options(t *testing.T) {
+  now := time.Date(2019, 7, 18, 12, 0, 0, 0, time.UTC)
+  tests := []struct {
+    name string
+    time_series Custom_metric_time_series
+    expected_from time.Time
+  }{
+    {"default window", Custom_metric_time_series{}, time.Time{}},
+    {"one hour window", Custom_metric_time_series{Window: time.Hour, Desired_rollup: "HOURLY"}, now.Add(-time.Hour)},
+  }
+  for _, test := range tests {
+    options := test.time_series.options(now)
+    if !options.From.Equal(test.expected_from) || !options.To.IsZero() {
+      t.Errorf("%s: options() window = %v - %v, expected %v - now", test.name, options.From, options.To, test.expected_from)
+    }
+    if options.Desired_rollup != test.time_series.Desired_rollup || options.Must_use_desired_rollup != test.time_series.Must_use_desired_rollup {
+      t.Errorf("%s: options() = %+v, expected the rollup of %+v", test.name, options, test.time_series)
+    }
+  }
+}
+
+
+func Test_custom_metric_value_type(t *testing.T) {
+  tests := []struct {
+    value_type string
+    expected prometheus.ValueType
+  }{
+    {"", prometheus.GaugeValue},
+    {"gauge", prometheus.GaugeValue},
+    {"counter", prometheus.CounterValue},
+  }
+  for _, test := range tests {
+    metric, err := New_custom_metric("cpu", "", "SELECT cpu_percent", test.value_type, nil, Custom_metric_time_series{})
+    if err != nil || metric.Value_type != test.expected {
+      t.Errorf("New_custom_metric() with type %q = %v, %v, expected %v", test.value_type, metric, err, test.expected)
+    }
+  }
+}
+
+
+// The timeseries with the same label values are published once, so the
+// scrape does not fail with a duplicated metric
+func Test_build_custom_metrics(t *testing.T) {
+  metric, err := New_custom_metric("pool_memory", "", "SELECT allocated_memory", "", [][2]string{{"pool", "poolName"}}, Custom_metric_time_series{})
+  if err != nil {
+    t.Fatal(err)
+  }
+  serie := func(entity string, pool string, values ...float64) jp.Api_time_series {
+    serie := jp.Api_time_series{Metadata: jp.Api_time_series_metadata{
+      Entity_name: entity,
+      Attributes: map[string]json.RawMessage{"poolName": json.RawMessage(`"` + pool + `"`)},
+    }}
+    for _, value := range values {
+      serie.Data = append(serie.Data, jp.Api_time_series_data{Value: value})
+    }
+    return serie
+  }
+  tests := []struct {
+    name string
+    series []jp.Api_time_series
+    expected []string
+  }{
+    {"no timeseries", nil, []string{}},
+    {"distinct labels", []jp.Api_time_series{serie("impala1", "root.a", 1), serie("impala1", "root.b", 2)}, []string{"root.a=1", "root.b=2"}},
+    {"same labels", []jp.Api_time_series{serie("impala1", "root.a", 1, 5), serie("impala2", "root.a", 2), serie("impala2", "root.b", 3)}, []string{"root.a=5", "root.b=3"}},
+    {"same labels without data", []jp.Api_time_series{serie("impala1", "root.a"), serie("impala2", "root.a", 2)}, []string{"root.a=2"}},
+  }
+  for _, test := range tests {
+    published := []string{}
+    for _, custom_metric := range build_custom_metrics(metric, jp.Api_time_series_response{Time_series: test.series}) {
+      metric_data := dto.Metric{}
+      if err := custom_metric.Write(&metric_data); err != nil {
+        t.Fatalf("%s: invalid metric: %s", test.name, err)
+      }
+      published = append(published, fmt.Sprintf("%s=%v", metric_data.GetLabel()[0].GetValue(), metric_data.GetGauge().GetValue()))
+    }
+    if strings.Join(published, " ") != strings.Join(test.expected, " ") {
+      t.Errorf("%s: build_custom_metrics() = %v, expected %v", test.name, published, test.expected)
+    }
+  }
+}
@@ -38,8 +38,6 @@ import (
 type Metrics struct {
 	TotalScrapes  prometheus.Counter
 	ScrapeErrors  *prometheus.CounterVec
-	Error         prometheus.Gauge
-	CMUp          prometheus.Gauge
 }
 
 
@@ -63,43 +61,80 @@ func NewMetrics() Metrics {
 			Help:      "Total number of times Cloudera Manager was scraped for metrics.",
 		}),
 
-		Error: prometheus.NewGauge(prometheus.GaugeOpts {
-			Namespace: namespace,
-			Subsystem: subsystem,
-			Name:      "last_scrape_error",
-			Help:      "Whether the last scrape of metrics from Cloudera Manager resulted in an error (1 for error, 0 for success).",
-		}),
-
 		ScrapeErrors: prometheus.NewCounterVec(prometheus.CounterOpts {
 			Namespace: namespace,
 			Subsystem: subsystem,
 			Name:      "scrape_errors_total",
 			Help:      "Total number of times an error occurred scraping a Cloudera Manager.",
 		}, []string{"collector"}),
-
-		CMUp: prometheus.NewGauge(prometheus.GaugeOpts {
-			Namespace: namespace,
-			Subsystem: subsystem,
-			Name:      "up",
-			Help:      "Whether the Cloudera Manager server is up(1).",
-		}),
 	}
 }
 
-var scrapeDurationDesc = prometheus.NewDesc(
+var scrapeDurationDesc = new_metric_desc(
 		prometheus.BuildFQName(namespace, subsystem, "collector_duration_seconds"),
 		"Collector time duration.",
 		[]string{"collector"},
     nil,
 	)
 
+// The result of each scrape is published as constant metrics, so the
+// concurrent scrapes of several targets don't overwrite each other
+var upDesc = new_metric_desc(
+		prometheus.BuildFQName(namespace, subsystem, "up"),
+		"Whether the Cloudera Manager API is reachable (1) or not (0), from a probe of the API version.",
+		nil,
+		nil,
+	)
+
+var lastScrapeErrorDesc = new_metric_desc(
+		prometheus.BuildFQName(namespace, subsystem, "last_scrape_error"),
+		"Whether the last scrape of metrics from Cloudera Manager resulted in an error (1 for error, 0 for success).",
+		nil,
+		nil,
+	)
+
+var collectorSuccessDesc = new_metric_desc(
+		prometheus.BuildFQName(namespace, subsystem, "collector_success"),
+		"Whether all the queries of the collector succeeded (1) or any of them failed (0).",
+		[]string{"collector"},
+		nil,
+	)
+
+var queriesFailedDesc = new_metric_desc(
+		prometheus.BuildFQName(namespace, subsystem, "queries_failed"),
+		"Number of failed queries of the collector in the last scrape.",
+		[]string{"collector"},
+		nil,
+	)
+
 
 
+// Run the scrapers and the probe of the Cloudera Manager in parallel, and
+// publish the result of each scraper and of the whole scrape
 func (c *Collector) scrape (ctx context.Context, ch chan<- prometheus.Metric) {
 	c.metrics.TotalScrapes.Inc()
 
 	var wg sync.WaitGroup
-	defer wg.Wait()
+	var scrape_error_mutex sync.Mutex
+	scrape_error := 0.0
+	up := 0.0
+	// The API version of the target is queried on its first scrape, if the
+	// config file does not set it. The scrapers fail without it
+	config, version_err := resolve_api_version(ctx, c.config)
+	if version_err != nil {
+		log.Err_msg("Can't get the API version of the Cloudera Manager %s:%s: %s", c.config.Host, c.config.Port, version_err)
+	} else {
+		// The cached metadata is refreshed before the scrapers use it if the
+		// topology has changed since the previous scrape
+		poll_topology_events(ctx, config)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if Probe_cloudera_manager(ctx, c.config) {
+			up = 1
+		}
+	} ()
 	for _, scraper := range c.scrapers {
 
 		wg.Add(1)
@@ -107,15 +142,33 @@ func (c *Collector) scrape (ctx context.Context, ch chan<- prometheus.Metric) {
 			defer wg.Done()
 			label := scraper.Name()
 			scrapeTime := time.Now()
-			if err := scraper.Scrape(ctx, &c.config, ch); err != nil {
-				log.Err_msg("Error scraping for " + label + ":", err)
+			success := 1.0
+			failed_queries := 0
+			err := version_err
+			if err == nil {
+				err = scraper.Scrape(ctx, &config, ch)
+			}
+			if err != nil {
+				log.Err_msg("Error scraping for %s: %s", label, err)
 				c.metrics.ScrapeErrors.WithLabelValues(label).Inc()
-				c.metrics.CMUp.Set(0)
-				c.metrics.Error.Set(1)
+				success = 0
+				// Errors other than failed queries, like a timeout, count as a
+				// single failed query
+				failed_queries = 1
+				if partial, ok := err.(*Scrape_error); ok {
+					failed_queries = partial.Failed_queries
+				}
+				scrape_error_mutex.Lock()
+				scrape_error = 1
+				scrape_error_mutex.Unlock()
 			}
-			c.metrics.CMUp.Set(1)
 			ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, time.Since(scrapeTime).Seconds(), label)
+			ch <- prometheus.MustNewConstMetric(collectorSuccessDesc, prometheus.GaugeValue, success, label)
+			ch <- prometheus.MustNewConstMetric(queriesFailedDesc, prometheus.GaugeValue, float64(failed_queries), label)
 		} (scraper)
 	}
+	wg.Wait()
 
+	ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, up)
+	ch <- prometheus.MustNewConstMetric(lastScrapeErrorDesc, prometheus.GaugeValue, scrape_error)
 }
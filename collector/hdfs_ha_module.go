@@ -0,0 +1,219 @@
+/*
+ *
+ * title           :collector/hdfs_ha_module.go
+ * description     :Submodule Collector for the HDFS NameNode HA and JournalNode quorum metrics
+ * author		       :Alejandro Villegas
+ * date            :2019/07/02
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "fmt"
+  "sync"
+
+  // Own libraries
+  log "keedio/cloudera_exporter/logger"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+
+
+
+/* ======================================================================
+ * Constants
+ * ====================================================================== */
+const HDFS_SERVICE_TYPE = "HDFS"
+const NAMENODE_ROLE_TYPE = "NAMENODE"
+const JOURNALNODE_ROLE_TYPE = "JOURNALNODE"
+const HA_STATUS_ACTIVE = "ACTIVE"
+
+
+
+
+/* ======================================================================
+ * Global variables
+ * ====================================================================== */
+var (
+  // NameNode HA Status Metric Definition
+  hdfs_namenode_ha_active = new_metric_desc(
+    prometheus.BuildFQName(namespace, HDFS_SCRAPER_NAME, "namenode_ha_active"),
+    "NameNode HA state: 1 if the NameNode is the active one of its nameservice, 0 otherwise",
+    []string{"cluster", "nameservice", "role_name", "host_id"},
+    nil,
+  )
+
+  // NameNode Failovers Metric Definition
+  hdfs_namenode_failovers = new_metric_desc(
+    prometheus.BuildFQName(namespace, HDFS_SCRAPER_NAME, "namenode_failovers_total"),
+    "Number of active NameNode changes observed by the exporter for each nameservice",
+    []string{"cluster", "nameservice"},
+    nil,
+  )
+
+  // JournalNode Quorum Metric Definitions
+  hdfs_journalnode_total = new_metric_desc(
+    prometheus.BuildFQName(namespace, HDFS_SCRAPER_NAME, "journalnode_total"),
+    "Number of JournalNodes configured in the HDFS service",
+    []string{"cluster", "service"},
+    nil,
+  )
+  hdfs_journalnode_healthy = new_metric_desc(
+    prometheus.BuildFQName(namespace, HDFS_SCRAPER_NAME, "journalnode_healthy"),
+    "Number of JournalNodes started and with GOOD or CONCERNING health",
+    []string{"cluster", "service"},
+    nil,
+  )
+  hdfs_journalnode_quorum_up = new_metric_desc(
+    prometheus.BuildFQName(namespace, HDFS_SCRAPER_NAME, "journalnode_quorum_up"),
+    "Whether a majority of the JournalNodes are healthy (1) or the edits quorum is lost (0)",
+    []string{"cluster", "service"},
+    nil,
+  )
+)
+
+// Last active NameNode seen and failovers counted by
+// "host:port/cluster/nameservice", so the clusters with the same name in
+// different Cloudera Managers have their own history. The exporter is the only
+// one keeping this history, so it is lost on restart
+var (
+  namenode_ha_mutex sync.Mutex
+  namenode_last_active = make(map[string]string)
+  namenode_failovers = make(map[string]float64)
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Returns a map with the NameNode role name as key and its nameservice as value
+func get_namenode_nameservices(ctx context.Context, config Collector_connection_data, cluster_name string, service_name string) map[string]string {
+  nameservices := make(map[string]string)
+  api_nameservices, err := new_api_client(config).List_nameservices(ctx, cluster_name, service_name)
+  if err != nil {
+    return nameservices
+  }
+  for _, nameservice := range api_nameservices {
+    if role_name := nameservice.Active.Role_name; role_name != "" {
+      nameservices[role_name] = nameservice.Name
+    }
+    if role_name := nameservice.Stand_by.Role_name; role_name != "" {
+      nameservices[role_name] = nameservice.Name
+    }
+  }
+  return nameservices
+}
+
+
+// Update the failover counter of a nameservice with the active NameNode seen
+// in this scrape and return the accumulated number of failovers
+func register_namenode_active(config Collector_connection_data, cluster_name string, nameservice string, role_name string) float64 {
+  key := fmt.Sprintf("%s:%s/%s/%s", config.Host, config.Port, cluster_name, nameservice)
+
+  namenode_ha_mutex.Lock()
+  defer namenode_ha_mutex.Unlock()
+  if last_active, ok := namenode_last_active[key]; ok && last_active != role_name {
+    log.Warn_msg("NameNode failover detected in %s: %s -> %s", key, last_active, role_name)
+    namenode_failovers[key] += 1
+  }
+  namenode_last_active[key] = role_name
+  return namenode_failovers[key]
+}
+
+
+// Returns true if the JournalNode role is running and its health is acceptable
+func is_journalnode_healthy(role_state string, health_summary string) bool {
+  return role_state == "STARTED" && (health_summary == "GOOD" || health_summary == "CONCERNING")
+}
+
+
+// Returns true if a majority of the JournalNodes are healthy, so the edits
+// can still be written
+func is_journalnode_quorum_up(journalnodes_healthy int, journalnodes_total int) bool {
+  return journalnodes_healthy > journalnodes_total / 2
+}
+
+
+// Returns the names of the HDFS services of a cluster
+func get_hdfs_services(ctx context.Context, config Collector_connection_data, cluster_name string) ([]string, error) {
+  services, err := new_metadata_client(config).List_services(ctx, cluster_name)
+  if err != nil {
+    return nil, err
+  }
+  hdfs_services := []string{}
+  for _, service := range services {
+    if service.Type == HDFS_SERVICE_TYPE {
+      hdfs_services = append(hdfs_services, service.Name)
+    }
+  }
+  return hdfs_services, nil
+}
+
+
+// Function to Scrape the NameNode HA and JournalNode quorum Metrics of an HDFS
+// service
+func scrape_hdfs_ha_status(ctx context.Context, config Collector_connection_data, cluster_name string, service_name string, ch chan<- prometheus.Metric) bool {
+  roles, err := new_api_client(config).List_roles(ctx, cluster_name, service_name)
+  if err != nil {
+    return false
+  }
+  nameservices := get_namenode_nameservices(ctx, config, cluster_name, service_name)
+
+  journalnodes_total := 0
+  journalnodes_healthy := 0
+  active_namenodes := make(map[string]string)
+
+  for _, role := range roles {
+    switch role.Type {
+    case NAMENODE_ROLE_TYPE:
+      role_name := role.Name
+      host_id := role.Host_ref.Host_id
+      nameservice := nameservices[role_name]
+      is_active := 0.0
+      if role.Ha_status == HA_STATUS_ACTIVE {
+        is_active = 1.0
+        active_namenodes[nameservice] = role_name
+      }
+      ch <- prometheus.MustNewConstMetric(hdfs_namenode_ha_active, prometheus.GaugeValue, is_active, cluster_name, nameservice, role_name, host_id)
+    case JOURNALNODE_ROLE_TYPE:
+      journalnodes_total++
+      if is_journalnode_healthy(role.Role_state, role.Health_summary) {
+        journalnodes_healthy++
+      }
+    }
+  }
+
+  // Failovers are only meaningful for HA nameservices
+  for nameservice, role_name := range active_namenodes {
+    if nameservice == "" {
+      continue
+    }
+    failovers := register_namenode_active(config, cluster_name, nameservice, role_name)
+    ch <- prometheus.MustNewConstMetric(hdfs_namenode_failovers, prometheus.CounterValue, failovers, cluster_name, nameservice)
+  }
+
+  // Without JournalNodes the cluster is not using Quorum Journal Manager
+  if journalnodes_total > 0 {
+    quorum_up := 0.0
+    if is_journalnode_quorum_up(journalnodes_healthy, journalnodes_total) {
+      quorum_up = 1.0
+    }
+    ch <- prometheus.MustNewConstMetric(hdfs_journalnode_total, prometheus.GaugeValue, float64(journalnodes_total), cluster_name, service_name)
+    ch <- prometheus.MustNewConstMetric(hdfs_journalnode_healthy, prometheus.GaugeValue, float64(journalnodes_healthy), cluster_name, service_name)
+    ch <- prometheus.MustNewConstMetric(hdfs_journalnode_quorum_up, prometheus.GaugeValue, quorum_up, cluster_name, service_name)
+  }
+  return true
+}
@@ -0,0 +1,152 @@
+/*
+ *
+ * title           :collector/hdfs_ha_module_test.go
+ * description     :Tests of the HDFS NameNode HA and JournalNode quorum metrics
+ * author		       :Alejandro Villegas
+ * date            :2019/07/02
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "sort"
+  "strings"
+  "testing"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+func Test_is_journalnode_healthy(t *testing.T) {
+  tests := []struct {
+    role_state string
+    health_summary string
+    expected bool
+  }{
+    {"STARTED", "GOOD", true},
+    {"STARTED", "CONCERNING", true},
+    {"STARTED", "BAD", false},
+    {"STARTED", "DISABLED", false},
+    {"STOPPED", "GOOD", false},
+    {"", "", false},
+  }
+  for _, test := range tests {
+    if healthy := is_journalnode_healthy(test.role_state, test.health_summary); healthy != test.expected {
+      t.Errorf("is_journalnode_healthy(%q, %q) = %v, expected %v", test.role_state, test.health_summary, healthy, test.expected)
+    }
+  }
+}
+
+
+func Test_is_journalnode_quorum_up(t *testing.T) {
+  tests := []struct {
+    healthy int
+    total int
+    expected bool
+  }{
+    {3, 3, true},
+    {2, 3, true},
+    {1, 3, false},
+    {3, 5, true},
+    {2, 5, false},
+    {2, 4, false},
+    {3, 4, true},
+    {0, 1, false},
+  }
+  for _, test := range tests {
+    if quorum_up := is_journalnode_quorum_up(test.healthy, test.total); quorum_up != test.expected {
+      t.Errorf("is_journalnode_quorum_up(%d, %d) = %v, expected %v", test.healthy, test.total, quorum_up, test.expected)
+    }
+  }
+}
+
+
+func Test_register_namenode_active(t *testing.T) {
+  config := Collector_connection_data{Host: "cm1", Port: "7180"}
+  other_config := Collector_connection_data{Host: "cm2", Port: "7180"}
+  steps := []struct {
+    config Collector_connection_data
+    nameservice string
+    role_name string
+    expected float64
+  }{
+    // The first active NameNode seen is not a failover
+    {config, "ns1", "nn1", 0},
+    {config, "ns1", "nn1", 0},
+    {config, "ns1", "nn2", 1},
+    {config, "ns1", "nn2", 1},
+    {config, "ns1", "nn1", 2},
+    // Each nameservice has its own counter
+    {config, "ns2", "nn3", 0},
+    {config, "ns2", "nn4", 1},
+    {config, "ns1", "nn1", 2},
+    // A cluster with the same name in another Cloudera Manager too
+    {other_config, "ns1", "nn2", 0},
+    {other_config, "ns1", "nn2", 0},
+    {config, "ns1", "nn1", 2},
+  }
+  for index, step := range steps {
+    if failovers := register_namenode_active(step.config, "test_register_namenode_active", step.nameservice, step.role_name); failovers != step.expected {
+      t.Errorf("Step %d: register_namenode_active(%s:%s, %q, %q) = %v, expected %v", index, step.config.Host, step.config.Port, step.nameservice, step.role_name, failovers, step.expected)
+    }
+  }
+}
+
+
+// The JournalNode metrics of each HDFS service of a cluster have their own
+// series
+func Test_scrape_hdfs_ha_status(t *testing.T) {
+  server := new_test_cm_server(map[string]string{
+    "clusters/cluster1/services/hdfs1/roles": `{"items": [
+      {"name": "nn1", "type": "NAMENODE", "haStatus": "ACTIVE", "hostRef": {"hostId": "h1"}},
+      {"name": "jn1", "type": "JOURNALNODE", "roleState": "STARTED", "healthSummary": "GOOD"},
+      {"name": "jn2", "type": "JOURNALNODE", "roleState": "STOPPED", "healthSummary": "BAD"}]}`,
+    "clusters/cluster1/services/hdfs1/nameservices": `{"items": [{"name": "ns1", "active": {"roleName": "nn1"}}]}`,
+    "clusters/cluster1/services/hdfs2/roles": `{"items": [
+      {"name": "jn3", "type": "JOURNALNODE", "roleState": "STARTED", "healthSummary": "GOOD"}]}`,
+    "clusters/cluster1/services/hdfs2/nameservices": `{"items": []}`,
+  })
+  defer server.Close()
+  config := new_test_connection(t, server, Api_client_options{})
+
+  expected := []string{
+    "{cluster=cluster1,host_id=h1,nameservice=ns1,role_name=nn1}",
+    "{cluster=cluster1,nameservice=ns1}",
+    "{cluster=cluster1,service=hdfs1}",
+    "{cluster=cluster1,service=hdfs1}",
+    "{cluster=cluster1,service=hdfs1}",
+    "{cluster=cluster1,service=hdfs2}",
+    "{cluster=cluster1,service=hdfs2}",
+    "{cluster=cluster1,service=hdfs2}",
+  }
+  metrics := []string{}
+  for _, service_name := range []string{"hdfs1", "hdfs2"} {
+    published, ok := collect_test_metrics(func(ch chan<- prometheus.Metric) bool {
+      return scrape_hdfs_ha_status(context.Background(), config, "cluster1", service_name, ch)
+    })
+    if !ok {
+      t.Errorf("scrape_hdfs_ha_status(%q) failed", service_name)
+    }
+    metrics = append(metrics, format_metrics(t, published)...)
+  }
+  sort.Strings(metrics)
+  sort.Strings(expected)
+  if strings.Join(metrics, " ") != strings.Join(expected, " ") {
+    t.Errorf("scrape_hdfs_ha_status() = %v, expected %v", metrics, expected)
+  }
+}
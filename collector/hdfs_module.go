@@ -63,6 +63,7 @@ const (
     HDFS_NAMENODE_FD_MAX_DESCRIPTORS = "SELECT LAST(fd_max_across_namenodes) WHERE category=SERVICE"
     HDFS_SNAPSHOT_NUM =                "SELECT LAST(total_snapshots_across_namenodes) WHERE category=CLUSTER and entityName=1"
     HDFS_SNAPSHOT_DIRS =               "SELECT LAST(total_snapshottable_directories_across_namenodes) WHERE category=CLUSTER and entityName=1"
+    HDFS_JOURNALNODE_EDIT_LAG =        "SELECT LAST(journalnode_current_lag_txns) WHERE roleType=JOURNALNODE"
 )
 
 
@@ -92,29 +93,31 @@ var (
   hdfs_namenode_fd_max_descriptors = create_hdfs_metric_struct("namenode_fd_max_descriptors", "Distributed File System Namenode Max File Descriptors")
   hdfs_snapshot_num =                create_hdfs_metric_struct("snapshot_num",  "Distributed File System Num Total Snapshots")
   hdfs_snapshot_dirs=                create_hdfs_metric_struct("snapshot_dirs",  "Distributed File System Num Total Snapshottable Dirs")
+  hdfs_journalnode_edit_lag =        create_hdfs_metric_struct("journalnode_edit_lag_txns",  "Distributed File System JournalNode Num Transactions behind the Active NameNode")
 
 )
 
 // Creation of the structure that relates the queries with the descriptors of the Prometheus metrics
 var hdfs_query_variable_relationship = []relation {
-  {HDFS_DFS_CAPACITY,                *hdfs_dfs_capacity},
-  {HDFS_DFS_CAPACITY_USED,           *hdfs_dfs_capacity_used},
-  {HDFS_DFS_CAPACITY_USED_PERCENT,   *hdfs_dfs_capacity_used_percent},
-  {HDFS_DFS_CAPACITY_NON_HDFS_USED,  *hdfs_dfs_capacity_non_hdfs_used},
-  {HDFS_BLOCK_CAPACITY,              *hdfs_block_capacity},
-  {HDFS_BLOCK_TOTAL,                 *hdfs_block_total},
-  {HDFS_BLOCK_CORRUPT_REPLICAS,      *hdfs_block_corrupt_replicas},
-  {HDFS_BLOCK_EXCESS,                *hdfs_block_excess},
-  {HDFS_BLOCK_MISSING,               *hdfs_block_missing},
-  {HDFS_BLOCK_UNDER_REPLICATED,      *hdfs_block_under_replicated},
-  {HDFS_BLOCK_WRITE,                 *hdfs_block_write},
-  {HDFS_BLOCK_READ,                  *hdfs_block_read},
-  {HDFS_FILES_TOTAL,                 *hdfs_files_total},
-  {HDFS_FILES_SIZE_AVG,              *hdfs_files_size_avg},
-  {HDFS_HEARTBEATS_EXPIRED,          *hdfs_heartbeats_expired},
-  {HDFS_NAMENODE_FD_MAX_DESCRIPTORS, *hdfs_namenode_fd_max_descriptors},
-  {HDFS_SNAPSHOT_NUM,                *hdfs_snapshot_num},
-  {HDFS_SNAPSHOT_DIRS,               *hdfs_snapshot_dirs},
+  {HDFS_DFS_CAPACITY,                hdfs_dfs_capacity},
+  {HDFS_DFS_CAPACITY_USED,           hdfs_dfs_capacity_used},
+  {HDFS_DFS_CAPACITY_USED_PERCENT,   hdfs_dfs_capacity_used_percent},
+  {HDFS_DFS_CAPACITY_NON_HDFS_USED,  hdfs_dfs_capacity_non_hdfs_used},
+  {HDFS_BLOCK_CAPACITY,              hdfs_block_capacity},
+  {HDFS_BLOCK_TOTAL,                 hdfs_block_total},
+  {HDFS_BLOCK_CORRUPT_REPLICAS,      hdfs_block_corrupt_replicas},
+  {HDFS_BLOCK_EXCESS,                hdfs_block_excess},
+  {HDFS_BLOCK_MISSING,               hdfs_block_missing},
+  {HDFS_BLOCK_UNDER_REPLICATED,      hdfs_block_under_replicated},
+  {HDFS_BLOCK_WRITE,                 hdfs_block_write},
+  {HDFS_BLOCK_READ,                  hdfs_block_read},
+  {HDFS_FILES_TOTAL,                 hdfs_files_total},
+  {HDFS_FILES_SIZE_AVG,              hdfs_files_size_avg},
+  {HDFS_HEARTBEATS_EXPIRED,          hdfs_heartbeats_expired},
+  {HDFS_NAMENODE_FD_MAX_DESCRIPTORS, hdfs_namenode_fd_max_descriptors},
+  {HDFS_SNAPSHOT_NUM,                hdfs_snapshot_num},
+  {HDFS_SNAPSHOT_DIRS,               hdfs_snapshot_dirs},
+  {HDFS_JOURNALNODE_EDIT_LAG,        hdfs_journalnode_edit_lag},
 }
 
 
@@ -134,7 +137,7 @@ func create_hdfs_metric_struct(metric_name string, description string) *promethe
   }
 
   // return prometheus descriptor
-  return prometheus.NewDesc(
+  return new_metric_desc(
     prometheus.BuildFQName(namespace, HDFS_SCRAPER_NAME, metric_name),
     description,
     []string{"cluster", "entityName"},
@@ -145,32 +148,25 @@ func create_hdfs_metric_struct(metric_name string, description string) *promethe
 
 // Generic function to extract de metadata associated with the query value
 // Only for HDFS metric type
-func create_hdfs_metric (ctx context.Context, config Collector_connection_data, query string, metric_struct prometheus.Desc, ch chan<- prometheus.Metric) bool {
-  // Make the query
-  json_parsed, err := make_and_parse_timeseries_query(ctx, config, query)
-  if err != nil {
-    return false
-  }
-
-  // Get the num of hosts in the cluster or clusters
-  num_ts_series, err := jp.Get_timeseries_num(json_parsed)
-  if err != nil {
+func create_hdfs_metric (response jp.Api_time_series_response, metric_struct *prometheus.Desc, ch chan<- prometheus.Metric) bool {
+  if response.Time_series == nil {
     return false
   }
 
   // Extract Metadata for each TimeSerie
-  for ts_index := 0; ts_index < num_ts_series; ts_index ++ {
+  for ts_index := range response.Time_series {
+    serie := &response.Time_series[ts_index]
     // Get the Cluster Name
-    cluster_name := jp.Get_timeseries_query_cluster(json_parsed, ts_index)
+    cluster_name := serie.Get_attribute("clusterName")
     // Get the entity Name
-    entity_name := jp.Get_timeseries_query_entity_name(json_parsed, ts_index)
+    entity_name := serie.Get_attribute("entityName")
     // Get Query LAST value
-    value, err := jp.Get_timeseries_query_value(json_parsed, ts_index)
+    value, err := serie.Get_value()
     if err != nil {
       continue
     }
     // Assing the data to the Prometheus descriptor
-    ch <- prometheus.MustNewConstMetric(&metric_struct, prometheus.GaugeValue, value, cluster_name, entity_name)
+    ch <- prometheus.MustNewConstMetric(metric_struct, prometheus.GaugeValue, value, cluster_name, entity_name)
   }
   return true
 }
@@ -203,20 +199,35 @@ func (ScrapeHDFS) Version() float64 {
 func (ScrapeHDFS) Scrape (ctx context.Context, config *Collector_connection_data, ch chan<- prometheus.Metric) error {
   log.Debug_msg("Ejecutando HDFS Metrics Scraper")
 
-  // Queries counters
-  success_queries := 0
-  error_queries := 0
-
-  // Execute the generic funtion for creation of metrics with the pairs (QUERY, PROM:DESCRIPTOR)
-  for i:=0 ; i < len(hdfs_query_variable_relationship) ; i++ {
-    if create_hdfs_metric(ctx, *config, hdfs_query_variable_relationship[i].Query, hdfs_query_variable_relationship[i].Metric_struct, ch) {
-      success_queries += 1
-    } else {
-      error_queries += 1
+  // Execute the generic funtion for creation of metrics with the pairs
+  // (QUERY, PROM:DESCRIPTOR), making the queries in batches
+  success_queries, error_queries := scrape_timeseries_relations(ctx, *config, hdfs_query_variable_relationship, func(response jp.Api_time_series_response, query string, metric_struct *prometheus.Desc) bool {
+    return create_hdfs_metric(response, metric_struct, ch)
+  })
+
+  // NameNode HA, JournalNode quorum and snapshot policies metrics for each
+  // HDFS service of each cluster
+  clusters, err := new_metadata_client(*config).List_clusters(ctx)
+  if err == nil {
+    for _, cluster := range clusters {
+      cluster_name := cluster.Name
+      hdfs_services, err := get_hdfs_services(ctx, *config, cluster_name)
+      if err != nil {
+        error_queries += 1
+        continue
+      }
+      for _, service_name := range hdfs_services {
+        eval_scrape(scrape_hdfs_ha_status(ctx, *config, cluster_name, service_name, ch), &success_queries, &error_queries)
+        policies_success, policies_errors := scrape_hdfs_snapshot_policies(ctx, *config, cluster_name, service_name, ch)
+        success_queries += policies_success
+        error_queries += policies_errors
+      }
     }
+  } else {
+    error_queries += 1
   }
   log.Debug_msg("In the HDFS Module has been executed %d queries. %d success and %d with errors", success_queries + error_queries, success_queries, error_queries)
-  return nil
+  return scrape_result(success_queries, error_queries)
 }
 
 var _ Scraper = ScrapeHDFS{}
@@ -0,0 +1,209 @@
+/*
+ *
+ * title           :collector/hdfs_snapshot_module.go
+ * description     :Submodule Collector for the HDFS snapshot policies metrics
+ * author		       :Alejandro Villegas
+ * date            :2019/07/10
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "time"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+
+
+
+/* ======================================================================
+ * Data Structs
+ * ====================================================================== */
+// State of each path of a snapshot policy built from its history. Created and
+// deleted snapshots are matched by name, so the order of the history commands
+// does not matter
+type snapshot_policy_history struct {
+  created map[string]map[string]bool
+  deleted map[string]bool
+  last_success map[string]time.Time
+  failures map[string]float64
+}
+
+
+
+
+/* ======================================================================
+ * Global variables
+ * ====================================================================== */
+var (
+  hdfs_snapshot_policy_paused =        create_hdfs_snapshot_policy_metric_struct("paused", "Whether the snapshot policy is paused (1) or not (0)")
+  hdfs_snapshot_policy_retention =     create_hdfs_snapshot_policy_metric_struct("retention_snapshots", "Num of snapshots to retain configured in the policy, adding up all its schedules")
+  hdfs_snapshot_policy_retained =      create_hdfs_snapshot_policy_metric_struct("retained_snapshots", "Num of snapshots created by the policy and not deleted yet, as seen in the policy history")
+  hdfs_snapshot_policy_last_success =  create_hdfs_snapshot_policy_metric_struct("last_success_timestamp_seconds", "Unix time of the last snapshot successfully created by the policy")
+  hdfs_snapshot_policy_failures =      create_hdfs_snapshot_policy_metric_struct("failures", "Num of snapshot creation and deletion errors in the policy history")
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Create and returns a prometheus descriptor for a snapshot policy metric
+func create_hdfs_snapshot_policy_metric_struct(metric_name string, description string) *prometheus.Desc {
+  return new_metric_desc(
+    prometheus.BuildFQName(namespace, HDFS_SCRAPER_NAME, "snapshot_policy_" + metric_name),
+    description,
+    []string{"cluster", "policy", "path"},
+    nil,
+  )
+}
+
+
+// Returns an empty history with the given paths of the policy, so they are
+// reported even if they have no history
+func new_snapshot_policy_history(paths []string) *snapshot_policy_history {
+  history := &snapshot_policy_history{
+    created: make(map[string]map[string]bool),
+    deleted: make(map[string]bool),
+    last_success: make(map[string]time.Time),
+    failures: make(map[string]float64),
+  }
+  for _, path := range paths {
+    history.add_path(path)
+  }
+  return history
+}
+
+
+func (history *snapshot_policy_history) add_path(path string) {
+  if _, ok := history.created[path]; !ok {
+    history.created[path] = make(map[string]bool)
+  }
+}
+
+
+// Add a snapshot created by the policy
+func (history *snapshot_policy_history) add_created(path string, snapshot_name string, creation_time string) {
+  history.add_path(path)
+  history.created[path][snapshot_name] = true
+  if timestamp, ok := parse_api_timestamp(creation_time); ok && timestamp.After(history.last_success[path]) {
+    history.last_success[path] = timestamp
+  }
+}
+
+
+// Add a snapshot deleted by the policy
+func (history *snapshot_policy_history) add_deleted(path string, snapshot_name string) {
+  history.deleted[path + "@" + snapshot_name] = true
+}
+
+
+// Add a snapshot creation or deletion error of the policy
+func (history *snapshot_policy_history) add_error(path string) {
+  history.add_path(path)
+  history.failures[path] += 1
+}
+
+
+// Returns the paths of the policy
+func (history *snapshot_policy_history) paths() []string {
+  paths := []string{}
+  for path := range history.created {
+    paths = append(paths, path)
+  }
+  return paths
+}
+
+
+// Returns the num of snapshots of a path created and not deleted
+func (history *snapshot_policy_history) retained(path string) float64 {
+  num_retained := 0.0
+  for snapshot_name := range history.created[path] {
+    if !history.deleted[path + "@" + snapshot_name] {
+      num_retained += 1
+    }
+  }
+  return num_retained
+}
+
+
+// Function to Scrape the history of a snapshot policy. Metrics are reported
+// for every path of the policy and every path found in its history
+func scrape_hdfs_snapshot_policy_history(ctx context.Context, config Collector_connection_data, cluster_name string, service_name string, policy_name string, paths []string, retention float64, ch chan<- prometheus.Metric) bool {
+  commands, err := new_api_client(config).List_snapshot_history(ctx, cluster_name, service_name, policy_name)
+  if err != nil {
+    return false
+  }
+
+  history := new_snapshot_policy_history(paths)
+  for _, command := range commands {
+    result := command.Hdfs_result
+    for _, snapshot := range result.Created_snapshots {
+      history.add_created(snapshot.Path, snapshot.Snapshot_name, snapshot.Creation_time)
+    }
+    for _, snapshot := range result.Deleted_snapshots {
+      history.add_deleted(snapshot.Path, snapshot.Snapshot_name)
+    }
+    for _, snapshot_error := range append(result.Creation_errors, result.Deletion_errors...) {
+      history.add_error(snapshot_error.Path)
+    }
+  }
+
+  for _, path := range history.paths() {
+    ch <- prometheus.MustNewConstMetric(hdfs_snapshot_policy_retention, prometheus.GaugeValue, retention, cluster_name, policy_name, path)
+    ch <- prometheus.MustNewConstMetric(hdfs_snapshot_policy_retained, prometheus.GaugeValue, history.retained(path), cluster_name, policy_name, path)
+    ch <- prometheus.MustNewConstMetric(hdfs_snapshot_policy_failures, prometheus.GaugeValue, history.failures[path], cluster_name, policy_name, path)
+    if timestamp, ok := history.last_success[path]; ok {
+      ch <- prometheus.MustNewConstMetric(hdfs_snapshot_policy_last_success, prometheus.GaugeValue, float64(timestamp.Unix()), cluster_name, policy_name, path)
+    }
+  }
+  return true
+}
+
+
+// Function to Scrape the snapshot policies of an HDFS service. Returns the
+// number of queries succeeded and failed: the policies query and the history
+// query of each policy
+func scrape_hdfs_snapshot_policies(ctx context.Context, config Collector_connection_data, cluster_name string, service_name string, ch chan<- prometheus.Metric) (int, int) {
+  policies, err := new_api_client(config).List_snapshot_policies(ctx, cluster_name, service_name)
+  if err != nil {
+    return 0, 1
+  }
+
+  // The history of the policies is queried in parallel. Each policy keeps
+  // the result of its query in its own index, counted once all finish
+  results := make([]bool, len(policies))
+  tasks := []func(){}
+  for policy_index, policy := range policies {
+    policy_index, policy := policy_index, policy
+    tasks = append(tasks, func() {
+      paths := policy.Hdfs_arguments.Path_patterns
+      paused := 0.0
+      if policy.Paused {
+        paused = 1.0
+      }
+      for _, path := range paths {
+        ch <- prometheus.MustNewConstMetric(hdfs_snapshot_policy_paused, prometheus.GaugeValue, paused, cluster_name, policy.Name, path)
+      }
+      results[policy_index] = scrape_hdfs_snapshot_policy_history(ctx, config, cluster_name, service_name, policy.Name, paths, policy.Get_retention(), ch)
+    })
+  }
+  run_parallel(tasks)
+  success_queries, error_queries := 1, 0
+  for _, result := range results {
+    eval_scrape(result, &success_queries, &error_queries)
+  }
+  return success_queries, error_queries
+}
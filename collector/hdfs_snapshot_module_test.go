@@ -0,0 +1,158 @@
+/*
+ *
+ * title           :collector/hdfs_snapshot_module_test.go
+ * description     :Tests of the HDFS snapshot policies metrics
+ * author		       :Alejandro Villegas
+ * date            :2019/07/10
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "fmt"
+  "net/http"
+  "net/http/httptest"
+  "sort"
+  "strconv"
+  "strings"
+  "testing"
+  "time"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+  dto "github.com/prometheus/client_model/go"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+func Test_snapshot_policy_history(t *testing.T) {
+  history := new_snapshot_policy_history([]string{"/data", "/empty"})
+
+  // Deletions can be seen before the creations of the same snapshots
+  history.add_deleted("/data", "s1")
+  history.add_created("/data", "s1", "2019-07-08T10:00:00Z")
+  history.add_created("/data", "s2", "2019-07-09T10:00:00Z")
+  history.add_created("/data", "s3", "2019-07-07T10:00:00Z")
+  history.add_created("/data", "s3", "2019-07-07T10:00:00Z")
+  history.add_created("/other", "s1", "not a date")
+  history.add_deleted("/other", "s2")
+  history.add_error("/data")
+  history.add_error("/failing")
+  history.add_error("/failing")
+
+  paths := history.paths()
+  sort.Strings(paths)
+  expected_paths := []string{"/data", "/empty", "/failing", "/other"}
+  if len(paths) != len(expected_paths) {
+    t.Fatalf("paths() = %v, expected %v", paths, expected_paths)
+  }
+  for index := range paths {
+    if paths[index] != expected_paths[index] {
+      t.Fatalf("paths() = %v, expected %v", paths, expected_paths)
+    }
+  }
+
+  tests := []struct {
+    path string
+    retained float64
+    failures float64
+    last_success time.Time
+    has_success bool
+  }{
+    {"/data", 2, 1, time.Date(2019, 7, 9, 10, 0, 0, 0, time.UTC), true},
+    {"/empty", 0, 0, time.Time{}, false},
+    {"/failing", 0, 2, time.Time{}, false},
+    {"/other", 1, 0, time.Time{}, false},
+  }
+  for _, test := range tests {
+    if retained := history.retained(test.path); retained != test.retained {
+      t.Errorf("retained(%q) = %v, expected %v", test.path, retained, test.retained)
+    }
+    if failures := history.failures[test.path]; failures != test.failures {
+      t.Errorf("failures[%q] = %v, expected %v", test.path, failures, test.failures)
+    }
+    last_success, ok := history.last_success[test.path]
+    if ok != test.has_success || !last_success.Equal(test.last_success) {
+      t.Errorf("last_success[%q] = %v, %v, expected %v, %v", test.path, last_success, ok, test.last_success, test.has_success)
+    }
+  }
+}
+
+
+// The retained snapshots are counted from the whole history of the policies,
+// requested page by page, and each query is counted once
+func Test_scrape_hdfs_snapshot_policies(t *testing.T) {
+  // Each command creates a snapshot and the last ones delete the first ones
+  num_commands := 300
+  commands := make([]string, num_commands)
+  for index := range commands {
+    deleted := ""
+    if index >= num_commands - 20 {
+      deleted = fmt.Sprintf(`{"path": "/data", "snapshotName": "s%d"}`, index - (num_commands - 20))
+    }
+    commands[index] = fmt.Sprintf(`{"id": %d, "hdfsResult": {"createdSnapshots": [{"path": "/data", "snapshotName": "s%d", "creationTime": "2019-07-08T10:00:00Z"}], "deletedSnapshots": [%s]}}`, 1000 + index, index, deleted)
+  }
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    switch {
+    case strings.HasSuffix(r.URL.Path, "/services/hdfs/snapshots/policies"):
+      w.Write([]byte(`{"items": [
+        {"name": "daily", "hdfsArguments": {"pathPatterns": ["/data"]}, "dailySnapshots": 300},
+        {"name": "failing", "hdfsArguments": {"pathPatterns": ["/tmp"]}}]}`))
+    case strings.HasSuffix(r.URL.Path, "/policies/daily/history"):
+      offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+      limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+      end := offset + limit
+      if end > len(commands) || limit == 0 {
+        end = len(commands)
+      }
+      if offset > end {
+        offset = end
+      }
+      w.Write([]byte(`{"items": [` + strings.Join(commands[offset:end], ",") + `]}`))
+    default:
+      w.WriteHeader(http.StatusInternalServerError)
+    }
+  }))
+  defer server.Close()
+  config := new_test_connection(t, server, Api_client_options{})
+
+  var success, errors int
+  metrics, _ := collect_test_metrics(func(ch chan<- prometheus.Metric) bool {
+    success, errors = scrape_hdfs_snapshot_policies(context.Background(), config, "cluster1", "hdfs", ch)
+    return true
+  })
+  if success != 2 || errors != 1 {
+    t.Errorf("scrape_hdfs_snapshot_policies() = %d success and %d errors, expected 2 and 1", success, errors)
+  }
+  retained := -1.0
+  for _, metric := range metrics {
+    if metric.Desc() != hdfs_snapshot_policy_retained {
+      continue
+    }
+    metric_data := dto.Metric{}
+    if err := metric.Write(&metric_data); err != nil {
+      t.Fatal(err)
+    }
+    retained = metric_data.GetGauge().GetValue()
+  }
+  if retained != float64(num_commands - 20) {
+    t.Errorf("retained_snapshots = %v, expected %d", retained, num_commands - 20)
+  }
+
+  if success, errors := scrape_hdfs_snapshot_policies(context.Background(), config, "cluster1", "unknown", make(chan prometheus.Metric, 100)); success != 0 || errors != 1 {
+    t.Errorf("scrape_hdfs_snapshot_policies() of a failed policies query = %d success and %d errors, expected 0 and 1", success, errors)
+  }
+}
@@ -0,0 +1,308 @@
+/*
+ *
+ * title           :collector/hdfs_usage_module.go
+ * description     :Submodule Collector for the HDFS usage reports by user and watched directory
+ * author		       :Alejandro Villegas
+ * date            :2019/07/04
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "fmt"
+  "sync"
+  "time"
+
+  // Own libraries
+  "keedio/cloudera_exporter/cmapi"
+  jp "keedio/cloudera_exporter/json_parser"
+  log "keedio/cloudera_exporter/logger"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+
+
+
+/* ======================================================================
+ * Constants with the HDFS Usage module queries
+ * ====================================================================== */
+const HDFS_USAGE_SCRAPER_NAME = "hdfs_usage"
+const HDFS_USAGE_DEFAULT_REFRESH_INTERVAL = 3600
+const (
+  // Watched directories TSqueries
+  HDFS_USAGE_DIRECTORY_SIZE_QUERY =   "SELECT LAST(dir_size_bytes) WHERE category=DIRECTORY AND path=\"%s\""
+  HDFS_USAGE_DIRECTORY_FILES_QUERY =  "SELECT LAST(dir_file_count) WHERE category=DIRECTORY AND path=\"%s\""
+)
+
+
+
+
+/* ======================================================================
+ * Global variables
+ * ====================================================================== */
+var (
+  // User Usage Metric Definitions
+  hdfs_usage_bytes = create_hdfs_usage_metric_struct("bytes", "HDFS space used by the files owned by the user, without replication", "nameservice", "user")
+  hdfs_usage_raw_bytes = create_hdfs_usage_metric_struct("raw_bytes", "HDFS raw space used by the files owned by the user, including replication", "nameservice", "user")
+  hdfs_usage_files = create_hdfs_usage_metric_struct("files", "Num of HDFS files owned by the user", "nameservice", "user")
+
+  // Watched Directory Metric Definitions
+  hdfs_usage_directory_bytes = create_hdfs_usage_metric_struct("directory_bytes", "HDFS space used by the watched directory", "path")
+  hdfs_usage_directory_files = create_hdfs_usage_metric_struct("directory_files", "Num of HDFS files in the watched directory", "path")
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Create and returns a prometheus descriptor for a hdfs usage metric labelled
+// by cluster and by the given labels
+func create_hdfs_usage_metric_struct(metric_name string, description string, labels ...string) *prometheus.Desc {
+  return new_metric_desc(
+    prometheus.BuildFQName(namespace, "hdfs_usage", metric_name),
+    description,
+    append([]string{"cluster"}, labels...),
+    nil,
+  )
+}
+
+
+// Returns the newest row of the usage report of each user. The report has
+// one row for each user and day
+func newest_usage_report_rows(rows []jp.Api_hdfs_usage_report_row) map[string]jp.Api_hdfs_usage_report_row {
+  newest := make(map[string]jp.Api_hdfs_usage_report_row)
+  for _, row := range rows {
+    if last, ok := newest[row.User]; !ok || row.Date >= last.Date {
+      newest[row.User] = row
+    }
+  }
+  return newest
+}
+
+
+// Function to Scrape the HDFS usage report by user of an HDFS service. The
+// HA and federated services have a report for each nameservice, and the
+// others a single report without nameservice. Returns the metrics and the
+// number of success and failed queries
+func scrape_hdfs_usage_report(ctx context.Context, config Collector_connection_data, cluster_name string, service_name string) ([]prometheus.Metric, int, int) {
+  metrics := []prometheus.Metric{}
+  client := new_api_client(config)
+  nameservices, err := client.List_nameservices(ctx, cluster_name, service_name)
+  if err != nil {
+    return metrics, 0, 1
+  }
+  nameservice_names := []string{}
+  for _, nameservice := range nameservices {
+    nameservice_names = append(nameservice_names, nameservice.Name)
+  }
+  if len(nameservice_names) == 0 {
+    nameservice_names = append(nameservice_names, "")
+  }
+
+  success_queries := 1
+  error_queries := 0
+  for _, nameservice := range nameservice_names {
+    rows, err := client.Hdfs_usage_report(ctx, cluster_name, service_name, nameservice, time.Now().Add(-48 * time.Hour))
+    if err != nil {
+      error_queries++
+      continue
+    }
+    success_queries++
+    for user, row := range newest_usage_report_rows(rows) {
+      metrics = append(metrics,
+        prometheus.MustNewConstMetric(hdfs_usage_bytes, prometheus.GaugeValue, row.Size, cluster_name, nameservice, user),
+        prometheus.MustNewConstMetric(hdfs_usage_raw_bytes, prometheus.GaugeValue, row.Raw_size, cluster_name, nameservice, user),
+        prometheus.MustNewConstMetric(hdfs_usage_files, prometheus.GaugeValue, row.Num_files, cluster_name, nameservice, user),
+      )
+    }
+  }
+  return metrics, success_queries, error_queries
+}
+
+
+// Function to Scrape the space and file count of a watched directory
+func scrape_hdfs_directory_usage(ctx context.Context, config Collector_connection_data, directory string, query string, metric_struct *prometheus.Desc) ([]prometheus.Metric, bool) {
+  metrics := []prometheus.Metric{}
+  responses, err := make_timeseries_query(ctx, config, fmt.Sprintf(query, directory), cmapi.Time_series_options{})
+  if err != nil || len(responses) == 0 {
+    return metrics, false
+  }
+  for _, serie := range responses[0].Time_series {
+    value, err := serie.Get_value()
+    if err != nil {
+      log.Debug_msg("No data for watched directory: %s", directory)
+      continue
+    }
+    metrics = append(metrics, prometheus.MustNewConstMetric(metric_struct, prometheus.GaugeValue, value, serie.Get_attribute("clusterName"), directory))
+  }
+  return metrics, true
+}
+
+
+
+
+/* ======================================================================
+ * Scrape "Class"
+ * ====================================================================== */
+// ScrapeHDFSUsage struct. The usage reports are expensive for the Cloudera
+// Manager Reports Manager and change slowly, so the metrics are refreshed
+// every Refresh_interval seconds and served from the cache in between. The
+// scraper is shared by all the targets, so each Cloudera Manager has its own
+// cache
+type ScrapeHDFSUsage struct {
+  Watched_directories []string
+  Refresh_interval int
+
+  // Protects the caches. It's never held during a refresh
+  mutex sync.Mutex
+  caches map[string]*hdfs_usage_cache
+}
+
+// Metrics of the last refresh of a Cloudera Manager. While a refresh is
+// running the refreshing channel is set, and it's closed when the refresh
+// ends, so the concurrent scrapes of the same Cloudera Manager wait for it
+// instead of refreshing again
+type hdfs_usage_cache struct {
+  last_refresh time.Time
+  metrics []prometheus.Metric
+  err error
+  refreshing chan struct{}
+}
+
+// Name of the Scraper. Should be unique.
+func (*ScrapeHDFSUsage) Name() string {
+  return HDFS_USAGE_SCRAPER_NAME
+}
+
+// Help describes the role of the Scraper.
+func (*ScrapeHDFSUsage) Help() string {
+  return "HDFS Usage by user and watched directory"
+}
+
+// Version.
+func (*ScrapeHDFSUsage) Version() float64 {
+  return 1.0
+}
+
+// Returns the cache of the metrics of a Cloudera Manager. Must be called with
+// the lock
+func (s *ScrapeHDFSUsage) get_cache(config Collector_connection_data) *hdfs_usage_cache {
+  address := fmt.Sprintf("%s:%s", config.Host, config.Port)
+  if s.caches == nil {
+    s.caches = make(map[string]*hdfs_usage_cache)
+  }
+  cache, ok := s.caches[address]
+  if !ok {
+    cache = &hdfs_usage_cache{}
+    s.caches[address] = cache
+  }
+  return cache
+}
+
+
+// Returns the time between two refreshes of the metrics
+func (s *ScrapeHDFSUsage) get_refresh_interval() time.Duration {
+  if s.Refresh_interval <= 0 {
+    return HDFS_USAGE_DEFAULT_REFRESH_INTERVAL * time.Second
+  }
+  return time.Duration(s.Refresh_interval) * time.Second
+}
+
+
+// Returns true if the cached metrics must be refreshed at the given time. An
+// empty refresh is retried in the next scrape instead of being cached
+func (cache *hdfs_usage_cache) is_expired(refresh_interval time.Duration, now time.Time) bool {
+  return len(cache.metrics) == 0 || now.Sub(cache.last_refresh) >= refresh_interval
+}
+
+
+// Scrape generic function. Override for hdfs usage module.
+func (s *ScrapeHDFSUsage) Scrape (ctx context.Context, config *Collector_connection_data, ch chan<- prometheus.Metric) error {
+  log.Debug_msg("Ejecutando HDFS Usage Metrics Scraper")
+
+  s.mutex.Lock()
+  cache := s.get_cache(*config)
+  if refreshing := cache.refreshing; refreshing != nil {
+    // Wait for the refresh of another scrape and serve its metrics
+    s.mutex.Unlock()
+    select {
+    case <-refreshing:
+    case <-ctx.Done():
+      return ctx.Err()
+    }
+    s.mutex.Lock()
+  } else if cache.is_expired(s.get_refresh_interval(), time.Now()) {
+    refreshing := make(chan struct{})
+    cache.refreshing = refreshing
+    s.mutex.Unlock()
+    metrics, err := s.refresh(ctx, *config)
+    s.mutex.Lock()
+    cache.metrics, cache.err, cache.last_refresh = metrics, err, time.Now()
+    cache.refreshing = nil
+    close(refreshing)
+  }
+  metrics, err := cache.metrics, cache.err
+  s.mutex.Unlock()
+
+  for _, metric := range metrics {
+    ch <- metric
+  }
+  return err
+}
+
+
+// Execute all the queries of the module and returns the resulting metrics and
+// the result of the queries
+func (s *ScrapeHDFSUsage) refresh (ctx context.Context, config Collector_connection_data) ([]prometheus.Metric, error) {
+  metrics := []prometheus.Metric{}
+
+  // Queries counters
+  success_queries := 0
+  error_queries := 0
+
+  // Usage by user of each cluster
+  clusters, err := new_metadata_client(config).List_clusters(ctx)
+  if err != nil {
+    return metrics, scrape_result(0, 1)
+  }
+  for _, cluster := range clusters {
+    hdfs_services, err := get_hdfs_services(ctx, config, cluster.Name)
+    if err != nil {
+      error_queries++
+      continue
+    }
+    for _, service_name := range hdfs_services {
+      service_metrics, service_success, service_errors := scrape_hdfs_usage_report(ctx, config, cluster.Name, service_name)
+      success_queries += service_success
+      error_queries += service_errors
+      metrics = append(metrics, service_metrics...)
+    }
+  }
+
+  // Usage of each watched directory
+  for _, directory := range s.Watched_directories {
+    size_metrics, retval := scrape_hdfs_directory_usage(ctx, config, directory, HDFS_USAGE_DIRECTORY_SIZE_QUERY, hdfs_usage_directory_bytes)
+    eval_scrape(retval, &success_queries, &error_queries)
+    files_metrics, retval := scrape_hdfs_directory_usage(ctx, config, directory, HDFS_USAGE_DIRECTORY_FILES_QUERY, hdfs_usage_directory_files)
+    eval_scrape(retval, &success_queries, &error_queries)
+    metrics = append(metrics, size_metrics...)
+    metrics = append(metrics, files_metrics...)
+  }
+  log.Debug_msg("In the HDFS Usage Module has been executed %d queries. %d success and %d with errors", success_queries + error_queries, success_queries, error_queries)
+  return metrics, scrape_result(success_queries, error_queries)
+}
+
+var _ Scraper = &ScrapeHDFSUsage{}
@@ -0,0 +1,204 @@
+/*
+ *
+ * title           :collector/hdfs_usage_module_test.go
+ * description     :Tests of the HDFS usage reports by user and watched directory
+ * author		       :Alejandro Villegas
+ * date            :2019/07/04
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "net/http"
+  "net/http/httptest"
+  "reflect"
+  "strings"
+  "testing"
+  "time"
+
+  // Own libraries
+  jp "keedio/cloudera_exporter/json_parser"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+func Test_get_cache(t *testing.T) {
+  scraper := &ScrapeHDFSUsage{}
+  cm1 := Collector_connection_data{Host: "cm1", Port: "7180"}
+  cm1_other_port := Collector_connection_data{Host: "cm1", Port: "7183"}
+  cm2 := Collector_connection_data{Host: "cm2", Port: "7180"}
+
+  scraper.mutex.Lock()
+  defer scraper.mutex.Unlock()
+  cache := scraper.get_cache(cm1)
+  if scraper.get_cache(cm1) != cache {
+    t.Errorf("get_cache() returned a new cache for the same Cloudera Manager")
+  }
+  if scraper.get_cache(cm1_other_port) == cache {
+    t.Errorf("get_cache() shared the cache between two ports of the same host")
+  }
+  if scraper.get_cache(cm2) == cache {
+    t.Errorf("get_cache() shared the cache between two Cloudera Managers")
+  }
+}
+
+
+func Test_get_refresh_interval(t *testing.T) {
+  tests := []struct {
+    refresh_interval int
+    expected time.Duration
+  }{
+    {0, HDFS_USAGE_DEFAULT_REFRESH_INTERVAL * time.Second},
+    {-1, HDFS_USAGE_DEFAULT_REFRESH_INTERVAL * time.Second},
+    {60, time.Minute},
+  }
+  for _, test := range tests {
+    scraper := &ScrapeHDFSUsage{Refresh_interval: test.refresh_interval}
+    if interval := scraper.get_refresh_interval(); interval != test.expected {
+      t.Errorf("get_refresh_interval() with %d = %v, expected %v", test.refresh_interval, interval, test.expected)
+    }
+  }
+}
+
+
+func Test_is_expired(t *testing.T) {
+  now := time.Now()
+  metric := prometheus.MustNewConstMetric(hdfs_usage_files, prometheus.GaugeValue, 1, "cluster1", "ns1", "hdfs")
+  tests := []struct {
+    name string
+    cache hdfs_usage_cache
+    expected bool
+  }{
+    {"never refreshed", hdfs_usage_cache{}, true},
+    {"empty refresh", hdfs_usage_cache{last_refresh: now}, true},
+    {"fresh metrics", hdfs_usage_cache{last_refresh: now.Add(-time.Minute), metrics: []prometheus.Metric{metric}}, false},
+    {"interval elapsed", hdfs_usage_cache{last_refresh: now.Add(-time.Hour), metrics: []prometheus.Metric{metric}}, true},
+  }
+  for _, test := range tests {
+    if expired := test.cache.is_expired(time.Hour, now); expired != test.expected {
+      t.Errorf("%s: is_expired() = %v, expected %v", test.name, expired, test.expected)
+    }
+  }
+}
+
+
+// A scrape waiting for the refresh of another one serves its metrics without
+// refreshing again
+func Test_scrape_waits_for_refresh(t *testing.T) {
+  scraper := &ScrapeHDFSUsage{}
+  config := &Collector_connection_data{Host: "test_scrape_waits_for_refresh", Port: "7180"}
+  metric := prometheus.MustNewConstMetric(hdfs_usage_files, prometheus.GaugeValue, 1, "cluster1", "ns1", "hdfs")
+
+  scraper.mutex.Lock()
+  cache := scraper.get_cache(*config)
+  refreshing := make(chan struct{})
+  cache.refreshing = refreshing
+  scraper.mutex.Unlock()
+
+  ch := make(chan prometheus.Metric, 1)
+  done := make(chan error)
+  go func() {
+    done <- scraper.Scrape(context.Background(), config, ch)
+  }()
+
+  scraper.mutex.Lock()
+  cache.metrics, cache.last_refresh = []prometheus.Metric{metric}, time.Now()
+  cache.refreshing = nil
+  close(refreshing)
+  scraper.mutex.Unlock()
+
+  if err := <-done; err != nil {
+    t.Fatalf("Scrape() = %v, expected no error", err)
+  }
+  if served := <-ch; served != metric {
+    t.Errorf("Scrape() did not serve the metrics of the running refresh")
+  }
+}
+
+
+func Test_newest_usage_report_rows(t *testing.T) {
+  rows := []jp.Api_hdfs_usage_report_row{
+    {Date: "2019-07-03T00:00:00.000Z", User: "alice", Size: 1},
+    {Date: "2019-07-04T00:00:00.000Z", User: "alice", Size: 2},
+    {Date: "2019-07-04T00:00:00.000Z", User: "bob", Size: 3},
+    {Date: "2019-07-03T00:00:00.000Z", User: "bob", Size: 4},
+  }
+  tests := []struct {
+    name string
+    rows []jp.Api_hdfs_usage_report_row
+    expected map[string]float64
+  }{
+    {"no rows", nil, map[string]float64{}},
+    {"newest day of each user", rows, map[string]float64{"alice": 2, "bob": 3}},
+  }
+  for _, test := range tests {
+    sizes := map[string]float64{}
+    for user, row := range newest_usage_report_rows(test.rows) {
+      sizes[user] = row.Size
+    }
+    if !reflect.DeepEqual(sizes, test.expected) {
+      t.Errorf("%s: newest_usage_report_rows() = %v, expected %v", test.name, sizes, test.expected)
+    }
+  }
+}
+
+
+// The HA and federated services have a report of each nameservice, and the
+// others a single report without nameservice
+func Test_scrape_hdfs_usage_report(t *testing.T) {
+  report := `{"items": [{"date": "2019-07-04T00:00:00.000Z", "user": "alice", "size": 1, "rawSize": 3, "numFiles": 2}]}`
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    switch {
+    case strings.HasSuffix(r.URL.Path, "/ha/nameservices"):
+      w.Write([]byte(`{"items": [{"name": "ns1"}, {"name": "ns2"}]}`))
+    case strings.HasSuffix(r.URL.Path, "/plain/nameservices"):
+      w.Write([]byte(`{"items": []}`))
+    case strings.HasSuffix(r.URL.Path, "/reports/hdfsUsageReport") && r.URL.Query().Get("nameservice") != "ns2":
+      w.Write([]byte(report))
+    default:
+      w.WriteHeader(http.StatusBadRequest)
+    }
+  }))
+  defer server.Close()
+  config := new_test_connection(t, server, Api_client_options{})
+
+  tests := []struct {
+    service_name string
+    success int
+    errors int
+    expected []string
+  }{
+    {"ha", 2, 1, []string{"{cluster=cluster1,nameservice=ns1,user=alice}"}},
+    {"plain", 2, 0, []string{"{cluster=cluster1,nameservice=,user=alice}"}},
+    {"unknown", 0, 1, []string{}},
+  }
+  for _, test := range tests {
+    metrics, success, errors := scrape_hdfs_usage_report(context.Background(), config, "cluster1", test.service_name)
+    if success != test.success || errors != test.errors {
+      t.Errorf("%s: scrape_hdfs_usage_report() = %d success and %d errors, expected %d and %d", test.service_name, success, errors, test.success, test.errors)
+    }
+    expected := []string{}
+    for _, labels := range test.expected {
+      expected = append(expected, labels, labels, labels)
+    }
+    if formatted := format_metrics(t, metrics); strings.Join(formatted, " ") != strings.Join(expected, " ") {
+      t.Errorf("%s: scrape_hdfs_usage_report() = %v, expected %v", test.service_name, formatted, expected)
+    }
+  }
+}
@@ -87,7 +87,6 @@ const (
  * Global variables
  * ====================================================================== */
 // Prometheus data Descriptors for the metrics to export
-var type_node_list map[string] []string
 var (
   // Agent Metrics
   global_host_agent_cpu_system_percent = create_host_metric_struct("agent_cpu_system_percent", "Agent CPU System Percent")
@@ -127,31 +126,31 @@ var (
 
 // Creation of the structure that relates the queries with the descriptors of the Prometheus metrics
 var host_query_variable_relationship = []relation {
-  {HOST_AGENT_CPU_SYSTEM_PERCENT_QUERY, *global_host_agent_cpu_system_percent},
-  {HOST_AGENT_CPU_USER_PERCENT_QUERY,   *global_host_agent_cpu_user_percent},
-  {HOST_AGENT_PHYS_MEM_USE_QUERY,       *global_host_agent_phys_mem_use},
-  {HOST_AGENT_VIRT_MEM_USE_QUERY,       *global_host_agent_virt_mem_use},
-  {HOST_CPU_CORES_QUERY,                *global_host_cpu_cores},
-  {HOST_CPU_IDLE_PERCENT_QUERY,         *global_host_cpu_iddle_percent},
-  {HOST_CPU_IOWAIT_PERCENT_QUERY,       *global_host_cpu_iowait_percent},
-  {HOST_CPU_LOAD15_QUERY,               *global_host_cpu_load15},
-  {HOST_CPU_LOAD1_QUERY,                *global_host_cpu_load1},
-  {HOST_CPU_LOAD5_QUERY,                *global_host_cpu_load5},
-  {HOST_CPU_PERCENT_QUERY,              *global_host_cpu_percent},
-  {HOST_CPU_SYSTEM_PERCENT_QUERY,       *global_host_cpu_system_percent},
-  {HOST_CPU_USER_PERCENT_QUERY,         *global_host_cpu_user_percent},
-  {HOST_MEM_FREE_QUERY,                 *global_host_mem_free},
-  {HOST_MEM_TOTAL_QUERY,                *global_host_mem_total},
-  {HOST_MEM_USED_QUERY,                 *global_host_mem_used},
-  {HOST_MEM_WRITE_BACK_QUERY,           *global_host_mem_write_back},
-  {HOST_OTHER_ALERTS_QUERY,             *global_host_other_alerts},
-  {HOST_OTHER_CLOCK_OFFSET_QUERY,       *global_host_other_clock_offset},
-  {HOST_OTHER_DNS_RESOLUTION_TIME,      *global_host_other_dns_resolution_time},
-  {HOST_OTHER_UPTIME,                   *global_host_other_uptime},
-  {HOST_SWAP_FREE_QUERY,                *global_host_swap_free},
-  {HOST_SWAP_OUT_QUERY,                 *global_host_swap_out},
-  {HOST_SWAP_TOTAL_QUERY,               *global_host_swap_total},
-  {HOST_SWAP_USED_QUERY,                *global_host_swap_used},
+  {HOST_AGENT_CPU_SYSTEM_PERCENT_QUERY, global_host_agent_cpu_system_percent},
+  {HOST_AGENT_CPU_USER_PERCENT_QUERY,   global_host_agent_cpu_user_percent},
+  {HOST_AGENT_PHYS_MEM_USE_QUERY,       global_host_agent_phys_mem_use},
+  {HOST_AGENT_VIRT_MEM_USE_QUERY,       global_host_agent_virt_mem_use},
+  {HOST_CPU_CORES_QUERY,                global_host_cpu_cores},
+  {HOST_CPU_IDLE_PERCENT_QUERY,         global_host_cpu_iddle_percent},
+  {HOST_CPU_IOWAIT_PERCENT_QUERY,       global_host_cpu_iowait_percent},
+  {HOST_CPU_LOAD15_QUERY,               global_host_cpu_load15},
+  {HOST_CPU_LOAD1_QUERY,                global_host_cpu_load1},
+  {HOST_CPU_LOAD5_QUERY,                global_host_cpu_load5},
+  {HOST_CPU_PERCENT_QUERY,              global_host_cpu_percent},
+  {HOST_CPU_SYSTEM_PERCENT_QUERY,       global_host_cpu_system_percent},
+  {HOST_CPU_USER_PERCENT_QUERY,         global_host_cpu_user_percent},
+  {HOST_MEM_FREE_QUERY,                 global_host_mem_free},
+  {HOST_MEM_TOTAL_QUERY,                global_host_mem_total},
+  {HOST_MEM_USED_QUERY,                 global_host_mem_used},
+  {HOST_MEM_WRITE_BACK_QUERY,           global_host_mem_write_back},
+  {HOST_OTHER_ALERTS_QUERY,             global_host_other_alerts},
+  {HOST_OTHER_CLOCK_OFFSET_QUERY,       global_host_other_clock_offset},
+  {HOST_OTHER_DNS_RESOLUTION_TIME,      global_host_other_dns_resolution_time},
+  {HOST_OTHER_UPTIME,                   global_host_other_uptime},
+  {HOST_SWAP_FREE_QUERY,                global_host_swap_free},
+  {HOST_SWAP_OUT_QUERY,                 global_host_swap_out},
+  {HOST_SWAP_TOTAL_QUERY,               global_host_swap_total},
+  {HOST_SWAP_USED_QUERY,                global_host_swap_used},
 }
 
 
@@ -171,10 +170,10 @@ func create_host_metric_struct(metric_name string, description string) *promethe
   }
 
   // return prometheus descriptor
-  return prometheus.NewDesc(
+  return new_metric_desc(
     prometheus.BuildFQName(namespace, HOST_SCRAPER_NAME, metric_name),
     description,
-    []string{"cluster", "hostname", "hostid", "is_master_node", "is_border_node", "is_worker_node"},
+    []string{"cluster", "hostname", "hostid", "node_class"},
     nil,
   )
 }
@@ -185,40 +184,29 @@ func create_host_metric_struct(metric_name string, description string) *promethe
 // For this module, the cluster to which the host belongs is indifferent.  The
 // name of the cluster to which the host belongs is associated as metadata to
 // its corresponding metric
-func create_host_metric (ctx context.Context, config Collector_connection_data, query string, metric_struct prometheus.Desc, ch chan<- prometheus.Metric) bool {
-  // Make the query
-  json_parsed, err := make_and_parse_timeseries_query(ctx, config, query)
-  if err != nil {
-    return false
-  }
-
-  // Get the num of hosts in the cluster or clusters
-  num_hosts, err := jp.Get_timeseries_num(json_parsed)
-  if err != nil {
+func create_host_metric (response jp.Api_time_series_response, node_class_list map[string] string, metric_struct *prometheus.Desc, ch chan<- prometheus.Metric) bool {
+  if response.Time_series == nil {
     return false
   }
 
   // Extract Metadata for each host
-  for host_index := 0; host_index < num_hosts; host_index ++ {
+  for host_index := range response.Time_series {
+    serie := &response.Time_series[host_index]
     // Get Host ID
-    host_id := jp.Get_timeseries_query_host_id(json_parsed, host_index)
+    host_id := serie.Get_attribute("hostId")
     // Get Host Name
-    host_name := jp.Get_timeseries_query_host_name(json_parsed, host_index)
+    host_name := serie.Get_attribute("hostname")
     // Get Cluster Name
-    cluster_name := jp.Get_timeseries_query_cluster(json_parsed, host_index)
-    // Get the flag to determine if the host is a Master Node
-    is_master_node := get_if_is_master(host_id)
-    // Get the flag to determine if the host is a Border Node
-    is_border_node := get_if_is_border(host_id)
-    // Get the flag to determine if the host is a Worker Node
-    is_worker_node := get_if_is_worker(host_id)
+    cluster_name := serie.Get_attribute("clusterName")
+    // Get the class of the host by the roles it runs
+    node_class := get_node_class(node_class_list, host_id)
     // Get Query LAST value
-    value, err := jp.Get_timeseries_query_value(json_parsed, host_index)
+    value, err := serie.Get_value()
     if err != nil {
 	continue
     }
     // Assing the data to the Prometheus descriptor
-    ch <- prometheus.MustNewConstMetric(&metric_struct, prometheus.GaugeValue, value, cluster_name, host_name, host_id, is_master_node, is_border_node, is_worker_node)
+    ch <- prometheus.MustNewConstMetric(metric_struct, prometheus.GaugeValue, value, cluster_name, host_name, host_id, node_class)
   }
   return true
 }
@@ -229,8 +217,11 @@ func create_host_metric (ctx context.Context, config Collector_connection_data,
 /* ======================================================================
  * Scrape "Class"
  * ====================================================================== */
-// ScrapeHost struct
-type ScrapeHost struct{}
+// ScrapeHost struct. The hosts are labelled with the class of the first
+// Node_classes rule matching their roles
+type ScrapeHost struct{
+  Node_classes []Node_class_rule
+}
 
 // Name of the Scraper. Should be unique.
 func (ScrapeHost) Name() string {
@@ -248,26 +239,25 @@ func (ScrapeHost) Version() float64 {
 }
 
 // Scrape generic function. Override for host module.
-func (ScrapeHost) Scrape (ctx context.Context, config *Collector_connection_data, ch chan<- prometheus.Metric) error {
+func (s ScrapeHost) Scrape (ctx context.Context, config *Collector_connection_data, ch chan<- prometheus.Metric) error {
   log.Debug_msg("Ejecutando Hosts Metrics Scraper")
 
-  // Make the list of the Hosts Types (Master, Worker, Border)
-  type_node_list = get_type_node_list(ctx, *config)
-
-  // Queries counters
-  success_queries := 0
-  error_queries := 0
-
-  // Execute the generic funtion for creation of metrics with the pairs (QUERY, PROM:DESCRIPTOR)
-  for i:=0 ; i < len(host_query_variable_relationship) ; i++ {
-    if create_host_metric(ctx, *config, host_query_variable_relationship[i].Query, host_query_variable_relationship[i].Metric_struct, ch) {
-      success_queries += 1
-    } else {
-      error_queries += 1
-    }
+  // Make the list of the Hosts Classes
+  node_classes := s.Node_classes
+  if len(node_classes) == 0 {
+    node_classes = Default_node_class_rules
   }
+  node_class_list := get_node_class_list(ctx, *config, node_classes)
+
+  // Execute the generic funtion for creation of metrics with the pairs
+  // (QUERY, PROM:DESCRIPTOR), making the queries in batches
+  success_queries, error_queries := scrape_timeseries_relations(ctx, *config, host_query_variable_relationship, func(response jp.Api_time_series_response, query string, metric_struct *prometheus.Desc) bool {
+    return create_host_metric(response, node_class_list, metric_struct, ch)
+  })
+  // Publish the roles and hosts placement as info metrics
+  eval_scrape(scrape_cluster_topology(ctx, *config, ch), &success_queries, &error_queries)
   log.Debug_msg("In the Host Module has been executed %d queries. %d success and %d with errors", success_queries + error_queries, success_queries, error_queries)
-  return nil
+  return scrape_result(success_queries, error_queries)
 }
 
-var _ Scraper = ScrapeHost{}
+var _ Scraper = &ScrapeHost{}
@@ -38,10 +38,7 @@ import (
  * ====================================================================== */
 // None
 
-type relationa struct {
-  Query *string
-  Metric_struct prometheus.Desc
-}
+// None
 
 
 
@@ -49,7 +46,7 @@ type relationa struct {
  * Constants with the Host module TSquery sentences
  * ====================================================================== */
 const IMPALA_SCRAPER_NAME = "impala"
-var (
+const (
   // Agent Queries
   IMPALA_CATALOG_JVM_COMITTED_BYTES =           "SELECT LAST(impala_catalogserver_jvm_heap_committed_usage_bytes) WHERE serviceType = \"IMPALA\""
   IMPALA_CATALOG_JVM_CURRENT_BYTES =            "SELECT LAST(impala_catalogserver_jvm_heap_current_usage_bytes) WHERE serviceType = \"IMPALA\""
@@ -162,57 +159,57 @@ var (
   impala_write_rate =                          create_impala_metric_struct("write_bytes_rate", "The number of bytes written to the device.")
 
 )
-var impala_query_variable_relationship = []relationa {
-  {&IMPALA_CATALOG_JVM_COMITTED_BYTES,          *impala_catalog_jvm_comitted_bytes},
-  {&IMPALA_CATALOG_JVM_CURRENT_BYTES,           *impala_catalog_jvm_current_bytes},
-  {&IMPALA_CATALOG_JVM_INIT_BYTES,              *impala_catalog_jvm_init_bytes},
-  {&IMPALA_CATALOG_JVM_MAX_BYTES,               *impala_catalog_jvm_max_bytes},
-  {&IMPALA_CGROUP_MEM_PAGE_CACHE,               *impala_cgroup_mem_page_cache},
-  {&IMPALA_CGROUP_MEM_RSS,                      *impala_cgroup_mem_rss},
-  {&IMPALA_CGROUP_MEM_SWAP,                     *impala_cgroup_mem_swap},
-  {&IMPALA_CGROUP_READ_IOSRATE,                 *impala_cgroup_read_iosrate},
-  {&IMPALA_CGROUP_READ_RATE,                    *impala_cgroup_read_rate},
-  {&IMPALA_CGROUP_SYSTEM_RATE,                  *impala_cgroup_system_rate},
-  {&IMPALA_CGROUP_USER_RATE,                    *impala_cgroup_user_rate},
-  {&IMPALA_CGROUP_WRITE_IOSRATE,                *impala_cgroup_write_iosrate},
-  {&IMPALA_CGROUP_WRITE_RATE,                   *impala_cgroup_write_rate},
-  {&IMPALA_MEM_RSS,                             *impala_mem_rss},
-  {&IMPALA_MEM_SWAP,                            *impala_mem_swap},
-  {&IMPALA_MEM_VIRT,                            *impala_mem_virt},
-  {&IMPALA_OOMEXIT,                             *impala_oomexit},
-  {&IMPALA_QUERY_ADMISSION_WAIT_RATE,           *impala_query_admission_wait_rate},
-  {&IMPALA_QUERY_BYTES_HDFS_READ_RATE,          *impala_query_bytes_hdfs_read_rate},
-  {&IMPALA_QUERY_BYTES_HDFS_WRITTE_RATE,        *impala_query_bytes_hdfs_writte_rate},
-  {&IMPALA_QUERY_BYTES_STREAMED_RATE,           *impala_query_bytes_streamed_rate},
-  {&IMPALA_QUERY_CM_CPU,                        *impala_query_cm_cpu},
-  {&IMPALA_QUERY_DURATION_RATE,                 *impala_query_duration_rate},
-  {&IMPALA_QUERY_INGESTED_RATE,                 *impala_query_ingested_rate},
-  {&IMPALA_QUERY_MEM_ACCRUAL_RATE,              *impala_query_mem_accrual_rate},
-  {&IMPALA_QUERY_MEM_SPILLED_RATE,              *impala_query_mem_spilled_rate},
-  {&IMPALA_QUERY_OOMRATE,                       *impala_query_oomrate},
-  {&IMPALA_QUERY_REJECTED_RATE,                 *impala_query_rejected_rate},
-  {&IMPALA_QUERY_SPILLED_RATE,                  *impala_query_spilled_rate},
-  {&IMPALA_QUERY_SUCCESSFUL_RATE,               *impala_query_successful_rate},
-  {&IMPALA_QUERY_THREAD_CPU_RATE,               *impala_query_thread_cpu_rate},
-  {&IMPALA_QUERY_TIME_OUT_RATE,                 *impala_query_time_out_rate},
-  {&IMPALA_READ_RATE,                           *impala_read_rate},
-  {&IMPALA_STATE_STORE_CACHE_TOTAL_CLIENTS,     *impala_state_store_cache_total_clients},
-  {&IMPALA_STATE_STORE_CLIENTS_IN_USE,          *impala_state_store_clients_in_use},
-  {&IMPALA_STATE_STORE_HEART_BEAT_LAST,         *impala_state_store_heart_beat_last},
-  {&IMPALA_STATE_STORE_HEART_BEAT_MAX,          *impala_state_store_heart_beat_max},
-  {&IMPALA_STATE_STORE_HEART_BEAT_MEAN,         *impala_state_store_heart_beat_mean},
-  {&IMPALA_STATE_STORE_HEART_BEAT_MIN,          *impala_state_store_heart_beat_min},
-  {&IMPALA_STATE_STORE_HEART_BEAT_RATE,         *impala_state_store_heart_beat_rate},
-  {&IMPALA_STATE_STORE_HEART_BEAT_STDDEV,       *impala_state_store_heart_beat_stddev},
-  {&IMPALA_STATE_STORE_LAST_RECOVERY_DURATION,  *impala_state_store_last_recovery_duration},
-  {&IMPALA_TCMALLOC_FREE_BYTES,                 *impala_tcmalloc_free_bytes},
-  {&IMPALA_TCMALLOC_PHYSICAL_RESERVED_BYTES,    *impala_tcmalloc_physical_reserved_bytes},
-  {&IMPALA_TCMALLOC_TOTAL_RESERVED_BYTES,       *impala_tcmalloc_total_reserved_bytes},
-  {&IMPALA_TCMALLOC_UNMAPPED_BYTES,             *impala_tcmalloc_unmapped_bytes},
-  {&IMPALA_TCMALLOC_USED_BYTES,                 *impala_tcmalloc_used_bytes},
-  {&IMPALA_THRIFT_CONNECTIONS_RATE,             *impala_thrift_connections_rate},
-  {&IMPALA_THRIFT_CONNECTIONS_USED,             *impala_thrift_connections_used},
-  {&IMPALA_WRITE_RATE,                          *impala_write_rate},
+var impala_query_variable_relationship = []relation {
+  {IMPALA_CATALOG_JVM_COMITTED_BYTES,           impala_catalog_jvm_comitted_bytes},
+  {IMPALA_CATALOG_JVM_CURRENT_BYTES,            impala_catalog_jvm_current_bytes},
+  {IMPALA_CATALOG_JVM_INIT_BYTES,               impala_catalog_jvm_init_bytes},
+  {IMPALA_CATALOG_JVM_MAX_BYTES,                impala_catalog_jvm_max_bytes},
+  {IMPALA_CGROUP_MEM_PAGE_CACHE,                impala_cgroup_mem_page_cache},
+  {IMPALA_CGROUP_MEM_RSS,                       impala_cgroup_mem_rss},
+  {IMPALA_CGROUP_MEM_SWAP,                      impala_cgroup_mem_swap},
+  {IMPALA_CGROUP_READ_IOSRATE,                  impala_cgroup_read_iosrate},
+  {IMPALA_CGROUP_READ_RATE,                     impala_cgroup_read_rate},
+  {IMPALA_CGROUP_SYSTEM_RATE,                   impala_cgroup_system_rate},
+  {IMPALA_CGROUP_USER_RATE,                     impala_cgroup_user_rate},
+  {IMPALA_CGROUP_WRITE_IOSRATE,                 impala_cgroup_write_iosrate},
+  {IMPALA_CGROUP_WRITE_RATE,                    impala_cgroup_write_rate},
+  {IMPALA_MEM_RSS,                              impala_mem_rss},
+  {IMPALA_MEM_SWAP,                             impala_mem_swap},
+  {IMPALA_MEM_VIRT,                             impala_mem_virt},
+  {IMPALA_OOMEXIT,                              impala_oomexit},
+  {IMPALA_QUERY_ADMISSION_WAIT_RATE,            impala_query_admission_wait_rate},
+  {IMPALA_QUERY_BYTES_HDFS_READ_RATE,           impala_query_bytes_hdfs_read_rate},
+  {IMPALA_QUERY_BYTES_HDFS_WRITTE_RATE,         impala_query_bytes_hdfs_writte_rate},
+  {IMPALA_QUERY_BYTES_STREAMED_RATE,            impala_query_bytes_streamed_rate},
+  {IMPALA_QUERY_CM_CPU,                         impala_query_cm_cpu},
+  {IMPALA_QUERY_DURATION_RATE,                  impala_query_duration_rate},
+  {IMPALA_QUERY_INGESTED_RATE,                  impala_query_ingested_rate},
+  {IMPALA_QUERY_MEM_ACCRUAL_RATE,               impala_query_mem_accrual_rate},
+  {IMPALA_QUERY_MEM_SPILLED_RATE,               impala_query_mem_spilled_rate},
+  {IMPALA_QUERY_OOMRATE,                        impala_query_oomrate},
+  {IMPALA_QUERY_REJECTED_RATE,                  impala_query_rejected_rate},
+  {IMPALA_QUERY_SPILLED_RATE,                   impala_query_spilled_rate},
+  {IMPALA_QUERY_SUCCESSFUL_RATE,                impala_query_successful_rate},
+  {IMPALA_QUERY_THREAD_CPU_RATE,                impala_query_thread_cpu_rate},
+  {IMPALA_QUERY_TIME_OUT_RATE,                  impala_query_time_out_rate},
+  {IMPALA_READ_RATE,                            impala_read_rate},
+  {IMPALA_STATE_STORE_CACHE_TOTAL_CLIENTS,      impala_state_store_cache_total_clients},
+  {IMPALA_STATE_STORE_CLIENTS_IN_USE,           impala_state_store_clients_in_use},
+  {IMPALA_STATE_STORE_HEART_BEAT_LAST,          impala_state_store_heart_beat_last},
+  {IMPALA_STATE_STORE_HEART_BEAT_MAX,           impala_state_store_heart_beat_max},
+  {IMPALA_STATE_STORE_HEART_BEAT_MEAN,          impala_state_store_heart_beat_mean},
+  {IMPALA_STATE_STORE_HEART_BEAT_MIN,           impala_state_store_heart_beat_min},
+  {IMPALA_STATE_STORE_HEART_BEAT_RATE,          impala_state_store_heart_beat_rate},
+  {IMPALA_STATE_STORE_HEART_BEAT_STDDEV,        impala_state_store_heart_beat_stddev},
+  {IMPALA_STATE_STORE_LAST_RECOVERY_DURATION,   impala_state_store_last_recovery_duration},
+  {IMPALA_TCMALLOC_FREE_BYTES,                  impala_tcmalloc_free_bytes},
+  {IMPALA_TCMALLOC_PHYSICAL_RESERVED_BYTES,     impala_tcmalloc_physical_reserved_bytes},
+  {IMPALA_TCMALLOC_TOTAL_RESERVED_BYTES,        impala_tcmalloc_total_reserved_bytes},
+  {IMPALA_TCMALLOC_UNMAPPED_BYTES,              impala_tcmalloc_unmapped_bytes},
+  {IMPALA_TCMALLOC_USED_BYTES,                  impala_tcmalloc_used_bytes},
+  {IMPALA_THRIFT_CONNECTIONS_RATE,              impala_thrift_connections_rate},
+  {IMPALA_THRIFT_CONNECTIONS_USED,              impala_thrift_connections_used},
+  {IMPALA_WRITE_RATE,                           impala_write_rate},
 }
 
 
@@ -232,7 +229,7 @@ func create_impala_metric_struct(metric_name string, description string) *promet
   }
 
   // return prometheus descriptor
-  return prometheus.NewDesc(
+  return new_metric_desc(
     prometheus.BuildFQName(namespace, IMPALA_SCRAPER_NAME, metric_name),
     description,
     []string{"cluster", "entityName"},
@@ -243,33 +240,25 @@ func create_impala_metric_struct(metric_name string, description string) *promet
 
 // Generic function to extract de metadata associated with the query value
 // Only for Impala metric type
-func create_impala_metric (ctx context.Context, config Collector_connection_data, query string, metric_struct prometheus.Desc, ch chan<- prometheus.Metric) bool {
-  if query == "" { return true }
-  // Make the query
-  json_parsed, err := make_and_parse_timeseries_query(ctx, config, query)
-  if err != nil {
-    return false
-  }
-
-  // Get the num of hosts in the cluster or clusters
-  num_ts_series, err := jp.Get_timeseries_num(json_parsed)
-  if err != nil {
+func create_impala_metric (response jp.Api_time_series_response, query string, metric_struct *prometheus.Desc, ch chan<- prometheus.Metric) bool {
+  if response.Time_series == nil {
     return false
   }
 
   // Extract Metadata for each TimeSerie
-  for ts_index := 0; ts_index < num_ts_series; ts_index ++ {
+  for ts_index := range response.Time_series {
+    serie := &response.Time_series[ts_index]
     // Get the Cluster Name
-    cluster_name := jp.Get_timeseries_query_cluster(json_parsed, ts_index)
-    entity_name := jp.Get_timeseries_query_entity_name(json_parsed, ts_index)
+    cluster_name := serie.Get_attribute("clusterName")
+    entity_name := serie.Get_attribute("entityName")
     // Get Query LAST value
-    value, err := jp.Get_timeseries_query_value(json_parsed, ts_index)
+    value, err := serie.Get_value()
     if err != nil {
       log.Debug_msg("No data for query: %s", query)
       continue
     }
     // Assing the data to the Prometheus descriptor
-    ch <- prometheus.MustNewConstMetric(&metric_struct, prometheus.GaugeValue, value, cluster_name, entity_name)
+    ch <- prometheus.MustNewConstMetric(metric_struct, prometheus.GaugeValue, value, cluster_name, entity_name)
   }
   return true
 }
@@ -308,19 +297,18 @@ func (ScrapeImpalaMetrics) Scrape(ctx context.Context, config *Collector_connect
   // Get Cloudera Version
   cm_version := get_cloudera_manager_version(ctx, *config)
   log.Debug_msg("Version Cloudera: %s", cm_version)
-  load_impala_queries(cm_version)
 
 
-  // Execute the generic funtion for creation of metrics with the pairs (QUERY, PROM:DESCRIPTOR)
-  for i:=0 ; i < len(impala_query_variable_relationship) ; i++ {
-    if create_impala_metric(ctx, *config, *impala_query_variable_relationship[i].Query, impala_query_variable_relationship[i].Metric_struct, ch) {
-      success_queries += 1
-    } else {
-      error_queries += 1
-    }
-  }
+  // Execute the generic funtion for creation of metrics with the pairs
+  // (QUERY, PROM:DESCRIPTOR), making the queries in batches. The queries are
+  // built on each scrape for the version of the Cloudera Manager of this
+  // target, so the targets with other versions don't change them
+  relations := impala_relations(cm_version)
+  success_queries, error_queries = scrape_timeseries_relations(ctx, *config, relations, func(response jp.Api_time_series_response, query string, metric_struct *prometheus.Desc) bool {
+    return create_impala_metric(response, query, metric_struct, ch)
+  })
   log.Debug_msg("In the Impala Module has been executed %d queries. %d success and %d with errors", success_queries + error_queries, success_queries, error_queries)
-  return nil
+  return scrape_result(success_queries, error_queries)
 }
 
 
@@ -0,0 +1,109 @@
+/*
+ *
+ * title           :collector/impala_module_test.go
+ * description     :Tests of the Impala queries of each Cloudera Manager version
+ * author		       :Alejandro Villegas
+ * date            :2019/07/03
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "sync"
+  "testing"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Returns the query of the metric in the relations
+func find_relation_query(relations []relation, metric_struct *prometheus.Desc) (string, bool) {
+  for _, relation := range relations {
+    if relation.Metric_struct == metric_struct {
+      return relation.Query, true
+    }
+  }
+  return "", false
+}
+
+
+
+
+/* ======================================================================
+ * Tests
+ * ====================================================================== */
+func Test_impala_relations(t *testing.T) {
+  spilled_5_16 := "SELECT LAST(INTEGRAL(queries_spilled_memory_rate)) WHERE entityName rlike \".*impala.*\""
+  cases := []struct {
+    version string
+    metric_struct *prometheus.Desc
+    query string
+  }{
+    {"5.16.1", impala_query_mem_spilled_rate, spilled_5_16},
+    {"5.16.1", impala_query_cm_cpu, ""},
+    {"5.16.1", impala_catalog_jvm_max_bytes, IMPALA_CATALOG_JVM_MAX_BYTES},
+    {"5.8", impala_catalog_jvm_max_bytes, ""},
+    {"5.8", impala_query_mem_spilled_rate, IMPALA_QUERY_MEM_SPILLED_RATE},
+    {"6.3.0", impala_query_cm_cpu, IMPALA_QUERY_CM_CPU},
+    {"", impala_catalog_jvm_max_bytes, IMPALA_CATALOG_JVM_MAX_BYTES},
+  }
+  for _, c := range cases {
+    relations := impala_relations(c.version)
+    if len(relations) != len(impala_query_variable_relationship) {
+      t.Errorf("version %q: got %d relations, want %d", c.version, len(relations), len(impala_query_variable_relationship))
+    }
+    if query, ok := find_relation_query(relations, c.metric_struct); !ok || query != c.query {
+      t.Errorf("version %q: got the query %q, want %q", c.version, query, c.query)
+    }
+  }
+
+  // The queries of a version don't change the queries of the rest
+  for index, relation := range impala_query_variable_relationship {
+    if relation.Query == "" {
+      t.Errorf("the base query %d was changed to an empty query", index)
+    }
+  }
+}
+
+
+// The scrapes of targets with different versions build their queries at the
+// same time without sharing them
+func Test_impala_relations_concurrent(t *testing.T) {
+  var wg sync.WaitGroup
+  for i := 0; i < 20; i++ {
+    version := "5.8"
+    if i % 2 == 0 {
+      version = "5.16.1"
+    }
+    wg.Add(1)
+    go func(version string) {
+      defer wg.Done()
+      for j := 0; j < 50; j++ {
+        query, _ := find_relation_query(impala_relations(version), impala_catalog_jvm_max_bytes)
+        if version == "5.16.1" && query != IMPALA_CATALOG_JVM_MAX_BYTES {
+          t.Errorf("version 5.16.1 got the query %q of another version", query)
+          return
+        }
+        if version == "5.8" && query != "" {
+          t.Errorf("version 5.8 got the query %q of another version", query)
+          return
+        }
+      }
+    }(version)
+  }
+  wg.Wait()
+}
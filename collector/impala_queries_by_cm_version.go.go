@@ -13,7 +13,11 @@ package collector
 
 
 import (
+  // Own libraries
   log "keedio/cloudera_exporter/logger"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
 )
 
 
@@ -23,28 +27,45 @@ import (
 //https://golangvedu.wordpress.com/2017/01/31/golang-design-pattern-abstract-factory-and-factory-method/
 
 
-func load_impala_queries(version string) {
+// Queries of the Impala metrics that change in a version of Cloudera Manager.
+// An empty query disables the metric in that version
+var impala_queries_by_cm_version = map[string]map[*prometheus.Desc]string {
+  "5.16.1": {
+    impala_query_admission_wait_rate:         "",
+    impala_query_bytes_hdfs_read_rate:        "",
+    impala_query_bytes_hdfs_writte_rate:      "",
+    impala_query_bytes_streamed_rate:         "",
+    impala_query_cm_cpu:                      "",
+    impala_query_duration_rate:               "",
+    impala_query_mem_accrual_rate:            "",
+    impala_query_mem_spilled_rate:            "SELECT LAST(INTEGRAL(queries_spilled_memory_rate)) WHERE entityName rlike \".*impala.*\"",
+    impala_query_thread_cpu_rate:             "",
+  },
+  "5.8": {
+    impala_catalog_jvm_comitted_bytes:        "",
+    impala_catalog_jvm_current_bytes:         "",
+    impala_catalog_jvm_init_bytes:            "",
+    impala_catalog_jvm_max_bytes:             "",
+  },
+}
+
+
+// Returns the pairs (QUERY, PROM:DESCRIPTOR) of the Impala metrics for a
+// version of Cloudera Manager. The result is a new slice on each call, so the
+// scrapes of targets with different versions don't share their queries
+func impala_relations(version string) []relation {
   log.Debug_msg("Setting queries to Cloudera Manager version %s", version)
-  switch version {
-  case "5.16.1":
-    IMPALA_QUERY_ADMISSION_WAIT_RATE =            ""
-    IMPALA_QUERY_BYTES_HDFS_READ_RATE =           ""
-    IMPALA_QUERY_BYTES_HDFS_WRITTE_RATE =         ""
-    IMPALA_QUERY_BYTES_STREAMED_RATE =            ""
-    IMPALA_QUERY_CM_CPU =                         ""
-    IMPALA_QUERY_DURATION_RATE =                  ""
-    IMPALA_QUERY_MEM_ACCRUAL_RATE =               ""
-    IMPALA_QUERY_MEM_SPILLED_RATE =               "SELECT LAST(INTEGRAL(queries_spilled_memory_rate)) WHERE entityName rlike \".*impala.*\""
-    IMPALA_QUERY_THREAD_CPU_RATE =                ""
-  case "5.8":
-    IMPALA_CATALOG_JVM_COMITTED_BYTES =           ""
-    IMPALA_CATALOG_JVM_CURRENT_BYTES =            ""
-    IMPALA_CATALOG_JVM_INIT_BYTES =               ""
-    IMPALA_CATALOG_JVM_MAX_BYTES =                ""
-
-    default:
+  overrides, ok := impala_queries_by_cm_version[version]
+  if !ok {
     log.Warn_msg("Dont Have specific queries for Cloudera Manager version %s", version)
   }
-}
-
 
+  relations := make([]relation, len(impala_query_variable_relationship))
+  copy(relations, impala_query_variable_relationship)
+  for index := range relations {
+    if query, ok := overrides[relations[index].Metric_struct]; ok {
+      relations[index].Query = query
+    }
+  }
+  return relations
+}
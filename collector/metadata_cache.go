@@ -0,0 +1,393 @@
+/*
+ *
+ * title           :collector/metadata_cache.go
+ * description     :Cache of the Cloudera Manager metadata queries shared by the scrapes
+ * author		       :Alejandro Villegas
+ * date            :2019/08/02
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "fmt"
+  "strings"
+  "sync"
+  "time"
+
+  // Own libraries
+  "keedio/cloudera_exporter/cmapi"
+  jp "keedio/cloudera_exporter/json_parser"
+  log "keedio/cloudera_exporter/logger"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+
+
+
+/* ======================================================================
+ * Constants
+ * ====================================================================== */
+// Default time the metadata queries are cached
+const API_DEFAULT_METADATA_CACHE_TTL = 5 * time.Minute
+
+// The audit events are queried since the previous poll minus this margin, so
+// a clock difference between the exporter and the Cloudera Manager doesn't
+// lose events. The events already seen are not counted again
+const TOPOLOGY_EVENTS_MARGIN = time.Minute
+
+
+
+
+/* ======================================================================
+ * Data Structs
+ * ====================================================================== */
+// Cached decoded response of a metadata query and the entities it lists, to
+// detect the topology changes
+type metadata_cache_entry struct {
+  value interface{}
+  fingerprint string
+  expires time.Time
+}
+
+// Client of the Cloudera Manager API of a connection. The lists of clusters,
+// hosts, services and roles are compared with the metadata cache to detect
+// the topology changes and, if cached is set, served from it during the
+// metadata cache TTL
+type api_client struct {
+  *cmapi.Client
+  config Collector_connection_data
+  cached bool
+}
+
+// Cache of the metadata queries of a Cloudera Manager, by query, with the
+// time of the last poll of its audit events and the events seen in it
+type metadata_cache struct {
+  entries map[string]*metadata_cache_entry
+  hits uint64
+  misses uint64
+  events_polled time.Time
+  seen_events map[string]bool
+}
+
+
+
+
+/* ======================================================================
+ * Global variables
+ * ====================================================================== */
+// Metadata caches by Cloudera Manager address, shared by all the scrapes of
+// the same Cloudera Manager
+var metadata_caches = make(map[string]*metadata_cache)
+var metadata_caches_mutex sync.Mutex
+
+// Codes of the audit events of the Cloudera Manager that change the topology
+var topology_event_codes = map[string]bool {
+  "EV_CLUSTER_CREATED": true,
+  "EV_CLUSTER_DELETED": true,
+  "EV_CLUSTER_RENAMED": true,
+  "EV_SERVICE_CREATED": true,
+  "EV_SERVICE_DELETED": true,
+  "EV_SERVICE_RENAMED": true,
+  "EV_ROLE_CREATED": true,
+  "EV_ROLE_DELETED": true,
+  "EV_HOST_CREATED": true,
+  "EV_HOST_DELETED": true,
+}
+
+var metadataCacheHitsDesc = new_metric_desc(
+  prometheus.BuildFQName(namespace, subsystem, "metadata_cache_hits_total"),
+  "Total number of metadata queries to the Cloudera Manager served from the cache.",
+  nil,
+  nil,
+)
+
+var metadataCacheMissesDesc = new_metric_desc(
+  prometheus.BuildFQName(namespace, subsystem, "metadata_cache_misses_total"),
+  "Total number of metadata queries to the Cloudera Manager not found in the cache or expired.",
+  nil,
+  nil,
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Returns the metadata cache of a Cloudera Manager. Must be called with the
+// lock
+func get_metadata_cache(config Collector_connection_data) *metadata_cache {
+  address := fmt.Sprintf("%s:%s", config.Host, config.Port)
+  cache, ok := metadata_caches[address]
+  if !ok {
+    cache = &metadata_cache{entries: make(map[string]*metadata_cache_entry)}
+    metadata_caches[address] = cache
+  }
+  return cache
+}
+
+
+// Returns the entities listed by a decoded response: the names and the host
+// ids of its items. A change of the entities is a change of the topology
+func topology_fingerprint(value interface{}) string {
+  entities := []string{}
+  switch items := value.(type) {
+  case []jp.Api_cluster:
+    for _, item := range items {
+      entities = append(entities, item.Name)
+    }
+  case []jp.Api_host:
+    for _, item := range items {
+      entities = append(entities, item.Host_id)
+    }
+  case []jp.Api_service:
+    for _, item := range items {
+      entities = append(entities, item.Name)
+    }
+  case []jp.Api_role:
+    for _, item := range items {
+      entities = append(entities, item.Name + "@" + item.Host_ref.Host_id)
+    }
+  case []jp.Api_host_template:
+    for _, item := range items {
+      entities = append(entities, item.Name)
+    }
+  }
+  return strings.Join(entities, ",")
+}
+
+
+// Compare the decoded response of a query with the cached response of the
+// same query. If the listed entities are different the topology of the
+// Cloudera Manager has changed, and all its cached metadata is invalidated
+func check_topology_change(config Collector_connection_data, query string, value interface{}) {
+  metadata_caches_mutex.Lock()
+  defer metadata_caches_mutex.Unlock()
+  cache := get_metadata_cache(config)
+  entry, ok := cache.entries[query]
+  if !ok || entry.fingerprint == topology_fingerprint(value) {
+    return
+  }
+  log.Info_msg("Topology of the Cloudera Manager %s:%s changed in the query %s, invalidating the metadata cache", config.Host, config.Port, query)
+  cache.entries = make(map[string]*metadata_cache_entry)
+}
+
+
+// Returns the code of the event if it is an audit event that changes the
+// topology, or an empty string
+func get_topology_event_code(event jp.Api_event) string {
+  for _, attribute := range event.Attributes {
+    if attribute.Name != "EVENTCODE" {
+      continue
+    }
+    for _, code := range attribute.Values {
+      if topology_event_codes[code] {
+        return code
+      }
+    }
+  }
+  return ""
+}
+
+
+// Query the audit events received by the Cloudera Manager since the previous
+// poll, the cheap signal of a topology change, and invalidate all its cached
+// metadata if a cluster, service, role or host was created, deleted or
+// renamed. The first poll only takes note of the events already received
+func poll_topology_events(ctx context.Context, config Collector_connection_data) {
+  if config.Client.Metadata_cache_ttl <= 0 {
+    return
+  }
+  now := time.Now()
+  metadata_caches_mutex.Lock()
+  cache := get_metadata_cache(config)
+  since := cache.events_polled
+  seen_events := cache.seen_events
+  metadata_caches_mutex.Unlock()
+  if since.IsZero() {
+    since = now
+  }
+
+  query := fmt.Sprintf("category==AUDIT_EVENT;timeReceived=ge=%s", since.Add(-TOPOLOGY_EVENTS_MARGIN).UTC().Format("2006-01-02T15:04:05.000Z"))
+  events, err := new_cmapi_client(config).Events(ctx, query)
+  if err != nil {
+    log.Warn_msg("Can't query the audit events of the Cloudera Manager %s:%s to detect the topology changes: %s", config.Host, config.Port, err)
+    return
+  }
+  events_ids := make(map[string]bool)
+  change_code := ""
+  for _, event := range events {
+    events_ids[event.Id] = true
+    if seen_events == nil || seen_events[event.Id] {
+      continue
+    }
+    if code := get_topology_event_code(event); code != "" {
+      change_code = code
+    }
+  }
+
+  metadata_caches_mutex.Lock()
+  defer metadata_caches_mutex.Unlock()
+  cache.events_polled = now
+  cache.seen_events = events_ids
+  if change_code != "" {
+    log.Info_msg("Topology of the Cloudera Manager %s:%s changed by the audit event %s, invalidating the metadata cache", config.Host, config.Port, change_code)
+    cache.entries = make(map[string]*metadata_cache_entry)
+  }
+}
+
+
+// Make a query of metadata that changes rarely, like the lists of clusters,
+// hosts, services and roles, or the Cloudera Manager version, with the query
+// function, which returns the decoded response. The response is compared
+// with the cached one and, if the cache is used, cached during the metadata
+// cache TTL
+func make_metadata_query(config Collector_connection_data, query string, cached bool, make_query func() (interface{}, error)) (interface{}, error) {
+  cached = cached && config.Client.Metadata_cache_ttl > 0
+  if cached {
+    metadata_caches_mutex.Lock()
+    cache := get_metadata_cache(config)
+    if entry, ok := cache.entries[query]; ok && time.Now().Before(entry.expires) {
+      cache.hits++
+      metadata_caches_mutex.Unlock()
+      return entry.value, nil
+    }
+    cache.misses++
+    metadata_caches_mutex.Unlock()
+  }
+
+  // The response is compared with the expired entry, if any, before replacing
+  // it
+  value, err := make_query()
+  if err != nil {
+    return value, err
+  }
+  check_topology_change(config, query, value)
+  if cached {
+    metadata_caches_mutex.Lock()
+    get_metadata_cache(config).entries[query] = &metadata_cache_entry {
+      value: value,
+      fingerprint: topology_fingerprint(value),
+      expires: time.Now().Add(config.Client.Metadata_cache_ttl),
+    }
+    metadata_caches_mutex.Unlock()
+  }
+  return value, nil
+}
+
+
+// Returns a client of the Cloudera Manager API whose queries are made with
+// retries, rate limit and the circuit breaker of the Cloudera Manager
+func new_api_client(config Collector_connection_data) *api_client {
+  return &api_client{Client: new_cmapi_client(config), config: config}
+}
+
+
+// Returns a client of the Cloudera Manager API whose metadata queries are
+// also cached in the metadata cache
+func new_metadata_client(config Collector_connection_data) *api_client {
+  return &api_client{Client: new_cmapi_client(config), config: config, cached: true}
+}
+
+
+// Returns the clusters of the Cloudera Manager
+func (client *api_client) List_clusters(ctx context.Context) ([]jp.Api_cluster, error) {
+  value, err := make_metadata_query(client.config, cmapi.Clusters_path(), client.cached, func() (interface{}, error) {
+    return client.Client.List_clusters(ctx)
+  })
+  clusters, _ := value.([]jp.Api_cluster)
+  return clusters, err
+}
+
+
+// Returns the host templates of a cluster
+func (client *api_client) List_host_templates(ctx context.Context, cluster_name string) ([]jp.Api_host_template, error) {
+  value, err := make_metadata_query(client.config, cmapi.Host_templates_path(cluster_name), client.cached, func() (interface{}, error) {
+    return client.Client.List_host_templates(ctx, cluster_name)
+  })
+  templates, _ := value.([]jp.Api_host_template)
+  return templates, err
+}
+
+
+// Returns the hosts of the Cloudera Manager
+func (client *api_client) List_hosts(ctx context.Context) ([]jp.Api_host, error) {
+  value, err := make_metadata_query(client.config, cmapi.Hosts_path(), client.cached, func() (interface{}, error) {
+    return client.Client.List_hosts(ctx)
+  })
+  hosts, _ := value.([]jp.Api_host)
+  return hosts, err
+}
+
+
+// Returns the services of a cluster
+func (client *api_client) List_services(ctx context.Context, cluster_name string) ([]jp.Api_service, error) {
+  value, err := make_metadata_query(client.config, cmapi.Services_path(cluster_name), client.cached, func() (interface{}, error) {
+    return client.Client.List_services(ctx, cluster_name)
+  })
+  services, _ := value.([]jp.Api_service)
+  return services, err
+}
+
+
+// Returns the roles of a service of a cluster
+func (client *api_client) List_roles(ctx context.Context, cluster_name string, service_name string) ([]jp.Api_role, error) {
+  value, err := make_metadata_query(client.config, cmapi.Roles_path(cluster_name, service_name), client.cached, func() (interface{}, error) {
+    return client.Client.List_roles(ctx, cluster_name, service_name)
+  })
+  roles, _ := value.([]jp.Api_role)
+  return roles, err
+}
+
+
+// Returns the roles of the Cloudera Management Service
+func (client *api_client) List_management_roles(ctx context.Context) ([]jp.Api_role, error) {
+  value, err := make_metadata_query(client.config, cmapi.Management_roles_path(), client.cached, func() (interface{}, error) {
+    return client.Client.List_management_roles(ctx)
+  })
+  roles, _ := value.([]jp.Api_role)
+  return roles, err
+}
+
+
+// Returns the version of the Cloudera Manager
+func (client *api_client) Version(ctx context.Context) (jp.Api_version_info, error) {
+  value, err := make_metadata_query(client.config, "cm/version", client.cached, func() (interface{}, error) {
+    return client.Client.Version(ctx)
+  })
+  version, _ := value.(jp.Api_version_info)
+  return version, err
+}
+
+
+// Invalidate the cached metadata of all the Cloudera Managers, with the API
+// versions queried to them
+func Invalidate_metadata_cache() {
+  invalidate_api_versions()
+  metadata_caches_mutex.Lock()
+  defer metadata_caches_mutex.Unlock()
+  for _, cache := range metadata_caches {
+    cache.entries = make(map[string]*metadata_cache_entry)
+  }
+}
+
+
+// Returns the number of metadata queries to the Cloudera Manager served from
+// the cache and not found in the cache
+func Get_metadata_cache_stats(config Collector_connection_data) (uint64, uint64) {
+  metadata_caches_mutex.Lock()
+  defer metadata_caches_mutex.Unlock()
+  cache := get_metadata_cache(config)
+  return cache.hits, cache.misses
+}
@@ -0,0 +1,200 @@
+/*
+ *
+ * title           :collector/metadata_cache_test.go
+ * description     :Tests of the cache of the Cloudera Manager metadata queries
+ * author		       :Alejandro Villegas
+ * date            :2019/08/02
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "errors"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "sync"
+  "testing"
+  "time"
+
+  // Own libraries
+  jp "keedio/cloudera_exporter/json_parser"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+func Test_topology_fingerprint(t *testing.T) {
+  tests := []struct {
+    name string
+    value interface{}
+    expected string
+  }{
+    {"clusters", []jp.Api_cluster{{Name: "cluster1"}, {Name: "cluster2"}}, "cluster1,cluster2"},
+    {"hosts", []jp.Api_host{{Host_id: "h1", Hostname: "host1"}}, "h1"},
+    {"services", []jp.Api_service{{Name: "hdfs", Service_state: "STARTED"}}, "hdfs"},
+    {"roles", []jp.Api_role{{Name: "hdfs-DATANODE-1", Host_ref: jp.Api_host_ref{Host_id: "h1"}}}, "hdfs-DATANODE-1@h1"},
+    {"host templates", []jp.Api_host_template{{Name: "workers"}}, "workers"},
+    {"empty list", []jp.Api_host{}, ""},
+    {"not a list", jp.Api_version_info{Version: "6.3.0"}, ""},
+  }
+  for _, test := range tests {
+    if result := topology_fingerprint(test.value); result != test.expected {
+      t.Errorf("%s: topology_fingerprint() = %q, expected %q", test.name, result, test.expected)
+    }
+  }
+}
+
+
+func Test_make_metadata_query(t *testing.T) {
+  config := Collector_connection_data{Host: "metadata-cache-test", Port: "7180", Client: Api_client_options{Metadata_cache_ttl: time.Hour}}
+  Invalidate_metadata_cache()
+  hosts := []jp.Api_host{{Host_id: "h1"}}
+  queries := 0
+  make_query := func() (interface{}, error) {
+    queries++
+    return hosts, nil
+  }
+
+  steps := []struct {
+    name string
+    query string
+    cached bool
+    hosts []jp.Api_host
+    queries int
+  }{
+    {"miss", "hosts", true, hosts, 1},
+    {"hit", "hosts", true, hosts, 1},
+    {"other query", "clusters/cluster1/services", true, hosts, 2},
+    {"not cached", "hosts", false, hosts, 3},
+    {"topology change", "hosts", false, []jp.Api_host{{Host_id: "h1"}, {Host_id: "h2"}}, 4},
+    {"invalidated", "hosts", true, []jp.Api_host{{Host_id: "h1"}, {Host_id: "h2"}}, 5},
+    {"other query invalidated", "clusters/cluster1/services", true, []jp.Api_host{{Host_id: "h1"}, {Host_id: "h2"}}, 6},
+  }
+  for _, step := range steps {
+    hosts = step.hosts
+    value, err := make_metadata_query(config, step.query, step.cached, make_query)
+    if result, _ := value.([]jp.Api_host); err != nil || len(result) != len(step.hosts) || queries != step.queries {
+      t.Errorf("%s: make_metadata_query() = %v, %v after %d queries, expected %v after %d", step.name, value, err, queries, step.hosts, step.queries)
+    }
+  }
+
+  hits, misses := Get_metadata_cache_stats(config)
+  if hits != 1 || misses != 4 {
+    t.Errorf("Get_metadata_cache_stats() = %d, %d, expected 1, 4", hits, misses)
+  }
+
+  failed := errors.New("failed")
+  if _, err := make_metadata_query(config, "clusters", true, func() (interface{}, error) { return nil, failed }); err != failed {
+    t.Errorf("make_metadata_query() of a failed query = %v, expected %v", err, failed)
+  }
+}
+
+
+func Test_get_topology_event_code(t *testing.T) {
+  tests := []struct {
+    name string
+    attributes []jp.Api_event_attribute
+    expected string
+  }{
+    {"host added", []jp.Api_event_attribute{{Name: "EVENTCODE", Values: []string{"EV_HOST_CREATED"}}}, "EV_HOST_CREATED"},
+    {"role deleted", []jp.Api_event_attribute{{Name: "SERVICE", Values: []string{"hdfs"}}, {Name: "EVENTCODE", Values: []string{"EV_ROLE_DELETED"}}}, "EV_ROLE_DELETED"},
+    {"other audit event", []jp.Api_event_attribute{{Name: "EVENTCODE", Values: []string{"EV_LOGIN_SUCCESS"}}}, ""},
+    {"other attribute", []jp.Api_event_attribute{{Name: "COMMAND", Values: []string{"EV_HOST_CREATED"}}}, ""},
+    {"no attributes", nil, ""},
+  }
+  for _, test := range tests {
+    if code := get_topology_event_code(jp.Api_event{Attributes: test.attributes}); code != test.expected {
+      t.Errorf("%s: get_topology_event_code() = %q, expected %q", test.name, code, test.expected)
+    }
+  }
+}
+
+
+// A host added inside the metadata cache TTL is seen on the next scrape,
+// through the audit event of its creation
+func Test_poll_topology_events(t *testing.T) {
+  var lock sync.Mutex
+  hosts := `{"items": [{"hostId": "h1"}]}`
+  events := `{"items": [{"id": "e1", "category": "AUDIT_EVENT", "attributes": [{"name": "EVENTCODE", "values": ["EV_HOST_CREATED"]}]}]}`
+  events_queries := []string{}
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    lock.Lock()
+    defer lock.Unlock()
+    switch {
+    case strings.HasSuffix(r.URL.Path, "/hosts"):
+      w.Write([]byte(hosts))
+    case strings.HasSuffix(r.URL.Path, "/events"):
+      events_queries = append(events_queries, r.URL.Query().Get("query"))
+      w.Write([]byte(events))
+    default:
+      w.WriteHeader(http.StatusNotFound)
+    }
+  }))
+  defer server.Close()
+  config := new_test_connection(t, server, Api_client_options{Metadata_cache_ttl: time.Hour})
+  ctx := context.Background()
+
+  list_hosts := func() int {
+    result, err := new_metadata_client(config).List_hosts(ctx)
+    if err != nil {
+      t.Fatalf("List_hosts() failed: %s", err)
+    }
+    return len(result)
+  }
+  set_response := func(new_hosts string, new_events string) {
+    lock.Lock()
+    defer lock.Unlock()
+    hosts = new_hosts
+    events = new_events
+  }
+
+  // The events received before the first poll don't invalidate the cache
+  poll_topology_events(ctx, config)
+  if result := list_hosts(); result != 1 {
+    t.Fatalf("List_hosts() = %d hosts, expected 1", result)
+  }
+  set_response(`{"items": [{"hostId": "h1"}, {"hostId": "h2"}]}`, events)
+  poll_topology_events(ctx, config)
+  if result := list_hosts(); result != 1 {
+    t.Errorf("List_hosts() after an event already seen = %d hosts, expected the cached 1", result)
+  }
+
+  // Other audit events don't invalidate the cache either
+  set_response(`{"items": [{"hostId": "h1"}, {"hostId": "h2"}]}`, `{"items": [
+    {"id": "e1", "attributes": [{"name": "EVENTCODE", "values": ["EV_HOST_CREATED"]}]},
+    {"id": "e2", "attributes": [{"name": "EVENTCODE", "values": ["EV_LOGIN_SUCCESS"]}]}]}`)
+  poll_topology_events(ctx, config)
+  if result := list_hosts(); result != 1 {
+    t.Errorf("List_hosts() after another audit event = %d hosts, expected the cached 1", result)
+  }
+
+  // A new host created inside the TTL invalidates the cache
+  set_response(`{"items": [{"hostId": "h1"}, {"hostId": "h2"}]}`, `{"items": [
+    {"id": "e2", "attributes": [{"name": "EVENTCODE", "values": ["EV_LOGIN_SUCCESS"]}]},
+    {"id": "e3", "attributes": [{"name": "EVENTCODE", "values": ["EV_HOST_CREATED"]}]}]}`)
+  poll_topology_events(ctx, config)
+  if result := list_hosts(); result != 2 {
+    t.Errorf("List_hosts() after a topology change = %d hosts, expected 2", result)
+  }
+
+  lock.Lock()
+  defer lock.Unlock()
+  for _, query := range events_queries {
+    if !strings.HasPrefix(query, "category==AUDIT_EVENT;timeReceived=ge=") {
+      t.Errorf("Unexpected query of the audit events: %q", query)
+    }
+  }
+}
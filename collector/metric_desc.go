@@ -0,0 +1,112 @@
+/*
+ *
+ * title           :collector/metric_desc.go
+ * description     :Descriptors of the metrics with their names and label names
+ * author		       :Alejandro Villegas
+ * date            :2019/07/30
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "sync"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+
+
+
+/* ======================================================================
+ * Global variables
+ * ====================================================================== */
+// Full name of the metrics of each descriptor, to filter the metrics by name,
+// and label names of the metrics of the exporter, that the constant labels
+// can't use. The names of the custom metrics are the ones of the config in
+// use, replaced as a whole when a new config is set
+var (
+  metric_descs_mutex sync.RWMutex
+  metric_desc_names = make(map[*prometheus.Desc]string)
+  metric_label_names = make(map[string]bool)
+  custom_metric_desc_names = make(map[*prometheus.Desc]string)
+)
+
+// Label names of the metrics of the API queries, created with the first query
+var _ = reserve_metric_label_names("code", "endpoint", "query")
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Mark the label names as used by the metrics of the exporter, and return them
+func reserve_metric_label_names(label_names ...string) []string {
+  metric_descs_mutex.Lock()
+  defer metric_descs_mutex.Unlock()
+  for _, label_name := range label_names {
+    metric_label_names[label_name] = true
+  }
+  return label_names
+}
+
+
+// Create and returns a prometheus descriptor, keeping its full name and
+// reserving its label names. Used for all the metrics of the exporter
+func new_metric_desc(fq_name string, help string, variable_labels []string, const_labels prometheus.Labels) *prometheus.Desc {
+  desc := prometheus.NewDesc(fq_name, help, variable_labels, const_labels)
+  reserve_metric_label_names(variable_labels...)
+  for label_name := range const_labels {
+    reserve_metric_label_names(label_name)
+  }
+
+  metric_descs_mutex.Lock()
+  defer metric_descs_mutex.Unlock()
+  metric_desc_names[desc] = fq_name
+  return desc
+}
+
+
+// Set the custom metrics of the config in use, so their descriptors are
+// known by name. The ones of the previous config are dropped. It's called
+// when a new config is set, never while a config is parsed, so a config that
+// is rejected or only checked does not change the metrics in use
+func Set_custom_metrics(metrics []*Custom_metric) {
+  names := make(map[*prometheus.Desc]string)
+  for _, metric := range metrics {
+    names[metric.Metric_struct] = metric.fq_name
+  }
+
+  metric_descs_mutex.Lock()
+  defer metric_descs_mutex.Unlock()
+  custom_metric_desc_names = names
+}
+
+
+// Returns the full name of the metrics of a prometheus descriptor, or "" if
+// the descriptor was not created by the exporter
+func Get_desc_name(desc *prometheus.Desc) string {
+  metric_descs_mutex.RLock()
+  defer metric_descs_mutex.RUnlock()
+  if name, ok := metric_desc_names[desc]; ok {
+    return name
+  }
+  return custom_metric_desc_names[desc]
+}
+
+
+// Returns true if the label name is used by the metrics of the exporter
+func Is_metric_label_name(label_name string) bool {
+  metric_descs_mutex.RLock()
+  defer metric_descs_mutex.RUnlock()
+  return metric_label_names[label_name]
+}
@@ -0,0 +1,95 @@
+/*
+ *
+ * title           :collector/metric_desc_test.go
+ * description     :Tests of the descriptors of the metrics with their names and label names
+ * author		       :Alejandro Villegas
+ * date            :2019/07/30
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "testing"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+func Test_Get_desc_name(t *testing.T) {
+  new_custom := func(help string) *Custom_metric {
+    metric, err := New_custom_metric("test_get_desc_name", help, "SELECT cpu_percent", "", [][2]string{{"pool", "poolName"}}, Custom_metric_time_series{})
+    if err != nil {
+      t.Fatal(err)
+    }
+    return metric
+  }
+  // The custom metrics are known once their config is set, and the ones of
+  // the previous config are dropped. A parsed config that is not set, like
+  // a rejected reload, does not change them
+  first_custom := new_custom("First")
+  second_custom := new_custom("Second")
+  Set_custom_metrics([]*Custom_metric{first_custom})
+  Set_custom_metrics([]*Custom_metric{second_custom})
+  rejected_custom := new_custom("Rejected")
+  defer Set_custom_metrics(nil)
+  tests := []struct {
+    name string
+    desc *prometheus.Desc
+    expected string
+  }{
+    {"module metric", hdfs_namenode_ha_active, "kbdi_hdfs_namenode_ha_active"},
+    {"timeseries metric", global_host_cpu_cores, "kbdi_host_cpu_cores"},
+    {"exporter metric", upDesc, "kbdi_exporter_up"},
+    {"custom metric", second_custom.Metric_struct, "kbdi_custom_test_get_desc_name"},
+    {"custom metric of a previous config", first_custom.Metric_struct, ""},
+    {"custom metric of a config not set", rejected_custom.Metric_struct, ""},
+    {"unknown descriptor", prometheus.NewDesc("kbdi_unknown", "Unknown", nil, nil), ""},
+  }
+  for _, test := range tests {
+    if name := Get_desc_name(test.desc); name != test.expected {
+      t.Errorf("%s: Get_desc_name() = %q, expected %q", test.name, name, test.expected)
+    }
+  }
+}
+
+
+func Test_Is_metric_label_name(t *testing.T) {
+  metric, err := New_custom_metric("test_is_metric_label_name", "", "SELECT cpu_percent", "", [][2]string{{"test_custom_label", "poolName"}}, Custom_metric_time_series{})
+  if err != nil {
+    t.Fatal(err)
+  }
+  Set_custom_metrics([]*Custom_metric{metric})
+  defer Set_custom_metrics(nil)
+  tests := []struct {
+    label_name string
+    expected bool
+  }{
+    {"cluster", true},
+    {"hostname", true},
+    {"nameservice", true},
+    {"collector", true},
+    {"endpoint", true},
+    {"query", true},
+    {"environment", false},
+    {"test_custom_label", false},
+  }
+  for _, test := range tests {
+    if is_label := Is_metric_label_name(test.label_name); is_label != test.expected {
+      t.Errorf("Is_metric_label_name(%q) = %v, expected %v", test.label_name, is_label, test.expected)
+    }
+  }
+}
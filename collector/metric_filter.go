@@ -0,0 +1,198 @@
+/*
+ *
+ * title           :collector/metric_filter.go
+ * description     :Filters of the published metrics by name and label values
+ * author		       :Alejandro Villegas
+ * date            :2019/07/29
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "fmt"
+  "regexp"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+  dto "github.com/prometheus/client_model/go"
+)
+
+
+
+
+/* ======================================================================
+ * Data Structs
+ * ====================================================================== */
+// Filter of the published metrics. The regular expressions match the whole
+// metric name or label value. A metric is published if its name matches any
+// of the Include expressions (or there are none) and none of the Exclude
+// ones, and its label values pass the label expressions. Metrics without a
+// filtered label are not affected by the expression of that label
+type Metric_filter struct {
+  Include []string
+  Exclude []string
+  Include_labels map[string]string
+  Exclude_labels map[string]string
+
+  include []*regexp.Regexp
+  exclude []*regexp.Regexp
+  include_labels map[string]*regexp.Regexp
+  exclude_labels map[string]*regexp.Regexp
+}
+
+// Key of the module options in the scrape context
+type module_options_key struct{}
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Compile a regular expression that matches the whole value
+func compile_anchored_regexp(expression string) (*regexp.Regexp, error) {
+  compiled, err := regexp.Compile("^(?:" + expression + ")$")
+  if err != nil {
+    return nil, fmt.Errorf("Invalid regular expression %q: %s", expression, err)
+  }
+  return compiled, nil
+}
+
+
+// Create a metric filter compiling its regular expressions
+func New_metric_filter(include []string, exclude []string, include_labels map[string]string, exclude_labels map[string]string) (*Metric_filter, error) {
+  filter := Metric_filter {
+    Include: include,
+    Exclude: exclude,
+    Include_labels: include_labels,
+    Exclude_labels: exclude_labels,
+    include_labels: make(map[string]*regexp.Regexp),
+    exclude_labels: make(map[string]*regexp.Regexp),
+  }
+  for _, expression := range include {
+    compiled, err := compile_anchored_regexp(expression)
+    if err != nil {
+      return nil, err
+    }
+    filter.include = append(filter.include, compiled)
+  }
+  for _, expression := range exclude {
+    compiled, err := compile_anchored_regexp(expression)
+    if err != nil {
+      return nil, err
+    }
+    filter.exclude = append(filter.exclude, compiled)
+  }
+  for label, expression := range include_labels {
+    compiled, err := compile_anchored_regexp(expression)
+    if err != nil {
+      return nil, err
+    }
+    filter.include_labels[label] = compiled
+  }
+  for label, expression := range exclude_labels {
+    compiled, err := compile_anchored_regexp(expression)
+    if err != nil {
+      return nil, err
+    }
+    filter.exclude_labels[label] = compiled
+  }
+  return &filter, nil
+}
+
+
+// Returns true if the filter has no expressions
+func (filter *Metric_filter) Is_empty() bool {
+  return len(filter.include) == 0 && len(filter.exclude) == 0 && len(filter.include_labels) == 0 && len(filter.exclude_labels) == 0
+}
+
+
+// Returns true if the metric name passes the filter
+func (filter *Metric_filter) Match_name(name string) bool {
+  for _, expression := range filter.exclude {
+    if expression.MatchString(name) {
+      return false
+    }
+  }
+  if len(filter.include) == 0 {
+    return true
+  }
+  for _, expression := range filter.include {
+    if expression.MatchString(name) {
+      return true
+    }
+  }
+  return false
+}
+
+
+// Returns true if the label values pass the filter
+func (filter *Metric_filter) Match_labels(labels []*dto.LabelPair) bool {
+  for _, label := range labels {
+    if expression, ok := filter.exclude_labels[label.GetName()]; ok && expression.MatchString(label.GetValue()) {
+      return false
+    }
+    if expression, ok := filter.include_labels[label.GetName()]; ok && !expression.MatchString(label.GetValue()) {
+      return false
+    }
+  }
+  return true
+}
+
+
+// Returns the label pairs of the labels
+func make_label_pairs(labels map[string]string) []*dto.LabelPair {
+  label_pairs := []*dto.LabelPair{}
+  for name, value := range labels {
+    name, value := name, value
+    label_pairs = append(label_pairs, &dto.LabelPair{Name: &name, Value: &value})
+  }
+  return label_pairs
+}
+
+
+// Returns true if the metric, with the extra labels added to it, passes the
+// filter
+func (filter *Metric_filter) Match(name string, metric prometheus.Metric, extra_labels []*dto.LabelPair) bool {
+  if !filter.Match_name(name) {
+    return false
+  }
+  if len(filter.include_labels) == 0 && len(filter.exclude_labels) == 0 {
+    return true
+  }
+  metric_data := dto.Metric{}
+  if err := metric.Write(&metric_data); err != nil {
+    return true
+  }
+  return filter.Match_labels(append(metric_data.GetLabel(), extra_labels...))
+}
+
+
+// Returns a context with the module options, used by the scrapers to skip the
+// queries of the metrics that are not published
+func with_module_options(ctx context.Context, options *Module_options) context.Context {
+  return context.WithValue(ctx, module_options_key{}, options)
+}
+
+
+// Returns false if the metrics of the descriptor are not published with the
+// module options of the context, so the query of the metrics can be skipped
+func is_metric_scraped(ctx context.Context, desc *prometheus.Desc) bool {
+  if ctx == nil {
+    return true
+  }
+  options, ok := ctx.Value(module_options_key{}).(*Module_options)
+  if !ok {
+    return true
+  }
+  return options.Is_metric_name_published(Get_desc_name(desc))
+}
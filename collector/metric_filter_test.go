@@ -0,0 +1,192 @@
+/*
+ *
+ * title           :collector/metric_filter_test.go
+ * description     :Tests of the filters of the published metrics
+ * author		       :Alejandro Villegas
+ * date            :2019/07/29
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "testing"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Returns the metric filter of the expressions, failing the test if they are
+// not valid
+func must_metric_filter(t *testing.T, include []string, exclude []string, include_labels map[string]string, exclude_labels map[string]string) *Metric_filter {
+  filter, err := New_metric_filter(include, exclude, include_labels, exclude_labels)
+  if err != nil {
+    t.Fatalf("New_metric_filter() = %s, expected no error", err)
+  }
+  return filter
+}
+
+
+func Test_New_metric_filter(t *testing.T) {
+  tests := []struct {
+    name string
+    include []string
+    exclude []string
+    include_labels map[string]string
+    exclude_labels map[string]string
+    valid bool
+    empty bool
+  }{
+    {"empty", nil, nil, nil, nil, true, true},
+    {"names", []string{"kbdi_hdfs_.*"}, []string{"kbdi_hdfs_files"}, nil, nil, true, false},
+    {"labels", nil, nil, map[string]string{"hostname": "worker.*"}, map[string]string{"role": "GATEWAY"}, true, false},
+    {"invalid include", []string{"kbdi_("}, nil, nil, nil, false, false},
+    {"invalid exclude", nil, []string{"[a-"}, nil, nil, false, false},
+    {"invalid include label", nil, nil, map[string]string{"hostname": "*"}, nil, false, false},
+    {"invalid exclude label", nil, nil, nil, map[string]string{"hostname": "(?P<"}, false, false},
+  }
+  for _, test := range tests {
+    filter, err := New_metric_filter(test.include, test.exclude, test.include_labels, test.exclude_labels)
+    if (err == nil) != test.valid {
+      t.Errorf("%s: New_metric_filter() error = %v, expected valid %v", test.name, err, test.valid)
+      continue
+    }
+    if err == nil && filter.Is_empty() != test.empty {
+      t.Errorf("%s: Is_empty() = %v, expected %v", test.name, filter.Is_empty(), test.empty)
+    }
+  }
+}
+
+
+func Test_Metric_filter_Match_name(t *testing.T) {
+  tests := []struct {
+    name string
+    include []string
+    exclude []string
+    metric_name string
+    expected bool
+  }{
+    {"no expressions", nil, nil, "kbdi_hdfs_files", true},
+    {"included", []string{"kbdi_hdfs_.*"}, nil, "kbdi_hdfs_files", true},
+    {"not included", []string{"kbdi_hdfs_.*"}, nil, "kbdi_yarn_apps", false},
+    {"any include", []string{"kbdi_yarn_.*", "kbdi_hdfs_.*"}, nil, "kbdi_hdfs_files", true},
+    {"whole name", []string{"kbdi_hdfs"}, nil, "kbdi_hdfs_files", false},
+    {"excluded", nil, []string{"kbdi_hdfs_files"}, "kbdi_hdfs_files", false},
+    {"exclude over include", []string{"kbdi_hdfs_.*"}, []string{".*_files"}, "kbdi_hdfs_files", false},
+    {"not excluded", []string{"kbdi_hdfs_.*"}, []string{".*_files"}, "kbdi_hdfs_blocks", true},
+    {"alternatives anchored", []string{"kbdi_hdfs_files|kbdi_yarn"}, nil, "kbdi_yarn_apps", false},
+  }
+  for _, test := range tests {
+    filter := must_metric_filter(t, test.include, test.exclude, nil, nil)
+    if match := filter.Match_name(test.metric_name); match != test.expected {
+      t.Errorf("%s: Match_name(%q) = %v, expected %v", test.name, test.metric_name, match, test.expected)
+    }
+  }
+}
+
+
+func Test_Metric_filter_Match_labels(t *testing.T) {
+  include_labels := map[string]string{"hostname": "worker.*"}
+  exclude_labels := map[string]string{"role": "GATEWAY|HUE"}
+  tests := []struct {
+    name string
+    labels map[string]string
+    expected bool
+  }{
+    {"no labels", map[string]string{}, true},
+    {"included", map[string]string{"hostname": "worker1"}, true},
+    {"not included", map[string]string{"hostname": "master1"}, false},
+    {"excluded", map[string]string{"hostname": "worker1", "role": "GATEWAY"}, false},
+    {"not excluded", map[string]string{"hostname": "worker1", "role": "DATANODE"}, true},
+    {"partial value", map[string]string{"role": "GATEWAY_1"}, true},
+    {"other labels", map[string]string{"cluster": "master1"}, true},
+  }
+  filter := must_metric_filter(t, nil, nil, include_labels, exclude_labels)
+  for _, test := range tests {
+    if match := filter.Match_labels(make_label_pairs(test.labels)); match != test.expected {
+      t.Errorf("%s: Match_labels(%v) = %v, expected %v", test.name, test.labels, match, test.expected)
+    }
+  }
+}
+
+
+func Test_Metric_filter_Match(t *testing.T) {
+  desc := new_metric_desc("kbdi_test_filter_match", "Test metric", []string{"hostname"}, nil)
+  metric := prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, "worker1")
+  tests := []struct {
+    name string
+    filter *Metric_filter
+    extra_labels map[string]string
+    expected bool
+  }{
+    {"empty filter", must_metric_filter(t, nil, nil, nil, nil), nil, true},
+    {"name excluded", must_metric_filter(t, nil, []string{"kbdi_test_.*"}, nil, nil), nil, false},
+    {"label included", must_metric_filter(t, nil, nil, map[string]string{"hostname": "worker.*"}, nil), nil, true},
+    {"label excluded", must_metric_filter(t, nil, nil, nil, map[string]string{"hostname": "worker1"}), nil, false},
+    {"extra label included", must_metric_filter(t, nil, nil, map[string]string{"team": "data"}, nil), map[string]string{"team": "data"}, true},
+    {"extra label excluded", must_metric_filter(t, nil, nil, nil, map[string]string{"team": "data"}), map[string]string{"team": "data"}, false},
+  }
+  for _, test := range tests {
+    if match := test.filter.Match("kbdi_test_filter_match", metric, make_label_pairs(test.extra_labels)); match != test.expected {
+      t.Errorf("%s: Match() = %v, expected %v", test.name, match, test.expected)
+    }
+  }
+}
+
+
+func Test_Module_options_Is_metric_name_published(t *testing.T) {
+  tests := []struct {
+    name string
+    options Module_options
+    metric_name string
+    expected bool
+  }{
+    {"no options", Module_options{}, "kbdi_hdfs_files", true},
+    {"allowed", Module_options{Metric_allowlist: []string{"kbdi_hdfs_files"}}, "kbdi_hdfs_files", true},
+    {"not allowed", Module_options{Metric_allowlist: []string{"kbdi_hdfs_files"}}, "kbdi_hdfs_blocks", false},
+    {"denied", Module_options{Metric_denylist: []string{"kbdi_hdfs_files"}}, "kbdi_hdfs_files", false},
+    {"denied over allowed", Module_options{Metric_allowlist: []string{"kbdi_hdfs_files"}, Metric_denylist: []string{"kbdi_hdfs_files"}}, "kbdi_hdfs_files", false},
+    {"filtered", Module_options{Metric_filters: []*Metric_filter{must_metric_filter(t, nil, []string{"kbdi_hdfs_.*"}, nil, nil)}}, "kbdi_hdfs_files", false},
+    {"all the filters", Module_options{Metric_filters: []*Metric_filter{must_metric_filter(t, []string{"kbdi_.*"}, nil, nil, nil), must_metric_filter(t, []string{".*_blocks"}, nil, nil, nil)}}, "kbdi_hdfs_files", false},
+    {"label filters", Module_options{Metric_filters: []*Metric_filter{must_metric_filter(t, nil, nil, map[string]string{"hostname": "worker.*"}, nil)}}, "kbdi_hdfs_files", true},
+  }
+  for _, test := range tests {
+    if published := test.options.Is_metric_name_published(test.metric_name); published != test.expected {
+      t.Errorf("%s: Is_metric_name_published(%q) = %v, expected %v", test.name, test.metric_name, published, test.expected)
+    }
+  }
+}
+
+
+func Test_is_metric_scraped(t *testing.T) {
+  desc := new_metric_desc("kbdi_test_is_metric_scraped", "Test metric", nil, nil)
+  tests := []struct {
+    name string
+    ctx context.Context
+    expected bool
+  }{
+    {"no context", nil, true},
+    {"no options", context.Background(), true},
+    {"published", with_module_options(context.Background(), &Module_options{Metric_allowlist: []string{"kbdi_test_is_metric_scraped"}}), true},
+    {"not published", with_module_options(context.Background(), &Module_options{Metric_denylist: []string{"kbdi_test_is_metric_scraped"}}), false},
+  }
+  for _, test := range tests {
+    if scraped := is_metric_scraped(test.ctx, desc); scraped != test.expected {
+      t.Errorf("%s: is_metric_scraped() = %v, expected %v", test.name, scraped, test.expected)
+    }
+  }
+}
@@ -0,0 +1,234 @@
+/*
+ *
+ * title           :collector/module_scraper.go
+ * description     :Scraper wrapper to apply the per module options of the config file
+ * author		       :Alejandro Villegas
+ * date            :2019/07/22
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "fmt"
+  "sync"
+  "time"
+
+  // Own libraries
+  log "keedio/cloudera_exporter/logger"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+  dto "github.com/prometheus/client_model/go"
+)
+
+
+
+
+/* ======================================================================
+ * Data Structs
+ * ====================================================================== */
+// Per module options of the config file. The zero value keeps the default
+// behaviour of the module
+type Module_options struct {
+  // Minimum time between two executions of the module. In between, the
+  // metrics of the last execution are published again
+  Interval time.Duration
+  // Maximum duration of an execution of the module
+  Timeout time.Duration
+  // Labels added to all the metrics of the module
+  Extra_labels map[string]string
+  // Metric names (with namespace and subsystem) to publish or to drop. An
+  // empty allowlist publishes all the metrics
+  Metric_allowlist []string
+  Metric_denylist []string
+  // Filters by name and label values. A metric is published if it passes
+  // all of them
+  Metric_filters []*Metric_filter
+}
+
+// Metrics of the last execution of the module for a target
+type module_cache struct {
+  timestamp time.Time
+  metrics []prometheus.Metric
+}
+
+// Scraper with the module options applied
+type Module_scraper struct {
+  Scraper
+  Options Module_options
+
+  mutex sync.Mutex
+  cache map[string]*module_cache
+}
+
+// Registerer that keeps the collector registered in it. Used to get the
+// collectors wrapped by prometheus.WrapRegistererWith
+type collector_capture struct {
+  collector prometheus.Collector
+}
+
+// Collector that publishes the metrics received from a channel
+type relay_collector struct {
+  metrics <-chan prometheus.Metric
+}
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+func (c *collector_capture) Register(collector prometheus.Collector) error {
+  c.collector = collector
+  return nil
+}
+
+func (c *collector_capture) MustRegister(collectors ...prometheus.Collector) {
+  for _, collector := range collectors {
+    c.Register(collector)
+  }
+}
+
+func (c *collector_capture) Unregister(collector prometheus.Collector) bool {
+  return false
+}
+
+func (r *relay_collector) Describe(ch chan<- *prometheus.Desc) {
+}
+
+func (r *relay_collector) Collect(ch chan<- prometheus.Metric) {
+  for metric := range r.metrics {
+    ch <- metric
+  }
+}
+
+
+// Returns the scraper with the module options applied, or the same scraper if
+// the options are the default ones
+func New_module_scraper(scraper Scraper, options Module_options) Scraper {
+  if options.Interval == 0 && options.Timeout == 0 && len(options.Extra_labels) == 0 && len(options.Metric_allowlist) == 0 && len(options.Metric_denylist) == 0 && len(options.Metric_filters) == 0 {
+    return scraper
+  }
+  return &Module_scraper {
+    Scraper: scraper,
+    Options: options,
+    cache: make(map[string]*module_cache),
+  }
+}
+
+
+// Returns true if the metric name passes the allowlist, the denylist and the
+// name expressions of the filters
+func (options *Module_options) Is_metric_name_published(name string) bool {
+  for _, denied := range options.Metric_denylist {
+    if name == denied {
+      return false
+    }
+  }
+  for _, filter := range options.Metric_filters {
+    if !filter.Match_name(name) {
+      return false
+    }
+  }
+  if len(options.Metric_allowlist) == 0 {
+    return true
+  }
+  for _, allowed := range options.Metric_allowlist {
+    if name == allowed {
+      return true
+    }
+  }
+  return false
+}
+
+
+// Returns true if the metric passes the allowlist, the denylist and the
+// filters. The metric is checked before the extra labels are added, so its
+// descriptor is the one created by the module
+func (s *Module_scraper) is_metric_published(metric prometheus.Metric, extra_labels []*dto.LabelPair) bool {
+  name := Get_desc_name(metric.Desc())
+  if !s.Options.Is_metric_name_published(name) {
+    return false
+  }
+  for _, filter := range s.Options.Metric_filters {
+    if !filter.Match(name, metric, extra_labels) {
+      return false
+    }
+  }
+  return true
+}
+
+
+// Scrape the wrapped scraper applying the module options
+func (s *Module_scraper) Scrape (ctx context.Context, config *Collector_connection_data, ch chan<- prometheus.Metric) error {
+  cache_key := fmt.Sprintf("%s:%s", config.Host, config.Port)
+
+  // Publish the last metrics if the interval has not expired
+  s.mutex.Lock()
+  if cached, ok := s.cache[cache_key]; ok && time.Since(cached.timestamp) < s.Options.Interval {
+    metrics := cached.metrics
+    s.mutex.Unlock()
+    log.Debug_msg("Publishing cached metrics of the %s module", s.Name())
+    for _, metric := range metrics {
+      ch <- metric
+    }
+    return nil
+  }
+  s.mutex.Unlock()
+
+  if s.Options.Timeout > 0 {
+    var cancel context.CancelFunc
+    ctx, cancel = context.WithTimeout(ctx, s.Options.Timeout)
+    defer cancel()
+  }
+  ctx = with_module_options(ctx, &s.Options)
+
+  // The published metrics of the scraper go through a collector wrapped with
+  // the extra labels
+  scraper_ch := make(chan prometheus.Metric)
+  published_ch := make(chan prometheus.Metric)
+  capture := &collector_capture{}
+  prometheus.WrapRegistererWith(s.Options.Extra_labels, capture).MustRegister(&relay_collector{published_ch})
+  labelled_ch := make(chan prometheus.Metric)
+  extra_labels := make_label_pairs(s.Options.Extra_labels)
+
+  var err error
+  go func() {
+    err = s.Scraper.Scrape(ctx, config, scraper_ch)
+    close(scraper_ch)
+  }()
+  go func() {
+    for metric := range scraper_ch {
+      if s.is_metric_published(metric, extra_labels) {
+        published_ch <- metric
+      }
+    }
+    close(published_ch)
+  }()
+  go func() {
+    capture.collector.Collect(labelled_ch)
+    close(labelled_ch)
+  }()
+
+  metrics := []prometheus.Metric{}
+  for metric := range labelled_ch {
+    metrics = append(metrics, metric)
+    ch <- metric
+  }
+
+  if err == nil && s.Options.Interval > 0 {
+    s.mutex.Lock()
+    s.cache[cache_key] = &module_cache{time.Now(), metrics}
+    s.mutex.Unlock()
+  }
+  return err
+}
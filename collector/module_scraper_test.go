@@ -0,0 +1,147 @@
+/*
+ *
+ * title           :collector/module_scraper_test.go
+ * description     :Tests of the per module options of the scrapers
+ * author		       :Alejandro Villegas
+ * date            :2019/07/22
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "sort"
+  "strings"
+  "testing"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+  dto "github.com/prometheus/client_model/go"
+)
+
+
+
+
+/* ======================================================================
+ * Data Structs
+ * ====================================================================== */
+// Scraper that publishes the given metrics
+type test_scraper struct {
+  metrics []prometheus.Metric
+}
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+func (test_scraper) Name() string {
+  return "test"
+}
+
+func (test_scraper) Help() string {
+  return "Test Scraper"
+}
+
+func (test_scraper) Version() float64 {
+  return 1.0
+}
+
+func (s test_scraper) Scrape(ctx context.Context, config *Collector_connection_data, ch chan<- prometheus.Metric) error {
+  for _, metric := range s.metrics {
+    ch <- metric
+  }
+  return nil
+}
+
+
+// Returns the metrics as "name{label=value,...}" strings, sorted
+func format_metrics(t *testing.T, metrics []prometheus.Metric) []string {
+  formatted := []string{}
+  for _, metric := range metrics {
+    metric_data := dto.Metric{}
+    if err := metric.Write(&metric_data); err != nil {
+      t.Fatalf("Invalid metric: %s", err)
+    }
+    labels := []string{}
+    for _, label := range metric_data.GetLabel() {
+      labels = append(labels, label.GetName() + "=" + label.GetValue())
+    }
+    sort.Strings(labels)
+    formatted = append(formatted, "{" + strings.Join(labels, ",") + "}")
+  }
+  sort.Strings(formatted)
+  return formatted
+}
+
+
+func Test_Module_scraper_Scrape(t *testing.T) {
+  metrics := []prometheus.Metric{
+    prometheus.MustNewConstMetric(hdfs_usage_files, prometheus.GaugeValue, 1, "cluster1", "ns1", "alice"),
+    prometheus.MustNewConstMetric(hdfs_usage_files, prometheus.GaugeValue, 1, "cluster1", "ns1", "bob"),
+    prometheus.MustNewConstMetric(hdfs_usage_bytes, prometheus.GaugeValue, 1, "cluster1", "ns1", "alice"),
+  }
+  filter := func(include []string, include_labels map[string]string) []*Metric_filter {
+    metric_filter, err := New_metric_filter(include, nil, include_labels, nil)
+    if err != nil {
+      t.Fatalf("New_metric_filter() = %s", err)
+    }
+    return []*Metric_filter{metric_filter}
+  }
+  tests := []struct {
+    name string
+    options Module_options
+    expected []string
+  }{
+    {
+      "extra labels",
+      Module_options{Extra_labels: map[string]string{"team": "data"}},
+      []string{"{cluster=cluster1,nameservice=ns1,team=data,user=alice}", "{cluster=cluster1,nameservice=ns1,team=data,user=alice}", "{cluster=cluster1,nameservice=ns1,team=data,user=bob}"},
+    },
+    {
+      "allowlist with extra labels",
+      Module_options{Extra_labels: map[string]string{"team": "data"}, Metric_allowlist: []string{"kbdi_hdfs_usage_files"}},
+      []string{"{cluster=cluster1,nameservice=ns1,team=data,user=alice}", "{cluster=cluster1,nameservice=ns1,team=data,user=bob}"},
+    },
+    {
+      "denylist",
+      Module_options{Metric_denylist: []string{"kbdi_hdfs_usage_files"}},
+      []string{"{cluster=cluster1,nameservice=ns1,user=alice}"},
+    },
+    {
+      "filter by label",
+      Module_options{Extra_labels: map[string]string{"team": "data"}, Metric_filters: filter([]string{"kbdi_hdfs_usage_.*"}, map[string]string{"user": "b.*"})},
+      []string{"{cluster=cluster1,nameservice=ns1,team=data,user=bob}"},
+    },
+    {
+      "filter by extra label",
+      Module_options{Extra_labels: map[string]string{"team": "data"}, Metric_filters: filter(nil, map[string]string{"team": "ops"})},
+      []string{},
+    },
+  }
+  for _, test := range tests {
+    scraper := New_module_scraper(test_scraper{metrics}, test.options)
+    ch := make(chan prometheus.Metric, len(metrics))
+    config := &Collector_connection_data{Host: "test_module_scraper", Port: "7180"}
+    if err := scraper.Scrape(context.Background(), config, ch); err != nil {
+      t.Fatalf("%s: Scrape() = %s", test.name, err)
+    }
+    close(ch)
+    published := []prometheus.Metric{}
+    for metric := range ch {
+      published = append(published, metric)
+    }
+    if formatted := format_metrics(t, published); strings.Join(formatted, " ") != strings.Join(test.expected, " ") {
+      t.Errorf("%s: Scrape() published %v, expected %v", test.name, formatted, test.expected)
+    }
+  }
+}
@@ -0,0 +1,170 @@
+/*
+ *
+ * title           :collector/rate_limiter.go
+ * description     :Rate limit of the queries to each Cloudera Manager
+ * author		       :Alejandro Villegas
+ * date            :2019/08/01
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "fmt"
+  "sync"
+  "time"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+
+
+
+/* ======================================================================
+ * Constants
+ * ====================================================================== */
+// Default rate limit of the queries to a Cloudera Manager
+const (
+  // Queries per second. 0 to not limit them
+  API_DEFAULT_REQUESTS_PER_SECOND = 0
+  // Queries made at once before the limit applies
+  API_DEFAULT_BURST = 10
+)
+
+
+
+
+/* ======================================================================
+ * Data Structs
+ * ====================================================================== */
+// Token bucket of the queries to a Cloudera Manager. The bucket holds up to
+// burst tokens and is refilled with requests_per_second tokens each second.
+// Each query takes a token, waiting for it if the bucket is empty
+type rate_limiter struct {
+  requests_per_second float64
+  burst int
+  tokens float64
+  last_refill time.Time
+  // Queries that had to wait for a token
+  throttled uint64
+}
+
+
+
+
+/* ======================================================================
+ * Global variables
+ * ====================================================================== */
+// Rate limiters by Cloudera Manager address, shared by all the scrapes of the
+// same Cloudera Manager
+var rate_limiters = make(map[string]*rate_limiter)
+var rate_limiters_mutex sync.Mutex
+
+var requestsThrottledDesc = new_metric_desc(
+  prometheus.BuildFQName(namespace, subsystem, "cm_requests_throttled_total"),
+  "Total number of queries to the Cloudera Manager delayed by the rate limit.",
+  nil,
+  nil,
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Returns the rate limiter of a Cloudera Manager. The bucket is created full,
+// and again if the limit changes when the config is reloaded. Must be called
+// with the lock
+func get_rate_limiter(config Collector_connection_data) *rate_limiter {
+  address := fmt.Sprintf("%s:%s", config.Host, config.Port)
+  limiter, ok := rate_limiters[address]
+  if !ok || limiter.requests_per_second != config.Client.Requests_per_second || limiter.burst != config.Client.Burst {
+    throttled := uint64(0)
+    if ok {
+      throttled = limiter.throttled
+    }
+    limiter = &rate_limiter {
+      requests_per_second: config.Client.Requests_per_second,
+      burst: config.Client.Burst,
+      tokens: float64(config.Client.Burst),
+      last_refill: time.Now(),
+      throttled: throttled,
+    }
+    rate_limiters[address] = limiter
+  }
+  return limiter
+}
+
+
+// Refill the bucket up to the given time and reserve a token. Returns the
+// time to wait until the token is available, 0 if it's available now. The
+// token is reserved even if it's not available yet, so the waiting queries
+// are served in order. Must be called with the lock
+func (limiter *rate_limiter) reserve(now time.Time) time.Duration {
+  if now.After(limiter.last_refill) {
+    limiter.tokens += now.Sub(limiter.last_refill).Seconds() * limiter.requests_per_second
+    limiter.last_refill = now
+  }
+  if limiter.tokens > float64(limiter.burst) {
+    limiter.tokens = float64(limiter.burst)
+  }
+  limiter.tokens--
+  if limiter.tokens >= 0 {
+    return 0
+  }
+  limiter.throttled++
+  return time.Duration(-limiter.tokens / limiter.requests_per_second * float64(time.Second))
+}
+
+
+// Take a token of the rate limiter of the Cloudera Manager, waiting for it if
+// the bucket is empty. Returns an error if the context is done before
+func wait_rate_limit(ctx context.Context, config Collector_connection_data) error {
+  if config.Client.Requests_per_second <= 0 {
+    return nil
+  }
+
+  rate_limiters_mutex.Lock()
+  limiter := get_rate_limiter(config)
+  wait := limiter.reserve(time.Now())
+  rate_limiters_mutex.Unlock()
+  if wait == 0 {
+    return nil
+  }
+
+  timer := time.NewTimer(wait)
+  select {
+  case <-timer.C:
+    return nil
+  case <-ctx.Done():
+    timer.Stop()
+    // Give back the reserved token
+    rate_limiters_mutex.Lock()
+    limiter.tokens++
+    rate_limiters_mutex.Unlock()
+    return ctx.Err()
+  }
+}
+
+
+// Returns the number of queries to the Cloudera Manager delayed by the rate
+// limit
+func Get_throttled_requests(config Collector_connection_data) uint64 {
+  rate_limiters_mutex.Lock()
+  defer rate_limiters_mutex.Unlock()
+  address := fmt.Sprintf("%s:%s", config.Host, config.Port)
+  if limiter, ok := rate_limiters[address]; ok {
+    return limiter.throttled
+  }
+  return 0
+}
@@ -0,0 +1,113 @@
+/*
+ *
+ * title           :collector/rate_limiter_test.go
+ * description     :Tests of the rate limit of the queries to each Cloudera Manager
+ * author		       :Alejandro Villegas
+ * date            :2019/07/31
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "testing"
+  "time"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+func Test_rate_limiter_reserve(t *testing.T) {
+  start := time.Date(2019, 7, 31, 12, 0, 0, 0, time.UTC)
+  limiter := rate_limiter{requests_per_second: 10, burst: 2, tokens: 2, last_refill: start}
+
+  // Each step reserves a token at the elapsed time and checks the wait
+  steps := []struct {
+    name string
+    elapsed time.Duration
+    wait time.Duration
+  }{
+    {"first of the burst", 0, 0},
+    {"second of the burst", 0, 0},
+    {"empty bucket", 0, 100 * time.Millisecond},
+    {"queued after the previous one", 0, 200 * time.Millisecond},
+    {"refilled while waiting", 200 * time.Millisecond, 100 * time.Millisecond},
+    {"refilled", 400 * time.Millisecond, 0},
+    {"refill capped at the burst", time.Hour, 0},
+    {"second after a long time", time.Hour, 0},
+    {"empty again", time.Hour, 100 * time.Millisecond},
+    {"clock going back", time.Hour - time.Second, 200 * time.Millisecond},
+  }
+  for _, step := range steps {
+    if wait := limiter.reserve(start.Add(step.elapsed)); wait != step.wait {
+      t.Errorf("%s: reserve() = %s, expected %s", step.name, wait, step.wait)
+    }
+  }
+  if limiter.throttled != 5 {
+    t.Errorf("throttled = %d, expected 5", limiter.throttled)
+  }
+}
+
+
+func Test_get_rate_limiter(t *testing.T) {
+  config := Collector_connection_data{Host: "rate-limiter-test", Port: "7180", Client: Api_client_options{Requests_per_second: 1, Burst: 1}}
+  rate_limiters_mutex.Lock()
+  defer rate_limiters_mutex.Unlock()
+
+  limiter := get_rate_limiter(config)
+  if limiter.tokens != 1 || get_rate_limiter(config) != limiter {
+    t.Fatalf("get_rate_limiter() = %+v, expected the same full bucket", limiter)
+  }
+  limiter.reserve(limiter.last_refill)
+  limiter.reserve(limiter.last_refill)
+
+  // A new limit creates a full bucket, keeping the throttled queries
+  config.Client.Burst = 5
+  reloaded := get_rate_limiter(config)
+  if reloaded == limiter || reloaded.tokens != 5 || reloaded.throttled != 1 {
+    t.Errorf("get_rate_limiter() after a config change = %+v, expected a full bucket of 5 with 1 throttled query", reloaded)
+  }
+}
+
+
+func Test_wait_rate_limit(t *testing.T) {
+  unlimited := Collector_connection_data{Host: "rate-limiter-test", Port: "7181"}
+  if err := wait_rate_limit(context.Background(), unlimited); err != nil {
+    t.Errorf("wait_rate_limit() without limit = %s, expected no error", err)
+  }
+
+  // A slow limit with an empty bucket: the query waits until the context is
+  // done, and the reserved token is given back
+  config := Collector_connection_data{Host: "rate-limiter-test", Port: "7182", Client: Api_client_options{Requests_per_second: 0.01, Burst: 1}}
+  if err := wait_rate_limit(context.Background(), config); err != nil {
+    t.Fatalf("wait_rate_limit() of the burst = %s, expected no error", err)
+  }
+  ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+  defer cancel()
+  if err := wait_rate_limit(ctx, config); err != context.DeadlineExceeded {
+    t.Errorf("wait_rate_limit() with an empty bucket = %v, expected %s", err, context.DeadlineExceeded)
+  }
+  rate_limiters_mutex.Lock()
+  tokens := get_rate_limiter(config).tokens
+  rate_limiters_mutex.Unlock()
+  if tokens < 0 {
+    t.Errorf("tokens = %f after a canceled query, expected the token given back", tokens)
+  }
+  if throttled := Get_throttled_requests(config); throttled != 1 {
+    t.Errorf("Get_throttled_requests() = %d, expected 1", throttled)
+  }
+  if throttled := Get_throttled_requests(unlimited); throttled != 0 {
+    t.Errorf("Get_throttled_requests() without limit = %d, expected 0", throttled)
+  }
+}
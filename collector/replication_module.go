@@ -0,0 +1,302 @@
+/*
+ *
+ * title           :collector/replication_module.go
+ * description     :Submodule Collector for the HDFS and Hive replication schedules metrics
+ * author		       :Alejandro Villegas
+ * date            :2019/07/08
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "time"
+
+  // Own libraries
+  log "keedio/cloudera_exporter/logger"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+
+
+
+/* ======================================================================
+ * Constants with the Replication module API queries
+ * ====================================================================== */
+const REPLICATION_SCRAPER_NAME = "replication"
+const (
+  REPLICATION_HISTORY_LIMIT =   20
+)
+
+// Service types with replication schedules
+var replication_service_types = []string{"HDFS", "HIVE"}
+
+
+
+
+/* ======================================================================
+ * Data Structs
+ * ====================================================================== */
+// Run of a replication schedule, with the API dates unparsed
+type replication_run struct {
+  Active bool
+  Success bool
+  Start_time string
+  End_time string
+  Bytes_copied float64
+  Files_copied float64
+}
+
+// Summary of the run history of a replication schedule. Last_run is nil if no
+// run has finished, and Last_success is zero if no run has succeeded
+type replication_history_summary struct {
+  Active bool
+  Last_run *replication_run
+  Last_run_start time.Time
+  Last_success time.Time
+}
+
+
+
+
+/* ======================================================================
+ * Global variables
+ * ====================================================================== */
+var (
+  replication_paused =                 create_replication_metric_struct("paused", "Whether the replication schedule is paused (1) or not (0)")
+  replication_active =                 create_replication_metric_struct("active", "Whether a run of the replication schedule is in progress (1) or not (0)")
+  replication_next_run =               create_replication_metric_struct("next_run_timestamp_seconds", "Unix time of the next scheduled run")
+  replication_last_run_success =       create_replication_metric_struct("last_run_success", "Whether the last finished run of the replication was successful (1) or failed (0)")
+  replication_last_run_start =         create_replication_metric_struct("last_run_start_timestamp_seconds", "Unix time when the last finished run started")
+  replication_last_run_duration =      create_replication_metric_struct("last_run_duration_seconds", "Duration of the last finished run in seconds")
+  replication_last_run_bytes_copied =  create_replication_metric_struct("last_run_bytes_copied", "Bytes copied by the last finished run")
+  replication_last_run_files_copied =  create_replication_metric_struct("last_run_files_copied", "Files copied by the last finished run")
+  replication_last_success =           create_replication_metric_struct("last_success_timestamp_seconds", "Unix time when the last successful run finished")
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Create and returns a prometheus descriptor for a replication schedule metric
+func create_replication_metric_struct(metric_name string, description string) *prometheus.Desc {
+  return new_metric_desc(
+    prometheus.BuildFQName(namespace, REPLICATION_SCRAPER_NAME, metric_name),
+    description,
+    []string{"cluster", "service", "service_type", "schedule_id"},
+    nil,
+  )
+}
+
+
+// Convert a Cloudera Manager API date to Unix time. Returns false if the date
+// is empty or can not be parsed
+func parse_api_timestamp(date string) (time.Time, bool) {
+  if date == "" {
+    return time.Time{}, false
+  }
+  timestamp, err := time.Parse(time.RFC3339, date)
+  if err != nil {
+    log.Debug_msg("Cannot parse API date %s: %s", date, err)
+    return time.Time{}, false
+  }
+  return timestamp, true
+}
+
+
+// Returns true if the service type has replication schedules
+func is_replication_service_type(service_type string) bool {
+  for _, replication_type := range replication_service_types {
+    if service_type == replication_type {
+      return true
+    }
+  }
+  return false
+}
+
+
+// Summarize the run history of a replication schedule. The runs in progress
+// only mark the schedule as active, and the runs without a valid start time
+// are ignored
+func summarize_replication_history(runs []replication_run) replication_history_summary {
+  summary := replication_history_summary{}
+  for run_index := range runs {
+    run := &runs[run_index]
+    if run.Active {
+      summary.Active = true
+      continue
+    }
+    start_time, ok := parse_api_timestamp(run.Start_time)
+    if !ok {
+      continue
+    }
+    if summary.Last_run == nil || start_time.After(summary.Last_run_start) {
+      summary.Last_run = run
+      summary.Last_run_start = start_time
+    }
+    end_time, ok := parse_api_timestamp(run.End_time)
+    if ok && run.Success && end_time.After(summary.Last_success) {
+      summary.Last_success = end_time
+    }
+  }
+  return summary
+}
+
+
+// Function to Scrape the run history of a replication schedule
+func scrape_replication_history(ctx context.Context, config Collector_connection_data, cluster_name string, service_name string, schedule_id string, labels []string, ch chan<- prometheus.Metric) bool {
+  commands, err := new_api_client(config).List_replication_history(ctx, cluster_name, service_name, schedule_id, REPLICATION_HISTORY_LIMIT)
+  if err != nil {
+    return false
+  }
+
+  runs := []replication_run{}
+  for _, command := range commands {
+    run := replication_run{
+      Active: command.Active,
+      Success: command.Success,
+      Start_time: command.Start_time,
+      End_time: command.End_time,
+    }
+    if result := command.Get_data_result(); result != nil {
+      run.Bytes_copied = result.Num_bytes_copied
+      run.Files_copied = result.Num_files_copied
+    }
+    runs = append(runs, run)
+  }
+  summary := summarize_replication_history(runs)
+
+  is_active := 0.0
+  if summary.Active {
+    is_active = 1.0
+  }
+  ch <- prometheus.MustNewConstMetric(replication_active, prometheus.GaugeValue, is_active, labels...)
+  if last_run := summary.Last_run; last_run != nil {
+    success := 0.0
+    if last_run.Success {
+      success = 1.0
+    }
+    ch <- prometheus.MustNewConstMetric(replication_last_run_success, prometheus.GaugeValue, success, labels...)
+    ch <- prometheus.MustNewConstMetric(replication_last_run_start, prometheus.GaugeValue, float64(summary.Last_run_start.Unix()), labels...)
+    if end_time, ok := parse_api_timestamp(last_run.End_time); ok {
+      ch <- prometheus.MustNewConstMetric(replication_last_run_duration, prometheus.GaugeValue, end_time.Sub(summary.Last_run_start).Seconds(), labels...)
+    }
+    ch <- prometheus.MustNewConstMetric(replication_last_run_bytes_copied, prometheus.GaugeValue, last_run.Bytes_copied, labels...)
+    ch <- prometheus.MustNewConstMetric(replication_last_run_files_copied, prometheus.GaugeValue, last_run.Files_copied, labels...)
+  }
+  if !summary.Last_success.IsZero() {
+    ch <- prometheus.MustNewConstMetric(replication_last_success, prometheus.GaugeValue, float64(summary.Last_success.Unix()), labels...)
+  }
+  return true
+}
+
+
+// Function to Scrape the replication schedules of a service. Returns the
+// number of queries succeeded and failed: the schedules query and the history
+// query of each schedule
+func scrape_service_replications(ctx context.Context, config Collector_connection_data, cluster_name string, service_name string, service_type string, ch chan<- prometheus.Metric) (int, int) {
+  schedules, err := new_api_client(config).List_replications(ctx, cluster_name, service_name)
+  if err != nil {
+    return 0, 1
+  }
+
+  // The history of the schedules is queried in parallel. Each schedule keeps
+  // the result of its query in its own index, counted once all finish
+  results := make([]bool, len(schedules))
+  tasks := []func(){}
+  for schedule_index, schedule := range schedules {
+    schedule_index, schedule := schedule_index, schedule
+    tasks = append(tasks, func() {
+      schedule_id := schedule.Id.String()
+      labels := []string{cluster_name, service_name, service_type, schedule_id}
+
+      paused := 0.0
+      if schedule.Paused {
+        paused = 1.0
+      }
+      ch <- prometheus.MustNewConstMetric(replication_paused, prometheus.GaugeValue, paused, labels...)
+      if next_run, ok := parse_api_timestamp(schedule.Next_run); ok {
+        ch <- prometheus.MustNewConstMetric(replication_next_run, prometheus.GaugeValue, float64(next_run.Unix()), labels...)
+      }
+      results[schedule_index] = scrape_replication_history(ctx, config, cluster_name, service_name, schedule_id, labels, ch)
+    })
+  }
+  run_parallel(tasks)
+  success_queries, error_queries := 1, 0
+  for _, result := range results {
+    eval_scrape(result, &success_queries, &error_queries)
+  }
+  return success_queries, error_queries
+}
+
+
+
+
+/* ======================================================================
+ * Scrape "Class"
+ * ====================================================================== */
+// ScrapeReplication struct
+type ScrapeReplication struct{}
+
+// Name of the Scraper. Should be unique.
+func (ScrapeReplication) Name() string {
+  return REPLICATION_SCRAPER_NAME
+}
+
+// Help describes the role of the Scraper.
+func (ScrapeReplication) Help() string {
+  return "HDFS and Hive Replication Schedules Metrics"
+}
+
+// Version.
+func (ScrapeReplication) Version() float64 {
+  return 1.0
+}
+
+// Scrape generic function. Override for replication module.
+func (ScrapeReplication) Scrape (ctx context.Context, config *Collector_connection_data, ch chan<- prometheus.Metric) error {
+  log.Debug_msg("Ejecutando Replication Metrics Scraper")
+
+  // Queries counters
+  success_queries := 0
+  error_queries := 0
+
+  // Get Clusters list
+  client := new_metadata_client(*config)
+  clusters, err := client.List_clusters(ctx)
+  if err != nil {
+    return scrape_result(0, 1)
+  }
+
+  for _, cluster := range clusters {
+    services, err := client.List_services(ctx, cluster.Name)
+    if err != nil {
+      error_queries += 1
+      continue
+    }
+    for _, service := range services {
+      if !is_replication_service_type(service.Type) {
+        continue
+      }
+      replications_success, replications_errors := scrape_service_replications(ctx, *config, cluster.Name, service.Name, service.Type, ch)
+      success_queries += replications_success
+      error_queries += replications_errors
+    }
+  }
+  log.Debug_msg("In the Replication Module has been executed %d queries. %d success and %d with errors", success_queries + error_queries, success_queries, error_queries)
+  return scrape_result(success_queries, error_queries)
+}
+
+var _ Scraper = ScrapeReplication{}
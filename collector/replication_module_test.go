@@ -0,0 +1,104 @@
+/*
+ *
+ * title           :collector/replication_module_test.go
+ * description     :Tests of the HDFS and Hive replication schedules metrics
+ * author		       :Alejandro Villegas
+ * date            :2019/07/08
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "testing"
+  "time"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+func Test_parse_api_timestamp(t *testing.T) {
+  tests := []struct {
+    date string
+    expected time.Time
+    ok bool
+  }{
+    {"2019-07-08T10:00:00.000Z", time.Date(2019, 7, 8, 10, 0, 0, 0, time.UTC), true},
+    {"2019-07-08T12:00:00+02:00", time.Date(2019, 7, 8, 10, 0, 0, 0, time.UTC), true},
+    {"", time.Time{}, false},
+    {"2019-07-08", time.Time{}, false},
+    {"yesterday", time.Time{}, false},
+  }
+  for _, test := range tests {
+    timestamp, ok := parse_api_timestamp(test.date)
+    if ok != test.ok || !timestamp.Equal(test.expected) {
+      t.Errorf("parse_api_timestamp(%q) = %v, %v, expected %v, %v", test.date, timestamp, ok, test.expected, test.ok)
+    }
+  }
+}
+
+
+func Test_is_replication_service_type(t *testing.T) {
+  tests := []struct {
+    service_type string
+    expected bool
+  }{
+    {"HDFS", true},
+    {"HIVE", true},
+    {"hdfs", false},
+    {"IMPALA", false},
+    {"", false},
+  }
+  for _, test := range tests {
+    if is_replication := is_replication_service_type(test.service_type); is_replication != test.expected {
+      t.Errorf("is_replication_service_type(%q) = %v, expected %v", test.service_type, is_replication, test.expected)
+    }
+  }
+}
+
+
+func Test_summarize_replication_history(t *testing.T) {
+  failed := replication_run{Start_time: "2019-07-08T10:00:00Z", End_time: "2019-07-08T10:05:00Z"}
+  succeeded := replication_run{Success: true, Start_time: "2019-07-08T09:00:00Z", End_time: "2019-07-08T09:10:00Z", Bytes_copied: 100}
+  older_success := replication_run{Success: true, Start_time: "2019-07-07T09:00:00Z", End_time: "2019-07-07T09:10:00Z"}
+  running := replication_run{Active: true, Start_time: "2019-07-08T11:00:00Z"}
+  no_start := replication_run{Success: true, End_time: "2019-07-09T00:00:00Z"}
+
+  tests := []struct {
+    name string
+    runs []replication_run
+    active bool
+    last_run *replication_run
+    last_success string
+  }{
+    {"no runs", []replication_run{}, false, nil, ""},
+    {"only running", []replication_run{running}, true, nil, ""},
+    {"last run failed", []replication_run{succeeded, failed, older_success}, false, &failed, "2019-07-08T09:10:00Z"},
+    {"last run succeeded", []replication_run{older_success, succeeded}, false, &succeeded, "2019-07-08T09:10:00Z"},
+    {"running is not the last run", []replication_run{running, failed}, true, &failed, ""},
+    {"runs without start are ignored", []replication_run{no_start, succeeded}, false, &succeeded, "2019-07-08T09:10:00Z"},
+  }
+  for _, test := range tests {
+    summary := summarize_replication_history(test.runs)
+    if summary.Active != test.active {
+      t.Errorf("%s: Active = %v, expected %v", test.name, summary.Active, test.active)
+    }
+    if (summary.Last_run == nil) != (test.last_run == nil) || (summary.Last_run != nil && *summary.Last_run != *test.last_run) {
+      t.Errorf("%s: Last_run = %+v, expected %+v", test.name, summary.Last_run, test.last_run)
+    }
+    last_success, _ := parse_api_timestamp(test.last_success)
+    if !summary.Last_success.Equal(last_success) {
+      t.Errorf("%s: Last_success = %v, expected %v", test.name, summary.Last_success, last_success)
+    }
+  }
+}
@@ -2,6 +2,7 @@ package collector
 
 import (
   "context"
+  "fmt"
 
   "github.com/prometheus/client_golang/prometheus"
 
@@ -19,6 +20,24 @@ type Scraper interface {
 	Version() float64
 
 	// Scrape collects data from cloudera_manager connection and sends it over channel as prometheus metric.
+	// Returns a Scrape_error if any of its queries failed. The metrics of the success queries are sent anyway.
 	Scrape(ctx context.Context, config *Collector_connection_data, ch chan<- prometheus.Metric) error
 }
 
+// Partial or total failure of a scrape: the number of success and failed queries.
+type Scrape_error struct {
+  Success_queries int
+  Failed_queries int
+}
+
+func (err *Scrape_error) Error() string {
+  return fmt.Sprintf("%d of %d queries failed", err.Failed_queries, err.Success_queries + err.Failed_queries)
+}
+
+// Returns the result of a scrape from its queries counters: nil if all the queries succeeded, or a Scrape_error.
+func scrape_result(success_queries int, error_queries int) error {
+  if error_queries == 0 {
+    return nil
+  }
+  return &Scrape_error{Success_queries: success_queries, Failed_queries: error_queries}
+}
@@ -19,9 +19,11 @@ package collector
 import (
   // Go Default libraries
 	"context"
-	"fmt"
+	"strconv"
+	"sync"
 
   // Own libraries
+  "keedio/cloudera_exporter/cmapi"
   jp "keedio/cloudera_exporter/json_parser"
   log "keedio/cloudera_exporter/logger"
 
@@ -51,7 +53,7 @@ const STATUS_SCRAPER_NAME = "status"
  * ====================================================================== */
 var  (
   // Cluster Status Metric Definition
-	globalClusterDesc = prometheus.NewDesc(
+	globalClusterDesc = new_metric_desc(
       prometheus.BuildFQName(namespace, "status", "cluster_up"),
       "Cluster Up",
       []string{"cluster_name", "full_version", "cluster_state", "maintenance_mode"},
@@ -59,7 +61,7 @@ var  (
   )
 
   // Host Status Metric Definition
-	globalHostsDesc = prometheus.NewDesc(
+	globalHostsDesc = new_metric_desc(
       prometheus.BuildFQName(namespace, "status", "host_up"),
       "Host Up",
       []string{"host_id", "hostname", "ip", "commission_state", "maintenance_mode", "health_summary"},
@@ -67,7 +69,7 @@ var  (
   )
 
   // Service Status Metric Definition
-	globalServiceDesc = prometheus.NewDesc(
+	globalServiceDesc = new_metric_desc(
       prometheus.BuildFQName(namespace, "status", "service_up"),
       "Service Name up",
       []string{"service_name", "service_type", "service_state", "health_summary"},
@@ -75,7 +77,7 @@ var  (
   )
 
   // Role Status Metric Definition
-	globalRoleDesc = prometheus.NewDesc(
+	globalRoleDesc = new_metric_desc(
       prometheus.BuildFQName(namespace, "status", "role_up"),
       "Role Name up",
       []string{"role_name", "host_id", "host_name", "role_type", "role_state", "health_summary", "service"},
@@ -135,28 +137,39 @@ func get_value_from_state (state string) float64 {
   return retval
 }
 
-// Function to Scrape the Hosts Status Metrics
-func scrape_cluster_hosts_status(ctx context.Context, config Collector_connection_data, query string, ch chan<- prometheus.Metric) bool {
-  json_parsed, err := make_and_parse_api_query(ctx, config, query)
+// Function to Scrape the Hosts Status Metrics. The health of the hosts is
+// queried in parallel. The hosts whose query fails are skipped, and the scrape
+// fails if any of them failed
+func scrape_cluster_hosts_status(ctx context.Context, config Collector_connection_data, ch chan<- prometheus.Metric) bool {
+  client := new_api_client(config)
+  hosts, err := client.List_hosts(ctx)
   if err != nil {
     return false
   }
 
-  // For each Host
-  number_services := jp.Get_api_query_items_num(json_parsed)
-  for counter_hosts := 0; counter_hosts < int(number_services); counter_hosts++ {
-    host_id := jp.Get_api_query_host_id(json_parsed, counter_hosts)
-    host_name := jp.Get_api_query_host_name(json_parsed, counter_hosts)
-    host_ip := jp.Get_api_query_host_ip(json_parsed, counter_hosts)
-    host_commission_state := jp.Get_api_query_host_commission_state(json_parsed, counter_hosts)
-    host_maintenance_mode := jp.Get_api_query_host_maintenance_mode(json_parsed, counter_hosts)
-    query_by_host := fmt.Sprintf("%s/%s", query, host_id)
-    json_parsed_by_host, _ := make_and_parse_api_query(ctx, config, query_by_host)
-    host_health_summary := jp.Get_api_query_host_health_summary(json_parsed_by_host)
-    host_healt_summary_value := get_value_from_state(host_health_summary)
-    ch <- prometheus.MustNewConstMetric(globalHostsDesc, prometheus.GaugeValue, host_healt_summary_value, host_id, host_name, host_ip, host_commission_state, host_maintenance_mode, host_health_summary)
+  // For each Host. Each host keeps the result of its query in its own index
+  failed := make([]bool, len(hosts))
+  tasks := []func(){}
+  for host_index, host := range hosts {
+    host_index, host := host_index, host
+    tasks = append(tasks, func() {
+      host_id := host.Host_id
+      host_name := host.Hostname
+      host_ip := host.Ip_address
+      host_commission_state := host.Commission_state
+      host_maintenance_mode := strconv.FormatBool(host.Maintenance_mode)
+      host_by_id, err := client.Get_host(ctx, host_id)
+      if err != nil {
+        failed[host_index] = true
+        return
+      }
+      host_health_summary := host_by_id.Health_summary
+      host_healt_summary_value := get_value_from_state(host_health_summary)
+      ch <- prometheus.MustNewConstMetric(globalHostsDesc, prometheus.GaugeValue, host_healt_summary_value, host_id, host_name, host_ip, host_commission_state, host_maintenance_mode, host_health_summary)
+    })
   }
-  return true
+  run_parallel(tasks)
+  return count_failed(failed, "host health")
 }
 
 // Function to Scrape the Cluster Status Metric
@@ -177,20 +190,15 @@ func scrape_cluster_status(ctx context.Context, config Collector_connection_data
 }
 
 // Function to Scrape the Services Status Metrics
-func scrape_cluster_services_status(ctx context.Context, config Collector_connection_data, query string, ch chan<- prometheus.Metric) bool {
-  json_parsed, err := make_and_parse_api_query(ctx, config, query)
+func scrape_cluster_services_status(ctx context.Context, config Collector_connection_data, cluster_name string, ch chan<- prometheus.Metric) bool {
+  services, err := new_api_client(config).List_services(ctx, cluster_name)
   if err != nil {
     return false
   }
 
-  num_services := jp.Get_api_query_items_num(json_parsed)
-  for counter_services := 0; counter_services < int(num_services); counter_services++ {
-    service_name := jp.Get_api_query_service_name(json_parsed, counter_services)
-    service_type := jp.Get_api_query_service_type(json_parsed, counter_services)
-    service_state := jp.Get_api_query_service_state(json_parsed, counter_services)
-    health_summary := jp.Get_api_query_service_health(json_parsed, counter_services)
-    service_state_value := get_value_from_state(health_summary)
-    ch <- prometheus.MustNewConstMetric(globalServiceDesc, prometheus.GaugeValue, service_state_value, service_name, service_type, service_state, health_summary)
+  for _, service := range services {
+    service_state_value := get_value_from_state(service.Health_summary)
+    ch <- prometheus.MustNewConstMetric(globalServiceDesc, prometheus.GaugeValue, service_state_value, service.Name, service.Type, service.Service_state, service.Health_summary)
   }
   return true
 }
@@ -223,18 +231,15 @@ func scrape_cluster_cm_services_status(ctx context.Context, config Collector_con
 }
 
 // Function that returns to a map with the hostName and HostId
-func scrape_hostName(ctx context.Context, config Collector_connection_data, query string) map[string]string {
-  json_parsed,err:= make_and_parse_api_query(ctx, config, query)
+func scrape_hostName(ctx context.Context, config Collector_connection_data) map[string]string {
+  mapHost := make(map[string]string)
+  hosts, err := new_metadata_client(config).List_hosts(ctx)
   if err != nil {
-
+    return mapHost
   }
   // For each Host
-  number_services := jp.Get_api_query_items_num(json_parsed)
-  mapHost := make(map[string]string)
-  for counter_hosts := 0; counter_hosts < int(number_services); counter_hosts++ {
-    host_id := jp.Get_api_query_host_id(json_parsed, counter_hosts)
-    host_name := jp.Get_api_query_host_name(json_parsed, counter_hosts)
-    mapHost[host_id] = host_name
+  for _, host := range hosts {
+    mapHost[host.Host_id] = host.Hostname
   }
   return  mapHost
 }
@@ -247,31 +252,55 @@ func Get_hostName_with_hostId( mapHosName map[string]string, hostId string) stri
 }
 
 
-// Function to Scrape the Roles Status Metrics
-func scrape_cluster_roles_status(ctx context.Context, config Collector_connection_data, query string, ch chan<- prometheus.Metric) bool{
-  json_parsed_service, err := make_and_parse_api_query(ctx, config, query)
-  mapHost := scrape_hostName(ctx, config, "hosts")
+// Function to Scrape the Roles Status Metrics of a cluster. The roles of the
+// services are queried in parallel, and the scrape fails if the roles of any
+// service can't be listed
+func scrape_cluster_roles_status(ctx context.Context, config Collector_connection_data, cluster_name string, ch chan<- prometheus.Metric) bool{
+  services, err := new_metadata_client(config).List_services(ctx, cluster_name)
+  mapHost := scrape_hostName(ctx, config)
 
   if err != nil {
     return false
   }
 
-  num_services := jp.Get_api_query_items_num(json_parsed_service)
-  for counter_services := 0; counter_services < int(num_services); counter_services++ {
-    service_name := jp.Get_api_query_service_name(json_parsed_service, counter_services)
-    json_parsed_roles, _ := make_and_parse_api_query(ctx, config, fmt.Sprintf("%s/%s/roles", query, service_name))
-    num_roles := jp.Get_api_query_items_num(json_parsed_roles)
-    for counter_roles := 0; counter_roles < int(num_roles); counter_roles++ {
-      role_name := jp.Get_api_query_role_name(json_parsed_roles, counter_roles)
-      host_id := jp.Get_api_query_host_id_by_hostRef(json_parsed_roles, counter_roles)
-      host_name := Get_hostName_with_hostId(mapHost, host_id)
-      role_type := jp.Get_api_query_role_type(json_parsed_roles, counter_roles)
-      role_state := jp.Get_api_query_role_state(json_parsed_roles, counter_roles)
-      health_summary := jp.Get_api_query_role_health(json_parsed_roles, counter_roles)
-      role_state_value := get_value_from_state(health_summary)
-      ch <- prometheus.MustNewConstMetric(globalRoleDesc, prometheus.GaugeValue, role_state_value, role_name, host_id, host_name, role_type, role_state, health_summary, service_name)
+  client := new_api_client(config)
+  failed := make([]bool, len(services))
+  tasks := []func(){}
+  for service_index, service := range services {
+    service_index, service_name := service_index, service.Name
+    tasks = append(tasks, func() {
+      roles, err := client.List_roles(ctx, cluster_name, service_name)
+      if err != nil {
+        log.Err_msg("Error listing the roles of the service %s: %s", service_name, err)
+        failed[service_index] = true
+        return
+      }
+      for _, role := range roles {
+        host_id := role.Host_ref.Host_id
+        host_name := Get_hostName_with_hostId(mapHost, host_id)
+        role_state_value := get_value_from_state(role.Health_summary)
+        ch <- prometheus.MustNewConstMetric(globalRoleDesc, prometheus.GaugeValue, role_state_value, role.Name, host_id, host_name, role.Type, role.Role_state, role.Health_summary, service_name)
+      }
+    })
+  }
+  run_parallel(tasks)
+  return count_failed(failed, "service roles")
+}
+
+
+// Returns true if none of the parallel queries failed, logging how many
+// failed otherwise
+func count_failed(failed []bool, queries string) bool {
+  num_failed := 0
+  for _, query_failed := range failed {
+    if query_failed {
+      num_failed++
     }
   }
+  if num_failed > 0 {
+    log.Err_msg("%d of %d %s queries failed", num_failed, len(failed), queries)
+    return false
+  }
   return true
 }
 
@@ -318,24 +347,39 @@ func (ScrapeStatus) Scrape (ctx context.Context, config *Collector_connection_da
   error_queries := 0
 
   // Get Clusters list
-  json_clusters, err := make_and_parse_api_query(ctx, *config, "clusters")
+  clusters, err := new_api_client(*config).List_clusters(ctx)
   if err != nil {
-    return nil
+    return scrape_result(0, 1)
   }
 
-  eval_scrape(scrape_cluster_hosts_status(ctx, *config, "hosts", ch), &success_queries, &error_queries)
-  eval_scrape(scrape_cluster_cm_services_status(ctx, *config, "cm/service", ch), &success_queries, &error_queries)
+  // The scrape functions run in parallel, so the counters are updated with
+  // the lock
+  var counters_mutex sync.Mutex
+  scrape := func(scrape_function func() bool) func() {
+    return func() {
+      retval := scrape_function()
+      counters_mutex.Lock()
+      eval_scrape(retval, &success_queries, &error_queries)
+      counters_mutex.Unlock()
+    }
+  }
+  tasks := []func(){
+    scrape(func() bool { return scrape_cluster_hosts_status(ctx, *config, ch) }),
+    scrape(func() bool { return scrape_cluster_cm_services_status(ctx, *config, cmapi.Management_service_path(), ch) }),
+  }
 
-  clustersName := jp.Get_api_query_clusters_list(json_clusters)
-  for c_clusters := 0; c_clusters < len(clustersName); c_clusters++ {
-    cluster := clustersName[c_clusters].String()
+  for _, api_cluster := range clusters {
+    cluster := api_cluster.Name
 
-    eval_scrape(scrape_cluster_status(ctx, *config, fmt.Sprintf("clusters/%s", cluster), ch), &success_queries, &error_queries)
-    eval_scrape(scrape_cluster_services_status(ctx, *config, fmt.Sprintf("clusters/%s/services", cluster), ch), &success_queries, &error_queries)
-    eval_scrape(scrape_cluster_roles_status(ctx, *config, fmt.Sprintf("clusters/%s/services", cluster), ch), &success_queries, &error_queries)
+    tasks = append(tasks,
+      scrape(func() bool { return scrape_cluster_status(ctx, *config, cmapi.Cluster_path(cluster), ch) }),
+      scrape(func() bool { return scrape_cluster_services_status(ctx, *config, cluster, ch) }),
+      scrape(func() bool { return scrape_cluster_roles_status(ctx, *config, cluster, ch) }),
+    )
   }
+  run_parallel(tasks)
   log.Debug_msg("In the Status Module has been executed %d queries. %d success and %d with errors", success_queries + error_queries, success_queries, error_queries)
-  return nil
+  return scrape_result(success_queries, error_queries)
 }
 
 var _ Scraper = ScrapeStatus{}
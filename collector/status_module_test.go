@@ -0,0 +1,159 @@
+/*
+ *
+ * title           :collector/status_module_test.go
+ * description     :Tests of the status metrics of the clusters, services, hosts and roles
+ * author		       :Alejandro Villegas
+ * date            :2019/07/03
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "net/http"
+  "net/http/httptest"
+  "sort"
+  "strings"
+  "testing"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Fake Cloudera Manager that answers the paths of the responses, without the
+// /api/<version>/ prefix, and fails the rest with a 500 response
+func new_test_cm_server(responses map[string]string) *httptest.Server {
+  return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    path := strings.SplitN(strings.TrimPrefix(r.URL.EscapedPath(), "/api/"), "/", 2)
+    if len(path) == 2 {
+      if response, ok := responses[path[1]]; ok {
+        w.Write([]byte(response))
+        return
+      }
+    }
+    w.WriteHeader(http.StatusInternalServerError)
+  }))
+}
+
+
+// Returns the metrics sent by the scrape function and its result
+func collect_test_metrics(scrape func(ch chan<- prometheus.Metric) bool) ([]prometheus.Metric, bool) {
+  ch := make(chan prometheus.Metric)
+  done := make(chan []prometheus.Metric)
+  go func() {
+    metrics := []prometheus.Metric{}
+    for metric := range ch {
+      metrics = append(metrics, metric)
+    }
+    done <- metrics
+  }()
+  result := scrape(ch)
+  close(ch)
+  return <-done, result
+}
+
+
+func Test_count_failed(t *testing.T) {
+  tests := []struct {
+    failed []bool
+    expected bool
+  }{
+    {nil, true},
+    {[]bool{false, false}, true},
+    {[]bool{false, true}, false},
+    {[]bool{true, true}, false},
+  }
+  for _, test := range tests {
+    if result := count_failed(test.failed, "test"); result != test.expected {
+      t.Errorf("count_failed(%v) = %v, expected %v", test.failed, result, test.expected)
+    }
+  }
+}
+
+
+func Test_scrape_cluster_hosts_status(t *testing.T) {
+  hosts := `{"items": [{"hostId": "h1", "hostname": "host1"}, {"hostId": "h2", "hostname": "host2"}]}`
+  tests := []struct {
+    name string
+    responses map[string]string
+    metrics []string
+    result bool
+  }{
+    {
+      "all the hosts",
+      map[string]string{"hosts": hosts, "hosts/h1": `{"healthSummary": "GOOD"}`, "hosts/h2": `{"healthSummary": "BAD"}`},
+      []string{"host1", "host2"},
+      true,
+    },
+    {
+      "failed host skipped",
+      map[string]string{"hosts": hosts, "hosts/h1": `{"healthSummary": "GOOD"}`},
+      []string{"host1"},
+      false,
+    },
+    {"failed hosts list", map[string]string{}, []string{}, false},
+  }
+  for _, test := range tests {
+    server := new_test_cm_server(test.responses)
+    config := new_test_connection(t, server, Api_client_options{})
+    metrics, result := collect_test_metrics(func(ch chan<- prometheus.Metric) bool {
+      return scrape_cluster_hosts_status(context.Background(), config, ch)
+    })
+    server.Close()
+
+    host_names := []string{}
+    for _, labels := range format_metrics(t, metrics) {
+      for _, label := range strings.Split(strings.Trim(labels, "{}"), ",") {
+        if strings.HasPrefix(label, "hostname=") {
+          host_names = append(host_names, strings.TrimPrefix(label, "hostname="))
+        }
+      }
+    }
+    sort.Strings(host_names)
+    if result != test.result || strings.Join(host_names, ",") != strings.Join(test.metrics, ",") {
+      t.Errorf("%s: scrape_cluster_hosts_status() = %v with the hosts %v, expected %v with %v", test.name, result, host_names, test.result, test.metrics)
+    }
+  }
+}
+
+
+func Test_scrape_cluster_roles_status(t *testing.T) {
+  responses := map[string]string{
+    "hosts": `{"items": [{"hostId": "h1", "hostname": "host1"}]}`,
+    "clusters/cluster1/services": `{"items": [{"name": "hdfs"}, {"name": "yarn"}]}`,
+    "clusters/cluster1/services/hdfs/roles": `{"items": [{"name": "hdfs-DATANODE-1", "type": "DATANODE", "hostRef": {"hostId": "h1"}}]}`,
+  }
+  server := new_test_cm_server(responses)
+  defer server.Close()
+  config := new_test_connection(t, server, Api_client_options{})
+
+  // The roles of yarn can't be listed
+  metrics, result := collect_test_metrics(func(ch chan<- prometheus.Metric) bool {
+    return scrape_cluster_roles_status(context.Background(), config, "cluster1", ch)
+  })
+  if result || len(metrics) != 1 {
+    t.Errorf("scrape_cluster_roles_status() with a failed service = %v with %d metrics, expected false with 1", result, len(metrics))
+  }
+
+  responses["clusters/cluster1/services/yarn/roles"] = `{"items": []}`
+  metrics, result = collect_test_metrics(func(ch chan<- prometheus.Metric) bool {
+    return scrape_cluster_roles_status(context.Background(), config, "cluster1", ch)
+  })
+  if !result || len(metrics) != 1 {
+    t.Errorf("scrape_cluster_roles_status() = %v with %d metrics, expected true with 1", result, len(metrics))
+  }
+}
@@ -0,0 +1,207 @@
+/*
+ *
+ * title           :collector/topology_module.go
+ * description     :Submodule Collector for the Cluster topology and role assignment info metrics
+ * author		       :Alejandro Villegas
+ * date            :2019/07/15
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "strings"
+  "sync"
+
+  // Go Prometheus libraries
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+
+
+
+/* ======================================================================
+ * Data Structs
+ * ====================================================================== */
+// Host placement data used as labels of the topology metrics
+type topology_host struct {
+  Hostname string
+  Rack_id string
+  Cluster string
+  Config_groups map[string]bool
+}
+
+
+
+
+/* ======================================================================
+ * Global variables
+ * ====================================================================== */
+var (
+  // Role Placement Info Metric Definition
+  topology_role_info = new_metric_desc(
+    prometheus.BuildFQName(namespace, "topology", "role_info"),
+    "Role placement in the cluster topology. Always 1, the placement is in the labels",
+    []string{"cluster", "service", "role_type", "hostname", "rack_id"},
+    nil,
+  )
+
+  // Host Placement Info Metric Definition
+  topology_host_info = new_metric_desc(
+    prometheus.BuildFQName(namespace, "topology", "host_info"),
+    "Host placement in the cluster topology. Always 1, the placement is in the labels",
+    []string{"hostname", "rack_id", "cluster", "host_template"},
+    nil,
+  )
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Returns a map with the host_id as key and its placement as value
+func get_topology_hosts(ctx context.Context, config Collector_connection_data) (map[string]*topology_host, error) {
+  hosts := make(map[string]*topology_host)
+  api_hosts, err := new_metadata_client(config).List_hosts(ctx)
+  if err != nil {
+    return hosts, err
+  }
+  for _, api_host := range api_hosts {
+    hosts[api_host.Host_id] = &topology_host{
+      Hostname: api_host.Hostname,
+      Rack_id: api_host.Rack_id,
+      Cluster: api_host.Cluster_ref.Cluster_name,
+      Config_groups: make(map[string]bool),
+    }
+  }
+  return hosts, nil
+}
+
+
+// Returns the name of the host template whose role config groups are exactly
+// the ones of the host, or "" if the host does not match any template
+func get_host_template(host *topology_host, templates map[string]map[string]bool) string {
+  for template_name, config_groups := range templates {
+    if len(config_groups) != len(host.Config_groups) {
+      continue
+    }
+    matches := true
+    for config_group := range config_groups {
+      if !host.Config_groups[config_group] {
+        matches = false
+        break
+      }
+    }
+    if matches {
+      return template_name
+    }
+  }
+  return ""
+}
+
+
+// Returns the host templates of a cluster with their role config groups
+func get_cluster_host_templates(ctx context.Context, config Collector_connection_data, cluster_name string) map[string]map[string]bool {
+  templates := make(map[string]map[string]bool)
+  api_templates, err := new_metadata_client(config).List_host_templates(ctx, cluster_name)
+  if err != nil {
+    return templates
+  }
+  for _, api_template := range api_templates {
+    config_groups := make(map[string]bool)
+    for _, config_group := range api_template.Role_config_group_refs {
+      config_groups[config_group.Role_config_group_name] = true
+    }
+    templates[api_template.Name] = config_groups
+  }
+  return templates
+}
+
+
+// Function to Scrape the roles placement of a cluster. Fills the role config
+// groups of each host to match them with the host templates. The roles of
+// the services are queried in parallel, and the hosts are shared with the
+// other clusters, so they are updated with the lock held
+func scrape_cluster_roles_topology(ctx context.Context, config Collector_connection_data, cluster_name string, hosts map[string]*topology_host, hosts_lock *sync.Mutex, ch chan<- prometheus.Metric) bool {
+  client := new_metadata_client(config)
+  services, err := client.List_services(ctx, cluster_name)
+  if err != nil {
+    return false
+  }
+
+  // Several roles of the same type can run in the same host
+  published := make(map[string]bool)
+  tasks := []func(){}
+  for _, service := range services {
+    service_name := service.Name
+    tasks = append(tasks, func() {
+      roles, err := client.List_roles(ctx, cluster_name, service_name)
+      if err != nil {
+        return
+      }
+      hosts_lock.Lock()
+      defer hosts_lock.Unlock()
+      for _, role := range roles {
+        host, ok := hosts[role.Host_ref.Host_id]
+        if !ok {
+          continue
+        }
+        host.Config_groups[role.Role_config_group_ref.Role_config_group_name] = true
+
+        labels := []string{cluster_name, service_name, role.Type, host.Hostname, host.Rack_id}
+        if key := strings.Join(labels, "\x00"); !published[key] {
+          published[key] = true
+          ch <- prometheus.MustNewConstMetric(topology_role_info, prometheus.GaugeValue, 1, labels...)
+        }
+      }
+    })
+  }
+  run_parallel(tasks)
+  return true
+}
+
+
+// Function to Scrape the full topology of the clusters: the placement of
+// each role and each host
+func scrape_cluster_topology(ctx context.Context, config Collector_connection_data, ch chan<- prometheus.Metric) bool {
+  hosts, err := get_topology_hosts(ctx, config)
+  if err != nil {
+    return false
+  }
+  clusters, err := new_metadata_client(config).List_clusters(ctx)
+  if err != nil {
+    return false
+  }
+
+  // The clusters are scraped in parallel
+  var lock sync.Mutex
+  templates := make(map[string]map[string]map[string]bool)
+  tasks := []func(){}
+  for _, cluster := range clusters {
+    cluster_name := cluster.Name
+    tasks = append(tasks, func() {
+      scrape_cluster_roles_topology(ctx, config, cluster_name, hosts, &lock, ch)
+      cluster_templates := get_cluster_host_templates(ctx, config, cluster_name)
+      lock.Lock()
+      templates[cluster_name] = cluster_templates
+      lock.Unlock()
+    })
+  }
+  run_parallel(tasks)
+
+  for _, host := range hosts {
+    host_template := get_host_template(host, templates[host.Cluster])
+    ch <- prometheus.MustNewConstMetric(topology_host_info, prometheus.GaugeValue, 1, host.Hostname, host.Rack_id, host.Cluster, host_template)
+  }
+  return true
+}
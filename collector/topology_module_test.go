@@ -0,0 +1,63 @@
+/*
+ *
+ * title           :collector/topology_module_test.go
+ * description     :Tests of the cluster topology and role placement metrics
+ * author		       :Alejandro Villegas
+ * date            :2019/07/08
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "testing"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Returns a set with the config groups
+func config_group_set(config_groups ...string) map[string]bool {
+  set := make(map[string]bool)
+  for _, config_group := range config_groups {
+    set[config_group] = true
+  }
+  return set
+}
+
+
+func Test_get_host_template(t *testing.T) {
+  templates := map[string]map[string]bool {
+    "master": config_group_set("hdfs-NAMENODE-BASE", "yarn-RESOURCEMANAGER-BASE"),
+    "worker": config_group_set("hdfs-DATANODE-BASE", "yarn-NODEMANAGER-BASE"),
+    "gateway": config_group_set("hdfs-GATEWAY-BASE"),
+  }
+  tests := []struct {
+    name string
+    config_groups map[string]bool
+    expected string
+  }{
+    {"exact match", config_group_set("hdfs-DATANODE-BASE", "yarn-NODEMANAGER-BASE"), "worker"},
+    {"single group", config_group_set("hdfs-GATEWAY-BASE"), "gateway"},
+    {"extra group", config_group_set("hdfs-GATEWAY-BASE", "hive-GATEWAY-BASE"), ""},
+    {"missing group", config_group_set("hdfs-NAMENODE-BASE"), ""},
+    {"same size, other groups", config_group_set("hdfs-NAMENODE-BASE", "yarn-NODEMANAGER-BASE"), ""},
+    {"no roles", config_group_set(), ""},
+  }
+  for _, test := range tests {
+    host := &topology_host{Hostname: "host1", Config_groups: test.config_groups}
+    if template := get_host_template(host, templates); template != test.expected {
+      t.Errorf("%s: get_host_template() = %q, expected %q", test.name, template, test.expected)
+    }
+  }
+}
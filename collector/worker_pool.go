@@ -0,0 +1,110 @@
+/*
+ *
+ * title           :collector/worker_pool.go
+ * description     :Pool of the concurrent queries to each Cloudera Manager
+ * author		       :Alejandro Villegas
+ * date            :2019/07/31
+ * version         :1.0
+ *
+ */
+package collector
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "context"
+  "fmt"
+  "sync"
+)
+
+
+
+
+/* ======================================================================
+ * Constants
+ * ====================================================================== */
+// Default number of concurrent queries to a Cloudera Manager
+const API_DEFAULT_MAX_CONCURRENT_QUERIES = 4
+
+
+
+
+/* ======================================================================
+ * Data Structs
+ * ====================================================================== */
+// Pool of the queries to a Cloudera Manager. Each query takes a slot while
+// it's running, so all the scrapers of the Cloudera Manager together never
+// exceed the concurrency limit
+type worker_pool struct {
+  slots chan struct{}
+}
+
+
+
+
+/* ======================================================================
+ * Global variables
+ * ====================================================================== */
+// Pools by Cloudera Manager address, shared by all the scrapes of the same
+// Cloudera Manager
+var worker_pools = make(map[string]*worker_pool)
+var worker_pools_mutex sync.Mutex
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Returns the pool of a Cloudera Manager. The pool is created again if the
+// concurrency limit changes, when the config is reloaded
+func get_worker_pool(config Collector_connection_data) *worker_pool {
+  size := config.Client.Max_concurrent_queries
+  if size < 1 {
+    size = 1
+  }
+  address := fmt.Sprintf("%s:%s", config.Host, config.Port)
+
+  worker_pools_mutex.Lock()
+  defer worker_pools_mutex.Unlock()
+  pool, ok := worker_pools[address]
+  if !ok || cap(pool.slots) != size {
+    pool = &worker_pool{slots: make(chan struct{}, size)}
+    worker_pools[address] = pool
+  }
+  return pool
+}
+
+
+// Wait for a free slot of the pool of the Cloudera Manager. Returns the
+// function to release the slot, or an error if the context is done before
+func acquire_query_slot(ctx context.Context, config Collector_connection_data) (func(), error) {
+  pool := get_worker_pool(config)
+  select {
+  case pool.slots <- struct{}{}:
+    return func() { <-pool.slots }, nil
+  case <-ctx.Done():
+    return nil, ctx.Err()
+  }
+}
+
+
+// Run the tasks in parallel and wait for all of them. The queries of the tasks
+// are limited by the pool of the Cloudera Manager, so the tasks can be as
+// many as needed
+func run_parallel(tasks []func()) {
+  var wg sync.WaitGroup
+  for _, task := range tasks {
+    wg.Add(1)
+    go func(task func()) {
+      defer wg.Done()
+      task()
+    } (task)
+  }
+  wg.Wait()
+}
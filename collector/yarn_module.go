@@ -9,7 +9,7 @@ import (
 
 // Metric descriptors.
 var (
-       myNewYARNMetric  = prometheus.NewDesc(
+       myNewYARNMetric  = new_metric_desc(
                 prometheus.BuildFQName(namespace, "subsystem", "metric_name"),
                 "This is my metrics description.",
                 []string{"label1","label2","label3"}, nil,)
@@ -0,0 +1,154 @@
+/*
+ *
+ * title           :config_parser/config_checker.go
+ * description     :Module to check the cloudera exporter config file reporting all its problems
+ * author		       :Alejandro Villegas
+ * date            :2019/07/25
+ *
+ */
+package config_parser
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "errors"
+  "io/ioutil"
+  "strings"
+
+  // Go External libraries
+  "gopkg.in/ini.v1"
+  "gopkg.in/yaml.v2"
+)
+
+
+
+
+/* ======================================================================
+ * Global variables
+ * ====================================================================== */
+// Known keys of each section of the INI config file. The keys of the
+// node_classes and labels sections are the class and label names, so any key
+// is valid, and the keys of the modules section are the flags of the modules
+var ini_known_keys = map[string][]string {
+  ini.DEFAULT_SECTION: {},
+  "target": {"host", "port", "version"},
+  "user": {"username", "password", "password_file"},
+  "hdfs_usage": {"watched_directories", "refresh_interval"},
+  "system": {"num_procs", "deploy_ip", "deploy_port", "log_level"},
+  "api": {"max_retries", "initial_backoff", "max_backoff", "circuit_failures", "circuit_timeout", "tsquery_batch_size", "max_concurrent_queries", "requests_per_second", "burst", "metadata_cache_ttl", "debug_query_label", "max_response_size"},
+  CUSTOM_METRIC_SECTION_PREFIX: {"query", "help", "type", "labels", "window", "desired_rollup", "must_use_desired_rollup"},
+}
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Check the config file and returns all the problems found, instead of
+// stopping at the first one. An empty list means the config file is valid
+func Check_config(file_name string) []error {
+  var problems []error
+  if is_yaml_file(file_name) {
+    problems = check_yaml_config(file_name)
+  } else {
+    problems = check_ini_config(file_name)
+  }
+  return problems
+}
+
+
+// Check a config file in INI format
+func check_ini_config(file_name string) []error {
+  problems := []error{}
+  cfg, err := ini.LoadSources(ini.LoadOptions{AllowBooleanKeys: true}, file_name)
+  if err != nil {
+    return append(problems, err)
+  }
+
+  // Unknown sections and keys
+  for _, section := range cfg.Sections() {
+    // The keys of the metric filters are checked when they are parsed
+    if section.Name() == "node_classes" || section.Name() == LABELS_SECTION || section.Name() == METRIC_FILTER_SECTION || strings.HasPrefix(section.Name(), METRIC_FILTER_SECTION_PREFIX) {
+      continue
+    }
+    if section.Name() == "modules" {
+      for _, key := range section.Keys() {
+        if !is_ini_module_key(key.Name()) {
+          problems = append(problems, new_config_error("modules." + key.Name(), "Unknown module"))
+        }
+      }
+      continue
+    }
+    section_type := section.Name()
+    if strings.HasPrefix(section_type, CUSTOM_METRIC_SECTION_PREFIX) {
+      section_type = CUSTOM_METRIC_SECTION_PREFIX
+    }
+    known_keys, ok := ini_known_keys[section_type]
+    if !ok {
+      problems = append(problems, new_config_error(section.Name(), "Unknown section"))
+      continue
+    }
+    for _, key := range section.Keys() {
+      if !contains(known_keys, key.Name()) {
+        problems = append(problems, new_config_error(section.Name() + "." + key.Name(), "Unknown key"))
+      }
+    }
+  }
+
+  // Invalid values
+  _, errors := parse_ini_fields(cfg)
+  return append(problems, errors...)
+}
+
+
+// Check a config file in YAML format
+func check_yaml_config(file_name string) []error {
+  problems := []error{}
+  content, err := ioutil.ReadFile(file_name)
+  if err != nil {
+    return append(problems, err)
+  }
+
+  // Unknown keys and invalid values are reported together by the YAML
+  // library, and the rest of the file is decoded anyway
+  config := yaml_config{}
+  if err := yaml.UnmarshalStrict(content, &config); err != nil {
+    type_error, ok := err.(*yaml.TypeError)
+    if !ok {
+      return append(problems, err)
+    }
+    for _, message := range type_error.Errors {
+      problems = append(problems, errors.New(message))
+    }
+  }
+
+  // Invalid values
+  _, field_errors := parse_yaml_fields(config)
+  return append(problems, field_errors...)
+}
+
+
+// Returns true if the key is the flag of a module in the INI config file
+func is_ini_module_key(key string) bool {
+  for _, module_key := range ini_module_keys {
+    if key == module_key {
+      return true
+    }
+  }
+  return false
+}
+
+
+// Returns true if the list contains the value
+func contains(list []string, value string) bool {
+  for _, item := range list {
+    if item == value {
+      return true
+    }
+  }
+  return false
+}
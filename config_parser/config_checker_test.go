@@ -0,0 +1,158 @@
+/*
+ *
+ * title           :config_parser/config_checker_test.go
+ * description     :Tests of the config file checks
+ * author		       :Alejandro Villegas
+ * date            :2019/07/25
+ * version         :1.0
+ *
+ */
+package config_parser
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "io/ioutil"
+  "os"
+  "path"
+  "strings"
+  "testing"
+)
+
+
+
+
+/* ======================================================================
+ * Constants
+ * ====================================================================== */
+const TEST_INI_CONFIG = `
+[target]
+host = cm1.example.com
+port = 7180
+version = v19
+[user]
+username = user
+password = passwd
+[modules]
+global_status_module = true
+`
+
+const TEST_YAML_CONFIG = `
+targets:
+  - name: cm1
+    host: cm1.example.com
+    port: 7180
+    version: v19
+    username: user
+    password: passwd
+modules:
+  status:
+    enabled: true
+`
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+func Test_Check_config(t *testing.T) {
+  directory, err := ioutil.TempDir("", "config_checker")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(directory)
+
+  tests := []struct {
+    name string
+    file_name string
+    content string
+    expected []string
+  }{
+    {"valid INI", "config.ini", TEST_INI_CONFIG, []string{}},
+    {"unknown INI section", "config.ini", TEST_INI_CONFIG + "[unknown]\nkey = value\n", []string{"unknown: Unknown section"}},
+    {"unknown INI key", "config.ini", TEST_INI_CONFIG + "[system]\nworkers = 2\n", []string{"system.workers: Unknown key"}},
+    {"unknown INI module", "config.ini", TEST_INI_CONFIG + "solr_module = true\n", []string{"modules.solr_module: Unknown module"}},
+    {"unknown INI custom metric key", "config.ini", TEST_INI_CONFIG + "[custom_metric.cpu]\nquery = SELECT cpu_percent\nunit = percent\n", []string{"custom_metric.cpu.unit: Unknown key"}},
+    {"free INI sections", "config.ini", TEST_INI_CONFIG + "[labels]\nenvironment = production\n[node_classes]\nworker = DATANODE\n", []string{}},
+    {
+      "all the INI problems",
+      "config.ini",
+      TEST_INI_CONFIG + "[unknown]\n[system]\nworkers = 2\nnum_procs = many\n",
+      []string{"unknown: Unknown section", "system.workers: Unknown key", "system.num_procs: Invalid integer value many"},
+    },
+    {"valid YAML", "config.yaml", TEST_YAML_CONFIG, []string{}},
+    {"valid YML", "config.yml", TEST_YAML_CONFIG, []string{}},
+    {"unknown YAML key", "config.yaml", TEST_YAML_CONFIG + "workers: 2\n", []string{"field workers not found"}},
+    {"invalid YAML value", "config.yaml", TEST_YAML_CONFIG + "system:\n  num_procs: many\n", []string{"cannot unmarshal"}},
+    {"invalid YAML syntax", "config.yaml", "targets: [\n", []string{"yaml:"}},
+    {"no YAML targets", "config.yaml", "modules: {}\n", []string{"targets"}},
+  }
+  for _, test := range tests {
+    file_name := path.Join(directory, test.file_name)
+    if err := ioutil.WriteFile(file_name, []byte(test.content), 0600); err != nil {
+      t.Fatal(err)
+    }
+    problems := Check_config(file_name)
+    if len(problems) != len(test.expected) {
+      t.Errorf("%s: Check_config() = %v, expected %v", test.name, problems, test.expected)
+      continue
+    }
+    for index, problem := range problems {
+      if !strings.Contains(problem.Error(), test.expected[index]) {
+        t.Errorf("%s: Check_config() problem %d = %q, expected %q", test.name, index, problem, test.expected[index])
+      }
+    }
+  }
+
+  // Missing files
+  for _, file_name := range []string{"missing.ini", "missing.yaml"} {
+    if problems := Check_config(path.Join(directory, file_name)); len(problems) != 1 {
+      t.Errorf("Check_config(%q) = %v, expected one problem", file_name, problems)
+    }
+  }
+}
+
+
+func Test_is_ini_module_key(t *testing.T) {
+  tests := []struct {
+    key string
+    expected bool
+  }{
+    {"global_status_module", true},
+    {"hdfs_usage_module", true},
+    {"custom_module", true},
+    {"status", false},
+    {"solr_module", false},
+    {"", false},
+  }
+  for _, test := range tests {
+    if is_module := is_ini_module_key(test.key); is_module != test.expected {
+      t.Errorf("is_ini_module_key(%q) = %v, expected %v", test.key, is_module, test.expected)
+    }
+  }
+}
+
+
+func Test_contains(t *testing.T) {
+  tests := []struct {
+    list []string
+    value string
+    expected bool
+  }{
+    {nil, "host", false},
+    {[]string{"host", "port"}, "port", true},
+    {[]string{"host", "port"}, "Port", false},
+    {[]string{""}, "", true},
+  }
+  for _, test := range tests {
+    if found := contains(test.list, test.value); found != test.expected {
+      t.Errorf("contains(%v, %q) = %v, expected %v", test.list, test.value, found, test.expected)
+    }
+  }
+}
@@ -0,0 +1,334 @@
+/*
+ *
+ * title           :config_parser/config_fields.go
+ * description     :Defaults, sources and validation errors of the cloudera exporter config fields
+ * author		       :Alejandro Villegas
+ * date            :2019/07/26
+ *
+ */
+package config_parser
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "fmt"
+  "os"
+  "sort"
+  "strings"
+
+  // Own Libraries
+  cl "keedio/cloudera_exporter/collector"
+)
+
+
+
+
+/* ======================================================================
+ * Constants
+ * ====================================================================== */
+// Default values of the optional fields of the config file
+const (
+  // Number of Golang threads. 0 keeps the Golang default, one per CPU
+  DEFAULT_NUM_PROCS = 0
+  // IP address to publish the metrics. Empty to use all the interfaces
+  DEFAULT_DEPLOY_IP = ""
+  // Port to publish the metrics
+  DEFAULT_DEPLOY_PORT = 9200
+  // Log level. 0 (NORMAL) or 1 (DEBUG)
+  DEFAULT_LOG_LEVEL = 0
+  // API version of the targets. Empty to get it from the Cloudera Manager API
+  DEFAULT_API_VERSION = ""
+  // Modules are disabled unless they are enabled in the config file
+  DEFAULT_MODULE_ENABLED = false
+)
+
+// Sources of the value of a config field, by priority
+const (
+  SOURCE_FLAG Config_source = "flag"
+  SOURCE_ENV Config_source = "env"
+  SOURCE_FILE Config_source = "file"
+  SOURCE_DEFAULT Config_source = "default"
+)
+
+// Value shown instead of the secrets
+const REDACTED_VALUE = "******"
+
+// Prefix of the environment variables that override the fields of the config
+// file, like CE_API_MAX_RETRIES for api.max_retries
+const ENV_OVERRIDE_PREFIX = "CE_"
+
+
+
+
+/* ======================================================================
+ * Global variables
+ * ====================================================================== */
+// Blocks of the config file whose fields can be overridden by environment
+// variables. Both formats have the same fields in them
+var env_override_sections = []string{"system", "api"}
+
+
+
+
+/* ======================================================================
+ * Data Structs
+ * ====================================================================== */
+// Source of the value of a config field
+type Config_source string
+
+// Validation error of a field of the config file. The field is the path of
+// the field in the config file, like "system.deploy_port"
+type Config_error struct {
+  Field string
+  Message string
+}
+
+// Validation errors of several fields of the config file
+type Config_errors []error
+
+// Settings of the modules parsed from the config file, used to build the
+// scrapers
+type scrapers_settings struct {
+  Modules map[string]module_settings
+  Node_classes []cl.Node_class_rule
+  Watched_directories []string
+  Refresh_interval int
+  Custom_metrics []*cl.Custom_metric
+  // Filter of the metrics of all the modules
+  Metric_filter *cl.Metric_filter
+}
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+func (err *Config_error) Error() string {
+  return fmt.Sprintf("%s: %s", err.Field, err.Message)
+}
+
+
+func (errors Config_errors) Error() string {
+  messages := []string{}
+  for _, err := range errors {
+    messages = append(messages, err.Error())
+  }
+  return strings.Join(messages, "; ")
+}
+
+
+// Create a validation error of a field
+func new_config_error(field string, format string, args ...interface{}) *Config_error {
+  return &Config_error{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+
+// Environment variable that overrides a field of the config file
+func env_var_name(field string) string {
+  return ENV_OVERRIDE_PREFIX + strings.ToUpper(strings.Replace(field, ".", "_", -1))
+}
+
+
+// Returns the values of the environment variables that override the fields of
+// the config file, by field name. The empty variables are ignored
+func lookup_env_overrides() map[string]string {
+  overrides := make(map[string]string)
+  for _, section := range env_override_sections {
+    for _, key := range ini_known_keys[section] {
+      field := section + "." + key
+      if value := os.Getenv(env_var_name(field)); value != "" {
+        overrides[field] = value
+      }
+    }
+  }
+  return overrides
+}
+
+
+// Set the source of the value of a config field. The fields of the file
+// overridden by an environment variable have the environment as source
+func (config *CE_config) Set_source(field string, source Config_source) {
+  if source == SOURCE_FILE && config.env_fields[field] {
+    source = SOURCE_ENV
+  }
+  if config.Sources == nil {
+    config.Sources = make(map[string]Config_source)
+  }
+  config.Sources[field] = source
+}
+
+
+// Returns the source of the value of a config field
+func (config *CE_config) Get_source(field string) Config_source {
+  if source, ok := config.Sources[field]; ok {
+    return source
+  }
+  return SOURCE_DEFAULT
+}
+
+
+// Field name of a target in the effective config
+func Target_field(target_name string, field string) string {
+  return fmt.Sprintf("targets.%s.%s", target_name, field)
+}
+
+
+// Returns a value of the effective config in YAML flow style
+func format_value(value interface{}) string {
+  switch value := value.(type) {
+  case string:
+    if value == "" {
+      return `""`
+    }
+    return value
+  case []string:
+    return fmt.Sprintf("[%s]", strings.Join(value, ", "))
+  case map[string]string:
+    keys := []string{}
+    for key := range value {
+      keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    pairs := []string{}
+    for _, key := range keys {
+      pairs = append(pairs, fmt.Sprintf("%s: %s", key, value[key]))
+    }
+    return fmt.Sprintf("{%s}", strings.Join(pairs, ", "))
+  }
+  return fmt.Sprint(value)
+}
+
+
+// Returns the lines of a metric filter of the effective config
+func format_metric_filter(indent int, filter *cl.Metric_filter) []string {
+  lines := []string{}
+  prefix := strings.Repeat("  ", indent)
+  if len(filter.Include) > 0 {
+    lines = append(lines, fmt.Sprintf("%sinclude: %s", prefix, format_value(filter.Include)))
+  }
+  if len(filter.Exclude) > 0 {
+    lines = append(lines, fmt.Sprintf("%sexclude: %s", prefix, format_value(filter.Exclude)))
+  }
+  if len(filter.Include_labels) > 0 {
+    lines = append(lines, fmt.Sprintf("%sinclude_labels: %s", prefix, format_value(filter.Include_labels)))
+  }
+  if len(filter.Exclude_labels) > 0 {
+    lines = append(lines, fmt.Sprintf("%sexclude_labels: %s", prefix, format_value(filter.Exclude_labels)))
+  }
+  return lines
+}
+
+
+// Returns the effective config, merged from the flags, the environment, the
+// config file and the defaults, in YAML format. Each field is commented with
+// its source. The secrets are redacted
+func (config *CE_config) Format_effective_config() string {
+  var lines []string
+  add := func(indent int, field string, value interface{}, source_field string) {
+    line := fmt.Sprintf("%s%s: %s", strings.Repeat("  ", indent), field, format_value(value))
+    if source_field != "" {
+      line = fmt.Sprintf("%s  # %s", line, config.Get_source(source_field))
+    }
+    lines = append(lines, line)
+  }
+
+  lines = append(lines, "targets:")
+  for _, target := range config.Targets {
+    connection := target.Connection
+    lines = append(lines, fmt.Sprintf("  - name: %s", target.Name))
+    add(2, "host", connection.Host, Target_field(target.Name, "host"))
+    add(2, "port", connection.Port, Target_field(target.Name, "port"))
+    add(2, "version", connection.Api_version, Target_field(target.Name, "version"))
+    add(2, "username", connection.User, Target_field(target.Name, "username"))
+    if connection.Passwd_file != "" {
+      add(2, "password_file", connection.Passwd_file, Target_field(target.Name, "password_file"))
+    } else {
+      add(2, "password", REDACTED_VALUE, Target_field(target.Name, "password"))
+    }
+    add(2, "requests_per_second", connection.Client.Requests_per_second, Target_field(target.Name, "requests_per_second"))
+    add(2, "burst", connection.Client.Burst, Target_field(target.Name, "burst"))
+    if len(target.Labels) > 0 {
+      add(2, "labels", target.Get_labels(), "")
+    }
+  }
+
+  lines = append(lines, "modules:")
+  module_names := []string{}
+  for module_name := range config.settings.Modules {
+    module_names = append(module_names, module_name)
+  }
+  sort.Strings(module_names)
+  for _, module_name := range module_names {
+    settings := config.settings.Modules[module_name]
+    lines = append(lines, fmt.Sprintf("  %s:", module_name))
+    add(2, "enabled", settings.Enabled, "modules." + module_name)
+    if settings.Options.Interval != 0 {
+      add(2, "interval", settings.Options.Interval, "")
+    }
+    if settings.Options.Timeout != 0 {
+      add(2, "timeout", settings.Options.Timeout, "")
+    }
+    if len(settings.Options.Extra_labels) > 0 {
+      add(2, "extra_labels", settings.Options.Extra_labels, "")
+    }
+    if len(settings.Options.Metric_allowlist) > 0 {
+      add(2, "metric_allowlist", settings.Options.Metric_allowlist, "")
+    }
+    if len(settings.Options.Metric_denylist) > 0 {
+      add(2, "metric_denylist", settings.Options.Metric_denylist, "")
+    }
+    for _, filter := range settings.Options.Metric_filters {
+      lines = append(lines, "    metric_filter:")
+      lines = append(lines, format_metric_filter(3, filter)...)
+    }
+    if module_name == MODULE_HDFS_USAGE {
+      add(2, "watched_directories", config.settings.Watched_directories, "")
+      add(2, "refresh_interval", config.settings.Refresh_interval, "hdfs_usage.refresh_interval")
+    }
+  }
+
+  if config.settings.Metric_filter != nil {
+    lines = append(lines, "metric_filter:")
+    lines = append(lines, format_metric_filter(1, config.settings.Metric_filter)...)
+  }
+
+  lines = append(lines, "node_classes:")
+  for _, rule := range config.settings.Node_classes {
+    lines = append(lines, fmt.Sprintf("  - class: %s", rule.Class))
+    add(2, "role_types", rule.Role_types, "")
+  }
+
+  lines = append(lines, "custom_metrics:")
+  for _, metric := range config.settings.Custom_metrics {
+    lines = append(lines, fmt.Sprintf("  - name: %s", metric.Name))
+    add(2, "query", metric.Query, "")
+    add(2, "window", metric.Time_series.Window, "")
+    add(2, "desired_rollup", metric.Time_series.Desired_rollup, "")
+    add(2, "must_use_desired_rollup", metric.Time_series.Must_use_desired_rollup, "")
+  }
+
+  lines = append(lines, "api:")
+  add(1, "max_retries", config.Api_client.Max_retries, "api.max_retries")
+  add(1, "initial_backoff", config.Api_client.Initial_backoff, "api.initial_backoff")
+  add(1, "max_backoff", config.Api_client.Max_backoff, "api.max_backoff")
+  add(1, "circuit_failures", config.Api_client.Circuit_failures, "api.circuit_failures")
+  add(1, "circuit_timeout", config.Api_client.Circuit_timeout, "api.circuit_timeout")
+  add(1, "tsquery_batch_size", config.Api_client.Tsquery_batch_size, "api.tsquery_batch_size")
+  add(1, "max_concurrent_queries", config.Api_client.Max_concurrent_queries, "api.max_concurrent_queries")
+  add(1, "requests_per_second", config.Api_client.Requests_per_second, "api.requests_per_second")
+  add(1, "burst", config.Api_client.Burst, "api.burst")
+  add(1, "metadata_cache_ttl", config.Api_client.Metadata_cache_ttl, "api.metadata_cache_ttl")
+  add(1, "debug_query_label", config.Api_client.Debug_query_label, "api.debug_query_label")
+  add(1, "max_response_size", config.Api_client.Max_response_size, "api.max_response_size")
+
+  lines = append(lines, "system:")
+  add(1, "num_procs", config.Num_procs, "system.num_procs")
+  add(1, "deploy_ip", config.Deploy_ip, "system.deploy_ip")
+  add(1, "deploy_port", config.Deploy_port, "system.deploy_port")
+  add(1, "log_level", config.Log_level, "system.log_level")
+  return strings.Join(lines, "\n") + "\n"
+}
@@ -16,7 +16,14 @@ import (
   // Own Libraries
   cl "keedio/cloudera_exporter/collector"
   log "keedio/cloudera_exporter/logger"
-  "errors"
+  "fmt"
+  "os"
+  "regexp"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+  "unicode"
 
   // Go External libraries
   "gopkg.in/ini.v1"
@@ -26,38 +33,103 @@ import (
 
 
 /* ======================================================================
- * Error Messages
+ * Constants
  * ====================================================================== */
- var (
-  error_msg_no_user =     "No user specified in config file"
-  error_msg_no_password = "No password specified in config file"
-  error_msg_no_host =     "No host specified in config file"
-  error_msg_no_port =     "No port specified in config file"
-  error_msg_no_num_procs = "No num_procs specified in config file"
-  error_msg_no_deploy_ip = "No deploy_ip specified in config file. The exporter will use the public IP"
-  error_msg_no_deploy_port = "No deploy_port specified in config file"
-  error_msg_no_log_level = "No log_level specified in config file"
+const CUSTOM_METRIC_SECTION_PREFIX = "custom_metric."
+
+// Metric filter of all the modules, and prefix of the metric filter of a
+// module, in the INI config file
+const METRIC_FILTER_SECTION = "metric_filter"
+const METRIC_FILTER_SECTION_PREFIX = "metric_filter."
+
+// Constant labels of the metrics in the INI config file
+const LABELS_SECTION = "labels"
+
+// Module names
+const (
+  MODULE_STATUS = "status"
+  MODULE_HOST = "host"
+  MODULE_HDFS = "hdfs"
+  MODULE_IMPALA = "impala"
+  MODULE_YARN = "yarn"
+  MODULE_HDFS_USAGE = "hdfs_usage"
+  MODULE_REPLICATION = "replication"
+  MODULE_CUSTOM = "custom"
 )
 
+// Flag of each module in the modules section of the INI config file
+var ini_module_keys = map[string]string {
+  MODULE_STATUS: "global_status_module",
+  MODULE_HOST: "host_module",
+  MODULE_HDFS: "hdfs_module",
+  MODULE_IMPALA: "impala_module",
+  MODULE_YARN: "yarn_module",
+  MODULE_HDFS_USAGE: "hdfs_usage_module",
+  MODULE_REPLICATION: "replication_module",
+  MODULE_CUSTOM: "custom_module",
+}
+
+
+
+
+/* ======================================================================
+ * Global variables
+ * ====================================================================== */
+// Environment variable reference in the credential fields
+var env_var_regexp = regexp.MustCompile(`\$\{([a-zA-Z_][a-zA-Z0-9_]*)\}`)
+
+// Valid Prometheus label name
+var label_name_regexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Placeholders of the constant labels replaced with the fields of the target
+var label_template_regexp = regexp.MustCompile(`\{(target|host|port)\}`)
+
 
 
 
 /* ======================================================================
  * Data Structs
  * ====================================================================== */
-// Struct to store the list of Scrapers and if they are going to be loaded
+// Struct to store the list of Scrapers and if they are going to be loaded,
+// and the Scraper of each module name
 type CE_collectors_flags struct {
   Scrapers map [cl.Scraper] bool
+  Modules map [string] cl.Scraper
+}
+
+// Struct to store a Cloudera Manager to scrape and its name
+type CE_target struct {
+  Name string
+  Connection cl.Collector_connection_data
+  // Constant labels of all the metrics of the target. The values can use the
+  // {target}, {host} and {port} placeholders
+  Labels map[string]string
 }
 
 // Struct to group the two previous structs and some exporter configuration parameters
 type CE_config struct {
   Num_procs int
-  Connection cl.Collector_connection_data
+  Targets []CE_target
   Scrapers CE_collectors_flags
   Deploy_ip string
   Deploy_port uint
   Log_level int
+  // Retries and circuit breaker of the queries to all the targets
+  Api_client cl.Api_client_options
+
+  // Source of the value of each field, by field name of the effective config
+  Sources map[string]Config_source
+  // Fields of the file overridden by environment variables
+  env_fields map[string]bool
+
+  // Settings used to build the scrapers
+  settings scrapers_settings
+}
+
+// Struct to store if a module is going to be loaded and its options
+type module_settings struct {
+  Enabled bool
+  Options cl.Module_options
 }
 
 
@@ -66,221 +138,745 @@ type CE_config struct {
 /* ======================================================================
  * Functions
  * ====================================================================== */
-func parse_user (config_reader *ini.File) (string, error) {
-  user := config_reader.Section("user").Key("username").String()
-  if user == "" {
-    log.Err_msg(error_msg_no_user)
-    return "", errors.New(error_msg_no_user)
+// Returns the target with the given name, or the first target if the name is
+// empty
+func (config *CE_config) Get_target(name string) (*CE_target, bool) {
+  if len(config.Targets) == 0 {
+    return nil, false
+  }
+  if name == "" {
+    return &config.Targets[0], true
   }
-  return user, nil
+  for index := range config.Targets {
+    if config.Targets[index].Name == name {
+      return &config.Targets[index], true
+    }
+  }
+  return nil, false
 }
 
 
-func parse_passwd (config_reader *ini.File) (string, error) {
-  password := config_reader.Section("user").Key("password").String()
-  if password == "" {
-    log.Err_msg(error_msg_no_password)
-    return "", errors.New(error_msg_no_password)
-  }
-  return password, nil
+// Returns the custom metrics of the config
+func (config *CE_config) Get_custom_metrics() []*cl.Custom_metric {
+  return config.settings.Custom_metrics
 }
 
 
-func parse_host (config_reader *ini.File) (string, error) {
-  host := config_reader.Section("target").Key("host").String()
-  if host == "" {
-    log.Err_msg(error_msg_no_host)
-    return "", errors.New(error_msg_no_host)
+// Returns the constant labels of the metrics of the target, with the
+// placeholders replaced by the fields of the target
+func (target *CE_target) Get_labels() map[string]string {
+  labels := make(map[string]string)
+  for name, value := range target.Labels {
+    labels[name] = label_template_regexp.ReplaceAllStringFunc(value, func(placeholder string) string {
+      switch placeholder {
+      case "{target}":
+        return target.Name
+      case "{host}":
+        return target.Connection.Host
+      }
+      return target.Connection.Port
+    })
   }
-  return host, nil
+  return labels
 }
 
 
-func parse_port (config_reader *ini.File) (string, error) {
-  port := config_reader.Section("target").Key("port").String()
-  if port == "" {
-    log.Err_msg(error_msg_no_port)
-    return "", errors.New(error_msg_no_port)
+// Create the map of Scrapers with the settings of each module
+func build_scrapers(settings scrapers_settings) CE_collectors_flags {
+  scrapers := map[string]cl.Scraper {
+    MODULE_STATUS: cl.ScrapeStatus{},
+    MODULE_HOST: &cl.ScrapeHost{
+      Node_classes: settings.Node_classes,
+    },
+    MODULE_IMPALA: cl.ScrapeImpalaMetrics{},
+    MODULE_HDFS: cl.ScrapeHDFS{},
+    MODULE_YARN: cl.ScrapeYARNMetrics{},
+    MODULE_HDFS_USAGE: &cl.ScrapeHDFSUsage{
+      Watched_directories: settings.Watched_directories,
+      Refresh_interval: settings.Refresh_interval,
+    },
+    MODULE_REPLICATION: cl.ScrapeReplication{},
+    MODULE_CUSTOM: &cl.ScrapeCustom{
+      Metrics: settings.Custom_metrics,
+    },
   }
-  return port, nil
+
+  scrapers_flags := CE_collectors_flags {
+    Scrapers: make(map[cl.Scraper]bool),
+    Modules: make(map[string]cl.Scraper),
+  }
+  for module_name, scraper := range scrapers {
+    module_settings := settings.Modules[module_name]
+    options := module_settings.Options
+    if settings.Metric_filter != nil {
+      options.Metric_filters = append([]*cl.Metric_filter{settings.Metric_filter}, options.Metric_filters...)
+    }
+    module_scraper := cl.New_module_scraper(scraper, options)
+    scrapers_flags.Scrapers[module_scraper] = module_settings.Enabled
+    scrapers_flags.Modules[module_name] = module_scraper
+  }
+  return scrapers_flags
 }
 
 
-func parse_api_version (config_reader *ini.File) (string, error) {
-  api_version := config_reader.Section("target").Key("version").String()
-  if api_version == "" {
-    return "", nil
+// Parse the config file. The format is YAML if the file has the .yaml or
+// .yml extension and INI otherwise
+func Parse_config(config interface{}) (*CE_config, error) {
+  if file_name, ok := config.(string); ok && is_yaml_file(file_name) {
+    return parse_yaml_config_file(file_name)
   }
-  log.Warn_msg("Overwritting API Version value: %s", api_version)
-  return api_version, nil
+  return parse_ini_config(config)
 }
 
 
-
-// Dynamic load of modules
-func parse_global_status_module_flag (config_reader *ini.File) bool {
-  global_status_module_flag := config_reader.Section("modules").Key("global_status_module").MustBool(false)
-  return global_status_module_flag
+// Replace the ${ENV_VAR} references of a credential field with the value of
+// the environment variable. Undefined variables are an error
+func expand_env_vars(field string, value string) (string, error) {
+  var err error
+  expanded := env_var_regexp.ReplaceAllStringFunc(value, func(reference string) string {
+    name := env_var_regexp.FindStringSubmatch(reference)[1]
+    env_value, ok := os.LookupEnv(name)
+    if !ok && err == nil {
+      err = new_config_error(field, "Undefined environment variable %s", name)
+    }
+    return env_value
+  })
+  return expanded, err
 }
 
-func parse_host_module_flag (config_reader *ini.File) bool {
-  host_module_flag := config_reader.Section("modules").Key("host_module").MustBool(false)
-  return host_module_flag
-}
 
-func parse_impala_module_flag (config_reader *ini.File) bool {
-  impala_module_flag := config_reader.Section("modules").Key("impala_module").MustBool(false)
-  return impala_module_flag
+// Source of a credential field: the environment if it references an
+// environment variable, or the config file
+func credential_source(value string) Config_source {
+  if env_var_regexp.MatchString(value) {
+    return SOURCE_ENV
+  }
+  return SOURCE_FILE
 }
 
-func parse_hdfs_module_flag (config_reader *ini.File) bool {
-  hdfs_module_flag := config_reader.Section("modules").Key("hdfs_module").MustBool(false)
-  return hdfs_module_flag
+
+// Expand and check the credentials of a target. Only one of password and
+// password_file can be used. The password file is read to check it, but the
+// password is taken from the file again on each connection. The prefix is the
+// path of the credential fields in the config file
+func parse_credentials(prefix string, user string, password string, password_file string) (string, string, string, error) {
+  var err error
+  if user, err = expand_env_vars(prefix + ".username", user); err != nil {
+    return "", "", "", err
+  }
+  if password, err = expand_env_vars(prefix + ".password", password); err != nil {
+    return "", "", "", err
+  }
+  if password_file, err = expand_env_vars(prefix + ".password_file", password_file); err != nil {
+    return "", "", "", err
+  }
+
+  if user == "" {
+    return "", "", "", new_config_error(prefix + ".username", "No user specified")
+  }
+  if password == "" && password_file == "" {
+    return "", "", "", new_config_error(prefix + ".password", "No password or password_file specified")
+  }
+  if password != "" && password_file != "" {
+    return "", "", "", new_config_error(prefix + ".password", "Both password and password_file specified")
+  }
+  if password_file != "" {
+    if _, err = cl.Read_password_file(password_file); err != nil {
+      return "", "", "", new_config_error(prefix + ".password_file", "%s", err)
+    }
+  }
+  return user, password, password_file, nil
 }
 
-func parse_yarn_module_flag (config_reader *ini.File) bool {
-  yarn_module_flag := config_reader.Section("modules").Key("yarn_module").MustBool(false)
-  return yarn_module_flag
+
+// Check the constant labels of the metrics. The ${ENV_VAR} references of the
+// values are expanded, and the placeholders are kept to be replaced by the
+// fields of each target. The prefix is the path of the labels in the config
+// file
+func parse_labels(prefix string, labels map[string]string) (map[string]string, []error) {
+  errors := []error{}
+  parsed_labels := make(map[string]string)
+  for name, value := range labels {
+    field := prefix + "." + name
+    if !label_name_regexp.MatchString(name) || strings.HasPrefix(name, "__") {
+      errors = append(errors, new_config_error(field, "Invalid label name"))
+      continue
+    }
+    if cl.Is_metric_label_name(name) {
+      errors = append(errors, new_config_error(field, "Label name already used by the metrics"))
+      continue
+    }
+    expanded, err := expand_env_vars(field, value)
+    if err != nil {
+      errors = append(errors, err)
+      continue
+    }
+    parsed_labels[name] = expanded
+  }
+  return parsed_labels, errors
 }
 
 
-func parse_num_procs (config_reader *ini.File) (int, error) {
-  num_procs := config_reader.Section("system").Key("num_procs").MustInt(0)
-  if num_procs == 0 {
-    log.Err_msg(error_msg_no_num_procs)
-    return 0, errors.New(error_msg_no_num_procs)
+// Check that the constant labels of the targets, the extra labels of the
+// modules and the labels of the custom metrics don't collide. The constant and
+// extra labels are added with prometheus.WrapRegistererWith, which does not
+// check them, so a collision would fail the whole scrape
+func check_label_collisions(targets []CE_target, settings scrapers_settings) []error {
+  errors := []error{}
+  custom_labels := make(map[string]string)
+  for _, metric := range settings.Custom_metrics {
+    for _, label_name := range metric.Label_names {
+      custom_labels[label_name] = metric.Name
+    }
+  }
+
+  // Sorted to report the errors always in the same order
+  label_names := func(labels map[string]string) []string {
+    names := []string{}
+    for name := range labels {
+      names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
   }
-  return num_procs, nil
+  for _, target := range targets {
+    for _, name := range label_names(target.Labels) {
+      if metric_name, ok := custom_labels[name]; ok {
+        errors = append(errors, new_config_error(Target_field(target.Name, "labels." + name), "Label name already used by the custom metric %s", metric_name))
+      }
+    }
+  }
+  module_names := []string{}
+  for module_name := range settings.Modules {
+    module_names = append(module_names, module_name)
+  }
+  sort.Strings(module_names)
+  for _, module_name := range module_names {
+    for _, name := range label_names(settings.Modules[module_name].Options.Extra_labels) {
+      field := "modules." + module_name + ".extra_labels." + name
+      for _, target := range targets {
+        if _, ok := target.Labels[name]; ok {
+          errors = append(errors, new_config_error(field, "Label name already used by the labels of the target %s", target.Name))
+        }
+      }
+      if metric_name, ok := custom_labels[name]; ok && module_name == MODULE_CUSTOM {
+        errors = append(errors, new_config_error(field, "Label name already used by the custom metric %s", metric_name))
+      }
+    }
+  }
+  return errors
 }
 
-func parse_deploy_ip (config_reader *ini.File) (string, error) {
-  deploy_ip := config_reader.Section("system").Key("deploy_ip").String()
-  if deploy_ip == "" {
-    log.Warn_msg(error_msg_no_deploy_ip)
-    return "", errors.New(error_msg_no_deploy_ip)
+
+// Check the options of the queries to the targets and set them in the
+// connection of each target
+func set_api_client_options(config *CE_config, options cl.Api_client_options) []error {
+  errors := []error{}
+  if options.Max_retries < 0 {
+    errors = append(errors, new_config_error("api.max_retries", "Invalid number of retries %d", options.Max_retries))
+  }
+  if options.Initial_backoff < 0 {
+    errors = append(errors, new_config_error("api.initial_backoff", "Invalid duration %s", options.Initial_backoff))
   }
-  return deploy_ip, nil
+  if options.Max_backoff < options.Initial_backoff {
+    errors = append(errors, new_config_error("api.max_backoff", "Lower than the initial backoff %s", options.Initial_backoff))
+  }
+  if options.Circuit_failures < 0 {
+    errors = append(errors, new_config_error("api.circuit_failures", "Invalid number of failures %d", options.Circuit_failures))
+  }
+  if options.Circuit_timeout <= 0 {
+    errors = append(errors, new_config_error("api.circuit_timeout", "Invalid duration %s", options.Circuit_timeout))
+  }
+  if options.Tsquery_batch_size < 1 {
+    errors = append(errors, new_config_error("api.tsquery_batch_size", "Invalid batch size %d", options.Tsquery_batch_size))
+  }
+  if options.Max_concurrent_queries < 1 {
+    errors = append(errors, new_config_error("api.max_concurrent_queries", "Invalid number of queries %d", options.Max_concurrent_queries))
+  }
+  errors = append(errors, check_rate_limit("api", options.Requests_per_second, options.Burst)...)
+  if options.Metadata_cache_ttl < 0 {
+    errors = append(errors, new_config_error("api.metadata_cache_ttl", "Invalid duration %s", options.Metadata_cache_ttl))
+  }
+  if options.Max_response_size < 0 {
+    errors = append(errors, new_config_error("api.max_response_size", "Invalid size %d", options.Max_response_size))
+  }
+  config.Api_client = options
+  for index := range config.Targets {
+    config.Targets[index].Connection.Client = options
+    config.Set_source(Target_field(config.Targets[index].Name, "requests_per_second"), config.Get_source("api.requests_per_second"))
+    config.Set_source(Target_field(config.Targets[index].Name, "burst"), config.Get_source("api.burst"))
+  }
+  return errors
 }
 
-func parse_deploy_port (config_reader *ini.File) (uint, error) {
-  deploy_port := config_reader.Section("system").Key("deploy_port").MustUint(0)
-  if deploy_port == 0 {
-    log.Err_msg(error_msg_no_deploy_port)
-    return 0, errors.New(error_msg_no_deploy_port)
+
+// Check the rate limit of the queries to a target. The prefix is the path of
+// the rate limit fields in the config file
+func check_rate_limit(prefix string, requests_per_second float64, burst int) []error {
+  errors := []error{}
+  if requests_per_second < 0 {
+    errors = append(errors, new_config_error(prefix + ".requests_per_second", "Invalid rate %g", requests_per_second))
   }
-  return deploy_port, nil
+  if burst < 1 {
+    errors = append(errors, new_config_error(prefix + ".burst", "Invalid burst %d", burst))
+  }
+  return errors
 }
 
-func parse_log_level (config_reader *ini.File) (int, error) {
-  log_level := config_reader.Section("system").Key("log_level").MustInt(-1)
-  if log_level == -1 {
-    log.Err_msg(error_msg_no_log_level)
-    return 0, errors.New(error_msg_no_log_level)
+
+// Check the port of a target
+func check_port (field string, port string) error {
+  if port == "" {
+    return new_config_error(field, "No port specified")
+  }
+  if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+    return new_config_error(field, "Invalid port %s", port)
   }
-  return log_level, nil
+  return nil
 }
 
 
-func Parse_config(config interface{}) (*CE_config, error) {
-  var err error
+// Check the port to publish the metrics
+func check_deploy_port (field string, deploy_port int) error {
+  if deploy_port < 1 || deploy_port > 65535 {
+    return new_config_error(field, "Invalid port %d", deploy_port)
+  }
+  return nil
+}
 
-  opts := ini.LoadOptions {
-    AllowBooleanKeys: true, // Config file can have boolean keys.
+
+// Optional boolean field of the INI config file. Returns the default value if
+// the field is not set
+func parse_ini_bool (config_reader *ini.File, section string, key string, default_value bool) (bool, Config_source, error) {
+  if !config_reader.Section(section).HasKey(key) {
+    return default_value, SOURCE_DEFAULT, nil
   }
-  cfg, err := ini.LoadSources(opts, config)
+  value, err := config_reader.Section(section).Key(key).Bool()
   if err != nil {
-    log.Err_msg("Failed reading config file: %s", err)
-    return nil, err
+    return default_value, SOURCE_FILE, new_config_error(section + "." + key, "Invalid boolean value %s", config_reader.Section(section).Key(key).String())
   }
+  return value, SOURCE_FILE, nil
+}
 
-  // Parse File Options
 
-  // Username
-  user, err := parse_user(cfg)
+// Optional integer field of the INI config file. Returns the default value if
+// the field is not set or empty
+func parse_ini_int (config_reader *ini.File, section string, key string, default_value int) (int, Config_source, error) {
+  if config_reader.Section(section).Key(key).String() == "" {
+    return default_value, SOURCE_DEFAULT, nil
+  }
+  value, err := config_reader.Section(section).Key(key).Int()
   if err != nil {
-    log.Err_msg("Can't parse user field")
-    return nil, err
+    return default_value, SOURCE_FILE, new_config_error(section + "." + key, "Invalid integer value %s", config_reader.Section(section).Key(key).String())
   }
+  return value, SOURCE_FILE, nil
+}
 
-  // Password
-  password, err := parse_passwd(cfg)
+
+// Optional decimal field of the INI config file. Returns the default value if
+// the field is not set or empty
+func parse_ini_float (config_reader *ini.File, section string, key string, default_value float64) (float64, Config_source, error) {
+  if config_reader.Section(section).Key(key).String() == "" {
+    return default_value, SOURCE_DEFAULT, nil
+  }
+  value, err := config_reader.Section(section).Key(key).Float64()
   if err != nil {
-    log.Err_msg("Can't parse password field")
-    return nil, err
+    return default_value, SOURCE_FILE, new_config_error(section + "." + key, "Invalid decimal value %s", config_reader.Section(section).Key(key).String())
   }
+  return value, SOURCE_FILE, nil
+}
+
 
-  // Cloudera Manager entrypoint
-  host, err := parse_host(cfg)
+// Optional duration field of the INI config file, like 30s or 5m. Returns the
+// default value if the field is not set or empty
+func parse_ini_duration (config_reader *ini.File, section string, key string, default_value time.Duration) (time.Duration, Config_source, error) {
+  if config_reader.Section(section).Key(key).String() == "" {
+    return default_value, SOURCE_DEFAULT, nil
+  }
+  value, err := config_reader.Section(section).Key(key).Duration()
   if err != nil {
-    log.Err_msg("Can't parse host field")
-    return nil, err
+    return default_value, SOURCE_FILE, new_config_error(section + "." + key, "Invalid duration %s", config_reader.Section(section).Key(key).String())
+  }
+  return value, SOURCE_FILE, nil
+}
+
+
+// Optional string field of the INI config file. Returns the default value if
+// the field is not set or empty
+func parse_ini_string (config_reader *ini.File, section string, key string, default_value string) (string, Config_source) {
+  if value := config_reader.Section(section).Key(key).String(); value != "" {
+    return value, SOURCE_FILE
+  }
+  return default_value, SOURCE_DEFAULT
+}
+
+
+// Flags of the modules to load. Returns the settings of the modules and the
+// errors of the invalid flags
+func parse_ini_modules (config_reader *ini.File, config *CE_config) (map[string]module_settings, []error) {
+  modules := make(map[string]module_settings)
+  errors := []error{}
+  for module_name, key := range ini_module_keys {
+    enabled, source, err := parse_ini_bool(config_reader, "modules", key, DEFAULT_MODULE_ENABLED)
+    if err != nil {
+      errors = append(errors, err)
+    }
+    modules[module_name] = module_settings{Enabled: enabled}
+    config.Set_source("modules." + module_name, source)
+  }
+  return modules, errors
+}
+
+
+// Host classification rules. Each key of the section is a node class and its
+// value the comma separated list of role types of the class. The order of the
+// keys is the order of evaluation of the rules
+func parse_node_class_rules (config_reader *ini.File) []cl.Node_class_rule {
+  rules := []cl.Node_class_rule{}
+  for _, key := range config_reader.Section("node_classes").Keys() {
+    role_types := []string{}
+    for _, role_type := range key.Strings(",") {
+      if role_type = strings.ToUpper(strings.TrimSpace(role_type)); role_type != "" {
+        role_types = append(role_types, role_type)
+      }
+    }
+    rules = append(rules, cl.Node_class_rule{Class: key.Name(), Role_types: role_types})
+  }
+  if len(rules) == 0 {
+    return cl.Default_node_class_rules
+  }
+  return rules
+}
+
+
+// Custom metric of a "custom_metric.<name>" section. Each section defines a
+// metric with the keys query, help, type and labels, and optionally the time
+// window and the rollup of the query. Labels are a comma separated list of
+// "label_name:timeseries_attribute" pairs
+func parse_custom_metric_section (section *ini.Section) (*cl.Custom_metric, error) {
+  labels := [][2]string{}
+  for _, label := range section.Key("labels").Strings(",") {
+    pair := strings.SplitN(label, ":", 2)
+    if len(pair) != 2 {
+      return nil, fmt.Errorf("Invalid label %s in section %s. The format is label_name:timeseries_attribute", label, section.Name())
+    }
+    labels = append(labels, [2]string{strings.TrimSpace(pair[0]), strings.TrimSpace(pair[1])})
+  }
+
+  time_series := cl.Custom_metric_time_series {
+    Desired_rollup: strings.ToUpper(section.Key("desired_rollup").String()),
+  }
+  var err error
+  if section.HasKey("window") {
+    if time_series.Window, err = section.Key("window").Duration(); err != nil {
+      return nil, fmt.Errorf("Invalid window %s in section %s", section.Key("window").String(), section.Name())
+    }
+  }
+  if section.HasKey("must_use_desired_rollup") {
+    if time_series.Must_use_desired_rollup, err = section.Key("must_use_desired_rollup").Bool(); err != nil {
+      return nil, fmt.Errorf("Invalid must_use_desired_rollup %s in section %s", section.Key("must_use_desired_rollup").String(), section.Name())
+    }
   }
+  return cl.New_custom_metric(
+    strings.TrimPrefix(section.Name(), CUSTOM_METRIC_SECTION_PREFIX),
+    section.Key("help").String(),
+    section.Key("query").String(),
+    strings.ToLower(section.Key("type").String()),
+    labels,
+    time_series,
+  )
+}
 
-  // Cloudera Manager Port
-  port, err := parse_port(cfg)
+
+// Metric filter of a "metric_filter" or "metric_filter.<module>" section. The
+// keys include and exclude are regular expressions of the metric names, and the
+// keys "include_label.<label>" and "exclude_label.<label>" regular expressions
+// of the label values. Returns nil if the section is empty
+func parse_ini_metric_filter (section *ini.Section) (*cl.Metric_filter, []error) {
+  if len(section.Keys()) == 0 {
+    return nil, nil
+  }
+  errors := []error{}
+  include := []string{}
+  exclude := []string{}
+  include_labels := make(map[string]string)
+  exclude_labels := make(map[string]string)
+  for _, key := range section.Keys() {
+    switch {
+    case key.Name() == "include":
+      include = append(include, key.String())
+    case key.Name() == "exclude":
+      exclude = append(exclude, key.String())
+    case strings.HasPrefix(key.Name(), "include_label."):
+      include_labels[strings.TrimPrefix(key.Name(), "include_label.")] = key.String()
+    case strings.HasPrefix(key.Name(), "exclude_label."):
+      exclude_labels[strings.TrimPrefix(key.Name(), "exclude_label.")] = key.String()
+    default:
+      errors = append(errors, new_config_error(section.Name() + "." + key.Name(), "Unknown key"))
+    }
+  }
+  filter, err := cl.New_metric_filter(include, exclude, include_labels, exclude_labels)
   if err != nil {
-    log.Err_msg("Can't parse port field")
-    return nil, err
+    errors = append(errors, new_config_error(section.Name(), "%s", err))
+  }
+  if len(errors) > 0 {
+    return nil, errors
+  }
+  return filter, nil
+}
+
+
+// HDFS Usage module parameters
+func parse_hdfs_usage_watched_directories (config_reader *ini.File) []string {
+  watched_directories := []string{}
+  for _, directory := range config_reader.Section("hdfs_usage").Key("watched_directories").Strings(",") {
+    if directory = strings.TrimSpace(directory); directory != "" {
+      watched_directories = append(watched_directories, directory)
+    }
+  }
+  return watched_directories
+}
+
+// Check the watched directories of the HDFS usage module. They are quoted in
+// the TSqueries, which have no escaping, so the quotes, backslashes and
+// control characters are rejected
+func check_watched_directories(field string, directories []string) []error {
+  errors := []error{}
+  for _, directory := range directories {
+    if strings.ContainsAny(directory, "\"\\") || strings.IndexFunc(directory, unicode.IsControl) >= 0 {
+      errors = append(errors, new_config_error(field, "Invalid watched directory %q. The quotes, backslashes and control characters are not allowed", directory))
+    }
   }
+  return errors
+}
 
-  // Cloudera Manager API Version
-  api_version, err := parse_api_version(cfg)
+// Replace the fields of the INI config file overridden by environment
+// variables, so they are parsed and checked like the rest. Returns the fields
+// overridden
+func apply_ini_env_overrides (config_reader *ini.File) map[string]bool {
+  env_fields := make(map[string]bool)
+  for field, value := range lookup_env_overrides() {
+    path := strings.SplitN(field, ".", 2)
+    config_reader.Section(path[0]).Key(path[1]).SetValue(value)
+    env_fields[field] = true
+  }
+  return env_fields
+}
+
+
+func parse_hdfs_usage_refresh_interval (config_reader *ini.File) (int, Config_source, error) {
+  refresh_interval, source, err := parse_ini_int(config_reader, "hdfs_usage", "refresh_interval", cl.HDFS_USAGE_DEFAULT_REFRESH_INTERVAL)
+  if err == nil && refresh_interval <= 0 {
+    return cl.HDFS_USAGE_DEFAULT_REFRESH_INTERVAL, source, new_config_error("hdfs_usage.refresh_interval", "Invalid refresh interval %d", refresh_interval)
+  }
+  return refresh_interval, source, err
+}
+
+
+// Parse a config file in INI format
+func parse_ini_config(config interface{}) (*CE_config, error) {
+  opts := ini.LoadOptions {
+    AllowBooleanKeys: true, // Config file can have boolean keys.
+  }
+  cfg, err := ini.LoadSources(opts, config)
   if err != nil {
-    log.Err_msg("Can't parse api_version field")
+    log.Err_msg("Failed reading config file: %s", err)
     return nil, err
   }
 
-  global_status_module_flag := parse_global_status_module_flag (cfg)
-  host_module_flag := parse_host_module_flag (cfg)
-  impala_module_flag := parse_impala_module_flag (cfg)
-  hdfs_module_flag := parse_hdfs_module_flag (cfg)
-  yarn_module_flag := parse_yarn_module_flag (cfg)
+  ce_config, errors := parse_ini_fields(cfg)
+  if len(errors) > 0 {
+    for _, err := range errors {
+      log.Err_msg("%s", err)
+    }
+    return nil, Config_errors(errors)
+  }
+  return ce_config, nil
+}
 
 
-  // System parameters
-  num_procs, err := parse_num_procs(cfg)
-  if err != nil && err.Error() != error_msg_no_num_procs {
-    log.Err_msg("Can't parse num_procs field")
-    return nil, err
+// Parse the fields of a config file in INI format. All the invalid fields are
+// returned instead of stopping at the first one
+func parse_ini_fields(cfg *ini.File) (*CE_config, []error) {
+  errors := []error{}
+  config := &CE_config{env_fields: apply_ini_env_overrides(cfg)}
+
+  // Cloudera Manager entrypoint, port and API version
+  host := cfg.Section("target").Key("host").String()
+  if host == "" {
+    errors = append(errors, new_config_error("target.host", "No host specified"))
   }
-  deploy_ip, err := parse_deploy_ip(cfg)
-  if err != nil && err.Error() != error_msg_no_deploy_ip{
-    log.Err_msg("Can't parse deploy_ip field")
-    return nil, err
+  port := cfg.Section("target").Key("port").String()
+  if err := check_port("target.port", port); err != nil {
+    errors = append(errors, err)
   }
-  deploy_port, err := parse_deploy_port(cfg)
-  if err != nil && err.Error() != error_msg_no_deploy_port {
-    log.Err_msg("Can't parse deploy_port field")
-    return nil, err
+  api_version, api_version_source := parse_ini_string(cfg, "target", "version", DEFAULT_API_VERSION)
+  if api_version != "" {
+    log.Warn_msg("Overwritting API Version value: %s", api_version)
   }
-  log_level, err := parse_log_level(cfg)
-  if err != nil && err.Error() != error_msg_no_log_level {
-    log.Err_msg("Can't parse log_level field")
-    return nil, err
+  config.Set_source(Target_field(host, "host"), SOURCE_FILE)
+  config.Set_source(Target_field(host, "port"), SOURCE_FILE)
+  config.Set_source(Target_field(host, "version"), api_version_source)
+
+  // Username and password
+  user_section := cfg.Section("user")
+  user, password, password_file, err := parse_credentials(
+    "user",
+    user_section.Key("username").String(),
+    user_section.Key("password").String(),
+    user_section.Key("password_file").String(),
+  )
+  if err != nil {
+    errors = append(errors, err)
+  }
+  config.Set_source(Target_field(host, "username"), credential_source(user_section.Key("username").String()))
+  config.Set_source(Target_field(host, "password"), credential_source(user_section.Key("password").String()))
+  config.Set_source(Target_field(host, "password_file"), credential_source(user_section.Key("password_file").String()))
+
+  // Modules and their settings
+  modules, module_errors := parse_ini_modules(cfg, config)
+  errors = append(errors, module_errors...)
+  refresh_interval, source, err := parse_hdfs_usage_refresh_interval(cfg)
+  if err != nil {
+    errors = append(errors, err)
+  }
+  config.Set_source("hdfs_usage.refresh_interval", source)
+  watched_directories := parse_hdfs_usage_watched_directories(cfg)
+  errors = append(errors, check_watched_directories("hdfs_usage.watched_directories", watched_directories)...)
+  custom_metrics := []*cl.Custom_metric{}
+  for _, section := range cfg.Sections() {
+    if !strings.HasPrefix(section.Name(), CUSTOM_METRIC_SECTION_PREFIX) {
+      continue
+    }
+    if metric, err := parse_custom_metric_section(section); err != nil {
+      errors = append(errors, new_config_error(section.Name(), "%s", err))
+    } else {
+      custom_metrics = append(custom_metrics, metric)
+    }
+  }
+  // Metric filters of all the modules and of each module
+  global_filter, filter_errors := parse_ini_metric_filter(cfg.Section(METRIC_FILTER_SECTION))
+  errors = append(errors, filter_errors...)
+  for _, section := range cfg.Sections() {
+    if !strings.HasPrefix(section.Name(), METRIC_FILTER_SECTION_PREFIX) {
+      continue
+    }
+    module_name := strings.TrimPrefix(section.Name(), METRIC_FILTER_SECTION_PREFIX)
+    settings, ok := modules[module_name]
+    if !ok {
+      errors = append(errors, new_config_error(section.Name(), "Unknown module %s", module_name))
+      continue
+    }
+    filter, filter_errors := parse_ini_metric_filter(section)
+    if len(filter_errors) > 0 {
+      errors = append(errors, filter_errors...)
+    } else if filter != nil {
+      settings.Options.Metric_filters = []*cl.Metric_filter{filter}
+      modules[module_name] = settings
+    }
   }
 
+  config.settings = scrapers_settings {
+    Modules: modules,
+    Node_classes: parse_node_class_rules(cfg),
+    Watched_directories: watched_directories,
+    Refresh_interval: refresh_interval,
+    Custom_metrics: custom_metrics,
+    Metric_filter: global_filter,
+  }
 
-  return &CE_config {
-    num_procs,
-    cl.Collector_connection_data {
-      host,
-      port,
-      api_version,
-      user,
-      password,
-    },
-    CE_collectors_flags{
-      map [cl.Scraper] bool {
-        cl.ScrapeStatus{}: global_status_module_flag,
-        cl.ScrapeHost{}: host_module_flag,
-        cl.ScrapeImpalaMetrics{}: impala_module_flag,
-        cl.ScrapeHDFS{}: hdfs_module_flag,
-        cl.ScrapeYARNMetrics{}: yarn_module_flag,
+  // System parameters
+  if config.Num_procs, source, err = parse_ini_int(cfg, "system", "num_procs", DEFAULT_NUM_PROCS); err != nil {
+    errors = append(errors, err)
+  }
+  config.Set_source("system.num_procs", source)
+  config.Deploy_ip, source = parse_ini_string(cfg, "system", "deploy_ip", DEFAULT_DEPLOY_IP)
+  config.Set_source("system.deploy_ip", source)
+  deploy_port, source, err := parse_ini_int(cfg, "system", "deploy_port", DEFAULT_DEPLOY_PORT)
+  if err == nil {
+    err = check_deploy_port("system.deploy_port", deploy_port)
+  }
+  if err != nil {
+    errors = append(errors, err)
+  }
+  config.Deploy_port = uint(deploy_port)
+  config.Set_source("system.deploy_port", source)
+  if config.Log_level, source, err = parse_ini_int(cfg, "system", "log_level", DEFAULT_LOG_LEVEL); err != nil {
+    errors = append(errors, err)
+  }
+  config.Set_source("system.log_level", source)
+
+  // Constant labels of the metrics
+  labels, label_errors := parse_labels(LABELS_SECTION, cfg.Section(LABELS_SECTION).KeysHash())
+  errors = append(errors, label_errors...)
+
+  config.Targets = []CE_target {
+    {
+      Name: host,
+      Labels: labels,
+      Connection: cl.Collector_connection_data {
+        Host: host,
+        Port: port,
+        Api_version: api_version,
+        User: user,
+        Passwd: password,
+        Passwd_file: password_file,
       },
     },
-  deploy_ip,
-  deploy_port,
-  log_level,
-  },
-  nil
+  }
+  errors = append(errors, check_label_collisions(config.Targets, config.settings)...)
+  // Retries and circuit breaker of the queries
+  api_client := cl.Api_client_options{}
+  if api_client.Max_retries, source, err = parse_ini_int(cfg, "api", "max_retries", cl.API_DEFAULT_MAX_RETRIES); err != nil {
+    errors = append(errors, err)
+  }
+  config.Set_source("api.max_retries", source)
+  if api_client.Initial_backoff, source, err = parse_ini_duration(cfg, "api", "initial_backoff", cl.API_DEFAULT_INITIAL_BACKOFF); err != nil {
+    errors = append(errors, err)
+  }
+  config.Set_source("api.initial_backoff", source)
+  if api_client.Max_backoff, source, err = parse_ini_duration(cfg, "api", "max_backoff", cl.API_DEFAULT_MAX_BACKOFF); err != nil {
+    errors = append(errors, err)
+  }
+  config.Set_source("api.max_backoff", source)
+  if api_client.Circuit_failures, source, err = parse_ini_int(cfg, "api", "circuit_failures", cl.API_DEFAULT_CIRCUIT_FAILURES); err != nil {
+    errors = append(errors, err)
+  }
+  config.Set_source("api.circuit_failures", source)
+  if api_client.Circuit_timeout, source, err = parse_ini_duration(cfg, "api", "circuit_timeout", cl.API_DEFAULT_CIRCUIT_TIMEOUT); err != nil {
+    errors = append(errors, err)
+  }
+  config.Set_source("api.circuit_timeout", source)
+  if api_client.Tsquery_batch_size, source, err = parse_ini_int(cfg, "api", "tsquery_batch_size", cl.API_DEFAULT_TSQUERY_BATCH_SIZE); err != nil {
+    errors = append(errors, err)
+  }
+  config.Set_source("api.tsquery_batch_size", source)
+  if api_client.Max_concurrent_queries, source, err = parse_ini_int(cfg, "api", "max_concurrent_queries", cl.API_DEFAULT_MAX_CONCURRENT_QUERIES); err != nil {
+    errors = append(errors, err)
+  }
+  config.Set_source("api.max_concurrent_queries", source)
+  if api_client.Requests_per_second, source, err = parse_ini_float(cfg, "api", "requests_per_second", cl.API_DEFAULT_REQUESTS_PER_SECOND); err != nil {
+    errors = append(errors, err)
+  }
+  config.Set_source("api.requests_per_second", source)
+  if api_client.Burst, source, err = parse_ini_int(cfg, "api", "burst", cl.API_DEFAULT_BURST); err != nil {
+    errors = append(errors, err)
+  }
+  config.Set_source("api.burst", source)
+  if api_client.Metadata_cache_ttl, source, err = parse_ini_duration(cfg, "api", "metadata_cache_ttl", cl.API_DEFAULT_METADATA_CACHE_TTL); err != nil {
+    errors = append(errors, err)
+  }
+  config.Set_source("api.metadata_cache_ttl", source)
+  if api_client.Debug_query_label, source, err = parse_ini_bool(cfg, "api", "debug_query_label", false); err != nil {
+    errors = append(errors, err)
+  }
+  config.Set_source("api.debug_query_label", source)
+  if api_client.Max_response_size, source, err = parse_ini_int(cfg, "api", "max_response_size", cl.API_DEFAULT_MAX_RESPONSE_SIZE); err != nil {
+    errors = append(errors, err)
+  }
+  config.Set_source("api.max_response_size", source)
+  errors = append(errors, set_api_client_options(config, api_client)...)
+
+  config.Scrapers = build_scrapers(config.settings)
+  return config, errors
 }
@@ -0,0 +1,333 @@
+/*
+ *
+ * title           :config_parser/config_parser_test.go
+ * description     :Tests of the INI config file parsing
+ * author		       :Alejandro Villegas
+ * date            :2019/07/05
+ * version         :1.0
+ *
+ */
+package config_parser
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "io/ioutil"
+  "os"
+  "path"
+  "reflect"
+  "strings"
+  "testing"
+  "time"
+
+  // Own Libraries
+  cl "keedio/cloudera_exporter/collector"
+  log "keedio/cloudera_exporter/logger"
+
+  // Go External libraries
+  "gopkg.in/ini.v1"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// The loggers are discarded, so the output of the tests is only theirs
+func TestMain(m *testing.M) {
+  log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, 0)
+  os.Exit(m.Run())
+}
+
+
+// Returns the INI config file with the given content
+func load_ini(t *testing.T, content string) *ini.File {
+  config_reader, err := ini.Load([]byte(content))
+  if err != nil {
+    t.Fatalf("Invalid INI content: %s", err)
+  }
+  return config_reader
+}
+
+
+func Test_parse_node_class_rules(t *testing.T) {
+  tests := []struct {
+    name string
+    content string
+    expected []cl.Node_class_rule
+  }{
+    {"no section", "", cl.Default_node_class_rules},
+    {"empty section", "[node_classes]\n", cl.Default_node_class_rules},
+    {
+      "rules in order",
+      "[node_classes]\nworker = datanode, NodeManager\nmaster = NAMENODE,,\n",
+      []cl.Node_class_rule{
+        {Class: "worker", Role_types: []string{"DATANODE", "NODEMANAGER"}},
+        {Class: "master", Role_types: []string{"NAMENODE"}},
+      },
+    },
+  }
+  for _, test := range tests {
+    if rules := parse_node_class_rules(load_ini(t, test.content)); !reflect.DeepEqual(rules, test.expected) {
+      t.Errorf("%s: parse_node_class_rules() = %v, expected %v", test.name, rules, test.expected)
+    }
+  }
+}
+
+
+// Returns the field of a config error, or "" if the error is nil
+func config_error_field(err error) string {
+  if config_error, ok := err.(*Config_error); ok {
+    return config_error.Field
+  }
+  if err != nil {
+    return "unknown"
+  }
+  return ""
+}
+
+
+func Test_expand_env_vars(t *testing.T) {
+  os.Setenv("CE_TEST_USER", "admin")
+  os.Setenv("CE_TEST_DOMAIN", "example.com")
+  os.Setenv("CE_TEST_EMPTY", "")
+  os.Unsetenv("CE_TEST_UNDEFINED")
+  defer os.Unsetenv("CE_TEST_USER")
+  defer os.Unsetenv("CE_TEST_DOMAIN")
+  defer os.Unsetenv("CE_TEST_EMPTY")
+
+  tests := []struct {
+    value string
+    expected string
+    valid bool
+  }{
+    {"plain", "plain", true},
+    {"${CE_TEST_USER}", "admin", true},
+    {"${CE_TEST_USER}@${CE_TEST_DOMAIN}", "admin@example.com", true},
+    {"x${CE_TEST_EMPTY}y", "xy", true},
+    {"$CE_TEST_USER", "$CE_TEST_USER", true},
+    {"${CE_TEST_UNDEFINED}", "", false},
+    {"${CE_TEST_USER}${CE_TEST_UNDEFINED}", "admin", false},
+  }
+  for _, test := range tests {
+    expanded, err := expand_env_vars("targets.cm.username", test.value)
+    if expanded != test.expected || (err == nil) != test.valid {
+      t.Errorf("expand_env_vars(%q) = %q, %v, expected %q, valid %v", test.value, expanded, err, test.expected, test.valid)
+    }
+    if err != nil && config_error_field(err) != "targets.cm.username" {
+      t.Errorf("expand_env_vars(%q) error field = %q, expected %q", test.value, config_error_field(err), "targets.cm.username")
+    }
+  }
+}
+
+
+func Test_credential_source(t *testing.T) {
+  tests := []struct {
+    value string
+    expected Config_source
+  }{
+    {"", SOURCE_FILE},
+    {"passwd", SOURCE_FILE},
+    {"$PASSWD", SOURCE_FILE},
+    {"${PASSWD}", SOURCE_ENV},
+    {"prefix_${PASSWD}", SOURCE_ENV},
+  }
+  for _, test := range tests {
+    if source := credential_source(test.value); source != test.expected {
+      t.Errorf("credential_source(%q) = %s, expected %s", test.value, source, test.expected)
+    }
+  }
+}
+
+
+func Test_parse_credentials(t *testing.T) {
+  directory, err := ioutil.TempDir("", "config_parser")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(directory)
+  password_file := path.Join(directory, "password")
+  empty_file := path.Join(directory, "empty")
+  if err := ioutil.WriteFile(password_file, []byte("secret\n"), 0600); err != nil {
+    t.Fatal(err)
+  }
+  if err := ioutil.WriteFile(empty_file, []byte{}, 0600); err != nil {
+    t.Fatal(err)
+  }
+  os.Setenv("CE_TEST_PASSWORD_FILE", password_file)
+  defer os.Unsetenv("CE_TEST_PASSWORD_FILE")
+  os.Unsetenv("CE_TEST_UNDEFINED")
+
+  prefix := "targets.cm"
+  tests := []struct {
+    name string
+    user string
+    password string
+    password_file string
+    error_field string
+  }{
+    {"password", "admin", "secret", "", ""},
+    {"password file", "admin", "", password_file, ""},
+    {"password file from the environment", "admin", "", "${CE_TEST_PASSWORD_FILE}", ""},
+    {"undefined user variable", "${CE_TEST_UNDEFINED}", "secret", "", prefix + ".username"},
+    {"undefined password variable", "admin", "${CE_TEST_UNDEFINED}", "", prefix + ".password"},
+    {"undefined password file variable", "admin", "", "${CE_TEST_UNDEFINED}", prefix + ".password_file"},
+    {"no user", "", "secret", "", prefix + ".username"},
+    {"no password", "admin", "", "", prefix + ".password"},
+    {"password and password file", "admin", "secret", password_file, prefix + ".password"},
+    {"missing password file", "admin", "", path.Join(directory, "missing"), prefix + ".password_file"},
+    {"empty password file", "admin", "", empty_file, prefix + ".password_file"},
+  }
+  for _, test := range tests {
+    user, password, file, err := parse_credentials(prefix, test.user, test.password, test.password_file)
+    if field := config_error_field(err); field != test.error_field {
+      t.Errorf("%s: parse_credentials() error = %v, expected field %q", test.name, err, test.error_field)
+      continue
+    }
+    if err == nil && (user != "admin" || (password == "") == (file == "")) {
+      t.Errorf("%s: parse_credentials() = %q, %q, %q", test.name, user, password, file)
+    }
+  }
+}
+
+
+func Test_check_watched_directories(t *testing.T) {
+  tests := []struct {
+    directory string
+    valid bool
+  }{
+    {"/user/hive/warehouse", true},
+    {"/data/with spaces", true},
+    {"/data/ñandú", true},
+    {"/data/a=b&c", true},
+    {`/data/"quoted"`, false},
+    {`/data/back\slash`, false},
+    {"/data/new\nline", false},
+  }
+  for _, test := range tests {
+    errors := check_watched_directories("hdfs_usage.watched_directories", []string{"/tmp", test.directory})
+    if (len(errors) == 0) != test.valid {
+      t.Errorf("check_watched_directories(%q) = %v, expected valid %v", test.directory, errors, test.valid)
+    }
+    if len(errors) > 0 && config_error_field(errors[0]) != "hdfs_usage.watched_directories" {
+      t.Errorf("check_watched_directories(%q) error field = %q", test.directory, config_error_field(errors[0]))
+    }
+  }
+}
+
+
+func Test_env_var_name(t *testing.T) {
+  tests := []struct {
+    field string
+    expected string
+  }{
+    {"api.max_retries", "CE_API_MAX_RETRIES"},
+    {"system.log_level", "CE_SYSTEM_LOG_LEVEL"},
+  }
+  for _, test := range tests {
+    if name := env_var_name(test.field); name != test.expected {
+      t.Errorf("env_var_name(%q) = %s, expected %s", test.field, name, test.expected)
+    }
+  }
+}
+
+
+// The environment variables override the fields of both formats, are checked
+// like the fields of the file and are shown as the source of the fields
+func Test_env_overrides(t *testing.T) {
+  ini_content := `
+[target]
+host = cm1
+port = 7180
+[user]
+username = user
+password = passwd
+[api]
+max_retries = 2
+burst = 5
+[system]
+log_level = 0
+`
+  yaml_content := `
+targets:
+  - name: cm1
+    host: cm1
+    port: 7180
+    username: user
+    password: passwd
+api:
+  max_retries: 2
+  burst: 5
+system:
+  log_level: 0
+`
+  parsers := map[string]func() (*CE_config, []error) {
+    "ini": func() (*CE_config, []error) { return parse_ini_fields(load_ini(t, ini_content)) },
+    "yaml": func() (*CE_config, []error) {
+      config, err := parse_yaml_config([]byte(yaml_content))
+      if err != nil {
+        return nil, err.(Config_errors)
+      }
+      return config, nil
+    },
+  }
+  env := map[string]string {
+    "CE_API_MAX_RETRIES": "7",
+    "CE_API_METADATA_CACHE_TTL": "30s",
+    "CE_API_REQUESTS_PER_SECOND": "2.5",
+    "CE_API_DEBUG_QUERY_LABEL": "true",
+    "CE_SYSTEM_DEPLOY_IP": "127.0.0.1",
+    "CE_SYSTEM_LOG_LEVEL": "",
+  }
+  for name, value := range env {
+    os.Setenv(name, value)
+    defer os.Unsetenv(name)
+  }
+
+  for format, parse := range parsers {
+    config, errors := parse()
+    if len(errors) > 0 {
+      t.Fatalf("%s: parse errors %v", format, errors)
+    }
+    if config.Api_client.Max_retries != 7 || config.Api_client.Metadata_cache_ttl != 30 * time.Second || config.Api_client.Requests_per_second != 2.5 || !config.Api_client.Debug_query_label || config.Deploy_ip != "127.0.0.1" {
+      t.Errorf("%s: environment overrides not applied: %+v, deploy_ip %s", format, config.Api_client, config.Deploy_ip)
+    }
+    if config.Api_client.Burst != 5 || config.Log_level != 0 {
+      t.Errorf("%s: fields of the file changed: burst %d, log_level %d", format, config.Api_client.Burst, config.Log_level)
+    }
+    sources := map[string]Config_source {
+      "api.max_retries": SOURCE_ENV,
+      "api.metadata_cache_ttl": SOURCE_ENV,
+      "api.requests_per_second": SOURCE_ENV,
+      "api.debug_query_label": SOURCE_ENV,
+      "system.deploy_ip": SOURCE_ENV,
+      Target_field("cm1", "requests_per_second"): SOURCE_ENV,
+      "api.burst": SOURCE_FILE,
+      "system.log_level": SOURCE_FILE,
+      "api.max_backoff": SOURCE_DEFAULT,
+    }
+    for field, expected := range sources {
+      if source := config.Get_source(field); source != expected {
+        t.Errorf("%s: Get_source(%q) = %s, expected %s", format, field, source, expected)
+      }
+    }
+    if effective := config.Format_effective_config(); !strings.Contains(effective, "  max_retries: 7  # env\n") {
+      t.Errorf("%s: Format_effective_config() without the environment source:\n%s", format, effective)
+    }
+  }
+
+  // The invalid values are reported with the field
+  os.Setenv("CE_API_MAX_RETRIES", "many")
+  for format, parse := range parsers {
+    _, errors := parse()
+    if len(errors) != 1 || config_error_field(errors[0]) != "api.max_retries" {
+      t.Errorf("%s: parse errors %v, expected an invalid api.max_retries", format, errors)
+    }
+  }
+}
@@ -0,0 +1,543 @@
+/*
+ *
+ * title           :config_parser/yaml_config_parser.go
+ * description     :Module to read and check the cloudera exporter config file in YAML format
+ * author		       :Alejandro Villegas
+ * date            :2019/07/22
+ *
+ */
+package config_parser
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "fmt"
+  "io/ioutil"
+  "path/filepath"
+  "reflect"
+  "sort"
+  "strings"
+  "time"
+
+  // Own Libraries
+  cl "keedio/cloudera_exporter/collector"
+  log "keedio/cloudera_exporter/logger"
+
+  // Go External libraries
+  "gopkg.in/yaml.v2"
+)
+
+
+
+
+/* ======================================================================
+ * Data Structs
+ * ====================================================================== */
+// Cloudera Manager to scrape
+type yaml_target struct {
+  Name string `yaml:"name"`
+  Host string `yaml:"host"`
+  Port string `yaml:"port"`
+  Version string `yaml:"version"`
+  Username string `yaml:"username"`
+  Password string `yaml:"password"`
+  Password_file string `yaml:"password_file"`
+  Labels map[string]string `yaml:"labels"`
+  // Rate limit of the queries to this target, instead of the one of the api
+  // block
+  Requests_per_second *float64 `yaml:"requests_per_second"`
+  Burst *int `yaml:"burst"`
+}
+
+// Module settings. The last block of options only applies to some modules
+type yaml_module struct {
+  Enabled bool `yaml:"enabled"`
+  Interval time.Duration `yaml:"interval"`
+  Timeout time.Duration `yaml:"timeout"`
+  Extra_labels map[string]string `yaml:"extra_labels"`
+  Metric_allowlist []string `yaml:"metric_allowlist"`
+  Metric_denylist []string `yaml:"metric_denylist"`
+  Metric_filter yaml_metric_filter `yaml:"metric_filter"`
+
+  // HDFS Usage module
+  Watched_directories []string `yaml:"watched_directories"`
+  Refresh_interval int `yaml:"refresh_interval"`
+}
+
+// Metric filter by regular expressions of the names and the label values
+type yaml_metric_filter struct {
+  Include []string `yaml:"include"`
+  Exclude []string `yaml:"exclude"`
+  Include_labels map[string]string `yaml:"include_labels"`
+  Exclude_labels map[string]string `yaml:"exclude_labels"`
+}
+
+// Host classification rule
+type yaml_node_class struct {
+  Class string `yaml:"class"`
+  Role_types []string `yaml:"role_types"`
+}
+
+// Custom TSquery metric. Labels map the label name to the timeseries attribute
+type yaml_custom_metric struct {
+  Name string `yaml:"name"`
+  Query string `yaml:"query"`
+  Help string `yaml:"help"`
+  Type string `yaml:"type"`
+  Labels map[string]string `yaml:"labels"`
+  Window time.Duration `yaml:"window"`
+  Desired_rollup string `yaml:"desired_rollup"`
+  Must_use_desired_rollup bool `yaml:"must_use_desired_rollup"`
+}
+
+// Exporter run parameters. The fields not set in the file are nil and take
+// the default value
+type yaml_system struct {
+  Num_procs *int `yaml:"num_procs"`
+  Deploy_ip *string `yaml:"deploy_ip"`
+  Deploy_port *int `yaml:"deploy_port"`
+  Log_level *int `yaml:"log_level"`
+}
+
+// Retries and circuit breaker of the queries. The fields not set in the file
+// are nil and take the default value
+type yaml_api struct {
+  Max_retries *int `yaml:"max_retries"`
+  Initial_backoff *time.Duration `yaml:"initial_backoff"`
+  Max_backoff *time.Duration `yaml:"max_backoff"`
+  Circuit_failures *int `yaml:"circuit_failures"`
+  Circuit_timeout *time.Duration `yaml:"circuit_timeout"`
+  Tsquery_batch_size *int `yaml:"tsquery_batch_size"`
+  Max_concurrent_queries *int `yaml:"max_concurrent_queries"`
+  Requests_per_second *float64 `yaml:"requests_per_second"`
+  Burst *int `yaml:"burst"`
+  Metadata_cache_ttl *time.Duration `yaml:"metadata_cache_ttl"`
+  Debug_query_label *bool `yaml:"debug_query_label"`
+  Max_response_size *int `yaml:"max_response_size"`
+}
+
+// YAML config file
+type yaml_config struct {
+  Targets []yaml_target `yaml:"targets"`
+  Labels map[string]string `yaml:"labels"`
+  Modules map[string]yaml_module `yaml:"modules"`
+  Metric_filter yaml_metric_filter `yaml:"metric_filter"`
+  Node_classes []yaml_node_class `yaml:"node_classes"`
+  Custom_metrics []yaml_custom_metric `yaml:"custom_metrics"`
+  Api yaml_api `yaml:"api"`
+  System yaml_system `yaml:"system"`
+}
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Returns true if the file name has a YAML extension
+func is_yaml_file(file_name string) bool {
+  extension := strings.ToLower(filepath.Ext(file_name))
+  return extension == ".yaml" || extension == ".yml"
+}
+
+
+// Check and convert a target of the config file. The names of the previous
+// targets are used to reject duplicated names
+func parse_yaml_target(index int, target yaml_target, names map[string]bool, common_labels map[string]string) (CE_target, []error) {
+  errors := []error{}
+  if target.Name == "" {
+    target.Name = target.Host
+  }
+  field := fmt.Sprintf("targets.%s", target.Name)
+  if target.Name == "" {
+    field = fmt.Sprintf("targets[%d]", index)
+  }
+
+  if target.Host == "" {
+    errors = append(errors, new_config_error(field + ".host", "No host specified"))
+  }
+  if names[target.Name] {
+    errors = append(errors, new_config_error(field + ".name", "Duplicated target name %s", target.Name))
+  }
+  names[target.Name] = true
+  if err := check_port(field + ".port", target.Port); err != nil {
+    errors = append(errors, err)
+  }
+  user, password, password_file, err := parse_credentials(field, target.Username, target.Password, target.Password_file)
+  if err != nil {
+    errors = append(errors, err)
+  }
+  // The labels of the target override the common ones
+  target_labels, label_errors := parse_labels(field + ".labels", target.Labels)
+  errors = append(errors, label_errors...)
+  labels := make(map[string]string)
+  for name, value := range common_labels {
+    labels[name] = value
+  }
+  for name, value := range target_labels {
+    labels[name] = value
+  }
+
+  return CE_target {
+    Name: target.Name,
+    Labels: labels,
+    Connection: cl.Collector_connection_data {
+      Host: target.Host,
+      Port: target.Port,
+      Api_version: target.Version,
+      User: user,
+      Passwd: password,
+      Passwd_file: password_file,
+    },
+  }, errors
+}
+
+
+// Check the name and the options of a module of the config file
+func check_yaml_module(module_name string, module yaml_module) error {
+  switch module_name {
+  case MODULE_STATUS, MODULE_HOST, MODULE_HDFS, MODULE_IMPALA, MODULE_YARN, MODULE_HDFS_USAGE, MODULE_REPLICATION, MODULE_CUSTOM:
+  default:
+    return new_config_error("modules." + module_name, "Unknown module")
+  }
+  if module.Interval < 0 || module.Timeout < 0 {
+    return new_config_error("modules." + module_name, "Negative interval or timeout")
+  }
+  return nil
+}
+
+
+// Check and convert the host classification rules of the config file
+func parse_yaml_node_classes(node_classes []yaml_node_class) []cl.Node_class_rule {
+  if len(node_classes) == 0 {
+    return cl.Default_node_class_rules
+  }
+  rules := []cl.Node_class_rule{}
+  for _, node_class := range node_classes {
+    role_types := []string{}
+    for _, role_type := range node_class.Role_types {
+      if role_type = strings.ToUpper(strings.TrimSpace(role_type)); role_type != "" {
+        role_types = append(role_types, role_type)
+      }
+    }
+    rules = append(rules, cl.Node_class_rule{Class: node_class.Class, Role_types: role_types})
+  }
+  return rules
+}
+
+
+// Check and convert a custom metric of the config file. The names of the
+// previous metrics are used to reject duplicated names
+func parse_yaml_custom_metric(custom_metric yaml_custom_metric, names map[string]bool) (*cl.Custom_metric, error) {
+  field := fmt.Sprintf("custom_metrics.%s", custom_metric.Name)
+  if names[custom_metric.Name] {
+    return nil, new_config_error(field, "Duplicated custom metric name")
+  }
+  names[custom_metric.Name] = true
+
+  // Sort the labels to build always the same descriptor
+  label_names := []string{}
+  for label_name := range custom_metric.Labels {
+    label_names = append(label_names, label_name)
+  }
+  sort.Strings(label_names)
+  labels := [][2]string{}
+  for _, label_name := range label_names {
+    labels = append(labels, [2]string{label_name, custom_metric.Labels[label_name]})
+  }
+  time_series := cl.Custom_metric_time_series {
+    Window: custom_metric.Window,
+    Desired_rollup: strings.ToUpper(custom_metric.Desired_rollup),
+    Must_use_desired_rollup: custom_metric.Must_use_desired_rollup,
+  }
+  metric, err := cl.New_custom_metric(custom_metric.Name, custom_metric.Help, custom_metric.Query, strings.ToLower(custom_metric.Type), labels, time_series)
+  if err != nil {
+    return nil, new_config_error(field, "%s", err)
+  }
+  return metric, nil
+}
+
+
+// Check and convert a metric filter of the config file. Returns nil if the
+// filter is empty
+func parse_yaml_metric_filter(field string, filter yaml_metric_filter) (*cl.Metric_filter, error) {
+  metric_filter, err := cl.New_metric_filter(filter.Include, filter.Exclude, filter.Include_labels, filter.Exclude_labels)
+  if err != nil {
+    return nil, new_config_error(field, "%s", err)
+  }
+  if metric_filter.Is_empty() {
+    return nil, nil
+  }
+  return metric_filter, nil
+}
+
+
+// Optional integer field of the system block. Returns the default value if
+// the field is not set
+func parse_yaml_int(value *int, default_value int) (int, Config_source) {
+  if value == nil {
+    return default_value, SOURCE_DEFAULT
+  }
+  return *value, SOURCE_FILE
+}
+
+
+// Optional duration field of the config file
+func parse_yaml_duration(value *time.Duration, default_value time.Duration) (time.Duration, Config_source) {
+  if value == nil {
+    return default_value, SOURCE_DEFAULT
+  }
+  return *value, SOURCE_FILE
+}
+
+
+// Replace the fields of the system and api blocks overridden by environment
+// variables. The values are parsed like the values of the file, so a duration
+// is written like 30s. Returns the fields overridden and the invalid values
+func apply_yaml_env_overrides(config *yaml_config) (map[string]bool, []error) {
+  env_fields := make(map[string]bool)
+  errors := []error{}
+  overrides := lookup_env_overrides()
+  blocks := map[string]reflect.Value {
+    "system": reflect.ValueOf(&config.System).Elem(),
+    "api": reflect.ValueOf(&config.Api).Elem(),
+  }
+  for _, section := range env_override_sections {
+    block := blocks[section]
+    for index := 0; index < block.NumField(); index++ {
+      field := section + "." + block.Type().Field(index).Tag.Get("yaml")
+      value, ok := overrides[field]
+      if !ok {
+        continue
+      }
+      parsed := reflect.New(block.Field(index).Type().Elem())
+      if parsed.Elem().Kind() == reflect.String {
+        parsed.Elem().SetString(value)
+      } else if err := yaml.Unmarshal([]byte(value), parsed.Interface()); err != nil {
+        errors = append(errors, new_config_error(field, "Invalid value %s of the environment variable %s", value, env_var_name(field)))
+        continue
+      }
+      block.Field(index).Set(parsed)
+      env_fields[field] = true
+    }
+  }
+  return env_fields, errors
+}
+
+
+// Read and parse a config file in YAML format
+func parse_yaml_config_file(file_name string) (*CE_config, error) {
+  content, err := ioutil.ReadFile(file_name)
+  if err != nil {
+    log.Err_msg("Failed reading config file: %s", err)
+    return nil, err
+  }
+  return parse_yaml_config(content)
+}
+
+
+// Parse a config file in YAML format. Unknown keys are rejected
+func parse_yaml_config(content []byte) (*CE_config, error) {
+  config := yaml_config{}
+  if err := yaml.UnmarshalStrict(content, &config); err != nil {
+    log.Err_msg("Failed parsing YAML config file: %s", err)
+    return nil, err
+  }
+
+  ce_config, errors := parse_yaml_fields(config)
+  if len(errors) > 0 {
+    for _, err := range errors {
+      log.Err_msg("%s", err)
+    }
+    return nil, Config_errors(errors)
+  }
+  for _, target := range ce_config.Targets {
+    if target.Connection.Api_version != "" {
+      log.Warn_msg("Overwritting API Version value of target %s: %s", target.Name, target.Connection.Api_version)
+    }
+  }
+  return ce_config, nil
+}
+
+
+// Parse the fields of a config file in YAML format. All the invalid fields are
+// returned instead of stopping at the first one
+func parse_yaml_fields(config yaml_config) (*CE_config, []error) {
+  env_fields, errors := apply_yaml_env_overrides(&config)
+  ce_config := &CE_config{env_fields: env_fields}
+
+  // Targets
+  if len(config.Targets) == 0 {
+    errors = append(errors, new_config_error("targets", "No targets specified"))
+  }
+  common_labels, label_errors := parse_labels("labels", config.Labels)
+  errors = append(errors, label_errors...)
+  target_names := make(map[string]bool)
+  for index, target := range config.Targets {
+    ce_target, target_errors := parse_yaml_target(index, target, target_names, common_labels)
+    errors = append(errors, target_errors...)
+    ce_config.Targets = append(ce_config.Targets, ce_target)
+    for _, field := range []string{"host", "port", "username", "password", "password_file"} {
+      ce_config.Set_source(Target_field(ce_target.Name, field), SOURCE_FILE)
+    }
+    ce_config.Set_source(Target_field(ce_target.Name, "username"), credential_source(target.Username))
+    ce_config.Set_source(Target_field(ce_target.Name, "password"), credential_source(target.Password))
+    ce_config.Set_source(Target_field(ce_target.Name, "password_file"), credential_source(target.Password_file))
+    if target.Version != DEFAULT_API_VERSION {
+      ce_config.Set_source(Target_field(ce_target.Name, "version"), SOURCE_FILE)
+    }
+  }
+
+  // Modules. The modules not set in the file are disabled
+  module_names := []string{}
+  for module_name := range config.Modules {
+    module_names = append(module_names, module_name)
+  }
+  sort.Strings(module_names)
+  modules := make(map[string]module_settings)
+  for module_name := range ini_module_keys {
+    modules[module_name] = module_settings{Enabled: DEFAULT_MODULE_ENABLED}
+  }
+  for _, module_name := range module_names {
+    module := config.Modules[module_name]
+    if err := check_yaml_module(module_name, module); err != nil {
+      errors = append(errors, err)
+      continue
+    }
+    extra_labels, label_errors := parse_labels("modules." + module_name + ".extra_labels", module.Extra_labels)
+    errors = append(errors, label_errors...)
+    settings := module_settings {
+      Enabled: module.Enabled,
+      Options: cl.Module_options {
+        Interval: module.Interval,
+        Timeout: module.Timeout,
+        Extra_labels: extra_labels,
+        Metric_allowlist: module.Metric_allowlist,
+        Metric_denylist: module.Metric_denylist,
+      },
+    }
+    if filter, err := parse_yaml_metric_filter("modules." + module_name + ".metric_filter", module.Metric_filter); err != nil {
+      errors = append(errors, err)
+    } else if filter != nil {
+      settings.Options.Metric_filters = []*cl.Metric_filter{filter}
+    }
+    modules[module_name] = settings
+    ce_config.Set_source("modules." + module_name, SOURCE_FILE)
+  }
+
+  // HDFS usage module settings
+  hdfs_usage := config.Modules[MODULE_HDFS_USAGE]
+  refresh_interval := hdfs_usage.Refresh_interval
+  if refresh_interval < 0 {
+    errors = append(errors, new_config_error("modules.hdfs_usage.refresh_interval", "Invalid refresh interval %d", refresh_interval))
+  }
+  if refresh_interval <= 0 {
+    refresh_interval = cl.HDFS_USAGE_DEFAULT_REFRESH_INTERVAL
+  } else {
+    ce_config.Set_source("hdfs_usage.refresh_interval", SOURCE_FILE)
+  }
+  errors = append(errors, check_watched_directories("modules.hdfs_usage.watched_directories", hdfs_usage.Watched_directories)...)
+
+  // Custom metrics
+  custom_metrics := []*cl.Custom_metric{}
+  metric_names := make(map[string]bool)
+  for _, custom_metric := range config.Custom_metrics {
+    if metric, err := parse_yaml_custom_metric(custom_metric, metric_names); err != nil {
+      errors = append(errors, err)
+    } else {
+      custom_metrics = append(custom_metrics, metric)
+    }
+  }
+
+  // Metric filter of all the modules
+  global_filter, err := parse_yaml_metric_filter("metric_filter", config.Metric_filter)
+  if err != nil {
+    errors = append(errors, err)
+  }
+
+  ce_config.settings = scrapers_settings {
+    Modules: modules,
+    Node_classes: parse_yaml_node_classes(config.Node_classes),
+    Watched_directories: hdfs_usage.Watched_directories,
+    Refresh_interval: refresh_interval,
+    Custom_metrics: custom_metrics,
+    Metric_filter: global_filter,
+  }
+  errors = append(errors, check_label_collisions(ce_config.Targets, ce_config.settings)...)
+  ce_config.Scrapers = build_scrapers(ce_config.settings)
+
+  // System parameters
+  var source Config_source
+  ce_config.Num_procs, source = parse_yaml_int(config.System.Num_procs, DEFAULT_NUM_PROCS)
+  ce_config.Set_source("system.num_procs", source)
+  ce_config.Deploy_ip = DEFAULT_DEPLOY_IP
+  ce_config.Set_source("system.deploy_ip", SOURCE_DEFAULT)
+  if config.System.Deploy_ip != nil {
+    ce_config.Deploy_ip = *config.System.Deploy_ip
+    ce_config.Set_source("system.deploy_ip", SOURCE_FILE)
+  }
+  deploy_port, source := parse_yaml_int(config.System.Deploy_port, DEFAULT_DEPLOY_PORT)
+  if err := check_deploy_port("system.deploy_port", deploy_port); err != nil {
+    errors = append(errors, err)
+  }
+  ce_config.Deploy_port = uint(deploy_port)
+  ce_config.Set_source("system.deploy_port", source)
+  ce_config.Log_level, source = parse_yaml_int(config.System.Log_level, DEFAULT_LOG_LEVEL)
+  ce_config.Set_source("system.log_level", source)
+
+  // Retries and circuit breaker of the queries
+  api_client := cl.Api_client_options{}
+  api_client.Max_retries, source = parse_yaml_int(config.Api.Max_retries, cl.API_DEFAULT_MAX_RETRIES)
+  ce_config.Set_source("api.max_retries", source)
+  api_client.Initial_backoff, source = parse_yaml_duration(config.Api.Initial_backoff, cl.API_DEFAULT_INITIAL_BACKOFF)
+  ce_config.Set_source("api.initial_backoff", source)
+  api_client.Max_backoff, source = parse_yaml_duration(config.Api.Max_backoff, cl.API_DEFAULT_MAX_BACKOFF)
+  ce_config.Set_source("api.max_backoff", source)
+  api_client.Circuit_failures, source = parse_yaml_int(config.Api.Circuit_failures, cl.API_DEFAULT_CIRCUIT_FAILURES)
+  ce_config.Set_source("api.circuit_failures", source)
+  api_client.Circuit_timeout, source = parse_yaml_duration(config.Api.Circuit_timeout, cl.API_DEFAULT_CIRCUIT_TIMEOUT)
+  ce_config.Set_source("api.circuit_timeout", source)
+  api_client.Tsquery_batch_size, source = parse_yaml_int(config.Api.Tsquery_batch_size, cl.API_DEFAULT_TSQUERY_BATCH_SIZE)
+  ce_config.Set_source("api.tsquery_batch_size", source)
+  api_client.Max_concurrent_queries, source = parse_yaml_int(config.Api.Max_concurrent_queries, cl.API_DEFAULT_MAX_CONCURRENT_QUERIES)
+  ce_config.Set_source("api.max_concurrent_queries", source)
+  api_client.Requests_per_second = cl.API_DEFAULT_REQUESTS_PER_SECOND
+  ce_config.Set_source("api.requests_per_second", SOURCE_DEFAULT)
+  if config.Api.Requests_per_second != nil {
+    api_client.Requests_per_second = *config.Api.Requests_per_second
+    ce_config.Set_source("api.requests_per_second", SOURCE_FILE)
+  }
+  api_client.Burst, source = parse_yaml_int(config.Api.Burst, cl.API_DEFAULT_BURST)
+  ce_config.Set_source("api.burst", source)
+  api_client.Metadata_cache_ttl, source = parse_yaml_duration(config.Api.Metadata_cache_ttl, cl.API_DEFAULT_METADATA_CACHE_TTL)
+  ce_config.Set_source("api.metadata_cache_ttl", source)
+  ce_config.Set_source("api.debug_query_label", SOURCE_DEFAULT)
+  if config.Api.Debug_query_label != nil {
+    api_client.Debug_query_label = *config.Api.Debug_query_label
+    ce_config.Set_source("api.debug_query_label", SOURCE_FILE)
+  }
+  api_client.Max_response_size, source = parse_yaml_int(config.Api.Max_response_size, cl.API_DEFAULT_MAX_RESPONSE_SIZE)
+  ce_config.Set_source("api.max_response_size", source)
+  errors = append(errors, set_api_client_options(ce_config, api_client)...)
+
+  // Rate limit of each target
+  for index, target := range config.Targets {
+    if index >= len(ce_config.Targets) || (target.Requests_per_second == nil && target.Burst == nil) {
+      continue
+    }
+    ce_target := &ce_config.Targets[index]
+    if target.Requests_per_second != nil {
+      ce_target.Connection.Client.Requests_per_second = *target.Requests_per_second
+      ce_config.Set_source(Target_field(ce_target.Name, "requests_per_second"), SOURCE_FILE)
+    }
+    if target.Burst != nil {
+      ce_target.Connection.Client.Burst = *target.Burst
+      ce_config.Set_source(Target_field(ce_target.Name, "burst"), SOURCE_FILE)
+    }
+    errors = append(errors, check_rate_limit("targets." + ce_target.Name, ce_target.Connection.Client.Requests_per_second, ce_target.Connection.Client.Burst)...)
+  }
+  return ce_config, errors
+}
@@ -0,0 +1,119 @@
+/*
+ *
+ * title           :config_parser/yaml_config_parser_test.go
+ * description     :Tests of the YAML config file parsing
+ * author		       :Alejandro Villegas
+ * date            :2019/07/05
+ * version         :1.0
+ *
+ */
+package config_parser
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "reflect"
+  "strings"
+  "testing"
+
+  // Own Libraries
+  cl "keedio/cloudera_exporter/collector"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+func Test_parse_yaml_node_classes(t *testing.T) {
+  tests := []struct {
+    name string
+    node_classes []yaml_node_class
+    expected []cl.Node_class_rule
+  }{
+    {"no classes", nil, cl.Default_node_class_rules},
+    {
+      "classes in order",
+      []yaml_node_class{
+        {Class: "worker", Role_types: []string{" datanode", "NodeManager", ""}},
+        {Class: "master", Role_types: []string{"NAMENODE"}},
+      },
+      []cl.Node_class_rule{
+        {Class: "worker", Role_types: []string{"DATANODE", "NODEMANAGER"}},
+        {Class: "master", Role_types: []string{"NAMENODE"}},
+      },
+    },
+  }
+  for _, test := range tests {
+    if rules := parse_yaml_node_classes(test.node_classes); !reflect.DeepEqual(rules, test.expected) {
+      t.Errorf("%s: parse_yaml_node_classes() = %v, expected %v", test.name, rules, test.expected)
+    }
+  }
+}
+
+
+// Returns the fields of the config errors of the YAML config file
+func yaml_config_error_fields(t *testing.T, content string) []string {
+  _, err := parse_yaml_config([]byte(content))
+  if err == nil {
+    return nil
+  }
+  errors, ok := err.(Config_errors)
+  if !ok {
+    t.Fatalf("parse_yaml_config() = %v, expected config errors", err)
+  }
+  fields := []string{}
+  for _, err := range errors {
+    config_error, ok := err.(*Config_error)
+    if !ok {
+      t.Fatalf("parse_yaml_config() error %v is not a config error", err)
+    }
+    fields = append(fields, config_error.Field)
+  }
+  return fields
+}
+
+
+func Test_parse_yaml_config_labels(t *testing.T) {
+  base := `
+targets:
+  - name: cm1
+    host: cm1.example.com
+    port: 7180
+    username: user
+    password: passwd
+    labels:
+      environment: production
+custom_metrics:
+  - name: yarn_apps_running
+    query: SELECT LAST(apps_running_cumulative) WHERE category=YARN_POOL
+    labels:
+      pool: poolName
+`
+  tests := []struct {
+    name string
+    content string
+    expected []string
+  }{
+    {"valid extra labels", base + "modules:\n  host:\n    enabled: true\n    extra_labels:\n      team: data\n", []string{}},
+    {"invalid extra label name", base + "modules:\n  host:\n    extra_labels:\n      team-name: data\n", []string{"modules.host.extra_labels.team-name"}},
+    {"reserved extra label name", base + "modules:\n  host:\n    extra_labels:\n      __team: data\n", []string{"modules.host.extra_labels.__team"}},
+    {"extra label of the metrics", base + "modules:\n  host:\n    extra_labels:\n      hostname: data\n", []string{"modules.host.extra_labels.hostname"}},
+    {"extra label of the target", base + "modules:\n  host:\n    extra_labels:\n      environment: data\n", []string{"modules.host.extra_labels.environment"}},
+    {"extra label of the custom metrics", base + "modules:\n  custom:\n    extra_labels:\n      pool: data\n", []string{"modules.custom.extra_labels.pool"}},
+    {"custom metric label in other module", base + "modules:\n  host:\n    extra_labels:\n      pool: data\n", []string{}},
+    {"common label of the custom metrics", base + "labels:\n  pool: data\n", []string{"targets.cm1.labels.pool"}},
+  }
+  for _, test := range tests {
+    fields := yaml_config_error_fields(t, test.content)
+    if strings.Join(fields, ",") != strings.Join(test.expected, ",") {
+      t.Errorf("%s: errors in %v, expected %v", test.name, fields, test.expected)
+    }
+  }
+}
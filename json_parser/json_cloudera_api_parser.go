@@ -15,10 +15,6 @@ package json_parser
  * Dependencies
  */
 import (
-  // Go Default libraries
-  "fmt"
-  "strings"
-
   // Go JSON parsing libraries
 	"github.com/tidwall/gjson"
 )
@@ -39,13 +35,3 @@ func Get_json_field(json gjson.Result, item string) string {
 func Get_json_array(json gjson.Result, item string) []gjson.Result {
   return json.Get(item).Array()
 }
-
-// Compose the complete URL connection to the Cloudera API in HTTP format 
-func Encode_tsquery_to_http(tsquery string) string {
-  return fmt.Sprintf("query=%s", Encode_http_symbols(tsquery))
-}
-
-// Find and replace special symbols to the HTTP encoding format
-func Encode_http_symbols(s string) string {
-  return strings.Replace(s, " ", "+", -1)
-}
@@ -23,14 +23,6 @@ import (
   "github.com/tidwall/gjson"
 )
 
-// Base string to the Cloudera URL TimeSeries Query API
-const TIMESERIES_API_BASE_URL="http://%s:%s/api/%s/timeseries?%s"
-
-// Compose the URL connection to the Cloudera API TimeSeries Query
-func Build_timeseries_api_query_url(host string, port string, timeseries_version string, query string) string {
-  return fmt.Sprintf(TIMESERIES_API_BASE_URL, host, port, timeseries_version, query)
-}
-
 // Return the host_id metadata parameter from a TimeSeries Query
 func Get_timeseries_query_host_id(json_timeseries gjson.Result, serie_index int) string {
   return Get_json_field(json_timeseries, fmt.Sprintf("items.0.timeSeries.%d.metadata.attributes.hostId", serie_index))
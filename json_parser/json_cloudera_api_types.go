@@ -0,0 +1,520 @@
+/*
+ *
+ * title           :json_cloudera_api_types.go
+ * description     :Typed objects of the Cloudera Manager API and their streaming decoders
+ * author		       :Alejandro Villegas Lopez (avillegas@keedio.com)
+ * date            :2019/08/06
+ * version         :1.0
+ * notes           :Submodule
+ *
+ */
+package json_parser
+
+/*
+ * Dependencies
+ */
+import (
+  // Go Default libraries
+  "encoding/json"
+  "fmt"
+  "io"
+)
+
+// Reference to a host, in the roles
+type Api_host_ref struct {
+  Host_id string `json:"hostId"`
+}
+
+// Reference to a cluster, in the hosts
+type Api_cluster_ref struct {
+  Cluster_name string `json:"clusterName"`
+}
+
+// Reference to a role config group, in the roles
+type Api_role_config_group_ref struct {
+  Role_config_group_name string `json:"roleConfigGroupName"`
+}
+
+// Cluster of the clusters endpoint (ApiCluster)
+type Api_cluster struct {
+  Name string `json:"name"`
+  Display_name string `json:"displayName"`
+  Full_version string `json:"fullVersion"`
+  Entity_status string `json:"entityStatus"`
+  Maintenance_mode bool `json:"maintenanceMode"`
+}
+
+// Host of the hosts endpoint (ApiHost)
+type Api_host struct {
+  Host_id string `json:"hostId"`
+  Hostname string `json:"hostname"`
+  Ip_address string `json:"ipAddress"`
+  Rack_id string `json:"rackId"`
+  Commission_state string `json:"commissionState"`
+  Maintenance_mode bool `json:"maintenanceMode"`
+  Health_summary string `json:"healthSummary"`
+  Cluster_ref Api_cluster_ref `json:"clusterRef"`
+}
+
+// Role of the roles endpoint of a service (ApiRole)
+type Api_role struct {
+  Name string `json:"name"`
+  Type string `json:"type"`
+  Host_ref Api_host_ref `json:"hostRef"`
+  Role_state string `json:"roleState"`
+  Health_summary string `json:"healthSummary"`
+  Ha_status string `json:"haStatus"`
+  Role_config_group_ref Api_role_config_group_ref `json:"roleConfigGroupRef"`
+}
+
+// Host template of a cluster (ApiHostTemplate)
+type Api_host_template struct {
+  Name string `json:"name"`
+  Role_config_group_refs []Api_role_config_group_ref `json:"roleConfigGroupRefs"`
+}
+
+// Service of the services endpoint of a cluster (ApiService)
+type Api_service struct {
+  Name string `json:"name"`
+  Type string `json:"type"`
+  Service_state string `json:"serviceState"`
+  Health_summary string `json:"healthSummary"`
+}
+
+// Reference to a role, in the nameservices
+type Api_role_ref struct {
+  Role_name string `json:"roleName"`
+}
+
+// Nameservice of an HDFS service (ApiNameservice), with its active and
+// standby NameNodes
+type Api_nameservice struct {
+  Name string `json:"name"`
+  Active Api_role_ref `json:"active"`
+  Stand_by Api_role_ref `json:"standBy"`
+}
+
+// Snapshot policy of a service (ApiSnapshotPolicy). The snapshots retained
+// of each schedule are added up as its retention
+type Api_snapshot_policy struct {
+  Name string `json:"name"`
+  Paused bool `json:"paused"`
+  Hdfs_arguments Api_hdfs_snapshot_policy_arguments `json:"hdfsArguments"`
+  Minute_snapshots float64 `json:"minuteSnapshots"`
+  Hourly_snapshots float64 `json:"hourlySnapshots"`
+  Daily_snapshots float64 `json:"dailySnapshots"`
+  Weekly_snapshots float64 `json:"weeklySnapshots"`
+  Monthly_snapshots float64 `json:"monthlySnapshots"`
+  Yearly_snapshots float64 `json:"yearlySnapshots"`
+}
+
+// HDFS arguments of a snapshot policy
+type Api_hdfs_snapshot_policy_arguments struct {
+  Path_patterns []string `json:"pathPatterns"`
+}
+
+// Command of the history of a snapshot policy (ApiSnapshotCommand). The id is
+// a number
+type Api_snapshot_command struct {
+  Id json.Number `json:"id"`
+  Hdfs_result Api_hdfs_snapshot_result `json:"hdfsResult"`
+}
+
+// Snapshots created and deleted by a snapshot command, and its errors
+type Api_hdfs_snapshot_result struct {
+  Created_snapshots []Api_hdfs_snapshot `json:"createdSnapshots"`
+  Deleted_snapshots []Api_hdfs_snapshot `json:"deletedSnapshots"`
+  Creation_errors []Api_hdfs_snapshot_error `json:"creationErrors"`
+  Deletion_errors []Api_hdfs_snapshot_error `json:"deletionErrors"`
+}
+
+// Snapshot of an HDFS path
+type Api_hdfs_snapshot struct {
+  Path string `json:"path"`
+  Snapshot_name string `json:"snapshotName"`
+  Creation_time string `json:"creationTime"`
+}
+
+// Error of the creation or deletion of a snapshot of an HDFS path
+type Api_hdfs_snapshot_error struct {
+  Path string `json:"path"`
+  Snapshot_name string `json:"snapshotName"`
+  Error string `json:"error"`
+}
+
+// Replication schedule of a service (ApiReplicationSchedule). The id is a
+// number
+type Api_replication_schedule struct {
+  Id json.Number `json:"id"`
+  Paused bool `json:"paused"`
+  Next_run string `json:"nextRun"`
+}
+
+// Run of a replication schedule (ApiReplicationCommand). HDFS replications
+// report the data copied in the HDFS result and Hive ones in the data
+// replication result of the Hive result
+type Api_replication_command struct {
+  Active bool `json:"active"`
+  Success bool `json:"success"`
+  Start_time string `json:"startTime"`
+  End_time string `json:"endTime"`
+  Hdfs_result *Api_hdfs_replication_result `json:"hdfsResult"`
+  Hive_result *Api_hive_replication_result `json:"hiveResult"`
+}
+
+// Data copied by an HDFS replication
+type Api_hdfs_replication_result struct {
+  Num_bytes_copied float64 `json:"numBytesCopied"`
+  Num_files_copied float64 `json:"numFilesCopied"`
+}
+
+// Result of a Hive replication
+type Api_hive_replication_result struct {
+  Data_replication_result *Api_hdfs_replication_result `json:"dataReplicationResult"`
+}
+
+// Usage of an HDFS service by a user in a day (ApiHdfsUsageReportRow)
+type Api_hdfs_usage_report_row struct {
+  Date string `json:"date"`
+  User string `json:"user"`
+  Size float64 `json:"size"`
+  Raw_size float64 `json:"rawSize"`
+  Num_files float64 `json:"numFiles"`
+}
+
+// Event of the events endpoint (ApiEvent). The attributes have a list of
+// values each
+type Api_event struct {
+  Id string `json:"id"`
+  Content string `json:"content"`
+  Time_occurred string `json:"timeOccurred"`
+  Time_received string `json:"timeReceived"`
+  Category string `json:"category"`
+  Severity string `json:"severity"`
+  Alert bool `json:"alert"`
+  Attributes []Api_event_attribute `json:"attributes"`
+}
+
+// Attribute of an event
+type Api_event_attribute struct {
+  Name string `json:"name"`
+  Values []string `json:"values"`
+}
+
+// Version of the Cloudera Manager (ApiVersionInfo)
+type Api_version_info struct {
+  Version string `json:"version"`
+  Build_user string `json:"buildUser"`
+  Build_timestamp string `json:"buildTimestamp"`
+  Git_hash string `json:"gitHash"`
+  Snapshot bool `json:"snapshot"`
+}
+
+// Point of a time series
+type Api_time_series_data struct {
+  Timestamp string `json:"timestamp"`
+  Value float64 `json:"value"`
+  Type string `json:"type"`
+}
+
+// Metadata of a time series. The attributes are the ones of the entity, like
+// hostId, hostname, entityName or clusterName. They are kept raw, as a few of
+// them are not strings
+type Api_time_series_metadata struct {
+  Metric_name string `json:"metricName"`
+  Entity_name string `json:"entityName"`
+  Attributes map[string]json.RawMessage `json:"attributes"`
+}
+
+// Time series of an entity
+type Api_time_series struct {
+  Metadata Api_time_series_metadata `json:"metadata"`
+  Data []Api_time_series_data `json:"data"`
+}
+
+// Response of a statement of a TSquery (ApiTimeSeriesResponse)
+type Api_time_series_response struct {
+  Time_series []Api_time_series `json:"timeSeries"`
+  Warnings []string `json:"warnings"`
+  Time_series_query string `json:"timeSeriesQuery"`
+}
+
+// Return a metadata attribute of a time series, or "" if it's not set
+func (serie *Api_time_series) Get_attribute(attribute string) string {
+  raw, ok := serie.Metadata.Attributes[attribute]
+  if !ok {
+    return ""
+  }
+  var value string
+  if err := json.Unmarshal(raw, &value); err == nil {
+    return value
+  }
+  return string(raw)
+}
+
+// Return the value of the first point of a time series, the only one of the
+// LAST statements, or an error if it has no data
+func (serie *Api_time_series) Get_value() (float64, error) {
+  if len(serie.Data) == 0 {
+    return 0, fmt.Errorf("No data in the time series of %s", serie.Metadata.Entity_name)
+  }
+  return serie.Data[0].Value, nil
+}
+
+// Return the value of the last point of a time series, the newest one of a
+// time window, or an error if it has no data
+func (serie *Api_time_series) Get_last_value() (float64, error) {
+  if len(serie.Data) == 0 {
+    return 0, fmt.Errorf("No data in the time series of %s", serie.Metadata.Entity_name)
+  }
+  return serie.Data[len(serie.Data) - 1].Value, nil
+}
+
+// Return the snapshots retained by a snapshot policy, adding up all its
+// schedules
+func (policy *Api_snapshot_policy) Get_retention() float64 {
+  return policy.Minute_snapshots + policy.Hourly_snapshots + policy.Daily_snapshots + policy.Weekly_snapshots + policy.Monthly_snapshots + policy.Yearly_snapshots
+}
+
+// Return the data copied by a replication run, of the HDFS result or of the
+// data replication result of a Hive replication, or nil if it has none
+func (command *Api_replication_command) Get_data_result() *Api_hdfs_replication_result {
+  if command.Hdfs_result != nil {
+    return command.Hdfs_result
+  }
+  if command.Hive_result != nil {
+    return command.Hive_result.Data_replication_result
+  }
+  return nil
+}
+
+// Decode the items of a list response of the Cloudera API, like
+// {"items": [...]}, one by one from the reader. Each item is decoded by the
+// function, so the response is never held as a generic tree
+func Decode_api_items(reader io.Reader, decode_item func(decoder *json.Decoder) error) error {
+  decoder := json.NewDecoder(reader)
+  if err := expect_json_delim(decoder, '{'); err != nil {
+    return err
+  }
+  for decoder.More() {
+    token, err := decoder.Token()
+    if err != nil {
+      return err
+    }
+    if token != "items" {
+      // Other fields of the response are skipped
+      var skipped json.RawMessage
+      if err := decoder.Decode(&skipped); err != nil {
+        return err
+      }
+      continue
+    }
+    if err := expect_json_delim(decoder, '['); err != nil {
+      return err
+    }
+    for decoder.More() {
+      if err := decode_item(decoder); err != nil {
+        return err
+      }
+    }
+    if err := expect_json_delim(decoder, ']'); err != nil {
+      return err
+    }
+  }
+  return expect_json_delim(decoder, '}')
+}
+
+// Read the next token of the decoder and check it's the delimiter
+func expect_json_delim(decoder *json.Decoder, delim json.Delim) error {
+  token, err := decoder.Token()
+  if err != nil {
+    return err
+  }
+  if token != delim {
+    return fmt.Errorf("Invalid Cloudera API response: expected %s and got %v", delim, token)
+  }
+  return nil
+}
+
+// Decode the clusters of a clusters response
+func Decode_api_clusters(reader io.Reader) ([]Api_cluster, error) {
+  clusters := []Api_cluster{}
+  err := Decode_api_items(reader, func(decoder *json.Decoder) error {
+    var cluster Api_cluster
+    if err := decoder.Decode(&cluster); err != nil {
+      return err
+    }
+    clusters = append(clusters, cluster)
+    return nil
+  })
+  return clusters, err
+}
+
+// Decode the hosts of a hosts response
+func Decode_api_hosts(reader io.Reader) ([]Api_host, error) {
+  hosts := []Api_host{}
+  err := Decode_api_items(reader, func(decoder *json.Decoder) error {
+    var host Api_host
+    if err := decoder.Decode(&host); err != nil {
+      return err
+    }
+    hosts = append(hosts, host)
+    return nil
+  })
+  return hosts, err
+}
+
+// Decode the roles of a roles response
+func Decode_api_roles(reader io.Reader) ([]Api_role, error) {
+  roles := []Api_role{}
+  err := Decode_api_items(reader, func(decoder *json.Decoder) error {
+    var role Api_role
+    if err := decoder.Decode(&role); err != nil {
+      return err
+    }
+    roles = append(roles, role)
+    return nil
+  })
+  return roles, err
+}
+
+// Decode the services of a services response
+func Decode_api_services(reader io.Reader) ([]Api_service, error) {
+  services := []Api_service{}
+  err := Decode_api_items(reader, func(decoder *json.Decoder) error {
+    var service Api_service
+    if err := decoder.Decode(&service); err != nil {
+      return err
+    }
+    services = append(services, service)
+    return nil
+  })
+  return services, err
+}
+
+// Decode the host templates of a host templates response
+func Decode_api_host_templates(reader io.Reader) ([]Api_host_template, error) {
+  templates := []Api_host_template{}
+  err := Decode_api_items(reader, func(decoder *json.Decoder) error {
+    var template Api_host_template
+    if err := decoder.Decode(&template); err != nil {
+      return err
+    }
+    templates = append(templates, template)
+    return nil
+  })
+  return templates, err
+}
+
+// Decode the nameservices of a nameservices response
+func Decode_api_nameservices(reader io.Reader) ([]Api_nameservice, error) {
+  nameservices := []Api_nameservice{}
+  err := Decode_api_items(reader, func(decoder *json.Decoder) error {
+    var nameservice Api_nameservice
+    if err := decoder.Decode(&nameservice); err != nil {
+      return err
+    }
+    nameservices = append(nameservices, nameservice)
+    return nil
+  })
+  return nameservices, err
+}
+
+// Decode the snapshot policies of a snapshot policies response
+func Decode_api_snapshot_policies(reader io.Reader) ([]Api_snapshot_policy, error) {
+  snapshot_policies := []Api_snapshot_policy{}
+  err := Decode_api_items(reader, func(decoder *json.Decoder) error {
+    var policy Api_snapshot_policy
+    if err := decoder.Decode(&policy); err != nil {
+      return err
+    }
+    snapshot_policies = append(snapshot_policies, policy)
+    return nil
+  })
+  return snapshot_policies, err
+}
+
+// Decode the snapshot commands of a snapshot history response
+func Decode_api_snapshot_commands(reader io.Reader) ([]Api_snapshot_command, error) {
+  snapshot_commands := []Api_snapshot_command{}
+  err := Decode_api_items(reader, func(decoder *json.Decoder) error {
+    var command Api_snapshot_command
+    if err := decoder.Decode(&command); err != nil {
+      return err
+    }
+    snapshot_commands = append(snapshot_commands, command)
+    return nil
+  })
+  return snapshot_commands, err
+}
+
+// Decode the replication schedules of a replication schedules response
+func Decode_api_replication_schedules(reader io.Reader) ([]Api_replication_schedule, error) {
+  replication_schedules := []Api_replication_schedule{}
+  err := Decode_api_items(reader, func(decoder *json.Decoder) error {
+    var schedule Api_replication_schedule
+    if err := decoder.Decode(&schedule); err != nil {
+      return err
+    }
+    replication_schedules = append(replication_schedules, schedule)
+    return nil
+  })
+  return replication_schedules, err
+}
+
+// Decode the replication commands of a replication history response
+func Decode_api_replication_commands(reader io.Reader) ([]Api_replication_command, error) {
+  replication_commands := []Api_replication_command{}
+  err := Decode_api_items(reader, func(decoder *json.Decoder) error {
+    var command Api_replication_command
+    if err := decoder.Decode(&command); err != nil {
+      return err
+    }
+    replication_commands = append(replication_commands, command)
+    return nil
+  })
+  return replication_commands, err
+}
+
+// Decode the rows of an HDFS usage report
+func Decode_api_hdfs_usage_report(reader io.Reader) ([]Api_hdfs_usage_report_row, error) {
+  rows := []Api_hdfs_usage_report_row{}
+  err := Decode_api_items(reader, func(decoder *json.Decoder) error {
+    var row Api_hdfs_usage_report_row
+    if err := decoder.Decode(&row); err != nil {
+      return err
+    }
+    rows = append(rows, row)
+    return nil
+  })
+  return rows, err
+}
+
+// Decode the events of an events response
+func Decode_api_events(reader io.Reader) ([]Api_event, error) {
+  events := []Api_event{}
+  err := Decode_api_items(reader, func(decoder *json.Decoder) error {
+    var event Api_event
+    if err := decoder.Decode(&event); err != nil {
+      return err
+    }
+    events = append(events, event)
+    return nil
+  })
+  return events, err
+}
+
+// Decode the responses of the statements of a TSquery, in the order of the
+// statements
+func Decode_timeseries_responses(reader io.Reader) ([]Api_time_series_response, error) {
+  responses := []Api_time_series_response{}
+  err := Decode_api_items(reader, func(decoder *json.Decoder) error {
+    var response Api_time_series_response
+    if err := decoder.Decode(&response); err != nil {
+      return err
+    }
+    responses = append(responses, response)
+    return nil
+  })
+  return responses, err
+}
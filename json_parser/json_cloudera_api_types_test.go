@@ -0,0 +1,216 @@
+/*
+ *
+ * title           :json_parser/json_cloudera_api_types_test.go
+ * description     :Tests and benchmarks of the typed decoding of the Cloudera API responses
+ * author		       :Alejandro Villegas
+ * date            :2019/08/07
+ * version         :1.0
+ *
+ */
+package json_parser
+
+
+
+
+/* ======================================================================
+ * Dependencies and libraries
+ * ====================================================================== */
+import (
+  // Go Default libraries
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "reflect"
+  "strings"
+  "testing"
+)
+
+
+
+
+/* ======================================================================
+ * Functions
+ * ====================================================================== */
+// Returns a hosts response with the number of hosts
+func test_hosts_response(num_hosts int) string {
+  hosts := make([]string, num_hosts)
+  for index := range hosts {
+    hosts[index] = fmt.Sprintf(`{"hostId": "h%d", "hostname": "host%d", "ipAddress": "10.0.0.%d", "rackId": "/default", "commissionState": "COMMISSIONED", "maintenanceMode": false, "healthSummary": "GOOD", "clusterRef": {"clusterName": "cluster1"}}`, index, index, index % 256)
+  }
+  return `{"items": [` + strings.Join(hosts, ",") + `]}`
+}
+
+
+func Test_Decode_api_items(t *testing.T) {
+  tests := []struct {
+    name string
+    response string
+    expected []string
+    valid bool
+  }{
+    {"items", `{"items": [{"name": "a"}, {"name": "b"}]}`, []string{"a", "b"}, true},
+    {"other fields skipped", `{"totalResults": 2, "warnings": ["x"], "items": [{"name": "a"}], "extra": {"items": []}}`, []string{"a"}, true},
+    {"empty items", `{"items": []}`, []string{}, true},
+    {"without items", `{}`, []string{}, true},
+    {"not an object", `[{"name": "a"}]`, []string{}, false},
+    {"items not a list", `{"items": {"name": "a"}}`, []string{}, false},
+    {"truncated", `{"items": [{"name": "a"}, {"na`, []string{"a"}, false},
+    {"invalid item", `{"items": [{"name": 1}]}`, []string{}, false},
+    {"empty response", ``, []string{}, false},
+  }
+  for _, test := range tests {
+    names := []string{}
+    err := Decode_api_items(strings.NewReader(test.response), func(decoder *json.Decoder) error {
+      var item struct { Name string `json:"name"` }
+      if err := decoder.Decode(&item); err != nil {
+        return err
+      }
+      names = append(names, item.Name)
+      return nil
+    })
+    if (err == nil) != test.valid {
+      t.Errorf("%s: Decode_api_items() = %v, expected valid %v", test.name, err, test.valid)
+    }
+    if !reflect.DeepEqual(names, test.expected) {
+      t.Errorf("%s: Decode_api_items() decoded %v, expected %v", test.name, names, test.expected)
+    }
+  }
+}
+
+
+func Test_Decode_api_hosts(t *testing.T) {
+  hosts, err := Decode_api_hosts(strings.NewReader(test_hosts_response(2)))
+  expected := []Api_host{
+    {Host_id: "h0", Hostname: "host0", Ip_address: "10.0.0.0", Rack_id: "/default", Commission_state: "COMMISSIONED", Health_summary: "GOOD", Cluster_ref: Api_cluster_ref{Cluster_name: "cluster1"}},
+    {Host_id: "h1", Hostname: "host1", Ip_address: "10.0.0.1", Rack_id: "/default", Commission_state: "COMMISSIONED", Health_summary: "GOOD", Cluster_ref: Api_cluster_ref{Cluster_name: "cluster1"}},
+  }
+  if err != nil || !reflect.DeepEqual(hosts, expected) {
+    t.Errorf("Decode_api_hosts() = %+v, %v, expected %+v", hosts, err, expected)
+  }
+}
+
+
+func Test_Decode_api_roles(t *testing.T) {
+  response := `{"items": [{"name": "hdfs-NAMENODE-1", "type": "NAMENODE", "hostRef": {"hostId": "h1"}, "roleState": "STARTED", "healthSummary": "GOOD", "haStatus": "ACTIVE", "roleConfigGroupRef": {"roleConfigGroupName": "hdfs-NAMENODE-BASE"}, "serviceRef": {"serviceName": "hdfs"}}]}`
+  roles, err := Decode_api_roles(strings.NewReader(response))
+  expected := []Api_role{{
+    Name: "hdfs-NAMENODE-1",
+    Type: "NAMENODE",
+    Host_ref: Api_host_ref{Host_id: "h1"},
+    Role_state: "STARTED",
+    Health_summary: "GOOD",
+    Ha_status: "ACTIVE",
+    Role_config_group_ref: Api_role_config_group_ref{Role_config_group_name: "hdfs-NAMENODE-BASE"},
+  }}
+  if err != nil || !reflect.DeepEqual(roles, expected) {
+    t.Errorf("Decode_api_roles() = %+v, %v, expected %+v", roles, err, expected)
+  }
+}
+
+
+func Test_Decode_api_services(t *testing.T) {
+  response := `{"items": [{"name": "hdfs", "type": "HDFS", "serviceState": "STARTED", "healthSummary": "CONCERNING"}, {"name": "hive", "type": "HIVE"}]}`
+  services, err := Decode_api_services(strings.NewReader(response))
+  expected := []Api_service{
+    {Name: "hdfs", Type: "HDFS", Service_state: "STARTED", Health_summary: "CONCERNING"},
+    {Name: "hive", Type: "HIVE"},
+  }
+  if err != nil || !reflect.DeepEqual(services, expected) {
+    t.Errorf("Decode_api_services() = %+v, %v, expected %+v", services, err, expected)
+  }
+}
+
+
+func Test_Decode_timeseries_responses(t *testing.T) {
+  response := `{"items": [
+    {"timeSeries": [{"metadata": {"metricName": "cpu_percent", "entityName": "host1", "attributes": {"hostname": "host1", "clusterName": "cluster1", "rackId": null, "numCores": 8}},
+      "data": [{"timestamp": "2019-08-07T08:00:00.000Z", "value": 10.5, "type": "SAMPLE"}, {"timestamp": "2019-08-07T08:01:00.000Z", "value": 12, "type": "SAMPLE"}]}],
+     "warnings": [], "timeSeriesQuery": "SELECT cpu_percent"},
+    {"timeSeries": [{"metadata": {"entityName": "host2"}, "data": []}], "warnings": ["No data"], "timeSeriesQuery": "SELECT x"}]}`
+  responses, err := Decode_timeseries_responses(strings.NewReader(response))
+  if err != nil || len(responses) != 2 {
+    t.Fatalf("Decode_timeseries_responses() = %d responses, %v, expected 2", len(responses), err)
+  }
+  if responses[0].Time_series_query != "SELECT cpu_percent" || !reflect.DeepEqual(responses[1].Warnings, []string{"No data"}) {
+    t.Errorf("Decode_timeseries_responses() = %+v", responses)
+  }
+
+  serie := &responses[0].Time_series[0]
+  attributes := []struct {
+    name string
+    expected string
+  }{
+    {"hostname", "host1"},
+    {"clusterName", "cluster1"},
+    {"numCores", "8"},
+    {"rackId", ""},
+    {"unknown", ""},
+  }
+  for _, attribute := range attributes {
+    if value := serie.Get_attribute(attribute.name); value != attribute.expected {
+      t.Errorf("Get_attribute(%q) = %q, expected %q", attribute.name, value, attribute.expected)
+    }
+  }
+  if value, err := serie.Get_value(); err != nil || value != 10.5 {
+    t.Errorf("Get_value() = %v, %v, expected 10.5", value, err)
+  }
+  if value, err := serie.Get_last_value(); err != nil || value != 12 {
+    t.Errorf("Get_last_value() = %v, %v, expected 12", value, err)
+  }
+  empty := &responses[1].Time_series[0]
+  if _, err := empty.Get_value(); err == nil {
+    t.Errorf("Get_value() of a time series without data succeeded")
+  }
+  if _, err := empty.Get_last_value(); err == nil {
+    t.Errorf("Get_last_value() of a time series without data succeeded")
+  }
+}
+
+
+// Decoding of the hosts with the typed decoder, from the reader of the body
+func Benchmark_decode_hosts_typed(b *testing.B) {
+  response := []byte(test_hosts_response(1000))
+  b.SetBytes(int64(len(response)))
+  b.ReportAllocs()
+  for iteration := 0; iteration < b.N; iteration++ {
+    hosts, err := Decode_api_hosts(bytes.NewReader(response))
+    if err != nil || len(hosts) != 1000 {
+      b.Fatalf("Decode_api_hosts() = %d hosts, %v", len(hosts), err)
+    }
+  }
+}
+
+
+// Decoding of the hosts with the gjson accessors, as the modules did before
+// the typed decoding: the whole body is read as a string and each field of
+// each host is looked up from the root with an "items.<index>.<field>" path
+func Benchmark_decode_hosts_gjson(b *testing.B) {
+  response := []byte(test_hosts_response(1000))
+  b.SetBytes(int64(len(response)))
+  b.ReportAllocs()
+  for iteration := 0; iteration < b.N; iteration++ {
+    content, err := ioutil.ReadAll(bytes.NewReader(response))
+    if err != nil {
+      b.Fatal(err)
+    }
+    json_parsed := Parse_json_response(string(content))
+    num_hosts := Get_api_query_items_num(json_parsed)
+    hosts := []Api_host{}
+    for index := 0; index < num_hosts; index++ {
+      hosts = append(hosts, Api_host{
+        Host_id: Get_api_query_host_id(json_parsed, index),
+        Hostname: Get_api_query_host_name(json_parsed, index),
+        Ip_address: Get_api_query_host_ip(json_parsed, index),
+        Rack_id: Get_json_field(json_parsed, fmt.Sprintf("items.%d.rackId", index)),
+        Commission_state: Get_api_query_host_commission_state(json_parsed, index),
+        Maintenance_mode: Get_api_query_host_maintenance_mode(json_parsed, index) == "true",
+        Health_summary: Get_json_field(json_parsed, fmt.Sprintf("items.%d.healthSummary", index)),
+        Cluster_ref: Api_cluster_ref{Cluster_name: Get_json_field(json_parsed, fmt.Sprintf("items.%d.clusterRef.clusterName", index))},
+      })
+    }
+    if len(hosts) != 1000 || len(Get_json_array(json_parsed, "items")) != 1000 {
+      b.Fatalf("%d hosts", len(hosts))
+    }
+  }
+}
@@ -19,23 +19,25 @@ package kbdi_logger
 import (
   // Go Default libraries
   "io"
-  "io/ioutil"
   "log"
   "fmt"
   "runtime"
   "path"
+  "sync/atomic"
 )
 
 
 
 
+// The loggers are set once by Init, before logging. The log level can be
+// changed at any time with Set_level, so it's read atomically
 var (
     Ok        *log.Logger
     Info      *log.Logger
     Warning   *log.Logger
     Error     *log.Logger
     Debug     *log.Logger
-    Log_level int
+    current_level int32
 )
 
 
@@ -48,7 +50,6 @@ func Init(okHandle io.Writer, infoHandle io.Writer, warningHandle io.Writer, err
     warn_head :=  "\033[37m[\033[33mWARN\033[37m]\033[0m"
     error_head := "\033[37m[\033[31mERROR\033[37m]\033[0m"
     debug_head := "\033[37m[\033[36mDEBUG\033[37m]\033[0m"
-    Log_level = log_level
 
     Ok = log.New(okHandle,
       fmt.Sprintf("%s  %s ", ok_head, head),
@@ -66,20 +67,32 @@ func Init(okHandle io.Writer, infoHandle io.Writer, warningHandle io.Writer, err
       fmt.Sprintf("%s %s ", error_head, head),
       log.Ldate|log.Ltime)
 
-    if Log_level == 1 {
-      Debug = log.New(debugHandle,
-        fmt.Sprintf("%s %s ", debug_head, head),
-        log.Ldate|log.Ltime)
+    Debug = log.New(debugHandle,
+      fmt.Sprintf("%s %s ", debug_head, head),
+      log.Ldate|log.Ltime)
+
+    atomic.StoreInt32(&current_level, int32(log_level))
+    if log_level == 1 {
       Warn_msg("Enabled Debug logger Mode")
-    } else if Log_level == 0 {
-      Debug = log.New(ioutil.Discard,
-        fmt.Sprintf("%s %s ", debug_head, head),
-        log.Ldate|log.Ltime)
-      Debug_msg("Disabled Debug logger Mode")
     }
 }
 
 
+// Set the log level: 1 enables the Debug logger and 0 disables it. It's safe
+// to call while other goroutines are logging, so the level can be changed
+// when the config is reloaded
+func Set_level(level int) {
+  if atomic.SwapInt32(&current_level, int32(level)) == int32(level) {
+    return
+  }
+  if level == 1 {
+    Warn_msg("Enabled Debug logger Mode")
+  } else {
+    Info_msg("Disabled Debug logger Mode")
+  }
+}
+
+
 func format_msg (msg ...interface{}) string{
   return fmt.Sprintf(msg[0].(string), msg[1:]...)
 }
@@ -102,6 +115,9 @@ func Err_msg (msg ...interface{}){
 }
 
 func Debug_msg (msg ...interface{}){
+  if atomic.LoadInt32(&current_level) != 1 {
+    return
+  }
   _, fileName, fileLine, _ := runtime.Caller(1)
   Debug.Printf("%s:%d:  %s", path.Base(fileName), fileLine, format_msg(msg...))
 }